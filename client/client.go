@@ -0,0 +1,540 @@
+// Package client is a Go client of the HTTP API served by the server package.
+// The API is described by the OpenAPI document served at /openapi.json.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MShoaei/quakeADC/sensor"
+	"github.com/MShoaei/quakeADC/survey"
+)
+
+// Client makes requests to a quakeADC unit
+type Client struct {
+	// BaseURL is the address of the unit e.g. https://rpi.local:8443
+	BaseURL string
+
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+
+	// Token is a session token returned by Login or an API token
+	Token string
+}
+
+// New returns a client of the unit at baseURL authenticated with token
+func New(baseURL, token string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), Token: token}
+}
+
+// APIError is an error response of the server
+type APIError struct {
+	Status int
+
+	// Code identifies the kind of failure e.g. not_found or invalid_field
+	Code    string
+	Message string
+
+	// Field is the request field which failed validation
+	Field string
+
+	// Path and Reason are set for rejected paths
+	Path   string
+	Reason string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server responded %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("server responded %d: %s", e.Status, e.Message)
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// escapePath escapes the elements of a data root path
+func escapePath(p string) string {
+	elems := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	for i := range elems {
+		elems[i] = url.PathEscape(elems[i])
+	}
+	return "/" + strings.Join(elems, "/")
+}
+
+// do sends a request and returns the response when its status is 2xx
+func (c *Client) do(method, p string, query url.Values, body interface{}) (*http.Response, error) {
+	u := strings.TrimRight(c.BaseURL, "/") + p
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		apiErr := &APIError{Status: resp.StatusCode}
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		var msg struct {
+			Code   string `json:"code"`
+			Error  string `json:"error"`
+			Field  string `json:"field"`
+			Path   string `json:"path"`
+			Reason string `json:"reason"`
+		}
+		if json.Unmarshal(b, &msg) == nil {
+			apiErr.Code, apiErr.Message, apiErr.Field = msg.Code, msg.Error, msg.Field
+			apiErr.Path, apiErr.Reason = msg.Path, msg.Reason
+		} else {
+			apiErr.Message = strings.TrimSpace(string(b))
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// call sends a JSON request and decodes the JSON response into out when it is not nil
+func (c *Client) call(method, p string, query url.Values, body, out interface{}) error {
+	resp, err := c.do(method, p, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response from %s %s: %v", method, p, err)
+	}
+	return nil
+}
+
+// Login logs in and uses the returned session token for later requests
+func (c *Client) Login(name, password string) (Session, error) {
+	var res struct {
+		Token   string  `json:"token"`
+		Session Session `json:"session"`
+	}
+	err := c.call("POST", "/auth/login", nil, map[string]string{"name": name, "password": password}, &res)
+	if err != nil {
+		return Session{}, err
+	}
+	c.Token = res.Token
+	return res.Session, nil
+}
+
+// Logout ends the session of the token
+func (c *Client) Logout() error {
+	return c.call("POST", "/auth/logout", nil, nil, nil)
+}
+
+// Session returns the user and role of the token
+func (c *Client) Session() (Session, error) {
+	var res struct {
+		Session Session `json:"session"`
+	}
+	err := c.call("GET", "/auth/session", nil, nil, &res)
+	return res.Session, err
+}
+
+// Recording reports whether a recording is running
+func (c *Client) Recording() (bool, error) {
+	err := c.call("GET", "/status", nil, nil, nil)
+	if e, ok := err.(*APIError); ok && e.Status == http.StatusServiceUnavailable {
+		return true, nil
+	}
+	return false, err
+}
+
+// Tree lists the directory dir of the data root
+func (c *Client) Tree(dir string) ([]Item, error) {
+	var res struct {
+		Items []Item `json:"items"`
+	}
+	err := c.call("GET", "/tree"+escapePath(dir), nil, nil, &res)
+	return res.Items, err
+}
+
+// Delete moves p to the trash or deletes it when permanent is true
+func (c *Client) Delete(p string, permanent bool) error {
+	q := url.Values{}
+	if permanent {
+		q.Set("permanent", "true")
+	}
+	return c.call("DELETE", "/tree"+escapePath(p), q, nil, nil)
+}
+
+// Rename renames p to newName in the same directory
+func (c *Client) Rename(p, newName string) error {
+	return c.call("PATCH", "/tree"+escapePath(p), nil, map[string]string{"newName": newName}, nil)
+}
+
+// CreateProject creates a project directory
+func (c *Client) CreateProject(name string) error {
+	return c.call("POST", "/tree", nil, map[string]string{"name": name}, nil)
+}
+
+// ActiveProject returns the project new recordings are written to
+func (c *Client) ActiveProject() (string, error) {
+	var res struct {
+		Path string `json:"path"`
+	}
+	err := c.call("GET", "/project/active", nil, nil, &res)
+	return res.Path, err
+}
+
+// SetActiveProject selects the project new recordings are written to
+func (c *Client) SetActiveProject(p string) error {
+	return c.call("PATCH", "/project/active", nil, map[string]string{"path": p}, nil)
+}
+
+// Trash lists the trash
+func (c *Client) Trash() (Trash, error) {
+	var res Trash
+	err := c.call("GET", "/trash", nil, nil, &res)
+	return res, err
+}
+
+// Restore moves a trash item back to target or to where it was deleted from
+// when target is empty. It returns the restored path.
+func (c *Client) Restore(id, target string) (string, error) {
+	var body interface{}
+	if target != "" {
+		body = map[string]string{"path": target}
+	}
+	var res struct {
+		Path string `json:"path"`
+	}
+	err := c.call("POST", "/trash/"+url.PathEscape(id)+"/restore", nil, body, &res)
+	return res.Path, err
+}
+
+// PurgeTrash permanently deletes the trash item id or everything in the trash when id is empty
+func (c *Client) PurgeTrash(id string) error {
+	if id == "" {
+		return c.call("DELETE", "/trash", nil, nil, nil)
+	}
+	return c.call("DELETE", "/trash/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// Storage returns the space used by projects and the trash
+func (c *Client) Storage() (Storage, error) {
+	var res Storage
+	err := c.call("GET", "/storage", nil, nil, &res)
+	return res, err
+}
+
+// Estimate estimates the size of a recording with the enabled channels
+func (c *Client) Estimate(mode string, recordTime int, samplingTime float32) (Estimate, error) {
+	q := url.Values{}
+	q.Set("mode", mode)
+	q.Set("recordTime", strconv.Itoa(recordTime))
+	q.Set("samplingTime", strconv.FormatFloat(float64(samplingTime), 'f', -1, 32))
+	var res Estimate
+	err := c.call("GET", "/storage/estimate", q, nil, &res)
+	return res, err
+}
+
+// Channels returns the enabled channels
+func (c *Client) Channels() ([24]bool, error) {
+	var res struct {
+		Channels [24]bool `json:"channels"`
+	}
+	err := c.call("GET", "/channels", nil, nil, &res)
+	return res.Channels, err
+}
+
+// SetChannels enables the channels
+func (c *Client) SetChannels(channels [24]bool) error {
+	return c.call("POST", "/channels", nil, channels, nil)
+}
+
+// Gains returns the gain of every channel
+func (c *Client) Gains() ([24]uint32, error) {
+	var res struct {
+		Gains [24]uint32 `json:"gains"`
+	}
+	err := c.call("GET", "/gains", nil, nil, &res)
+	return res.Gains, err
+}
+
+// SetGains sets the gain of every channel
+func (c *Client) SetGains(gains [24]uint32) error {
+	return c.call("POST", "/gains", nil, gains, nil)
+}
+
+// Sensors returns the sensor of every channel in the active project
+func (c *Client) Sensors() ([24]sensor.Model, error) {
+	var res struct {
+		Sensors [24]sensor.Model `json:"sensors"`
+	}
+	err := c.call("GET", "/sensors", nil, nil, &res)
+	return res.Sensors, err
+}
+
+// SetSensors sets the sensor of every channel in the active project
+func (c *Client) SetSensors(sensors [24]sensor.Model) error {
+	return c.call("POST", "/sensors", nil, sensors, nil)
+}
+
+// Survey returns the survey of the active project
+func (c *Client) Survey() (survey.Survey, error) {
+	var res struct {
+		Survey survey.Survey `json:"survey"`
+	}
+	err := c.call("GET", "/survey", nil, nil, &res)
+	return res.Survey, err
+}
+
+// SetSurvey replaces the survey of the active project
+func (c *Client) SetSurvey(sv survey.Survey) (survey.Survey, error) {
+	var res struct {
+		Survey survey.Survey `json:"survey"`
+	}
+	err := c.call("PUT", "/survey", nil, sv, &res)
+	return res.Survey, err
+}
+
+// SetShot sets the shot number of new recordings
+func (c *Client) SetShot(shot int) (survey.Survey, error) {
+	var res struct {
+		Survey survey.Survey `json:"survey"`
+	}
+	err := c.call("PATCH", "/survey/shot", nil, map[string]int{"shot": shot}, &res)
+	return res.Survey, err
+}
+
+// Setup makes a recording in the active project and waits for it to finish
+func (c *Client) Setup(req SetupRequest) (SetupResult, error) {
+	var res SetupResult
+	err := c.call("POST", "/setup", nil, req, &res)
+	return res, err
+}
+
+// Info returns the voltages and currents of the board
+func (c *Client) Info() (BoardInfo, error) {
+	var res BoardInfo
+	err := c.call("GET", "/info", nil, nil, &res)
+	return res, err
+}
+
+// SelfTest tests the ADCs of the unit. capture records the diagnostic inputs on every channel.
+func (c *Client) SelfTest(capture bool) (SelfTestReport, error) {
+	q := url.Values{}
+	q.Set("capture", strconv.FormatBool(capture))
+	var res SelfTestReport
+	err := c.call("POST", "/selftest", q, nil, &res)
+	return res, err
+}
+
+// Calibration returns the stored calibration of the unit
+func (c *Client) Calibration() (CalibrationInfo, error) {
+	var res CalibrationInfo
+	err := c.call("GET", "/calibration", nil, nil, &res)
+	return res, err
+}
+
+// Calibrate calibrates the channels of the unit with source "diagnostic" or
+// "fixture". The calibration is stored when it converged.
+func (c *Client) Calibrate(source string) (CalibrationResult, error) {
+	q := url.Values{}
+	q.Set("source", source)
+	var res struct {
+		Result CalibrationResult `json:"result"`
+	}
+	err := c.call("POST", "/calibrate", q, nil, &res)
+	return res.Result, err
+}
+
+// CalibrateSync aligns the channels of the unit recording a common signal
+// with their sync offsets. A recording in req.File is calibrated for the unit
+// which made it.
+func (c *Client) CalibrateSync(req SyncCalibrationRequest) (SyncCalibrationResult, error) {
+	var res struct {
+		Result SyncCalibrationResult `json:"result"`
+	}
+	err := c.call("POST", "/calibrate/sync", nil, req, &res)
+	return res.Result, err
+}
+
+// ResetADCs resets the ADC with chip select adc or every ADC when adc is 0.
+// mode is "soft" or "hard". The unit restores its settings afterwards.
+func (c *Client) ResetADCs(mode string, adc int) ([]ResetResult, error) {
+	q := url.Values{}
+	q.Set("mode", mode)
+	q.Set("adc", strconv.Itoa(adc))
+	var res struct {
+		Results []ResetResult `json:"results"`
+	}
+	err := c.call("POST", "/adc/reset", q, nil, &res)
+	return res.Results, err
+}
+
+// Health returns the state of the acquisition chain of the unit. It fails
+// with status 503 while a recording is running.
+func (c *Client) Health() (HealthStatus, error) {
+	var res struct {
+		Health HealthStatus `json:"health"`
+	}
+	err := c.call("GET", "/status", nil, nil, &res)
+	return res.Health, err
+}
+
+// Metrics writes the metrics of the unit in the Prometheus text format to w
+func (c *Client) Metrics(w io.Writer) error {
+	return c.download(w, "/metrics", nil)
+}
+
+// Telemetry returns the last measurement of the power rails
+func (c *Client) Telemetry() (Telemetry, error) {
+	var res Telemetry
+	err := c.call("GET", "/telemetry", nil, nil, &res)
+	return res, err
+}
+
+// TelemetryHistory returns the measurements after since from the oldest. A
+// zero since returns every kept measurement.
+func (c *Client) TelemetryHistory(since time.Time) ([]Telemetry, error) {
+	q := url.Values{}
+	if !since.IsZero() {
+		q.Set("since", since.Format(time.RFC3339))
+	}
+	var res struct {
+		Samples []Telemetry `json:"samples"`
+	}
+	err := c.call("GET", "/telemetry/history", q, nil, &res)
+	return res.Samples, err
+}
+
+// Events lists the events of the journal between since and until from the
+// oldest. Zero times do not limit the range and no types list every type.
+func (c *Client) Events(since, until time.Time, types ...string) ([]Event, error) {
+	q := url.Values{}
+	if !since.IsZero() {
+		q.Set("since", since.Format(time.RFC3339))
+	}
+	if !until.IsZero() {
+		q.Set("until", until.Format(time.RFC3339))
+	}
+	if len(types) != 0 {
+		q.Set("type", strings.Join(types, ","))
+	}
+	var res struct {
+		Events []Event `json:"events"`
+	}
+	err := c.call("GET", "/events", q, nil, &res)
+	return res.Events, err
+}
+
+// Recordings searches the recordings. query holds the sort, order, limit and
+// offset parameters and the field filters of GET /recordings.
+func (c *Client) Recordings(query url.Values) (RecordingList, error) {
+	var res RecordingList
+	err := c.call("GET", "/recordings", query, nil, &res)
+	return res, err
+}
+
+// Annotate sets the notes and tags of the recording p. nil values are left unchanged.
+func (c *Client) Annotate(p string, notes *string, tags []string) (Recording, error) {
+	body := struct {
+		Path  string   `json:"path"`
+		Notes *string  `json:"notes"`
+		Tags  []string `json:"tags"`
+	}{p, notes, tags}
+	var res struct {
+		Recording Recording `json:"recording"`
+	}
+	err := c.call("PATCH", "/recordings", nil, body, &res)
+	return res.Recording, err
+}
+
+// USB lists the removable disks
+func (c *Client) USB() ([]StorageDevice, error) {
+	var res struct {
+		Devices []StorageDevice `json:"devices"`
+	}
+	err := c.call("GET", "/usb", nil, nil, &res)
+	return res.Devices, err
+}
+
+// Download writes the recording p converted to fileType to w. unit may be empty for counts.
+func (c *Client) Download(w io.Writer, p, fileType string, unit sensor.Unit) error {
+	q := url.Values{}
+	q.Set("type", fileType)
+	if unit != "" {
+		q.Set("unit", string(unit))
+	}
+	return c.download(w, "/dl"+escapePath(p), q)
+}
+
+// Bundle writes the project p as an archive of archiveType, zip or tar.gz, to w
+func (c *Client) Bundle(w io.Writer, p, archiveType, fileType string) error {
+	q := url.Values{}
+	q.Set("archive", archiveType)
+	q.Set("type", fileType)
+	return c.download(w, "/bundle"+escapePath(p), q)
+}
+
+func (c *Client) download(w io.Writer, p string, query url.Values) error {
+	resp, err := c.do("GET", p, query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Shutdown stops the work of the unit and powers it off. force waits for a
+// running recording or export instead of failing.
+func (c *Client) Shutdown(force bool) ([]PowerStep, error) {
+	return c.power("/rpi/shutdown", force)
+}
+
+// Restart stops the work of the unit and reboots it. force waits for a
+// running recording or export instead of failing.
+func (c *Client) Restart(force bool) ([]PowerStep, error) {
+	return c.power("/rpi/restart", force)
+}
+
+func (c *Client) power(path string, force bool) ([]PowerStep, error) {
+	q := url.Values{}
+	q.Set("force", strconv.FormatBool(force))
+	var res struct {
+		Steps []PowerStep `json:"steps"`
+	}
+	err := c.call("POST", path, q, nil, &res)
+	return res.Steps, err
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/MShoaei/quakeADC/sensor"
+	"github.com/MShoaei/quakeADC/server"
+	"github.com/spf13/afero"
+)
+
+func TestClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := server.NewServer(afero.NewBasePathFs(afero.NewOsFs(), dir), afero.NewMemMapFs(), nil, nil, false)
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	c := New(ts.URL, "")
+
+	if err := c.CreateProject("line 1"); err != nil {
+		t.Fatalf("CreateProject() error = %v", err)
+	}
+	if err := c.SetActiveProject("/line 1"); err != nil {
+		t.Fatalf("SetActiveProject() error = %v", err)
+	}
+	if p, err := c.ActiveProject(); err != nil || p != "/line 1" {
+		t.Errorf("ActiveProject() = %q, %v", p, err)
+	}
+
+	var sensors [24]sensor.Model
+	sensors[2] = sensor.Model{Type: sensor.Geophone, Sensitivity: 28.8}
+	if err := c.SetSensors(sensors); err != nil {
+		t.Fatalf("SetSensors() error = %v", err)
+	}
+	if got, err := c.Sensors(); err != nil || got != sensors {
+		t.Errorf("Sensors() = %v, %v, want %v", got, err, sensors)
+	}
+
+	if err := c.Delete("/line 1", false); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	trash, err := c.Trash()
+	if err != nil || len(trash.Items) != 1 {
+		t.Fatalf("Trash() = %v, %v", trash, err)
+	}
+	if p, err := c.Restore(trash.Items[0].ID, ""); err != nil || p != "/line 1" {
+		t.Errorf("Restore() = %q, %v", p, err)
+	}
+	items, err := c.Tree("/")
+	if err != nil || len(items) != 1 || items[0].Name != "line 1" || !items[0].Dir {
+		t.Errorf("Tree() = %v, %v", items, err)
+	}
+
+	err = c.Rename("/missing", "other")
+	if e, ok := err.(*APIError); !ok || e.Status != http.StatusNotFound || e.Reason == "" {
+		t.Errorf("Rename() error = %#v, want not found", err)
+	}
+}
@@ -0,0 +1,320 @@
+package client
+
+import "time"
+
+// Role of a user or API token
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+)
+
+// Session is the user or API token a request is made as
+type Session struct {
+	Name    string    `json:"name"`
+	Role    Role      `json:"role"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// Item is an entry of a directory listing
+type Item struct {
+	Name string `json:"name"`
+	Dir  bool   `json:"dir"`
+}
+
+// SetupRequest configures and starts a recording in the active project
+type SetupRequest struct {
+	// StartMode is asap, hammer or trigger
+	StartMode        string  `json:"startMode"`
+	TriggerThreshold float64 `json:"threshold"`
+	ThresholdUnit    string  `json:"thresholdUnit"`
+	TriggerChannel   int     `json:"triggerChannel"`
+
+	// RecordTime in seconds
+	RecordTime int `json:"recordTime"`
+
+	// SamplingTime in microseconds
+	SamplingTime float32 `json:"samplingTime"`
+	Window       int     `json:"window"`
+	FileName     string  `json:"fileName"`
+
+	// Shot number of the recording. 0 uses the current shot of the project survey
+	Shot int `json:"shot"`
+}
+
+// SetupResult is the outcome of a recording
+type SetupResult struct {
+	Frames  int64  `json:"frames"`
+	Warning string `json:"warning,omitempty"`
+}
+
+// Recording is the metadata of a recording in the index of the server
+type Recording struct {
+	Path        string    `json:"path"`
+	Project     string    `json:"project"`
+	Size        int64     `json:"size"`
+	Version     uint16    `json:"version"`
+	StartTime   time.Time `json:"startTime"`
+	SampleRate  float64   `json:"sampleRate"`
+	Frames      int64     `json:"frames"`
+	Duration    float64   `json:"duration"`
+	Channels    []int     `json:"channels"`
+	Mode        string    `json:"mode"`
+	Line        string    `json:"line"`
+	Shot        int       `json:"shot"`
+	Peak        int64     `json:"peak"`
+	PeakChannel int       `json:"peakChannel"`
+	Notes       string    `json:"notes"`
+	Tags        []string  `json:"tags"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// RecordingList is a page of the recordings matching a query
+type RecordingList struct {
+	Recordings []Recording `json:"recordings"`
+	Total      int         `json:"total"`
+
+	// Indexing is true while the server is still scanning the recordings
+	Indexing bool `json:"indexing"`
+}
+
+// Annotation is the notes and tags of a recording
+type Annotation struct {
+	Notes string   `json:"notes,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+// TrashItem is a file or directory in the trash
+type TrashItem struct {
+	ID           string                `json:"id"`
+	OriginalPath string                `json:"originalPath"`
+	Name         string                `json:"name"`
+	Deleted      time.Time             `json:"deleted"`
+	Size         int64                 `json:"size"`
+	Dir          bool                  `json:"dir"`
+	Annotations  map[string]Annotation `json:"annotations,omitempty"`
+}
+
+// Trash is the content of the trash oldest first
+type Trash struct {
+	Items []TrashItem `json:"items"`
+	Size  int64       `json:"size"`
+}
+
+// ProjectUsage is the space used by the recordings of a project
+type ProjectUsage struct {
+	Project    string `json:"project"`
+	Recordings int    `json:"recordings"`
+	Size       int64  `json:"size"`
+}
+
+// Storage is the space used on the data root
+type Storage struct {
+	Projects []ProjectUsage `json:"projects"`
+	Trash    int64          `json:"trash"`
+	Reserve  uint64         `json:"reserve"`
+
+	// Hotplug are the latest removable disk events from the newest
+	Hotplug []StorageEvent `json:"hotplug"`
+
+	// Free is 0 when the server does not know the free space
+	Free uint64 `json:"free,omitempty"`
+}
+
+// Estimate is the expected size of a recording
+type Estimate struct {
+	Size    int64  `json:"size"`
+	Scratch int64  `json:"scratch"`
+	Fits    bool   `json:"fits"`
+	Warning string `json:"warning,omitempty"`
+}
+
+// StorageDevice is a file system on a removable block device
+type StorageDevice struct {
+	Name       string `json:"name"`
+	Disk       string `json:"disk"`
+	Label      string `json:"label"`
+	FSType     string `json:"fsType"`
+	Size       uint64 `json:"size"`
+	MountPoint string `json:"mountPoint"`
+	Free       uint64 `json:"free"`
+}
+
+// StorageEvent is a removable disk being plugged in (added) or removed
+type StorageEvent struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	Disk string    `json:"disk"`
+}
+
+// BoardInfo is the voltages and currents of the board
+type BoardInfo struct {
+	Voltage []int16 `json:"voltage"`
+	Current []int16 `json:"current"`
+}
+
+// Rail is a measurement of a power rail in volts and amps
+type Rail struct {
+	Voltages [3]float64 `json:"voltages"`
+	Currents [3]float64 `json:"currents"`
+}
+
+// Battery is the state of the battery
+type Battery struct {
+	Voltage float64 `json:"voltage"`
+	Current float64 `json:"current"`
+	Charge  float64 `json:"charge"`
+
+	// Runtime is 0 when the server can not estimate it
+	Runtime time.Duration `json:"runtime,omitempty"`
+}
+
+// TelemetryAlert is a measurement outside its limit
+type TelemetryAlert struct {
+	Type    string  `json:"type"`
+	Rail    int     `json:"rail"`
+	Channel int     `json:"channel"`
+	Value   float64 `json:"value"`
+	Limit   float64 `json:"limit"`
+}
+
+// Telemetry is a measurement of the power rails
+type Telemetry struct {
+	Time    time.Time        `json:"time"`
+	Rails   []Rail           `json:"rails"`
+	Battery Battery          `json:"battery"`
+	Alerts  []TelemetryAlert `json:"alerts,omitempty"`
+}
+
+// PowerStep is a step of the shutdown sequence. Error is set when it failed.
+type PowerStep struct {
+	Step  string `json:"step"`
+	Error string `json:"error,omitempty"`
+}
+
+// ChipResult is the self test of an ADC
+type ChipResult struct {
+	Chip     uint8    `json:"chip"`
+	Status   uint8    `json:"status"`
+	Revision uint8    `json:"revision"`
+	RAMBIST  bool     `json:"ramBist"`
+	Pass     bool     `json:"pass"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// ChannelResult is the mean of a channel measuring a diagnostic input
+type ChannelResult struct {
+	Channel  int     `json:"channel"`
+	Input    string  `json:"input"`
+	Expected int32   `json:"expected"`
+	Mean     float64 `json:"mean"`
+	Pass     bool    `json:"pass"`
+}
+
+// SelfTestReport is the result of a self test
+type SelfTestReport struct {
+	Time     time.Time       `json:"time"`
+	Pass     bool            `json:"pass"`
+	Chips    []ChipResult    `json:"chips"`
+	Channels []ChannelResult `json:"channels,omitempty"`
+	Errors   []string        `json:"errors,omitempty"`
+}
+
+// Calibration is the offset register and the gain correction of every channel
+type Calibration struct {
+	Offsets         [24]int32   `json:"offsets"`
+	GainCorrections [24]float64 `json:"gainCorrections"`
+}
+
+// CalibrationInfo is the stored calibration of a unit. Time, Source and Age
+// are empty when the unit was never calibrated.
+type CalibrationInfo struct {
+	Calibrated  bool          `json:"calibrated"`
+	Serial      string        `json:"serial"`
+	Calibration Calibration   `json:"calibration"`
+	Time        time.Time     `json:"time"`
+	Source      string        `json:"source"`
+	Age         time.Duration `json:"age"`
+}
+
+// CalibrationResult is the outcome of a calibration
+type CalibrationResult struct {
+	Calibration     Calibration `json:"calibration"`
+	Source          string      `json:"source"`
+	OffsetResiduals [24]float64 `json:"offsetResiduals"`
+	GainErrors      [24]float64 `json:"gainErrors"`
+	Iterations      int         `json:"iterations"`
+	Converged       bool        `json:"converged"`
+}
+
+// SyncCalibrationRequest selects the signal a sync offset calibration aligns
+// the channels with. Source is "capture" or "recording".
+type SyncCalibrationRequest struct {
+	Source       string  `json:"source"`
+	File         string  `json:"file,omitempty"`
+	SamplingTime float32 `json:"samplingTime,omitempty"`
+	CaptureTime  int     `json:"captureTime,omitempty"`
+	Reference    int     `json:"reference,omitempty"`
+}
+
+// SyncCalibrationResult is the outcome of a sync offset calibration. Skews are in microseconds.
+type SyncCalibrationResult struct {
+	Offsets         [24]uint8   `json:"offsets"`
+	Reference       int         `json:"reference"`
+	Skew            [24]float64 `json:"skew"`
+	MaxSkew         float64     `json:"maxSkew"`
+	ResidualSkew    [24]float64 `json:"residualSkew"`
+	MaxResidualSkew float64     `json:"maxResidualSkew"`
+	Skipped         []int       `json:"skipped,omitempty"`
+	Verified        bool        `json:"verified"`
+}
+
+// ResetResult is the reset of a single ADC
+type ResetResult struct {
+	Chip     uint8    `json:"chip"`
+	Response uint16   `json:"response,omitempty"`
+	Revision uint8    `json:"revision"`
+	Status   uint8    `json:"status"`
+	Pass     bool     `json:"pass"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// ComponentHealth is the last probe of a part of the acquisition chain
+type ComponentHealth struct {
+	Name     string    `json:"name"`
+	OK       bool      `json:"ok"`
+	Error    string    `json:"error,omitempty"`
+	Checked  time.Time `json:"checked"`
+	Failures int       `json:"failures"`
+}
+
+// RecoveryAttempt is a run of the recovery steps of a unit
+type RecoveryAttempt struct {
+	Time  time.Time `json:"time"`
+	Steps []string  `json:"steps"`
+	OK    bool      `json:"ok"`
+	Error string    `json:"error,omitempty"`
+}
+
+// HealthStatus is the state of the acquisition chain of a unit. State is
+// ok, failing or recovering.
+type HealthStatus struct {
+	State        string            `json:"state"`
+	Components   []ComponentHealth `json:"components"`
+	Recoveries   int               `json:"recoveries"`
+	LastRecovery *RecoveryAttempt  `json:"lastRecovery,omitempty"`
+	NextRecovery *time.Time        `json:"nextRecovery,omitempty"`
+}
+
+// Event is an entry of the event journal of a unit. User and Remote are
+// empty for events of the unit itself.
+type Event struct {
+	Time    time.Time              `json:"time"`
+	Type    string                 `json:"type"`
+	Message string                 `json:"message"`
+	User    string                 `json:"user,omitempty"`
+	Remote  string                 `json:"remote,omitempty"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
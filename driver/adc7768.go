@@ -97,17 +97,20 @@ const (
 
 // Adc7768 is an SPI connection to send commands and receive responses
 type Adc7768 struct {
-	connection *spi.SpiConnection
+	connection spi.Connection
 }
 
 // GetSpiConnection creates a new connection to send commands on.
 func GetSpiConnection(busNum, chipNum, mode, bits int, maxSpeed int64) (*Adc7768, error) {
+	if hostErr != nil {
+		return nil, hostErr
+	}
 	c, err := spi.GetSpiConnection(busNum, chipNum, mode, bits, maxSpeed)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Adc7768{connection: c.(*spi.SpiConnection)}, nil
+	return &Adc7768{connection: c}, nil
 }
 
 func (adc Adc7768) Connection() spi.Connection {
@@ -119,9 +122,11 @@ func (adc *Adc7768) Transmit(tx, rx []byte, cs uint8) error {
 	if cs < 1 || cs > 9 {
 		return fmt.Errorf("invalid chip select %d", cs)
 	}
-	chipSelectPins[cs].FastOut(gpio.Low)
+	busMu.Lock()
+	defer busMu.Unlock()
+	driveChipSelect(cs, gpio.Low)
 	err := adc.connection.Tx(tx, rx)
-	chipSelectPins[cs].FastOut(gpio.High)
+	driveChipSelect(cs, gpio.High)
 
 	return err
 }
@@ -131,9 +136,11 @@ func (adc *Adc7768) Write(tx []byte, cs uint8) error {
 	if cs < 1 || cs > 9 {
 		return fmt.Errorf("invalid chip select %d", cs)
 	}
-	chipSelectPins[cs].FastOut(gpio.Low)
+	busMu.Lock()
+	defer busMu.Unlock()
+	driveChipSelect(cs, gpio.Low)
 	err := adc.connection.Tx(tx, nil)
-	chipSelectPins[cs].FastOut(gpio.High)
+	driveChipSelect(cs, gpio.High)
 
 	return err
 }
@@ -143,9 +150,11 @@ func (adc *Adc7768) Read(rx []byte, cs uint8) error {
 	if cs < 1 || cs > 9 {
 		return fmt.Errorf("invalid chip select %d", cs)
 	}
-	chipSelectPins[cs].FastOut(gpio.Low)
+	busMu.Lock()
+	defer busMu.Unlock()
+	driveChipSelect(cs, gpio.Low)
 	err := adc.connection.Tx([]byte{0x8a, 0x00}, rx)
-	chipSelectPins[cs].FastOut(gpio.High)
+	driveChipSelect(cs, gpio.High)
 
 	return err
 }
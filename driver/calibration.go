@@ -0,0 +1,294 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// The AD7768 output is (x - OFFSET) * GAIN * 4 / 2^24 where x is
+// 3 * 2^21 * Vin / Vref. A full-scale input with the default GAIN of 0x555555
+// reads 2^23.
+const (
+	gainScale   = 4.0 / (1 << 24)
+	fullScaleIn = 3 << 21
+)
+
+// CalibrationGain is the gain register used while calibrating. Full-scale
+// inputs read about 3/8 of full scale with it so they do not clip.
+const CalibrationGain = 1 << 20
+
+// CalibrationSource is the input measured for the offsets
+type CalibrationSource string
+
+const (
+	// CalibrateDiagnostic measures the internal zero-scale input for the
+	// offsets and the full-scale inputs for the gains
+	CalibrateDiagnostic CalibrationSource = "diagnostic"
+
+	// CalibrateFixture measures the channel inputs shorted by a fixture for
+	// the offsets. The gains are not calibrated.
+	CalibrateFixture CalibrationSource = "fixture"
+)
+
+// Calibration is the offset register and the gain correction of every channel
+type Calibration struct {
+	Offsets [24]int32 `json:"offsets"`
+
+	// GainCorrections multiply the gain register of the channels
+	GainCorrections [24]float64 `json:"gainCorrections"`
+}
+
+// DefaultCalibration has no offsets and unity gain corrections
+func DefaultCalibration() Calibration {
+	var c Calibration
+	for i := range c.GainCorrections {
+		c.GainCorrections[i] = 1
+	}
+	return c
+}
+
+// GainRegister is the gain register of channel ch for the nominal gain register value
+func (c Calibration) GainRegister(ch int, nominal uint32) uint32 {
+	v := math.Round(float64(nominal) * c.GainCorrections[ch])
+	if v > 0xffffff {
+		return 0xffffff
+	}
+	return uint32(v)
+}
+
+// CalibrationOptions configures Calibrate
+type CalibrationOptions struct {
+	Source CalibrationSource
+
+	// Logic is the connection of the logic analyzer
+	Logic string
+
+	// CaptureTime of every measurement in milliseconds
+	CaptureTime int
+
+	// OffsetTolerance is the largest residual offset in counts
+	OffsetTolerance float64
+
+	// GainTolerance is the largest relative gain error
+	GainTolerance float64
+
+	// MaxIterations of every calibration step
+	MaxIterations int
+}
+
+// CalibrationResult is the outcome of Calibrate
+type CalibrationResult struct {
+	Calibration Calibration `json:"calibration"`
+	Source      string      `json:"source"`
+
+	// OffsetResiduals are the offsets in counts measured after calibration
+	OffsetResiduals [24]float64 `json:"offsetResiduals"`
+
+	// GainErrors are the relative gain errors measured after calibration
+	GainErrors [24]float64 `json:"gainErrors"`
+
+	Iterations int  `json:"iterations"`
+	Converged  bool `json:"converged"`
+}
+
+// Calibrate wakes every channel and measures its offset and gain until the
+// residual errors are within tolerance or MaxIterations is reached. The
+// calibration is left in the registers with CalibrationGain. cal is the starting
+// point and its gain corrections are kept by fixture calibrations.
+func (adc *Adc7768) Calibrate(x XMegaController, cal Calibration, opts CalibrationOptions) (CalibrationResult, error) {
+	if opts.CaptureTime <= 0 {
+		opts.CaptureTime = 256
+	}
+	if opts.OffsetTolerance <= 0 {
+		opts.OffsetTolerance = 16
+	}
+	if opts.GainTolerance <= 0 {
+		opts.GainTolerance = 1e-4
+	}
+	if opts.MaxIterations <= 0 {
+		opts.MaxIterations = 5
+	}
+	res := CalibrationResult{Source: string(opts.Source)}
+	capture := func(in DiagnosticInput) ([24]float64, error) {
+		if opts.Source == CalibrateFixture {
+			in = DiagnosticOff
+		}
+		for cs := uint8(1); cs <= 3; cs++ {
+			if err := adc.SetDiagnosticInput(cs, in); err != nil {
+				return [24]float64{}, fmt.Errorf("chip %d: %v", cs, err)
+			}
+		}
+		return adc.captureMeans(x, opts.Logic, opts.CaptureTime)
+	}
+	defer func() {
+		for cs := uint8(1); cs <= 3; cs++ {
+			_ = adc.SetDiagnosticInput(cs, DiagnosticOff)
+		}
+	}()
+	for cs := uint8(1); cs <= 3; cs++ {
+		if _, _, err := adc.ChStandby(ChStandbyOpts{Write: true}, cs); err != nil {
+			return res, fmt.Errorf("failed to wake the channels of chip %d: %v", cs, err)
+		}
+	}
+
+	switch opts.Source {
+	case CalibrateDiagnostic:
+		// the span between the full-scale inputs with an exact gain
+		ideal := 2 * fullScaleIn * CalibrationGain * gainScale
+		gainsDone := false
+		for i := 0; i < opts.MaxIterations && !gainsDone; i++ {
+			res.Iterations++
+			if err := adc.ApplyCalibration(cal, [24]uint32{}, CalibrationGain); err != nil {
+				return res, err
+			}
+			pos, err := capture(DiagnosticPositiveFullScale)
+			if err != nil {
+				return res, err
+			}
+			neg, err := capture(DiagnosticNegativeFullScale)
+			if err != nil {
+				return res, err
+			}
+			gainsDone = true
+			for ch := range cal.GainCorrections {
+				span := pos[ch] - neg[ch]
+				if span <= 0 {
+					return res, fmt.Errorf("channel %d does not respond to the full-scale inputs", ch+1)
+				}
+				res.GainErrors[ch] = span/ideal - 1
+				if math.Abs(res.GainErrors[ch]) > opts.GainTolerance {
+					gainsDone = false
+					cal.GainCorrections[ch] /= 1 + res.GainErrors[ch]
+				}
+			}
+		}
+		if !gainsDone {
+			res.Calibration = cal
+			return res, nil
+		}
+	case CalibrateFixture:
+	default:
+		return res, fmt.Errorf("invalid calibration source %q. expected %s or %s", opts.Source, CalibrateDiagnostic, CalibrateFixture)
+	}
+
+	for i := 0; i < opts.MaxIterations; i++ {
+		res.Iterations++
+		if err := adc.ApplyCalibration(cal, [24]uint32{}, CalibrationGain); err != nil {
+			return res, err
+		}
+		zero, err := capture(DiagnosticZeroScale)
+		if err != nil {
+			return res, err
+		}
+		res.OffsetResiduals = zero
+		done := true
+		for ch, m := range zero {
+			if math.Abs(m) <= opts.OffsetTolerance {
+				continue
+			}
+			done = false
+			step := m / (float64(cal.GainRegister(ch, CalibrationGain)) * gainScale)
+			cal.Offsets[ch] = clampOffset(float64(cal.Offsets[ch]) + step)
+		}
+		if done {
+			res.Converged = true
+			break
+		}
+	}
+	res.Calibration = cal
+	return res, nil
+}
+
+// clampOffset rounds v to the 24-bit signed range of the offset registers
+func clampOffset(v float64) int32 {
+	v = math.Round(v)
+	if v > FullScale {
+		return FullScale
+	}
+	if v < -FullScale-1 {
+		return -FullScale - 1
+	}
+	return int32(v)
+}
+
+// ApplyCalibration writes the offsets of cal and the gain registers of gains
+// corrected by cal. A zero gain uses nominal.
+func (adc *Adc7768) ApplyCalibration(cal Calibration, gains [24]uint32, nominal uint32) error {
+	for ch := 0; ch < 24; ch++ {
+		cs, opts := uint8(ch/8)+1, ChannelOffsetOpts{Write: true, Channel: uint8(ch % 8)}
+		opts.Offset = register24(uint32(cal.Offsets[ch]))
+		if err := adc.ChannelOffset(opts, cs, false); err != nil {
+			return fmt.Errorf("channel %d offset: %v", ch+1, err)
+		}
+		gain := gains[ch]
+		if gain == 0 {
+			gain = nominal
+		}
+		gainOpts := ChannelGainOpts{Write: true, Channel: uint8(ch % 8)}
+		gainOpts.Offset = register24(cal.GainRegister(ch, gain))
+		if _, err := adc.ChannelGain(gainOpts, cs, false); err != nil {
+			return fmt.Errorf("channel %d gain: %v", ch+1, err)
+		}
+	}
+	return nil
+}
+
+// register24 splits the low 24 bits of v into MSB, Mid and LSB
+func register24(v uint32) [3]uint8 {
+	return [3]uint8{uint8(v >> 16), uint8(v >> 8), uint8(v)}
+}
+
+// captureMeans records the 24 channels and returns the mean of every channel
+func (adc *Adc7768) captureMeans(x XMegaController, logic string, captureTime int) (means [24]float64, err error) {
+	data, err := adc.captureFrames(x, logic, captureTime)
+	if err != nil {
+		return means, err
+	}
+	return frameMeans(data)
+}
+
+// captureFrames records the 24 channels and returns frames of 24 little endian int32 samples
+func (adc *Adc7768) captureFrames(x XMegaController, logic string, captureTime int) ([]byte, error) {
+	SendSyncSignal()
+	if err := x.StartSampling(); err != nil {
+		return nil, err
+	}
+	// the digital filter settles in a few samples after sync
+	time.Sleep(10 * time.Millisecond)
+	f, size, err := ExecSigrokCLI(logic, captureTime)
+	if stopErr := x.StopSampling(); err == nil {
+		err = stopErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var all [24]bool
+	for i := range all {
+		all[i] = true
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	if err := Convert(f, buf, int(size), all); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// frameMeans averages every channel of frames of 24 little endian int32 samples
+func frameMeans(data []byte) (means [24]float64, err error) {
+	frames := len(data) / (4 * 24)
+	if frames == 0 {
+		return means, fmt.Errorf("no samples captured")
+	}
+	for i := 0; i < frames*4*24; i += 4 {
+		means[(i/4)%24] += float64(int32(binary.LittleEndian.Uint32(data[i:])))
+	}
+	for i := range means {
+		means[i] /= float64(frames)
+	}
+	return means, nil
+}
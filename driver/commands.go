@@ -1,13 +1,9 @@
 package driver
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
 	"log"
-	"time"
 
-	flag "github.com/spf13/pflag"
 	"periph.io/x/periph/conn/gpio"
 	"periph.io/x/periph/host/bcm283x"
 )
@@ -345,7 +341,7 @@ func (adc *Adc7768) DataControl(opts DataControlOpts, cs uint8) (tx []byte, rx [
 	tx = make([]byte, 2)
 	rx = make([]byte, 2)
 
-	if opts.Write {
+	if !opts.Write {
 		h = h | 0x80
 	}
 
@@ -831,6 +827,14 @@ func (adc *Adc7768) NegativeRefPrechargeBuf(opts ReferencePrechargeBufOpts, cs u
 	return tx, rx, err
 }
 
+// validChannel checks ch is one of the 8 channels of an ADC
+func validChannel(ch uint8) error {
+	if ch > 7 {
+		return fmt.Errorf("invalid channel %d. expected 0 to 7", ch)
+	}
+	return nil
+}
+
 type ChannelOffsetOpts struct {
 	Write   bool
 	Channel uint8
@@ -839,6 +843,10 @@ type ChannelOffsetOpts struct {
 	Offset [3]uint8
 }
 
+func (opts ChannelOffsetOpts) Validate() error {
+	return validChannel(opts.Channel)
+}
+
 func (adc Adc7768) ChannelOffset(opts ChannelOffsetOpts, cs uint8, debug bool) (err error) {
 	var h uint8
 	tx := make([]byte, 2)
@@ -847,8 +855,8 @@ func (adc Adc7768) ChannelOffset(opts ChannelOffsetOpts, cs uint8, debug bool) (
 	if !opts.Write {
 		h = h | 0x80
 	}
-	if opts.Channel < 0 || opts.Channel > 7 {
-		return fmt.Errorf("invalid channel: %s", err)
+	if err := validChannel(opts.Channel); err != nil {
+		return err
 	}
 
 	register := Ch0OffsetMSB + (opts.Channel * 3)
@@ -878,6 +886,10 @@ type ChannelGainOpts struct {
 	Offset [3]uint8
 }
 
+func (opts ChannelGainOpts) Validate() error {
+	return validChannel(opts.Channel)
+}
+
 func (adc Adc7768) ChannelGain(opts ChannelGainOpts, cs uint8, debug bool) (rx []byte, err error) {
 	var h uint8
 	tx := make([]byte, 2)
@@ -886,8 +898,8 @@ func (adc Adc7768) ChannelGain(opts ChannelGainOpts, cs uint8, debug bool) (rx [
 	if !opts.Write {
 		h = h | 0x80
 	}
-	if opts.Channel < 0 || opts.Channel > 7 {
-		return nil, fmt.Errorf("invalid channel: %s", err)
+	if err := validChannel(opts.Channel); err != nil {
+		return nil, err
 	}
 
 	register := Ch0GainMSB + (opts.Channel * 3)
@@ -916,6 +928,10 @@ type ChannelSyncOffsetOpts struct {
 	Offset  uint8
 }
 
+func (opts ChannelSyncOffsetOpts) Validate() error {
+	return validChannel(opts.Channel)
+}
+
 func (adc Adc7768) ChannelSyncOffset(opts ChannelSyncOffsetOpts, cs uint8) (err error) {
 	var h uint8
 	tx := make([]byte, 2)
@@ -924,8 +940,8 @@ func (adc Adc7768) ChannelSyncOffset(opts ChannelSyncOffsetOpts, cs uint8) (err
 	if !opts.Write {
 		h = h | 0x80
 	}
-	if opts.Channel < 0 || opts.Channel > 7 {
-		return fmt.Errorf("invalid channel: %s", err)
+	if err := validChannel(opts.Channel); err != nil {
+		return err
 	}
 
 	h |= Ch0SyncOffset + opts.Channel
@@ -1133,104 +1149,6 @@ func (adc *Adc7768) ChopControl(opts ChopControlOpts, cs uint8) (tx []byte, rx [
 	return tx, rx, err
 }
 
-func (adc *Adc7768) HardReset(_ *flag.FlagSet) (_ []byte, _ []byte, err error) {
-	panic("this should be implemented")
-	//r := raspi.NewAdaptor()
-	//pin := gpio.NewDirectPinDriver(r, "22")
-	//
-	//_ = pin.DigitalWrite(0)
-	//time.Sleep(3 * time.Second)
-	//_ = pin.DigitalWrite(1)
-	//if err := pin.Halt(); err != nil {
-	//	log.Println(err)
-	//	return nil, nil, err
-	//}
-	//return nil, nil, nil
-}
-
-func (adc *Adc7768) CilabrateChOffset(logicString string, debug bool) {
-	const MSBMask uint32 = 0x00ff0000
-	const MidMask uint32 = 0x0000ff00
-	const LSBMask uint32 = 0x000000ff
-	gainOpts := ChannelGainOpts{Write: true}
-	for i := 0; i < 24; i++ {
-		gainOpts.Channel = uint8(i) % 8
-		val := uint32(1000 * 1000)
-		gainOpts.Offset[0] = uint8((val & MSBMask) >> 16)
-		gainOpts.Offset[1] = uint8((val & MidMask) >> 8)
-		gainOpts.Offset[2] = uint8(val & LSBMask)
-		adc.ChannelGain(gainOpts, uint8(i/8)+1, debug)
-		time.Sleep(100 * time.Millisecond)
-	}
-
-	offsetOpts := ChannelOffsetOpts{Write: true}
-	for i := 0; i < 24; i++ {
-		offsetOpts.Channel = uint8(i) % 8
-		adc.ChannelOffset(offsetOpts, uint8(i/8)+1, debug)
-		time.Sleep(100 * time.Millisecond)
-	}
-
-	ChOpts := ChStandbyOpts{
-		Write:    true,
-		Channels: [8]bool{},
-	}
-	enabledCh := [24]bool{}
-	for i := 0; i < 24; i++ {
-		enabledCh[i] = true
-		ChOpts.Channels[i%8] = false
-		if i%8 == 7 {
-			if i < 8 {
-				ChOpts.Channels[0] = false
-			}
-			log.Println(ChOpts, uint8(i/8)+1)
-			adc.ChStandby(ChOpts, uint8(i/8)+1)
-			ChOpts.Channels = [8]bool{}
-			time.Sleep(100 * time.Millisecond)
-		}
-	}
-
-	SamplingStart(adc.Connection())
-
-	file1, size, err := ExecSigrokCLI(logicString, 1024)
-	if err != nil {
-		log.Printf("failed to record data: %v", err)
-		return
-	}
-	defer file1.Close()
-
-	buf := bytes.NewBuffer(make([]byte, 0, 1024*24*4))
-	Convert(file1, buf, int(size), enabledCh)
-	file1.Close()
-
-	SamplingEnd(adc.Connection())
-
-	total := make([]int, 24)
-	data := buf.Bytes()
-	for i := 0; i < len(data); i += 4 * 24 {
-		for j := 0; j < 24 && i+4+j*4 < len(data); j++ {
-			offset := i + j*4
-			total[j] += int(int32(binary.LittleEndian.Uint32([]byte{data[offset], data[offset+1], data[offset+2], data[offset+3]})))
-		}
-	}
-	for i := 0; i < len(total); i++ {
-		total[i] = total[i] / 1024
-	}
-
-	log.Println(total)
-
-	offsetOpts = ChannelOffsetOpts{Write: true}
-	for i := 0; i < 24; i++ {
-		offsetOpts.Channel = uint8(i) % 8
-		val := uint32(int32(float32(total[i]) * 4.2))
-		offsetOpts.Offset[0] = uint8((val & MSBMask) >> 16)
-		offsetOpts.Offset[1] = uint8((val & MidMask) >> 8)
-		offsetOpts.Offset[2] = uint8(val & LSBMask)
-		log.Println(offsetOpts.Offset)
-		adc.ChannelOffset(offsetOpts, uint8(i/8)+1, debug)
-		time.Sleep(100 * time.Millisecond)
-	}
-}
-
 func SendSyncSignal() {
 	bcm283x.GPIO7.FastOut(gpio.Low)
 	bcm283x.GPIO7.FastOut(gpio.High)
@@ -0,0 +1,22 @@
+package driver
+
+import (
+	"gobot.io/x/gobot/drivers/spi"
+	"periph.io/x/periph/conn/gpio"
+)
+
+// NewTestAdc is an Adc7768 on conn. selected is called with the chip select
+// driven low before every transfer instead of the pins of the Raspberry Pi.
+func NewTestAdc(conn spi.Connection, selected func(cs uint8)) *Adc7768 {
+	driveChipSelect = func(cs uint8, l gpio.Level) {
+		if l == gpio.Low {
+			selected(cs)
+		}
+	}
+	return &Adc7768{connection: conn}
+}
+
+// PrepareCapture loads the registers of the self test captures
+func (adc *Adc7768) PrepareCapture() (func() error, error) {
+	return adc.prepareCapture()
+}
@@ -8,9 +8,9 @@ import (
 	"log"
 	"os"
 	"os/exec"
-	"os/signal"
 	"path"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/MShoaei/quakeADC/driver/usb"
@@ -39,12 +39,15 @@ const (
 	logic1DataOut5Mask
 )
 
+// LogicSampleRate is the sample rate of the logic analyzer capture in Hz. Every sample is one byte.
+const LogicSampleRate = 24000000
+
 func execSigrokCLI(dstPath string, logicConnDigit string, duration int) error {
 	var d int
 	d, _ = strconv.Atoi(logicConnDigit)
 	c1 := exec.Command(
 		"sigrok-cli",
-		"--driver=fx2lafw:conn=1."+strconv.Itoa(d), "-O", "binary", "-D", "--time", strconv.Itoa(duration), "-o", dstPath, "--config", "samplerate=24m")
+		"--driver=fx2lafw:conn=1."+strconv.Itoa(d), "-O", "binary", "-D", "--time", strconv.Itoa(duration), "-o", dstPath, "--config", "samplerate="+strconv.Itoa(LogicSampleRate))
 
 	log.Println(c1.String())
 
@@ -68,102 +71,109 @@ func ExecSigrokCLI(logicConnDigit string, duration int) (io.ReadCloser, int64, e
 	}
 
 	stat, _ := f.Stat()
+	atomic.AddUint64(&stats.CapturedBytes, uint64(stat.Size()))
 
 	return f, stat.Size(), err
 }
 
-var (
-	buffer1 = new(bytes.Buffer)
-)
-
-const k float32 = 0.00000048828125 * 1e6 // (4.096/2^23)*1e6
+// VoltsPerCount is the weight of one LSB of the 24-bit ADC output with the 4.096V reference
+const VoltsPerCount = 4.096 / (1 << 23)
 
-func Convert(reader1 io.Reader, writer io.Writer, size int, channels [24]bool) {
-	interruptChan := make(chan os.Signal, 1)
-	signal.Notify(interruptChan, os.Interrupt)
-	go func() {
-		for sig := range interruptChan {
-			log.Println(sig)
-			os.Exit(1)
-		}
-	}()
+const k float32 = VoltsPerCount * 1e6 // (4.096/2^23)*1e6
 
-	defer func() {
-		err := recover()
-		if err != nil {
-			log.Printf("ignoring panic: %v", err)
-		}
-	}()
-
-	if reader1 != nil {
-		buffer1.Reset()
-		buffer1.Grow(int(size))
+// Convert decodes the logic analyzer capture in r and writes a frame of the
+// enabled channels to w for every sample. size is the expected size of the
+// capture. A frame cut by the end of the capture is dropped. It returns the
+// first error of r or w.
+func Convert(r io.Reader, w io.Writer, size int, channels [24]bool) error {
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	if _, err := buf.ReadFrom(r); err != nil {
+		return fmt.Errorf("failed to read capture: %v", err)
 	}
-
-	var line []byte
-
-	buffer1.ReadFrom(reader1)
-
-	bytes1 := buffer1.Bytes()
+	b := buf.Bytes()
 
 	enChannels := onlyEnabledChannels(channels)
-	line = make([]byte, len(enChannels)*4, len(enChannels)*4)
-
-	for i := 0; i < len(bytes1)-1; i++ {
-		if bytes1[i]&logic1DataReadyMask == 128 && bytes1[i+1]&logic1DataReadyMask == 0 {
-
-			data := make([]uint32, 24, 24)
-
-			for dataColumn := 0; dataColumn < 4; dataColumn++ {
-				for j := 0; j < 8; {
-					if bytes1[i]&logic1DataClockMask == 64 && bytes1[i+1]&logic1DataClockMask == 0 {
-						j++
-					}
-					i++
-				}
-
-				for bytes1[i]&logic1DataClockMask != 64 || bytes1[i+1]&logic1DataClockMask != 0 {
-					i++
-				}
-				offset := dataColumn * 6
-				if bytes1[i+1]&logic1DataOut0Mask == 16 {
-					data[0+offset] = 255 << 24
-				}
-				if bytes1[i+1]&logic1DataOut1Mask == 32 {
-					data[1+offset] = 255 << 24
-				}
-				if bytes1[i+1]&logic1DataOut2Mask == 2 {
-					data[2+offset] = 255 << 24
-				}
-				if bytes1[i+1]&logic1DataOut3Mask == 4 {
-					data[3+offset] = 255 << 24
-				}
-				if bytes1[i+1]&logic1DataOut4Mask == 8 {
-					data[4+offset] = 255 << 24
-				}
-				if bytes1[i+1]&logic1DataOut5Mask == 1 {
-					data[5+offset] = 255 << 24
-				}
-				for counter := 23; counter >= 0; counter-- {
-					for bytes1[i]&logic1DataClockMask != 64 || bytes1[i+1]&logic1DataClockMask != 0 {
-						i++
-					}
-					data[0+offset] |= uint32(bytes1[i]&logic1DataOut0Mask) >> 4 << counter
-					data[1+offset] |= uint32(bytes1[i]&logic1DataOut1Mask) >> 5 << counter
-					data[2+offset] |= uint32(bytes1[i]&logic1DataOut2Mask) >> 1 << counter
-					data[3+offset] |= uint32(bytes1[i]&logic1DataOut3Mask) >> 2 << counter
-					data[4+offset] |= uint32(bytes1[i]&logic1DataOut4Mask) >> 3 << counter
-					data[5+offset] |= uint32(bytes1[i]&logic1DataOut5Mask) >> 0 << counter
-					i++
-				}
+	line := make([]byte, len(enChannels)*4)
+	var data [24]uint32
+	for i := 0; i < len(b)-1; i++ {
+		if b[i]&logic1DataReadyMask == 128 && b[i+1]&logic1DataReadyMask == 0 {
+			var ok bool
+			if i, ok = decodeFrame(b, i, &data); !ok {
+				atomic.AddUint64(&stats.DroppedFrames, 1)
+				return nil
 			}
 			for index, value := range enChannels {
 				// binary.LittleEndian.PutUint32(line[index*4:], uint32(int32(float32(int32(data[(value*6)%24+(value/4)]))*k)))
 				binary.LittleEndian.PutUint32(line[index*4:], uint32(int32(int32(data[(value*6)%24+(value/4)]))))
 			}
-			writer.Write(line)
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decodeFrame decodes the 24 samples of the frame whose data ready edge is at
+// b[i] into data. It returns the index after the last bit and false when the
+// capture ends within the frame.
+func decodeFrame(b []byte, i int, data *[24]uint32) (int, bool) {
+	// clock reports whether the data clock falls after b[i]
+	clock := func(i int) bool {
+		return b[i]&logic1DataClockMask == 64 && b[i+1]&logic1DataClockMask == 0
+	}
+	for dataColumn := 0; dataColumn < 4; dataColumn++ {
+		for j := 0; j < 8; i++ {
+			if i+1 >= len(b) {
+				return i, false
+			}
+			if clock(i) {
+				j++
+			}
+		}
+		for ; i+1 < len(b) && !clock(i); i++ {
+		}
+		if i+1 >= len(b) {
+			return i, false
+		}
+		offset := dataColumn * 6
+		for ch := 0; ch < 6; ch++ {
+			data[ch+offset] = 0
+		}
+		if b[i+1]&logic1DataOut0Mask == 16 {
+			data[0+offset] = 255 << 24
+		}
+		if b[i+1]&logic1DataOut1Mask == 32 {
+			data[1+offset] = 255 << 24
+		}
+		if b[i+1]&logic1DataOut2Mask == 2 {
+			data[2+offset] = 255 << 24
+		}
+		if b[i+1]&logic1DataOut3Mask == 4 {
+			data[3+offset] = 255 << 24
+		}
+		if b[i+1]&logic1DataOut4Mask == 8 {
+			data[4+offset] = 255 << 24
+		}
+		if b[i+1]&logic1DataOut5Mask == 1 {
+			data[5+offset] = 255 << 24
+		}
+		for counter := 23; counter >= 0; counter-- {
+			for ; i+1 < len(b) && !clock(i); i++ {
+			}
+			if i+1 >= len(b) {
+				return i, false
+			}
+			data[0+offset] |= uint32(b[i]&logic1DataOut0Mask) >> 4 << counter
+			data[1+offset] |= uint32(b[i]&logic1DataOut1Mask) >> 5 << counter
+			data[2+offset] |= uint32(b[i]&logic1DataOut2Mask) >> 1 << counter
+			data[3+offset] |= uint32(b[i]&logic1DataOut3Mask) >> 2 << counter
+			data[4+offset] |= uint32(b[i]&logic1DataOut4Mask) >> 3 << counter
+			data[5+offset] |= uint32(b[i]&logic1DataOut5Mask) >> 0 << counter
+			i++
 		}
 	}
+	return i, true
 }
 
 func onlyEnabledChannels(channels [24]bool) []int {
@@ -178,10 +188,11 @@ func onlyEnabledChannels(channels [24]bool) []int {
 
 const maxPacketSize int = 512
 
-func MonitorLive(w io.WriteCloser, samples int) {
+// MonitorLive streams samples of the logic analyzer converted to w
+func MonitorLive(w io.WriteCloser, samples int) error {
 	streamConnection, err := usb.NewReadStream()
 	if err != nil {
-		log.Fatalf("failed to create ReadStream: %v", err)
+		return fmt.Errorf("failed to create ReadStream: %v", err)
 	}
 	defer streamConnection.Close()
 
@@ -193,9 +204,10 @@ func MonitorLive(w io.WriteCloser, samples int) {
 	i := 0
 	start := time.Now()
 	for i < size {
-		_, err := stream.Read(buf[i*maxPacketSize : (i+1)*maxPacketSize])
+		n, err := stream.Read(buf[i*maxPacketSize : (i+1)*maxPacketSize])
+		countStreamRead(n, err)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("failed to read stream: %v", err)
 		}
 		if i == 0 {
 			liveConvert(w, buf[i*maxPacketSize:(i+1)*maxPacketSize])
@@ -207,6 +219,7 @@ func MonitorLive(w io.WriteCloser, samples int) {
 	f, _ := os.Create("../testStream.raw")
 	f.Write(buf)
 	log.Println(time.Since(start))
+	return nil
 }
 
 var clkCounter = -1
@@ -3,6 +3,7 @@ package driver
 import (
 	"fmt"
 	"log"
+	"sync/atomic"
 	"time"
 
 	"github.com/MShoaei/quakeADC/driver/usb"
@@ -13,10 +14,13 @@ var (
 	tempBuf  = make([]byte, tempSize*maxPacketSize, tempSize*maxPacketSize)
 )
 
-func ReadWithThreshold(threshold int, duration int, channel int) []byte {
+// ReadWithThreshold waits up to 30 seconds for channel to reach threshold
+// and records duration seconds from then on. The result is nil when the
+// threshold was not reached.
+func ReadWithThreshold(threshold int, duration int, channel int) ([]byte, error) {
 	streamConnection, err := usb.NewReadStream()
 	if err != nil {
-		log.Fatalf("failed to create ReadStream: %v", err)
+		return nil, fmt.Errorf("failed to create ReadStream: %v", err)
 	}
 	defer streamConnection.Close()
 
@@ -54,9 +58,10 @@ func ReadWithThreshold(threshold int, duration int, channel int) []byte {
 	// threshold = int(int32(float32(threshold) / k))
 	log.Println(int(int32(threshold)))
 	for i < tempSize-1 {
-		_, err := stream.Read(tempBuf[i*maxPacketSize : (i+1)*maxPacketSize])
+		n, err := stream.Read(tempBuf[i*maxPacketSize : (i+1)*maxPacketSize])
+		countStreamRead(n, err)
 		if err != nil {
-			log.Fatal(err)
+			return nil, fmt.Errorf("failed to read stream: %v", err)
 		}
 		if i == 0 {
 			if checkThreshold(tempBuf[i*maxPacketSize:(i+1)*maxPacketSize], threshold, mask) {
@@ -74,21 +79,19 @@ func ReadWithThreshold(threshold int, duration int, channel int) []byte {
 
 	if !thresholdReached {
 		// f.Write(tempBuf)
-		return nil
+		return nil, nil
 	}
 
 	// stream, err = epIn.NewStream(512*10, 1000)
-	if err != nil {
-		log.Fatalf("failed to create ReadStream: %v", err)
-	}
 
 	i = 0
 	start := time.Now()
 	fmt.Println(start)
 	for i < size {
-		_, err := stream.Read(buf[i*maxPacketSize : (i+1)*maxPacketSize])
+		n, err := stream.Read(buf[i*maxPacketSize : (i+1)*maxPacketSize])
+		countStreamRead(n, err)
 		if err != nil {
-			log.Fatal(err)
+			return nil, fmt.Errorf("failed to read stream: %v", err)
 		}
 		// if i == 0 {
 		// 	liveConvert(w, buf[i*maxPacketSize:(i+1)*maxPacketSize])
@@ -99,7 +102,8 @@ func ReadWithThreshold(threshold int, duration int, channel int) []byte {
 	}
 	fmt.Println(time.Since(start))
 	stream.Close()
-	return buf
+	atomic.AddUint64(&stats.CapturedBytes, uint64(len(buf)))
+	return buf, nil
 }
 
 // var f, _ = os.Create("/home/pi/Desktop/threshold2.raw")
@@ -0,0 +1,72 @@
+package driver_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/MShoaei/quakeADC/driver"
+)
+
+// outBits are the bits of the six data outputs in a capture byte
+var outBits = [6]byte{0x10, 0x20, 0x02, 0x04, 0x08, 0x01}
+
+// encodeFrame is the capture of a frame of 24 samples indexed as decoded
+func encodeFrame(samples [24]int32) []byte {
+	res := []byte{0x80, 0x00}
+	for column := 0; column < 4; column++ {
+		for i := 0; i < 8; i++ {
+			res = append(res, 0x40, 0x00)
+		}
+		for bit := 23; bit >= 0; bit-- {
+			var b byte
+			for out, mask := range outBits {
+				if samples[column*6+out]>>uint(bit)&1 == 1 {
+					b |= mask
+				}
+			}
+			res = append(res, 0x40|b, b)
+		}
+	}
+	return res
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk error")
+}
+
+func TestConvert(t *testing.T) {
+	var samples [24]int32
+	for i := range samples {
+		samples[i] = int32(i*1000 - 5000)
+	}
+	frame := encodeFrame(samples)
+	capture := append(append(append([]byte{}, frame...), frame...), frame[:len(frame)/2]...)
+	var channels [24]bool
+	channels[0], channels[5], channels[23] = true, true, true
+
+	dropped := driver.ReadStats().DroppedFrames
+	var out bytes.Buffer
+	if err := driver.Convert(bytes.NewReader(capture), &out, len(capture), channels); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+	if out.Len() != 2*3*4 {
+		t.Fatalf("converted %d bytes, want 2 frames of 3 channels", out.Len())
+	}
+	for i, ch := range []int{0, 5, 23, 0, 5, 23} {
+		want := samples[(ch*6)%24+ch/4]
+		if got := int32(binary.LittleEndian.Uint32(out.Bytes()[i*4:])); got != want {
+			t.Errorf("sample %d of channel %d = %d, want %d", i/3, ch+1, got, want)
+		}
+	}
+	if got := driver.ReadStats().DroppedFrames - dropped; got != 1 {
+		t.Errorf("dropped frames = %d, want 1", got)
+	}
+
+	if err := driver.Convert(bytes.NewReader(frame), failingWriter{}, len(frame), channels); err == nil {
+		t.Errorf("Convert() to a failing writer succeeded")
+	}
+}
@@ -0,0 +1,112 @@
+package driver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// ResetResponse is the response of a chip to the first command after a soft reset
+const ResetResponse uint16 = 0x0e00
+
+// hardResetDelay is the wait after the reset pulse before the chips are accessed
+const hardResetDelay = 10 * time.Millisecond
+
+// ResetMode selects how ResetChips resets the chips
+type ResetMode string
+
+const (
+	// ResetSoft writes the SPI_RESET sequence to every chip
+	ResetSoft ResetMode = "soft"
+
+	// ResetHard makes the XMega pulse the RESET input of the ADCs. The line
+	// is shared by the nine chips and the Raspberry Pi has no GPIO wired to
+	// the RESET input of a single chip, so every chip is reset.
+	ResetHard ResetMode = "hard"
+)
+
+// ResetResult is the reset of a single chip
+type ResetResult struct {
+	Chip uint8 `json:"chip"`
+
+	// Response is the response to the first command after a soft reset
+	Response uint16   `json:"response,omitempty"`
+	Revision uint8    `json:"revision"`
+	Status   uint8    `json:"status"`
+	Pass     bool     `json:"pass"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// SoftReset writes the two successive SPI_RESET commands to the chip cs and
+// returns the response to the next command. It is ResetResponse when the
+// chip was reset.
+func (adc *Adc7768) SoftReset(cs uint8) (uint16, error) {
+	for _, step := range []uint8{0x03, 0x02} {
+		if err := adc.Write([]byte{DataControl, step}, cs); err != nil {
+			return 0, fmt.Errorf("write error: %s", err)
+		}
+	}
+	// the response to the first command after the reset comes on the next frame
+	if err := adc.Write([]byte{0x80 | DeviceStatus, 0}, cs); err != nil {
+		return 0, fmt.Errorf("write error: %s", err)
+	}
+	rx := make([]byte, 2)
+	if err := adc.Read(rx, cs); err != nil {
+		return 0, fmt.Errorf("read error: %s", err)
+	}
+	return binary.BigEndian.Uint16(rx), nil
+}
+
+// ResetChips resets the chips and checks their revision and status. Empty
+// chips resets 1 to 9. A hard reset must include every chip and is made by x.
+func (adc *Adc7768) ResetChips(x XMegaController, mode ResetMode, chips []uint8) ([]ResetResult, error) {
+	if len(chips) == 0 {
+		chips = []uint8{1, 2, 3, 4, 5, 6, 7, 8, 9}
+	}
+	switch mode {
+	case ResetSoft:
+	case ResetHard:
+		if len(chips) != 9 {
+			return nil, fmt.Errorf("a hard reset resets every chip because the ADCs share the reset line. use a soft reset for single chips")
+		}
+		if x == nil {
+			return nil, fmt.Errorf("a hard reset needs the xmega")
+		}
+		if err := x.ResetADCs(); err != nil {
+			return nil, fmt.Errorf("hard reset failed: %v", err)
+		}
+		time.Sleep(hardResetDelay)
+	default:
+		return nil, fmt.Errorf("invalid reset mode %q. expected %s or %s", mode, ResetSoft, ResetHard)
+	}
+
+	results := make([]ResetResult, 0, len(chips))
+	for _, cs := range chips {
+		res := ResetResult{Chip: cs}
+		fail := func(format string, args ...interface{}) {
+			res.Errors = append(res.Errors, fmt.Sprintf(format, args...))
+		}
+		if mode == ResetSoft {
+			var err error
+			if res.Response, err = adc.SoftReset(cs); err != nil {
+				fail("soft reset: %v", err)
+			} else if res.Response != ResetResponse {
+				fail("response after reset is 0x%04x, expected 0x%04x", res.Response, ResetResponse)
+			}
+		}
+		var err error
+		if res.Revision, err = adc.ReadRevision(cs); err != nil {
+			fail("failed to read revision: %v", err)
+		} else if res.Revision != ExpectedRevisionID {
+			fail("revision is 0x%02x, expected 0x%02x", res.Revision, ExpectedRevisionID)
+		}
+		if res.Status, err = adc.ReadStatus(cs); err != nil {
+			fail("failed to read status: %v", err)
+		} else if res.Status&StatusChipError != 0 {
+			fail("chip error")
+		}
+		res.Pass = len(res.Errors) == 0
+		results = append(results, res)
+	}
+	return results, nil
+}
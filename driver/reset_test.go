@@ -0,0 +1,149 @@
+package driver_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/MShoaei/quakeADC/driver"
+)
+
+// fakeChip answers like an AD7768: the response to a command is sent
+// during the next transfer
+type fakeChip struct {
+	regs  map[uint8]uint8
+	resp  [2]byte
+	step  uint8
+	reset bool
+
+	// stuck chips ignore the soft reset
+	stuck bool
+}
+
+func newFakeChip() *fakeChip {
+	return &fakeChip{regs: map[uint8]uint8{driver.RevisionID: driver.ExpectedRevisionID}}
+}
+
+func (c *fakeChip) tx(w, r []byte) {
+	if r != nil {
+		copy(r, c.resp[:])
+	}
+	if c.reset {
+		// the response to the first command after a reset
+		c.reset = false
+		c.resp = [2]byte{0x0e, 0x00}
+		return
+	}
+	addr := w[0] &^ 0x80
+	if w[0]&0x80 != 0 {
+		c.resp = [2]byte{w[0], c.regs[addr]}
+		c.step = 0
+		return
+	}
+	c.resp = [2]byte{}
+	c.regs[addr] = w[1]
+	if addr != driver.DataControl || c.stuck {
+		// the reset sequence is two successive commands
+		c.step = 0
+		return
+	}
+	step := w[1] & 0x03
+	if c.step == 3 && step == 2 {
+		c.reset = true
+	}
+	c.step = step
+}
+
+// fakeBus is the SPI bus of the XMega (chip select 0) and the ADCs
+type fakeBus struct {
+	chips    map[uint8]*fakeChip
+	selected uint8
+	xmega    [][]byte
+}
+
+func (b *fakeBus) Tx(w, r []byte) error {
+	if b.selected == driver.XMegaChipSelect {
+		b.xmega = append(b.xmega, append([]byte(nil), w...))
+		return nil
+	}
+	b.chips[b.selected].tx(w, r)
+	return nil
+}
+
+func (b *fakeBus) Close() error {
+	return nil
+}
+
+func newFakeBus() (*fakeBus, *driver.Adc7768) {
+	b := &fakeBus{chips: map[uint8]*fakeChip{}}
+	for cs := uint8(1); cs <= 9; cs++ {
+		b.chips[cs] = newFakeChip()
+	}
+	return b, driver.NewTestAdc(b, func(cs uint8) { b.selected = cs })
+}
+
+func TestSoftReset(t *testing.T) {
+	b, adc := newFakeBus()
+	b.chips[2].stuck = true
+	if got, err := adc.SoftReset(1); err != nil || got != driver.ResetResponse {
+		t.Errorf("SoftReset(1) = 0x%04x, %v, want 0x%04x", got, err, driver.ResetResponse)
+	}
+	if got, err := adc.SoftReset(2); err != nil || got == driver.ResetResponse {
+		t.Errorf("SoftReset(2) of a stuck chip = 0x%04x, %v", got, err)
+	}
+	if _, err := adc.SoftReset(10); err == nil {
+		t.Errorf("SoftReset(10) succeeded")
+	}
+}
+
+func TestResetChips(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      driver.ResetMode
+		chips     []uint8
+		stuck     uint8
+		status    uint8
+		wantErr   bool
+		wantChips []uint8
+		wantFail  []uint8
+		wantXMega bool
+	}{
+		{name: "soft", mode: driver.ResetSoft, chips: []uint8{3, 5}, wantChips: []uint8{3, 5}},
+		{name: "soft every chip", mode: driver.ResetSoft, stuck: 4, wantChips: []uint8{1, 2, 3, 4, 5, 6, 7, 8, 9}, wantFail: []uint8{4}},
+		{name: "chip error", mode: driver.ResetSoft, chips: []uint8{6}, status: driver.StatusChipError, wantChips: []uint8{6}, wantFail: []uint8{6}},
+		{name: "hard", mode: driver.ResetHard, stuck: 4, wantChips: []uint8{1, 2, 3, 4, 5, 6, 7, 8, 9}, wantXMega: true},
+		{name: "hard single chip", mode: driver.ResetHard, chips: []uint8{1}, wantErr: true},
+		{name: "unknown mode", mode: "warm", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, adc := newFakeBus()
+			if tt.stuck != 0 {
+				b.chips[tt.stuck].stuck = true
+			}
+			for _, cs := range tt.wantFail {
+				b.chips[cs].regs[driver.DeviceStatus] = tt.status
+			}
+			results, err := adc.ResetChips(driver.NewXMega(b), tt.mode, tt.chips)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResetChips() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var chips, failed []uint8
+			for _, r := range results {
+				chips = append(chips, r.Chip)
+				if !r.Pass {
+					failed = append(failed, r.Chip)
+				}
+			}
+			if !reflect.DeepEqual(chips, tt.wantChips) || !reflect.DeepEqual(failed, tt.wantFail) {
+				t.Errorf("reset chips %v and failed %v, want %v and %v", chips, failed, tt.wantChips, tt.wantFail)
+			}
+			reset := []byte{byte(driver.OpLogic), 0x01, 0}
+			if got := len(b.xmega) == 1 && reflect.DeepEqual(b.xmega[0], reset); got != tt.wantXMega {
+				t.Errorf("xmega frames = %v, want the ADC reset %v", b.xmega, tt.wantXMega)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package driver
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// AD7768 DEVICE_STATUS bits
+const (
+	StatusChipError      uint8 = 0x08
+	StatusNoClockError   uint8 = 0x04
+	StatusRAMBISTPass    uint8 = 0x02
+	StatusRAMBISTRunning uint8 = 0x01
+)
+
+// ExpectedRevisionID is the REVISION_ID of the AD7768
+const ExpectedRevisionID uint8 = 0x06
+
+// FullScale is the largest positive 24-bit ADC output
+const FullScale = 1<<23 - 1
+
+// DiagnosticInput is the signal the diagnostic mux routes to the channels
+type DiagnosticInput uint8
+
+// DIAGNOSTIC_MUX_CONTROL group selections. The mux has no separate reference
+// selection: the full-scale inputs connect the reference to the modulator,
+// REF+ on AIN+ and REF- on AIN- for positive full-scale and reversed for
+// negative full-scale, so they are the reference check. The other codes are
+// reserved.
+const (
+	DiagnosticOff               DiagnosticInput = 0
+	DiagnosticPositiveFullScale DiagnosticInput = 3
+	DiagnosticNegativeFullScale DiagnosticInput = 4
+	DiagnosticZeroScale         DiagnosticInput = 5
+)
+
+func (in DiagnosticInput) String() string {
+	switch in {
+	case DiagnosticOff:
+		return "off"
+	case DiagnosticPositiveFullScale:
+		return "positive full-scale"
+	case DiagnosticNegativeFullScale:
+		return "negative full-scale"
+	case DiagnosticZeroScale:
+		return "zero-scale"
+	}
+	return fmt.Sprintf("input %d", uint8(in))
+}
+
+// Expected is the output in counts of a channel measuring in
+func (in DiagnosticInput) Expected() int32 {
+	switch in {
+	case DiagnosticPositiveFullScale:
+		return FullScale
+	case DiagnosticNegativeFullScale:
+		return -FullScale - 1
+	}
+	return 0
+}
+
+// ReadStatus reads the DEVICE_STATUS register of the chip cs
+func (adc *Adc7768) ReadStatus(cs uint8) (uint8, error) {
+	_, rx, err := adc.DeviceStatus(cs)
+	if err != nil {
+		return 0, err
+	}
+	return rx[1], nil
+}
+
+// ReadRevision reads the REVISION_ID register of the chip cs
+func (adc *Adc7768) ReadRevision(cs uint8) (uint8, error) {
+	_, rx, err := adc.RevisionID(cs)
+	if err != nil {
+		return 0, err
+	}
+	return rx[1], nil
+}
+
+// RunRAMBIST runs the RAM built-in self test of the chip cs and reports whether it passed
+func (adc *Adc7768) RunRAMBIST(cs uint8) (bool, error) {
+	if _, _, err := adc.BISTControl(BISTControlOpts{Write: true, RamBISTStart: 1}, cs); err != nil {
+		return false, err
+	}
+	defer adc.BISTControl(BISTControlOpts{Write: true, RamBISTStart: 0}, cs)
+
+	// the test takes about 50µs
+	for i := 0; i < 10; i++ {
+		time.Sleep(time.Millisecond)
+		status, err := adc.ReadStatus(cs)
+		if err != nil {
+			return false, err
+		}
+		if status&StatusRAMBISTRunning == 0 {
+			return status&StatusRAMBISTPass != 0, nil
+		}
+	}
+	return false, fmt.Errorf("ram bist of chip %d did not finish", cs)
+}
+
+// SetDiagnosticInput routes in to every channel of the chip cs.
+// DiagnosticOff connects the channels to their inputs again.
+func (adc *Adc7768) SetDiagnosticInput(cs uint8, in DiagnosticInput) error {
+	rx := DiagnosticRXOpts{Write: true}
+	if in != DiagnosticOff {
+		rx.Channels = [8]uint8{1, 1, 1, 1, 1, 1, 1, 1}
+	}
+	mux := DiagnosticMuxControlOpts{Write: true, GrpaSelect: uint8(in), GrpbSelect: uint8(in)}
+	if _, _, err := adc.DiagnosticMuxControl(mux, cs); err != nil {
+		return err
+	}
+	_, _, err := adc.DiagnosticRX(rx, cs)
+	return err
+}
+
+// ChipResult is the self test of a single chip
+type ChipResult struct {
+	Chip     uint8    `json:"chip"`
+	Status   uint8    `json:"status"`
+	Revision uint8    `json:"revision"`
+	RAMBIST  bool     `json:"ramBist"`
+	Pass     bool     `json:"pass"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// ChannelResult is the mean of a channel measuring a diagnostic input
+type ChannelResult struct {
+	Channel  int     `json:"channel"`
+	Input    string  `json:"input"`
+	Expected int32   `json:"expected"`
+	Mean     float64 `json:"mean"`
+	Pass     bool    `json:"pass"`
+}
+
+// SelfTestReport is the result of SelfTest
+type SelfTestReport struct {
+	Time     time.Time       `json:"time"`
+	Pass     bool            `json:"pass"`
+	Chips    []ChipResult    `json:"chips"`
+	Channels []ChannelResult `json:"channels,omitempty"`
+
+	// Errors are failures of the capture checks
+	Errors []string `json:"errors,omitempty"`
+}
+
+// SelfTestOptions configures SelfTest
+type SelfTestOptions struct {
+	// Chips are the chip selects to test. Empty tests 1 to 9.
+	Chips []uint8
+
+	// Logic is the connection of the logic analyzer. Empty skips the capture checks.
+	Logic string
+
+	// CaptureTime of every diagnostic input in milliseconds
+	CaptureTime int
+
+	// Tolerance is the largest difference from the expected value as a fraction of full scale
+	Tolerance float64
+}
+
+// diagnosticInputs are the inputs measured by the capture checks. The
+// internal reference is checked through the full-scale inputs which read
+// FullScale and -FullScale-1 when it is right.
+var diagnosticInputs = []DiagnosticInput{DiagnosticZeroScale, DiagnosticPositiveFullScale, DiagnosticNegativeFullScale}
+
+// diagnosticGain is the reset value of the gain registers with which the
+// full-scale inputs read full scale
+const diagnosticGain = 0x555555
+
+// readRegister reads the register addr of the chip cs
+func (adc *Adc7768) readRegister(cs, addr uint8) (uint8, error) {
+	if err := adc.Write([]byte{0x80 | addr, 0}, cs); err != nil {
+		return 0, fmt.Errorf("write error: %s", err)
+	}
+	rx := make([]byte, 2)
+	if err := adc.Read(rx, cs); err != nil {
+		return 0, fmt.Errorf("read error: %s", err)
+	}
+	return rx[1], nil
+}
+
+// prepareCapture saves the standby, offset and gain registers of the chips 1
+// to 3, wakes every channel and loads zero offsets and diagnosticGain so the
+// diagnostic inputs read their expected values. restore writes the saved
+// registers back.
+func (adc *Adc7768) prepareCapture() (restore func() error, err error) {
+	addrs := []uint8{ChannelStandby}
+	for addr := Ch0OffsetMSB; addr <= Ch7GainLSB; addr++ {
+		addrs = append(addrs, addr)
+	}
+	var saved [3][]uint8
+	for cs := uint8(1); cs <= 3; cs++ {
+		for _, addr := range addrs {
+			v, err := adc.readRegister(cs, addr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to save register 0x%02x of chip %d: %v", addr, cs, err)
+			}
+			saved[cs-1] = append(saved[cs-1], v)
+		}
+	}
+	restore = func() error {
+		for cs := uint8(1); cs <= 3; cs++ {
+			for i, addr := range addrs {
+				if err := adc.Write([]byte{addr, saved[cs-1][i]}, cs); err != nil {
+					return fmt.Errorf("failed to restore register 0x%02x of chip %d: %v", addr, cs, err)
+				}
+			}
+		}
+		return nil
+	}
+
+	for cs := uint8(1); cs <= 3; cs++ {
+		if _, _, err := adc.ChStandby(ChStandbyOpts{Write: true}, cs); err != nil {
+			return restore, fmt.Errorf("failed to wake the channels of chip %d: %v", cs, err)
+		}
+	}
+	if err := adc.ApplyCalibration(DefaultCalibration(), [24]uint32{}, diagnosticGain); err != nil {
+		return restore, err
+	}
+	return restore, nil
+}
+
+// SelfTest checks the status, revision and RAM of the chips and captures the
+// diagnostic inputs on every channel with zero offsets and the reset gain. The
+// channels are connected to their inputs and their standby, offset and gain
+// registers restored afterwards.
+func (adc *Adc7768) SelfTest(x XMegaController, opts SelfTestOptions) (report SelfTestReport) {
+	if len(opts.Chips) == 0 {
+		opts.Chips = []uint8{1, 2, 3, 4, 5, 6, 7, 8, 9}
+	}
+	if opts.CaptureTime <= 0 {
+		opts.CaptureTime = 256
+	}
+	if opts.Tolerance <= 0 {
+		opts.Tolerance = 0.05
+	}
+	report = SelfTestReport{Time: time.Now(), Pass: true}
+	for _, cs := range opts.Chips {
+		res := adc.testChip(cs)
+		report.Pass = report.Pass && res.Pass
+		report.Chips = append(report.Chips, res)
+	}
+	if opts.Logic == "" {
+		return report
+	}
+
+	fail := func(format string, args ...interface{}) {
+		report.Pass = false
+		report.Errors = append(report.Errors, fmt.Sprintf(format, args...))
+	}
+	restore, err := adc.prepareCapture()
+	defer func() {
+		for cs := uint8(1); cs <= 3; cs++ {
+			if err := adc.SetDiagnosticInput(cs, DiagnosticOff); err != nil {
+				fail("failed to reconnect the inputs of chip %d: %v", cs, err)
+			}
+		}
+		if restore == nil {
+			return
+		}
+		if err := restore(); err != nil {
+			fail("%v", err)
+		}
+	}()
+	if err != nil {
+		fail("%v", err)
+		return report
+	}
+	for _, in := range diagnosticInputs {
+		means, err := adc.captureInput(x, opts.Logic, in, opts.CaptureTime)
+		if err != nil {
+			fail("%s capture: %v", in, err)
+			continue
+		}
+		for _, res := range EvaluateCapture(in, means, opts.Tolerance) {
+			report.Pass = report.Pass && res.Pass
+			report.Channels = append(report.Channels, res)
+		}
+	}
+	return report
+}
+
+func (adc *Adc7768) testChip(cs uint8) ChipResult {
+	res := ChipResult{Chip: cs}
+	fail := func(format string, args ...interface{}) {
+		res.Errors = append(res.Errors, fmt.Sprintf(format, args...))
+	}
+
+	var err error
+	if res.Revision, err = adc.ReadRevision(cs); err != nil {
+		fail("failed to read revision: %v", err)
+	} else if res.Revision != ExpectedRevisionID {
+		fail("revision is 0x%02x, expected 0x%02x", res.Revision, ExpectedRevisionID)
+	}
+	if res.RAMBIST, err = adc.RunRAMBIST(cs); err != nil {
+		fail("ram bist: %v", err)
+	} else if !res.RAMBIST {
+		fail("ram bist failed")
+	}
+	if res.Status, err = adc.ReadStatus(cs); err != nil {
+		fail("failed to read status: %v", err)
+	} else {
+		if res.Status&StatusChipError != 0 {
+			fail("chip error")
+		}
+		if res.Status&StatusNoClockError != 0 {
+			fail("no clock")
+		}
+	}
+	res.Pass = len(res.Errors) == 0
+	return res
+}
+
+// captureInput routes in to the 24 channels and returns the mean of every channel
+func (adc *Adc7768) captureInput(x XMegaController, logic string, in DiagnosticInput, captureTime int) (means [24]float64, err error) {
+	for cs := uint8(1); cs <= 3; cs++ {
+		if err := adc.SetDiagnosticInput(cs, in); err != nil {
+			return means, fmt.Errorf("chip %d: %v", cs, err)
+		}
+	}
+	return adc.captureMeans(x, logic, captureTime)
+}
+
+// EvaluateCapture checks the means of the 24 channels measuring in are within
+// tolerance of the expected value
+func EvaluateCapture(in DiagnosticInput, means [24]float64, tolerance float64) []ChannelResult {
+	expected := in.Expected()
+	res := make([]ChannelResult, len(means))
+	for i, mean := range means {
+		res[i] = ChannelResult{
+			Channel:  i + 1,
+			Input:    in.String(),
+			Expected: expected,
+			Mean:     mean,
+			Pass:     math.Abs(mean-float64(expected)) <= tolerance*FullScale,
+		}
+	}
+	return res
+}
@@ -0,0 +1,70 @@
+package driver_test
+
+import (
+	"testing"
+
+	"github.com/MShoaei/quakeADC/driver"
+)
+
+func TestEvaluateCapture(t *testing.T) {
+	tests := []struct {
+		name string
+		in   driver.DiagnosticInput
+		mean float64
+		want bool
+	}{
+		{name: "zero-scale", in: driver.DiagnosticZeroScale, mean: 120, want: true},
+		{name: "zero-scale offset", in: driver.DiagnosticZeroScale, mean: 0.1 * driver.FullScale, want: false},
+		{name: "positive full-scale", in: driver.DiagnosticPositiveFullScale, mean: 0.98 * driver.FullScale, want: true},
+		{name: "negative full-scale", in: driver.DiagnosticNegativeFullScale, mean: -0.97 * driver.FullScale, want: true},
+		{name: "stuck at zero", in: driver.DiagnosticNegativeFullScale, mean: 0, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var means [24]float64
+			for i := range means {
+				means[i] = tt.mean
+			}
+			means[5] = 0.5 * driver.FullScale
+			res := driver.EvaluateCapture(tt.in, means, 0.05)
+			if len(res) != 24 || res[0].Channel != 1 || res[0].Input != tt.in.String() {
+				t.Fatalf("EvaluateCapture = %+v", res)
+			}
+			if res[0].Pass != tt.want {
+				t.Errorf("channel 1 pass = %v, want %v", res[0].Pass, tt.want)
+			}
+			if res[5].Pass {
+				t.Errorf("channel 6 at half scale passed")
+			}
+		})
+	}
+}
+
+func TestPrepareCapture(t *testing.T) {
+	b, adc := newFakeBus()
+	for cs := uint8(1); cs <= 3; cs++ {
+		b.chips[cs].regs[driver.ChannelStandby] = 0xf0
+		b.chips[cs].regs[driver.Ch0OffsetLSB] = 0x12
+		b.chips[cs].regs[driver.Ch7GainMSB] = 0x40
+	}
+	restore, err := adc.PrepareCapture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for cs := uint8(1); cs <= 3; cs++ {
+		regs := b.chips[cs].regs
+		if regs[driver.ChannelStandby] != 0 || regs[driver.Ch0OffsetLSB] != 0 || regs[driver.Ch7GainMSB] != 0x55 || regs[driver.Ch7GainLSB] != 0x55 {
+			t.Errorf("chip %d standby 0x%02x, offset 0x%02x and gain 0x%02x%02x, want awake with no offset and gain 0x55..55",
+				cs, regs[driver.ChannelStandby], regs[driver.Ch0OffsetLSB], regs[driver.Ch7GainMSB], regs[driver.Ch7GainLSB])
+		}
+	}
+	if err := restore(); err != nil {
+		t.Fatal(err)
+	}
+	for cs := uint8(1); cs <= 3; cs++ {
+		regs := b.chips[cs].regs
+		if regs[driver.ChannelStandby] != 0xf0 || regs[driver.Ch0OffsetLSB] != 0x12 || regs[driver.Ch7GainMSB] != 0x40 || regs[driver.Ch7GainLSB] != 0 {
+			t.Errorf("chip %d registers were not restored", cs)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package driver
 
 import (
 	"fmt"
+	"sync"
 
 	"periph.io/x/periph/conn/gpio"
 	"periph.io/x/periph/host/bcm283x"
@@ -9,11 +10,25 @@ import (
 
 var chipSelectPins []*bcm283x.Pin
 
+// driveChipSelect sets the level of the chip select cs. Tests replace it
+// because the pins only exist on the Raspberry Pi.
+var driveChipSelect = func(cs uint8, l gpio.Level) {
+	chipSelectPins[cs].FastOut(l)
+}
+
+// busMu serialises the transfers on the SPI bus shared by the XMega and the
+// ADCs. A chip select is only driven while it is held.
+var busMu sync.Mutex
+
+// hostErr is the failure to set up the GPIO pins of the host. Connections
+// can not be made after it.
+var hostErr error
+
 func EnableChipSelect(chip uint8) error {
 	if chip < 0 || chip > 9 {
 		return fmt.Errorf("invalid chip value %d", chip)
 	}
-	chipSelectPins[chip].FastOut(gpio.Low)
+	driveChipSelect(chip, gpio.Low)
 	return nil
 }
 
@@ -21,6 +36,6 @@ func DisableChipSelect(chip uint8) error {
 	if chip < 0 || chip > 9 {
 		return fmt.Errorf("invalid chip value %d", chip)
 	}
-	chipSelectPins[chip].FastOut(gpio.High)
+	driveChipSelect(chip, gpio.High)
 	return nil
 }
@@ -1,7 +1,7 @@
 package driver
 
 import (
-	"log"
+	"fmt"
 
 	"periph.io/x/periph/conn/gpio"
 	"periph.io/x/periph/host"
@@ -10,7 +10,8 @@ import (
 
 func init() {
 	if _, err := host.Init(); err != nil {
-		log.Fatalf("failed to initialize periph: %v", err)
+		hostErr = fmt.Errorf("failed to initialize periph: %v", err)
+		return
 	}
 
 	chipSelectPins = []*bcm283x.Pin{
@@ -26,14 +27,15 @@ func init() {
 		bcm283x.GPIO17, // Pin 11
 	}
 
-	for _, c := range chipSelectPins {
+	for i, c := range chipSelectPins {
 		if err := c.SetFunc(gpio.OUT_HIGH); err != nil {
-			log.Fatal(err)
+			hostErr = fmt.Errorf("failed to set up chip select %d: %v", i, err)
+			return
 		}
 	}
 
 	err := bcm283x.GPIO7.SetFunc(gpio.OUT_HIGH) // Pin 26 for ADC Sync
 	if err != nil {
-		log.Fatalln(err)
+		hostErr = fmt.Errorf("failed to set up the sync pin: %v", err)
 	}
 }
@@ -0,0 +1,39 @@
+package driver
+
+import "sync/atomic"
+
+// Stats are counters of the acquisition since the program started
+type Stats struct {
+	// CapturedBytes is the size of the logic analyzer captures
+	CapturedBytes uint64
+
+	// DroppedFrames is the number of frames cut by the end of a capture
+	DroppedFrames uint64
+
+	// StreamBytes is the data read from the USB stream of the logic analyzer
+	StreamBytes uint64
+
+	// StreamUnderruns is the number of reads of the USB stream which failed
+	// or returned less than a packet
+	StreamUnderruns uint64
+}
+
+var stats Stats
+
+// ReadStats returns the acquisition counters
+func ReadStats() Stats {
+	return Stats{
+		CapturedBytes:   atomic.LoadUint64(&stats.CapturedBytes),
+		DroppedFrames:   atomic.LoadUint64(&stats.DroppedFrames),
+		StreamBytes:     atomic.LoadUint64(&stats.StreamBytes),
+		StreamUnderruns: atomic.LoadUint64(&stats.StreamUnderruns),
+	}
+}
+
+// countStreamRead adds a read of n bytes of a packet from the USB stream
+func countStreamRead(n int, err error) {
+	atomic.AddUint64(&stats.StreamBytes, uint64(n))
+	if err != nil || n < maxPacketSize {
+		atomic.AddUint64(&stats.StreamUnderruns, 1)
+	}
+}
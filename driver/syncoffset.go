@@ -0,0 +1,310 @@
+package driver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// MCLKFrequency is the master clock of the ADCs in Hz
+const MCLKFrequency = 32768000
+
+// maxSkewSamples is the largest skew in samples measured between two channels
+const maxSkewSamples = 4
+
+// minCoherence is the smallest normalized correlation of a channel with the
+// reference channel for the channel to be aligned
+const minCoherence = 0.5
+
+// ModulatorFrequency is the modulator clock in Hz for the MCLK_DIV field of POWER_MODE
+func ModulatorFrequency(mclkDiv uint8) (float64, error) {
+	switch mclkDiv {
+	case 0:
+		return MCLKFrequency / 32, nil
+	case 2:
+		return MCLKFrequency / 8, nil
+	case 3:
+		return MCLKFrequency / 4, nil
+	}
+	return 0, fmt.Errorf("invalid value for MCLK division. got %d, expected 0, 2 or 3", mclkDiv)
+}
+
+// SyncOffsets are the CHx_SYNC_OFFSET registers of the 24 channels. Every
+// step delays the sampling of the channel by one modulator clock period.
+type SyncOffsets [24]uint8
+
+// ApplySyncOffsets writes the sync offset of every channel. The offsets take
+// effect on the next sync pulse.
+func (adc *Adc7768) ApplySyncOffsets(offsets SyncOffsets) error {
+	for ch, o := range offsets {
+		opts := ChannelSyncOffsetOpts{Write: true, Channel: uint8(ch % 8), Offset: o}
+		if err := adc.ChannelSyncOffset(opts, uint8(ch/8)+1); err != nil {
+			return fmt.Errorf("channel %d sync offset: %v", ch+1, err)
+		}
+	}
+	return nil
+}
+
+// SyncOptions configures the measurement of the skew between channels
+type SyncOptions struct {
+	// Logic is the connection of the logic analyzer
+	Logic string
+
+	// CaptureTime of every measurement in milliseconds
+	CaptureTime int
+
+	// SampleRate of the channels in Hz
+	SampleRate float64
+
+	// ModulatorFrequency of the channels in Hz
+	ModulatorFrequency float64
+
+	// Reference is the zero based channel the other channels are aligned to
+	Reference int
+}
+
+// SyncCalibrationResult is the outcome of a sync offset calibration
+type SyncCalibrationResult struct {
+	Offsets SyncOffsets `json:"offsets"`
+
+	// Reference is the channel the other channels were aligned to
+	Reference int `json:"reference"`
+
+	// Skew is the delay of every channel behind the reference before
+	// alignment in microseconds
+	Skew    [24]float64 `json:"skew"`
+	MaxSkew float64     `json:"maxSkew"`
+
+	// ResidualSkew is the delay of every channel behind the reference after
+	// alignment in microseconds. It is measured when Verified and predicted
+	// from the register resolution otherwise.
+	ResidualSkew    [24]float64 `json:"residualSkew"`
+	MaxResidualSkew float64     `json:"maxResidualSkew"`
+
+	// Skipped are the channels which did not record the common signal. Their
+	// offsets are unchanged.
+	Skipped []int `json:"skipped,omitempty"`
+
+	Verified bool `json:"verified"`
+}
+
+// MeasureDelay returns the delay in samples of x behind ref and the
+// normalized correlation of the two at that delay. The changes of the signals
+// are correlated so a step or an impulse recorded by both works as well as a
+// periodic signal. Delays beyond maxLag samples have a zero correlation.
+func MeasureDelay(ref, x []float64, maxLag int) (delay float64, coherence float64) {
+	dr, dx := difference(ref), difference(x)
+	n := len(dr)
+	if len(dx) < n {
+		n = len(dx)
+	}
+	if n <= 2*maxLag+2 {
+		return 0, 0
+	}
+	dr, dx = dr[:n], dx[:n]
+	norm := math.Sqrt(energy(dr[maxLag:n-maxLag]) * energy(dx))
+	if norm == 0 {
+		return 0, 0
+	}
+
+	corr := make([]float64, 2*maxLag+1)
+	best := 0
+	for i := range corr {
+		lag := i - maxLag
+		for k := maxLag; k < n-maxLag; k++ {
+			corr[i] += dr[k] * dx[k+lag]
+		}
+		if corr[i] > corr[best] {
+			best = i
+		}
+	}
+	if best == 0 || best == len(corr)-1 {
+		return float64(best - maxLag), 0
+	}
+	// the vertex of the parabola through the peak gives the fraction of a sample
+	l, c, r := corr[best-1], corr[best], corr[best+1]
+	if den := l - 2*c + r; den != 0 {
+		delay = 0.5 * (l - r) / den
+	}
+	return float64(best-maxLag) + delay, c / norm
+}
+
+func difference(v []float64) []float64 {
+	if len(v) < 2 {
+		return nil
+	}
+	res := make([]float64, len(v)-1)
+	for i := range res {
+		res[i] = v[i+1] - v[i]
+	}
+	return res
+}
+
+func energy(v []float64) (e float64) {
+	for _, x := range v {
+		e += x * x
+	}
+	return e
+}
+
+// MeasureSkew returns the delay in microseconds of every recorded channel
+// behind the reference channel. ok is false for channels which did not record
+// the common signal.
+func MeasureSkew(samples [24][]float64, recorded [24]bool, opts SyncOptions) (skew [24]float64, ok [24]bool, err error) {
+	ref := opts.Reference
+	if ref < 0 || ref >= len(samples) || !recorded[ref] {
+		return skew, ok, fmt.Errorf("reference channel %d was not recorded", ref+1)
+	}
+	if opts.SampleRate <= 0 {
+		return skew, ok, fmt.Errorf("invalid sample rate %v", opts.SampleRate)
+	}
+	for ch := range samples {
+		if !recorded[ch] {
+			continue
+		}
+		d, coherence := MeasureDelay(samples[ref], samples[ch], maxSkewSamples)
+		if coherence < minCoherence {
+			continue
+		}
+		skew[ch] = d / opts.SampleRate * 1e6
+		ok[ch] = true
+	}
+	if !ok[ref] {
+		return skew, ok, fmt.Errorf("reference channel %d did not record a common signal", ref+1)
+	}
+	return skew, ok, nil
+}
+
+// AlignSyncOffsets returns the offsets which delay every aligned channel by
+// its skew in microseconds relative to current. The smallest offset is zero.
+// residual is the skew in microseconds left by the register resolution and range.
+func AlignSyncOffsets(current SyncOffsets, skew [24]float64, aligned [24]bool, fMod float64) (next SyncOffsets, residual [24]float64) {
+	next = current
+	var target [24]float64
+	base := math.Inf(1)
+	for ch := range target {
+		if !aligned[ch] {
+			continue
+		}
+		target[ch] = float64(current[ch]) + skew[ch]*1e-6*fMod
+		base = math.Min(base, target[ch])
+	}
+	for ch := range target {
+		if !aligned[ch] {
+			continue
+		}
+		v := math.Round(target[ch] - base)
+		if v > math.MaxUint8 {
+			v = math.MaxUint8
+		}
+		next[ch] = uint8(v)
+		residual[ch] = (target[ch] - base - v) / fMod * 1e6
+	}
+	return next, residual
+}
+
+// spread is the largest difference between the values of the aligned channels
+func spread(v [24]float64, aligned [24]bool) float64 {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for ch, x := range v {
+		if aligned[ch] {
+			lo, hi = math.Min(lo, x), math.Max(hi, x)
+		}
+	}
+	if hi < lo {
+		return 0
+	}
+	return hi - lo
+}
+
+// PlanSyncCalibration measures the skew of the recorded channels and returns
+// the offsets aligning them. current are the offsets the samples were recorded with.
+func PlanSyncCalibration(samples [24][]float64, recorded [24]bool, current SyncOffsets, opts SyncOptions) (SyncCalibrationResult, error) {
+	res := SyncCalibrationResult{Offsets: current, Reference: opts.Reference + 1}
+	if opts.ModulatorFrequency <= 0 {
+		return res, fmt.Errorf("invalid modulator frequency %v", opts.ModulatorFrequency)
+	}
+	skew, ok, err := MeasureSkew(samples, recorded, opts)
+	if err != nil {
+		return res, err
+	}
+	for ch := range ok {
+		if recorded[ch] && !ok[ch] {
+			res.Skipped = append(res.Skipped, ch+1)
+		}
+	}
+	res.Skew, res.MaxSkew = skew, spread(skew, ok)
+	res.Offsets, res.ResidualSkew = AlignSyncOffsets(current, skew, ok, opts.ModulatorFrequency)
+	res.MaxResidualSkew = spread(res.ResidualSkew, ok)
+	return res, nil
+}
+
+// CalibrateSync wakes every channel, records a signal common to every
+// channel, programs the sync offsets aligning the channels and records the
+// signal again to measure the residual skew. current are the offsets in the
+// registers.
+func (adc *Adc7768) CalibrateSync(x XMegaController, current SyncOffsets, opts SyncOptions) (SyncCalibrationResult, error) {
+	if opts.CaptureTime <= 0 {
+		opts.CaptureTime = 256
+	}
+	for cs := uint8(1); cs <= 3; cs++ {
+		if _, _, err := adc.ChStandby(ChStandbyOpts{Write: true}, cs); err != nil {
+			return SyncCalibrationResult{Offsets: current}, fmt.Errorf("failed to wake the channels of chip %d: %v", cs, err)
+		}
+	}
+	var all [24]bool
+	for i := range all {
+		all[i] = true
+	}
+	capture := func() ([24][]float64, error) {
+		data, err := adc.captureFrames(x, opts.Logic, opts.CaptureTime)
+		if err != nil {
+			return [24][]float64{}, err
+		}
+		return frameChannels(data), nil
+	}
+
+	if err := adc.ApplySyncOffsets(current); err != nil {
+		return SyncCalibrationResult{Offsets: current}, err
+	}
+	samples, err := capture()
+	if err != nil {
+		return SyncCalibrationResult{Offsets: current}, err
+	}
+	res, err := PlanSyncCalibration(samples, all, current, opts)
+	if err != nil {
+		return res, err
+	}
+	if err := adc.ApplySyncOffsets(res.Offsets); err != nil {
+		return res, err
+	}
+
+	// the capture sends the sync pulse which applies the new offsets
+	if samples, err = capture(); err != nil {
+		return res, err
+	}
+	residual, ok, err := MeasureSkew(samples, all, opts)
+	if err != nil {
+		return res, fmt.Errorf("failed to verify the alignment: %v", err)
+	}
+	for ch := range ok {
+		if !ok[ch] {
+			residual[ch] = 0
+		}
+	}
+	res.ResidualSkew, res.MaxResidualSkew = residual, spread(residual, ok)
+	res.Verified = true
+	return res, nil
+}
+
+// frameChannels splits frames of 24 little endian int32 samples into the samples of every channel
+func frameChannels(data []byte) (res [24][]float64) {
+	frames := len(data) / (4 * 24)
+	for ch := range res {
+		res[ch] = make([]float64, frames)
+	}
+	for i := 0; i < frames*4*24; i += 4 {
+		res[(i/4)%24][i/(4*24)] = float64(int32(binary.LittleEndian.Uint32(data[i:])))
+	}
+	return res
+}
@@ -0,0 +1,62 @@
+package driver_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/MShoaei/quakeADC/driver"
+)
+
+// impulse is a gaussian pulse recorded delay samples late
+func impulse(delay float64) []float64 {
+	res := make([]float64, 512)
+	for i := range res {
+		d := (float64(i) - 200 - delay) / 3
+		res[i] = 1e5 * math.Exp(-d*d/2)
+	}
+	return res
+}
+
+func TestPlanSyncCalibration(t *testing.T) {
+	// one sample is 128 modulator clock periods
+	opts := driver.SyncOptions{SampleRate: 32000, ModulatorFrequency: 4096000}
+	delays := map[int]float64{1: 0.25, 2: -0.5, 9: 1}
+	want := map[int]uint8{0: 64, 1: 96, 2: 0, 5: 0, 9: 192, 23: 0}
+
+	var samples [24][]float64
+	var recorded [24]bool
+	for ch := 0; ch < 23; ch++ {
+		samples[ch] = impulse(delays[ch])
+		recorded[ch] = true
+	}
+	// channel 6 is not connected
+	samples[5] = make([]float64, 512)
+
+	res, err := driver.PlanSyncCalibration(samples, recorded, driver.SyncOffsets{}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ch, o := range res.Offsets {
+		w, ok := want[ch]
+		if !ok {
+			w = 64
+		}
+		if math.Abs(float64(o)-float64(w)) > 4 {
+			t.Errorf("channel %d offset = %d, want %d", ch+1, o, w)
+		}
+	}
+	if len(res.Skipped) != 1 || res.Skipped[0] != 6 {
+		t.Errorf("skipped = %v, want [6]", res.Skipped)
+	}
+	if math.Abs(res.MaxSkew-1.5/32000*1e6) > 2 {
+		t.Errorf("max skew = %vµs, want about 46.9µs", res.MaxSkew)
+	}
+	if res.MaxResidualSkew > 1/4.096 || res.Verified {
+		t.Errorf("residual = %vµs verified %v, want at most one period and unverified", res.MaxResidualSkew, res.Verified)
+	}
+
+	samples[0] = make([]float64, 512)
+	if _, err := driver.PlanSyncCalibration(samples, recorded, driver.SyncOffsets{}, opts); err == nil {
+		t.Error("flat reference channel was accepted")
+	}
+}
@@ -1,10 +1,13 @@
 package driver
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"path"
 	"time"
 
 	"github.com/spf13/afero"
@@ -13,136 +16,302 @@ import (
 	"periph.io/x/periph/host/bcm283x"
 )
 
-func Reset() error {
-	if err := bcm283x.GPIO26.SetFunc(gpio.OUT_LOW); err != nil {
-		return fmt.Errorf("reset failed: %v", err)
-	}
-	bcm283x.GPIO26.FastOut(gpio.Low)
-	bcm283x.GPIO26.FastOut(gpio.High)
-	if err := bcm283x.GPIO26.SetFunc(gpio.IN); err != nil {
-		return fmt.Errorf("reset failed: %v", err)
-	}
-	return nil
-}
+// XMegaChipSelect is the chip select of the XMega on the SPI bus shared with the ADCs
+const XMegaChipSelect = 0
 
-func Shutdown(conn spi.Connection) {
-	var tx []byte
-	tx = []byte{uint8(0x02), uint8(0x02), 0}
-	rx := make([]byte, 3)
+// Opcode is the first byte of an XMega command frame. A frame is
+// [opcode, argument, 0] and the XMega answers with 3 bytes on the same
+// transfer.
+type Opcode uint8
+
+// XMega command table
+const (
+	// OpLogic controls the logic analyzers and the ADC reset line.
+	// Arguments: 0x00 resets every logic analyzer, 0x01 resets every ADC,
+	// 0x02, 0x06 and 0x0e enable the first one, two or three logic analyzers.
+	OpLogic Opcode = 0x01
+
+	// OpPower arguments: 0x01 enables the ADC master clock, 0x02 shuts the board down
+	OpPower Opcode = 0x02
+
+	// OpLED sets the status LED. Arguments: 0x00 off, 0x01 on
+	OpLED Opcode = 0x04
 
-	_ = EnableChipSelect(0)
-	_ = conn.Tx(tx, rx)
-	_ = DisableChipSelect(0)
+	// OpADCPower powers the ADCs. Argument 0x07 powers all of them
+	OpADCPower Opcode = 0x05
+
+	// OpTelemetryBank selects the power rail measured by the telemetry
+	// commands. Argument: 0x04 | rail
+	OpTelemetryBank Opcode = 0x0b
+
+	// OpTelemetryChannel selects a measurement of the rail. Channels 1, 3 and
+	// 5 are currents and 2, 4 and 6 are voltages.
+	OpTelemetryChannel Opcode = 0x0c
+
+	// OpSampling arguments: 0x42 starts sampling, 0x02 stops it
+	OpSampling Opcode = 0x10
+
+	// OpReadID answers with the identification of the board in the last two bytes
+	OpReadID Opcode = 0x88
+
+	// OpTelemetryHigh and OpTelemetryLow answer with the high and low byte of
+	// the selected measurement in the last byte
+	OpTelemetryHigh Opcode = 0x8d
+	OpTelemetryLow  Opcode = 0x8e
+)
+
+var opcodeNames = map[Opcode]string{
+	OpLogic:            "logic",
+	OpPower:            "power",
+	OpLED:              "led",
+	OpADCPower:         "adc power",
+	OpTelemetryBank:    "telemetry bank",
+	OpTelemetryChannel: "telemetry channel",
+	OpSampling:         "sampling",
+	OpReadID:           "read id",
+	OpTelemetryHigh:    "telemetry high",
+	OpTelemetryLow:     "telemetry low",
 }
 
-type status int
+func (op Opcode) String() string {
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", uint8(op))
+}
 
 const (
-	Off status = 0
-	On  status = 1
+	argLogicReset    = 0x00
+	argResetADCs     = 0x01
+	argMCLK          = 0x01
+	argShutdown      = 0x02
+	argAllADCs       = 0x07
+	argSamplingStart = 0x42
+	argSamplingStop  = 0x02
+	argTelemetryBank = 0x04
 )
 
-func StatusLED(conn spi.Connection, s status) error {
-	var tx []byte
-	switch s {
-	case On:
-		tx = []byte{uint8(4), uint8(1), 0}
-	case Off:
-		tx = []byte{uint8(4), uint8(0), 0}
-	}
-	rx := make([]byte, 3)
+// TelemetryRails is the number of power rails measured by the XMega
+const TelemetryRails = 4
 
-	_ = EnableChipSelect(0)
-	if err := conn.Tx(tx, rx); err != nil {
-		return fmt.Errorf("failed to set led status: %v", err)
-	}
-	_ = DisableChipSelect(0)
-	return nil
+// RailReading is a raw measurement of a power rail
+type RailReading struct {
+	Voltages [3]int16 `json:"voltages"`
+	Currents [3]int16 `json:"currents"`
 }
 
-func TurnOnAllADC(conn spi.Connection) error {
-	var tx []byte
-	tx = []byte{uint8(0x05), uint8(0x07), 0}
+// logicAnalyzerArgs enables the first one, two or three logic analyzers
+var logicAnalyzerArgs = []uint8{0x02, 0x06, 0x0e}
+
+// telemetryDelay is the time the XMega needs between telemetry commands
+const telemetryDelay = 100 * time.Millisecond
+
+// errNoResponse is returned when the XMega does not drive the bus
+var errNoResponse = errors.New("no response from xmega")
+
+// XMegaController is the interface of the XMega board controller. It is
+// implemented by XMega and can be replaced in tests.
+type XMegaController interface {
+	// Command sends a raw frame and returns the answer
+	Command(op Opcode, arg uint8) ([]byte, error)
+
+	ID() ([]byte, error)
+	SetLED(on bool) error
+	PowerADCs() error
+	ResetADCs() error
+	EnableMCLK() error
+
+	// EnableLogicAnalyzers resets the logic analyzers and enables the first n of them
+	EnableLogicAnalyzers(n int) error
+	StartSampling() error
+	StopSampling() error
+	Shutdown() error
+
+	// ReadRail measures the voltages and currents of a power rail
+	ReadRail(rail int) (RailReading, error)
+}
+
+// XMega talks to the XMega board controller over SPI
+type XMega struct {
+	conn spi.Connection
+}
+
+// NewXMega returns the controller on conn
+func NewXMega(conn spi.Connection) *XMega {
+	return &XMega{conn: conn}
+}
+
+// Command sends [op, arg, 0] and returns the 3 byte answer. The chip select
+// is always released.
+func (x *XMega) Command(op Opcode, arg uint8) ([]byte, error) {
+	busMu.Lock()
+	defer busMu.Unlock()
+
+	tx := []byte{uint8(op), arg, 0}
 	rx := make([]byte, 3)
+	if err := EnableChipSelect(XMegaChipSelect); err != nil {
+		return nil, fmt.Errorf("xmega %s: %v", op, err)
+	}
+	defer DisableChipSelect(XMegaChipSelect)
+	if err := x.conn.Tx(tx, rx); err != nil {
+		return nil, fmt.Errorf("xmega %s 0x%02x: %v", op, arg, err)
+	}
+	return rx, nil
+}
+
+func (x *XMega) command(op Opcode, arg uint8) error {
+	_, err := x.Command(op, arg)
+	return err
+}
 
-	_ = EnableChipSelect(0)
-	if err := conn.Tx(tx, rx); err != nil {
-		return fmt.Errorf("reset all adcs failed: %v", err)
+// ID returns the identification bytes of the board
+func (x *XMega) ID() ([]byte, error) {
+	rx, err := x.Command(OpReadID, 0)
+	if err != nil {
+		return nil, err
 	}
-	_ = DisableChipSelect(0)
-	return nil
+	id := rx[1:]
+	if bytes.Equal(id, []byte{0, 0}) || bytes.Equal(id, []byte{0xff, 0xff}) {
+		return nil, errNoResponse
+	}
+	return id, nil
 }
 
-func ResetAllADC(conn spi.Connection) error {
-	var tx []byte
-	tx = []byte{uint8(0x01), uint8(0x01), 0}
-	rx := make([]byte, 3)
+func (x *XMega) SetLED(on bool) error {
+	var arg uint8
+	if on {
+		arg = 1
+	}
+	return x.command(OpLED, arg)
+}
+
+func (x *XMega) PowerADCs() error {
+	return x.command(OpADCPower, argAllADCs)
+}
 
-	_ = EnableChipSelect(0)
-	if err := conn.Tx(tx, rx); err != nil {
-		return fmt.Errorf("reset all adcs failed: %v", err)
+func (x *XMega) ResetADCs() error {
+	return x.command(OpLogic, argResetADCs)
+}
+
+func (x *XMega) EnableMCLK() error {
+	return x.command(OpPower, argMCLK)
+}
+
+func (x *XMega) EnableLogicAnalyzers(n int) error {
+	if n < 1 || n > len(logicAnalyzerArgs) {
+		return fmt.Errorf("invalid number of logic analyzers %d. expected 1 to %d", n, len(logicAnalyzerArgs))
+	}
+	if err := x.command(OpLogic, argLogicReset); err != nil {
+		return err
+	}
+	for _, arg := range logicAnalyzerArgs[:n] {
+		time.Sleep(time.Second)
+		if err := x.command(OpLogic, arg); err != nil {
+			return err
+		}
 	}
-	_ = DisableChipSelect(0)
 	return nil
 }
 
-func EnableMCLK(conn spi.Connection) error {
-	var tx []byte
-	tx = []byte{uint8(0x02), uint8(0x01), 0}
-	rx := make([]byte, 3)
+func (x *XMega) StartSampling() error {
+	return x.command(OpSampling, argSamplingStart)
+}
+
+func (x *XMega) StopSampling() error {
+	return x.command(OpSampling, argSamplingStop)
+}
+
+// Shutdown powers the board off
+func (x *XMega) Shutdown() error {
+	return x.command(OpPower, argShutdown)
+}
 
-	_ = EnableChipSelect(0)
-	if err := conn.Tx(tx, rx); err != nil {
-		return fmt.Errorf("enabling MCLK failed: %v", err)
+// ReadRail measures the voltages and currents of rail. It takes about two
+// seconds because the XMega needs time between telemetry commands.
+func (x *XMega) ReadRail(rail int) (RailReading, error) {
+	var r RailReading
+	if rail < 0 || rail >= TelemetryRails {
+		return r, fmt.Errorf("invalid rail %d. expected 0 to %d", rail, TelemetryRails-1)
 	}
-	_ = DisableChipSelect(0)
-	return nil
+	if err := x.command(OpTelemetryBank, argTelemetryBank|uint8(rail)); err != nil {
+		return r, err
+	}
+	time.Sleep(telemetryDelay)
+	for i := uint8(0); i < 3; i++ {
+		var err error
+		if r.Currents[i], err = x.reading(2*i + 1); err != nil {
+			return r, err
+		}
+		if r.Voltages[i], err = x.reading(2*i + 2); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
 }
 
-func DetectLogicConnString(conn spi.Connection) (list []string, err error) {
-	var devices []os.FileInfo
-	var tx []byte
-	rx := make([]byte, 3)
+// reading selects the measurement ch of the current rail and reads it
+func (x *XMega) reading(ch uint8) (int16, error) {
+	if err := x.command(OpTelemetryChannel, ch); err != nil {
+		return 0, err
+	}
+	time.Sleep(telemetryDelay)
+	high, err := x.Command(OpTelemetryHigh, 0)
+	if err != nil {
+		return 0, err
+	}
+	time.Sleep(telemetryDelay)
+	low, err := x.Command(OpTelemetryLow, 0)
+	if err != nil {
+		return 0, err
+	}
+	time.Sleep(telemetryDelay)
+	return int16(high[2])<<8 | int16(low[2]), nil
+}
 
-	tx = []byte{uint8(0x01), uint8(0x00), 0}
-	_ = EnableChipSelect(0)
-	if err = conn.Tx(tx, rx); err != nil {
-		return nil, fmt.Errorf("failed to reset all logic analyzers: %v", err)
+// Reset pulses the reset line of the XMega. It works when the XMega does not answer on SPI.
+func Reset() error {
+	if err := bcm283x.GPIO26.SetFunc(gpio.OUT_LOW); err != nil {
+		return fmt.Errorf("reset failed: %v", err)
 	}
-	_ = DisableChipSelect(0)
-	time.Sleep(1 * time.Second)
-	// --------------------------------------
-	tx = []byte{uint8(0x01), uint8(0x02), 0}
-	_ = EnableChipSelect(0)
-	if err = conn.Tx(tx, rx); err != nil {
-		return nil, fmt.Errorf("failed to enable logic 1: %v", err)
+	bcm283x.GPIO26.FastOut(gpio.Low)
+	bcm283x.GPIO26.FastOut(gpio.High)
+	if err := bcm283x.GPIO26.SetFunc(gpio.IN); err != nil {
+		return fmt.Errorf("reset failed: %v", err)
 	}
-	_ = DisableChipSelect(0)
-	time.Sleep(1 * time.Second)
-	// --------------------------------------
-	tx = []byte{uint8(0x01), uint8(0x06), 0}
-	_ = EnableChipSelect(0)
-	if err = conn.Tx(tx, rx); err != nil {
-		return nil, fmt.Errorf("failed to enable logic 2: %v", err)
+	return nil
+}
+
+// logicUSBBus lists the devices on the USB bus of the logic analyzers
+const logicUSBBus = "/dev/bus/usb/001/"
+
+// LogicAnalyzerPresent checks the logic analyzer with the connection conn
+// returned by DetectLogicConnString is on the USB bus
+func LogicAnalyzerPresent(conn string) error {
+	if _, err := os.Stat(path.Join(logicUSBBus, conn)); err != nil {
+		return fmt.Errorf("logic analyzer %s not found on usb bus 001", conn)
 	}
-	_ = DisableChipSelect(0)
-	time.Sleep(1 * time.Second)
-	// --------------------------------------
-	tx = []byte{uint8(0x01), uint8(0x0e), 0}
-	_ = EnableChipSelect(0)
-	if err = conn.Tx(tx, rx); err != nil {
-		return nil, fmt.Errorf("failed to enable logic 3: %v", err)
+	return nil
+}
+
+// DetectLogicConnString enables the logic analyzers and returns the USB
+// device names sigrok uses to connect to them
+func DetectLogicConnString(x XMegaController) (list []string, err error) {
+	var devices []os.FileInfo
+	if err := x.EnableLogicAnalyzers(len(logicAnalyzerArgs)); err != nil {
+		return nil, fmt.Errorf("failed to enable logic analyzers: %v", err)
 	}
-	_ = DisableChipSelect(0)
 	// --------------------------------------
 	exec.Command("sigrok-cli", "--scan").Run()
 	time.Sleep(1 * time.Second)
 	exec.Command("sigrok-cli", "--scan").Run()
 	time.Sleep(2000 * time.Millisecond)
 
-	devices, err = afero.ReadDir(afero.NewOsFs(), "/dev/bus/usb/001/")
+	devices, err = afero.ReadDir(afero.NewOsFs(), logicUSBBus)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read directory at /dev/bus/usb/001/: %v", err)
+		return nil, fmt.Errorf("failed to read directory at %s: %v", logicUSBBus, err)
+	}
+	if len(devices) < 3 {
+		return nil, fmt.Errorf("logic analyzers not found on usb bus 001")
 	}
 
 	list = []string{
@@ -155,119 +324,3 @@ func DetectLogicConnString(conn spi.Connection) (list []string, err error) {
 
 	return list, err
 }
-
-func ReadID(conn spi.Connection) {
-	tx := []byte{uint8(0x88), uint8(0), 0}
-	rx := make([]byte, 3)
-
-	_ = EnableChipSelect(0)
-	conn.Tx(tx, rx)
-	_ = DisableChipSelect(0)
-	log.Println(rx)
-}
-
-func SamplingStart(conn spi.Connection) {
-	tx := []byte{uint8(0x10), uint8(0x42), 0}
-	rx := make([]byte, 3)
-
-	_ = EnableChipSelect(0)
-	conn.Tx(tx, rx)
-	_ = DisableChipSelect(0)
-	log.Println(rx)
-}
-
-func SamplingEnd(conn spi.Connection) {
-	tx := []byte{uint8(0x10), uint8(0x02), 0}
-	rx := make([]byte, 3)
-
-	_ = EnableChipSelect(0)
-	conn.Tx(tx, rx)
-	_ = DisableChipSelect(0)
-	log.Println(rx)
-}
-
-func GetVoltage(conn spi.Connection) []int16 {
-	tx := make([]byte, 3, 3)
-	rx := make([]byte, 3, 3)
-	res := make([]int16, 0, 12)
-	for i := uint8(0); i < 4; i++ {
-		if i == 1 || i == 2 || i == 3 {
-			res = append(res, 0, 0, 0)
-			continue
-		}
-		tx = []byte{uint8(0x0b), 0x04 | i, 0}
-		_ = EnableChipSelect(0)
-		_ = conn.Tx(tx, rx)
-		_ = DisableChipSelect(0)
-		time.Sleep(100 * time.Millisecond)
-
-		for j := uint8(2); j < 8; j += 2 {
-			tx = []byte{uint8(0x0c), j, 0}
-			_ = EnableChipSelect(0)
-			_ = conn.Tx(tx, rx)
-			_ = DisableChipSelect(0)
-			time.Sleep(100 * time.Millisecond)
-
-			var value int16
-			tx = []byte{uint8(0x8d), 0, 0}
-			_ = EnableChipSelect(0)
-			_ = conn.Tx(tx, rx)
-			_ = DisableChipSelect(0)
-			time.Sleep(100 * time.Millisecond)
-			value = int16(rx[2]) << 8
-
-			tx = []byte{uint8(0x8e), 0, 0}
-			_ = EnableChipSelect(0)
-			_ = conn.Tx(tx, rx)
-			_ = DisableChipSelect(0)
-			time.Sleep(100 * time.Millisecond)
-			value |= int16(rx[2])
-
-			res = append(res, value)
-		}
-	}
-	return res
-}
-
-func GetCurrent(conn spi.Connection) []int16 {
-	tx := make([]byte, 3, 3)
-	rx := make([]byte, 3, 3)
-	res := make([]int16, 0, 12)
-	for i := uint8(0); i < 4; i++ {
-		if i == 1 || i == 2 || i == 3 {
-			res = append(res, 0, 0, 0)
-			continue
-		}
-		tx = []byte{uint8(0x0b), 0x04 | i, 0}
-		_ = EnableChipSelect(0)
-		_ = conn.Tx(tx, rx)
-		_ = DisableChipSelect(0)
-		time.Sleep(100 * time.Millisecond)
-
-		for j := uint8(1); j < 7; j += 2 {
-			tx = []byte{uint8(0x0c), j, 0}
-			_ = EnableChipSelect(0)
-			_ = conn.Tx(tx, rx)
-			_ = DisableChipSelect(0)
-			time.Sleep(100 * time.Millisecond)
-
-			var value int16
-			tx = []byte{uint8(0x8d), 0, 0}
-			_ = EnableChipSelect(0)
-			_ = conn.Tx(tx, rx)
-			_ = DisableChipSelect(0)
-			time.Sleep(100 * time.Millisecond)
-			value = int16(rx[2]) << 8
-
-			tx = []byte{uint8(0x8e), 0, 0}
-			_ = EnableChipSelect(0)
-			_ = conn.Tx(tx, rx)
-			_ = DisableChipSelect(0)
-			time.Sleep(100 * time.Millisecond)
-			value |= int16(rx[2])
-
-			res = append(res, value)
-		}
-	}
-	return res
-}
@@ -0,0 +1,147 @@
+package record
+
+import (
+	"time"
+
+	"github.com/MShoaei/quakeADC/sensor"
+	"github.com/MShoaei/quakeADC/survey"
+)
+
+const (
+	// LegacyVersion is the version reported for files made of a single JSON
+	// line followed by raw samples, written before this format existed.
+	LegacyVersion uint16 = 1
+
+	// Version is the version written by Writer
+	Version uint16 = 2
+)
+
+// DefaultBlockFrames is the number of frames in each data block when the header does not set one
+const DefaultBlockFrames = 1024
+
+var magic = []byte("QADC")
+
+// Header describes the acquisition a recording was made with
+type Header struct {
+	// Version is set by the reader and writer. Any value set by the caller is ignored.
+	Version uint16 `json:"version"`
+
+	// Mode is the start mode of the acquisition e.g. asap or hammer
+	Mode    string  `json:"mode,omitempty"`
+	Profile Profile `json:"profile"`
+
+	// SampleRate in Hz
+	SampleRate float64   `json:"sampleRate"`
+	StartTime  time.Time `json:"startTime"`
+
+	// TriggerIndex is the frame at which the trigger fired or -1 if the recording was not triggered
+	TriggerIndex int64 `json:"triggerIndex"`
+	Window       int   `json:"window"`
+
+	// Channels holds one entry for every recorded channel in the order they appear in a frame
+	Channels []Channel `json:"channels"`
+
+	BoardSerials []string `json:"boardSerials,omitempty"`
+
+	// Line, Operator and Shot are copied from the survey of the project the recording was made in
+	Line     string       `json:"line,omitempty"`
+	Operator string       `json:"operator,omitempty"`
+	Shot     *survey.Shot `json:"shot,omitempty"`
+
+	// BlockFrames is the number of frames in every data block except the last one
+	BlockFrames int `json:"blockFrames"`
+
+	// BlockChecksums enables a CRC-32 (IEEE) after every data block
+	BlockChecksums bool `json:"blockChecksums"`
+}
+
+// Profile is the ADC configuration used for the acquisition
+type Profile struct {
+	// SamplingTime is the sampling interval in microseconds
+	SamplingTime float32 `json:"samplingTime"`
+
+	DecRate   uint16 `json:"decRate,omitempty"`
+	FType     uint8  `json:"fType,omitempty"`
+	PowerMode uint8  `json:"powerMode,omitempty"`
+	MCLKDiv   uint8  `json:"mclkDiv,omitempty"`
+	DclkDiv   uint8  `json:"dclkDiv,omitempty"`
+}
+
+// Channel describes a single recorded channel
+type Channel struct {
+	// Index is the zero based input number on the board [0..23]
+	Index  int    `json:"index"`
+	Gain   uint32 `json:"gain"`
+	Offset int32  `json:"offset"`
+
+	// SyncOffset is the CHx_SYNC_OFFSET register of the channel in modulator clock periods
+	SyncOffset uint8 `json:"syncOffset,omitempty"`
+
+	// Sensor connected to the channel when the recording was made
+	Sensor *sensor.Model `json:"sensor,omitempty"`
+
+	// Receiver is the station of the survey the channel was connected to
+	Receiver *survey.Receiver `json:"receiver,omitempty"`
+}
+
+// Model returns the sensor model of the channel including its gain.
+// Channels without a sensor are treated as voltage inputs.
+func (c Channel) Model() sensor.Model {
+	var m sensor.Model
+	if c.Sensor != nil {
+		m = *c.Sensor
+	}
+	return m.WithGain(float64(c.Gain))
+}
+
+// EnabledChannels returns the recorded channels as a mask of all 24 inputs
+func (h Header) EnabledChannels() [24]bool {
+	var res [24]bool
+	for _, ch := range h.Channels {
+		if ch.Index >= 0 && ch.Index < len(res) {
+			res[ch.Index] = true
+		}
+	}
+	return res
+}
+
+// FrameSize is the number of bytes in a single frame
+func (h Header) FrameSize() int {
+	return len(h.Channels) * 4
+}
+
+func (h Header) blockFrames() int {
+	if h.BlockFrames <= 0 {
+		return DefaultBlockFrames
+	}
+	return h.BlockFrames
+}
+
+// legacyHeader is the JSON line at the start of version 1 files
+type legacyHeader struct {
+	EnabledChannels [24]bool   `json:"EnabledChannels"`
+	Gains           [24]uint32 `json:"Gains"`
+	Window          int        `json:"Window"`
+
+	SamplingTime float32   `json:"SamplingTime,omitempty"`
+	StartTime    time.Time `json:"StartTime,omitempty"`
+}
+
+func (l legacyHeader) header() Header {
+	h := Header{
+		Version:      LegacyVersion,
+		Profile:      Profile{SamplingTime: l.SamplingTime},
+		StartTime:    l.StartTime,
+		TriggerIndex: -1,
+		Window:       l.Window,
+	}
+	if l.SamplingTime > 0 {
+		h.SampleRate = 1e6 / float64(l.SamplingTime)
+	}
+	for i, enabled := range l.EnabledChannels {
+		if enabled {
+			h.Channels = append(h.Channels, Channel{Index: i, Gain: l.Gains[i]})
+		}
+	}
+	return h
+}
@@ -0,0 +1,250 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// Limits of the sizes read from a file so a corrupt one can not make the
+// reader allocate more memory than a recording needs
+const (
+	maxHeaderSize = 1 << 20
+	maxBlockSize  = 64 << 20
+)
+
+// ErrChecksum is returned when a data block does not match its checksum
+var ErrChecksum = errors.New("block checksum mismatch")
+
+// checksumFailures counts the blocks read with ErrChecksum
+var checksumFailures uint64
+
+// ChecksumFailures returns the number of data blocks which did not match
+// their checksum since the program started
+func ChecksumFailures() uint64 {
+	return atomic.LoadUint64(&checksumFailures)
+}
+
+// Reader reads recordings of any version
+type Reader struct {
+	Header Header
+
+	// HeaderSize is the number of bytes before the first data block
+	HeaderSize int64
+
+	r     *bufio.Reader
+	block []byte
+	pos   int
+
+	// size of the file or -1 when src does not know it
+	size int64
+}
+
+// sourceSize is the size of src when it is a file or a reader of known size
+func sourceSize(src io.Reader) int64 {
+	switch s := src.(type) {
+	case interface{ Stat() (os.FileInfo, error) }:
+		if info, err := s.Stat(); err == nil && info.Mode().IsRegular() {
+			return info.Size()
+		}
+	case interface{ Size() int64 }:
+		return s.Size()
+	}
+	return -1
+}
+
+// NewReader reads the file header from src. The sizes in the file are
+// checked against the size of src when it is a file.
+func NewReader(src io.Reader) (*Reader, error) {
+	r := &Reader{r: bufio.NewReader(src), size: sourceSize(src)}
+
+	start, err := r.r.Peek(len(magic))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file header: %w", err)
+	}
+	if start[0] == '{' {
+		line, err := r.r.ReadBytes('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file header: %w", err)
+		}
+		var l legacyHeader
+		if err := json.Unmarshal(line, &l); err != nil {
+			return nil, fmt.Errorf("invalid file header: %w", err)
+		}
+		r.Header = l.header()
+		r.HeaderSize = int64(len(line))
+		return r, nil
+	}
+	if !bytes.Equal(start, magic) {
+		return nil, fmt.Errorf("unknown file format")
+	}
+
+	temp := make([]byte, len(magic)+6)
+	if _, err := io.ReadFull(r.r, temp); err != nil {
+		return nil, fmt.Errorf("failed to read file header: %w", err)
+	}
+	version := binary.LittleEndian.Uint16(temp[4:6])
+	if version != Version {
+		return nil, fmt.Errorf("unsupported file version %d", version)
+	}
+	size := binary.LittleEndian.Uint32(temp[6:10])
+	if size > maxHeaderSize || (r.size >= 0 && int64(size) > r.size-int64(len(temp))) {
+		return nil, fmt.Errorf("invalid file header size %d", size)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, fmt.Errorf("failed to read file header: %w", err)
+	}
+	if err := json.Unmarshal(b, &r.Header); err != nil {
+		return nil, fmt.Errorf("invalid file header: %w", err)
+	}
+	if len(r.Header.Channels) == 0 {
+		return nil, fmt.Errorf("invalid file header: no channels")
+	}
+	if r.Header.BlockFrames > maxBlockSize/r.Header.FrameSize() {
+		return nil, fmt.Errorf("invalid file header: blocks of %d frames", r.Header.BlockFrames)
+	}
+	r.Header.Version = version
+	r.HeaderSize = int64(len(temp)) + int64(size)
+	return r, nil
+}
+
+// FrameCount returns the number of frames in a file of the given total size
+func (r *Reader) FrameCount(size int64) int64 {
+	frameSize := int64(r.Header.FrameSize())
+	if frameSize == 0 {
+		return 0
+	}
+	data := size - r.HeaderSize
+	if data <= 0 {
+		return 0
+	}
+	if r.Header.Version == LegacyVersion {
+		return data / frameSize
+	}
+
+	overhead := int64(4)
+	if r.Header.BlockChecksums {
+		overhead += 4
+	}
+	blockFrames := int64(r.Header.blockFrames())
+	full := overhead + blockFrames*frameSize
+	count := data / full * blockFrames
+	if rem := data % full; rem > overhead {
+		count += (rem - overhead) / frameSize
+	}
+	return count
+}
+
+// ReadFrameBytes reads a single frame of little-endian int32 samples into p,
+// which must be Header.FrameSize() bytes long. It returns io.EOF when there
+// are no more frames and io.ErrUnexpectedEOF when the last data block is cut.
+func (r *Reader) ReadFrameBytes(p []byte) error {
+	if len(p) != r.Header.FrameSize() {
+		return fmt.Errorf("invalid frame buffer size %d, expected %d", len(p), r.Header.FrameSize())
+	}
+	if r.Header.Version == LegacyVersion {
+		if _, err := io.ReadFull(r.r, p); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return io.EOF
+			}
+			return err
+		}
+		return nil
+	}
+
+	if r.pos == len(r.block) {
+		if err := r.readBlock(); err != nil {
+			return err
+		}
+	}
+	if len(r.block)-r.pos < len(p) {
+		return io.EOF
+	}
+	copy(p, r.block[r.pos:])
+	r.pos += len(p)
+	return nil
+}
+
+// ReadFrame reads a single frame with one sample per recorded channel
+func (r *Reader) ReadFrame(frame []int32) error {
+	if len(frame) != len(r.Header.Channels) {
+		return fmt.Errorf("invalid frame length %d, expected %d", len(frame), len(r.Header.Channels))
+	}
+	p := make([]byte, r.Header.FrameSize())
+	if err := r.ReadFrameBytes(p); err != nil {
+		return err
+	}
+	for i := range frame {
+		frame[i] = int32(binary.LittleEndian.Uint32(p[i*4:]))
+	}
+	return nil
+}
+
+// ReadAll reads the remaining frames and returns the samples of each channel
+func (r *Reader) ReadAll() ([][]int32, error) {
+	res := make([][]int32, len(r.Header.Channels))
+	p := make([]byte, r.Header.FrameSize())
+	for {
+		err := r.ReadFrameBytes(p)
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return res, err
+		}
+		for i := range res {
+			res[i] = append(res[i], int32(binary.LittleEndian.Uint32(p[i*4:])))
+		}
+	}
+}
+
+// readBlock reads the next data block. It returns io.EOF at the end of the
+// file and io.ErrUnexpectedEOF when the file ends within the block.
+func (r *Reader) readBlock() error {
+	temp := make([]byte, 4)
+	if _, err := io.ReadFull(r.r, temp); err != nil {
+		return err
+	}
+	size := binary.LittleEndian.Uint32(temp)
+	if max := r.Header.blockFrames() * r.Header.FrameSize(); int64(size) > int64(max) || int(size)%r.Header.FrameSize() != 0 {
+		return fmt.Errorf("invalid block size %d", size)
+	}
+	if r.size >= 0 && int64(size) > r.size-r.HeaderSize {
+		return fmt.Errorf("invalid block size %d. larger than the file", size)
+	}
+	if cap(r.block) < int(size) {
+		r.block = make([]byte, size)
+	}
+	r.block = r.block[:size]
+	r.pos = 0
+	if _, err := io.ReadFull(r.r, r.block); err != nil {
+		r.block = r.block[:0]
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if r.Header.BlockChecksums {
+		if _, err := io.ReadFull(r.r, temp); err != nil {
+			r.block = r.block[:0]
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if crc32.ChecksumIEEE(r.block) != binary.LittleEndian.Uint32(temp) {
+			r.block = r.block[:0]
+			atomic.AddUint64(&checksumFailures, 1)
+			return ErrChecksum
+		}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+func frames(n, channels int) []byte {
+	b := make([]byte, 0, n*channels*4)
+	temp := make([]byte, 4)
+	for i := 0; i < n; i++ {
+		for j := 0; j < channels; j++ {
+			binary.LittleEndian.PutUint32(temp, uint32(int32(i*10-j)))
+			b = append(b, temp...)
+		}
+	}
+	return b
+}
+
+func TestWriterReader(t *testing.T) {
+	tests := []struct {
+		name      string
+		frames    int
+		checksums bool
+	}{
+		{name: "empty", frames: 0},
+		{name: "partial block", frames: 3, checksums: true},
+		{name: "full blocks", frames: 8},
+		{name: "full and partial blocks", frames: 11, checksums: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Header{
+				SampleRate:     1000,
+				StartTime:      time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC),
+				TriggerIndex:   -1,
+				Channels:       []Channel{{Index: 0, Gain: 1}, {Index: 5, Gain: 2, Offset: -3}},
+				BlockFrames:    4,
+				BlockChecksums: tt.checksums,
+			}
+			buf := new(bytes.Buffer)
+			w, err := NewWriter(buf, h)
+			if err != nil {
+				t.Fatalf("NewWriter() error = %v", err)
+			}
+			data := frames(tt.frames, 2)
+			// write in odd sized chunks to cross block boundaries
+			for i := 0; i < len(data); i += 7 {
+				end := i + 7
+				if end > len(data) {
+					end = len(data)
+				}
+				if _, err := w.Write(data[i:end]); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			if got := w.Frames(); got != int64(tt.frames) {
+				t.Errorf("Frames() = %d, want %d", got, tt.frames)
+			}
+
+			size := int64(buf.Len())
+			r, err := NewReader(buf)
+			if err != nil {
+				t.Fatalf("NewReader() error = %v", err)
+			}
+			if r.Header.Version != Version {
+				t.Errorf("Version = %d, want %d", r.Header.Version, Version)
+			}
+			if got := r.Header.EnabledChannels(); !got[0] || !got[5] || got[1] {
+				t.Errorf("EnabledChannels() = %v", got)
+			}
+			if got := r.FrameCount(size); got != int64(tt.frames) {
+				t.Errorf("FrameCount() = %d, want %d", got, tt.frames)
+			}
+			samples, err := r.ReadAll()
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if len(samples[0]) != tt.frames {
+				t.Fatalf("ReadAll() returned %d frames, want %d", len(samples[0]), tt.frames)
+			}
+			for i := 0; i < tt.frames; i++ {
+				if samples[0][i] != int32(i*10) || samples[1][i] != int32(i*10-1) {
+					t.Fatalf("frame %d = %d %d", i, samples[0][i], samples[1][i])
+				}
+			}
+		})
+	}
+}
+
+func TestReaderChecksum(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, _ := NewWriter(buf, Header{Channels: []Channel{{Index: 0}}, BlockChecksums: true})
+	_, _ = w.Write(frames(4, 1))
+	_ = w.Close()
+
+	b := buf.Bytes()
+	b[len(b)-5] ^= 0xff
+
+	r, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	failures := ChecksumFailures()
+	if _, err := r.ReadAll(); err != ErrChecksum {
+		t.Errorf("ReadAll() error = %v, want %v", err, ErrChecksum)
+	}
+	if got := ChecksumFailures() - failures; got != 1 {
+		t.Errorf("ChecksumFailures() grew by %d, want 1", got)
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, _ := NewWriter(buf, Header{Channels: []Channel{{Index: 0}}, BlockFrames: 4, BlockChecksums: true})
+	_, _ = w.Write(frames(6, 1))
+	_ = w.Close()
+	b := buf.Bytes()
+	// the second block is 4 bytes of size, 2 frames and 4 bytes of checksum
+	second := len(b) - 4 - 2*4 - 4
+
+	for _, tt := range []struct {
+		name string
+		size int
+	}{
+		{name: "size", size: second + 2},
+		{name: "frames", size: second + 4 + 6},
+		{name: "checksum", size: len(b) - 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewReader(bytes.NewReader(b[:tt.size]))
+			if err != nil {
+				t.Fatalf("NewReader() error = %v", err)
+			}
+			samples, err := r.ReadAll()
+			if err != io.ErrUnexpectedEOF || len(samples[0]) != 4 {
+				t.Errorf("ReadAll() = %d frames, %v, want 4 frames and %v", len(samples[0]), err, io.ErrUnexpectedEOF)
+			}
+		})
+	}
+}
+
+func TestReaderLimits(t *testing.T) {
+	file := func(size uint32, header string) []byte {
+		b := append([]byte{}, magic...)
+		b = append(b, byte(Version), byte(Version>>8))
+		b = append(b, byte(size), byte(size>>8), byte(size>>16), byte(size>>24))
+		return append(b, header...)
+	}
+	valid := `{"channels":[{"index":0}]}`
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "huge header", data: file(0xffffffff, valid)},
+		{name: "header larger than the file", data: file(uint32(len(valid)+1), valid)},
+		{name: "huge blocks", data: file(uint32(len(`{"channels":[{"index":0}],"blockFrames":1073741824}`)), `{"channels":[{"index":0}],"blockFrames":1073741824}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewReader(bytes.NewReader(tt.data)); err == nil {
+				t.Errorf("NewReader() succeeded")
+			}
+		})
+	}
+
+	// a block size beyond the end of the file
+	data := append(file(uint32(len(valid)), valid), 0, 0x10, 0, 0)
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	if _, err := r.ReadAll(); err == nil || err == io.ErrUnexpectedEOF {
+		t.Errorf("ReadAll() error = %v, want an invalid block size", err)
+	}
+}
+
+func TestReaderLegacy(t *testing.T) {
+	line := `{"EnabledChannels":[false,true,false,false,false,false,false,false,false,false,false,false,true,false,false,false,false,false,false,false,false,false,false,false],"Gains":[1,2,1,1,1,1,1,1,1,1,1,1,3,1,1,1,1,1,1,1,1,1,1,1],"Window":5}` + "\n"
+	data := append([]byte(line), frames(3, 2)...)
+	// trailing partial frame is ignored
+	data = append(data, 1, 2)
+
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	if r.Header.Version != LegacyVersion {
+		t.Errorf("Version = %d, want %d", r.Header.Version, LegacyVersion)
+	}
+	if r.Header.Window != 5 || len(r.Header.Channels) != 2 || r.Header.Channels[1].Index != 12 || r.Header.Channels[1].Gain != 3 {
+		t.Errorf("Header = %+v", r.Header)
+	}
+	if got := r.FrameCount(int64(len(data))); got != 3 {
+		t.Errorf("FrameCount() = %d, want 3", got)
+	}
+	frame := make([]int32, 2)
+	for i := 0; i < 3; i++ {
+		if err := r.ReadFrame(frame); err != nil {
+			t.Fatalf("ReadFrame() error = %v", err)
+		}
+	}
+	if err := r.ReadFrame(frame); err != io.EOF {
+		t.Errorf("ReadFrame() error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestEstimateSize(t *testing.T) {
+	h := Header{
+		Mode:        "asap",
+		StartTime:   time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC),
+		Channels:    []Channel{{Index: 0, Gain: 1}, {Index: 3, Gain: 1}},
+		BlockFrames: 4,
+	}
+	for _, checksums := range []bool{false, true} {
+		for _, n := range []int{0, 1, 4, 9} {
+			h.BlockChecksums = checksums
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, h)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = w.Write(frames(n, 2))
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := EstimateSize(h, int64(n)); got != int64(buf.Len()) {
+				t.Errorf("EstimateSize(%d frames, checksums %v) = %d, want %d", n, checksums, got, buf.Len())
+			}
+			if got := MaxFrames(h, int64(buf.Len())); got != int64(n) {
+				t.Errorf("MaxFrames(%d bytes, checksums %v) = %d, want %d", buf.Len(), checksums, got, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package record
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Writer writes a recording. Samples written to it are little-endian int32
+// values interleaved by channel, the same layout driver.Convert produces,
+// and are split into blocks of Header.BlockFrames frames.
+type Writer struct {
+	dst    io.Writer
+	header Header
+
+	block     []byte
+	blockSize int
+	frames    int64
+	err       error
+}
+
+// NewWriter writes the file header to dst and returns a Writer for the samples
+func NewWriter(dst io.Writer, h Header) (*Writer, error) {
+	if len(h.Channels) == 0 {
+		return nil, fmt.Errorf("recording has no channels")
+	}
+	h.Version = Version
+	h.BlockFrames = h.blockFrames()
+
+	b, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, len(magic)+6+len(b))
+	buf = append(buf, magic...)
+	temp := make([]byte, 4)
+	binary.LittleEndian.PutUint16(temp, Version)
+	buf = append(buf, temp[:2]...)
+	binary.LittleEndian.PutUint32(temp, uint32(len(b)))
+	buf = append(buf, temp...)
+	buf = append(buf, b...)
+	if _, err := dst.Write(buf); err != nil {
+		return nil, err
+	}
+
+	blockSize := h.BlockFrames * h.FrameSize()
+	return &Writer{
+		dst:       dst,
+		header:    h,
+		block:     make([]byte, 0, blockSize),
+		blockSize: blockSize,
+	}, nil
+}
+
+// headerSize is the number of bytes NewWriter writes before the first block
+func headerSize(h Header) int64 {
+	h.Version = Version
+	h.BlockFrames = h.blockFrames()
+	b, _ := json.Marshal(h)
+	return int64(len(magic) + 6 + len(b))
+}
+
+// blockOverhead is the number of bytes written with every block besides the samples
+func (h Header) blockOverhead() int64 {
+	if h.BlockChecksums {
+		return 8
+	}
+	return 4
+}
+
+// EstimateSize is the size in bytes of a recording with header h holding frames frames
+func EstimateSize(h Header, frames int64) int64 {
+	if frames <= 0 {
+		return headerSize(h)
+	}
+	bf := int64(h.blockFrames())
+	blocks := (frames + bf - 1) / bf
+	return headerSize(h) + frames*int64(h.FrameSize()) + blocks*h.blockOverhead()
+}
+
+// MaxFrames is the number of whole frames a recording with header h can hold in size bytes
+func MaxFrames(h Header, size int64) int64 {
+	size -= headerSize(h)
+	fs := int64(h.FrameSize())
+	if size <= 0 || fs == 0 {
+		return 0
+	}
+	bf := int64(h.blockFrames())
+	block := bf*fs + h.blockOverhead()
+	frames := size / block * bf
+	if rest := size%block - h.blockOverhead(); rest > 0 {
+		frames += rest / fs
+	}
+	return frames
+}
+
+// Write buffers p and writes every completed block to the underlying writer
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := 0
+	for len(p) > 0 {
+		c := w.blockSize - len(w.block)
+		if c > len(p) {
+			c = len(p)
+		}
+		w.block = append(w.block, p[:c]...)
+		p = p[c:]
+		n += c
+		if len(w.block) == w.blockSize {
+			if err := w.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Frames is the number of complete frames written so far
+func (w *Writer) Frames() int64 {
+	return w.frames + int64(len(w.block)/w.header.FrameSize())
+}
+
+// Close writes the last partial block. It does not close the underlying writer.
+// Trailing bytes which do not make up a whole frame are dropped.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.block = w.block[:len(w.block)-len(w.block)%w.header.FrameSize()]
+	if len(w.block) == 0 {
+		return nil
+	}
+	return w.flush()
+}
+
+func (w *Writer) flush() error {
+	temp := make([]byte, 4)
+	binary.LittleEndian.PutUint32(temp, uint32(len(w.block)))
+	if _, w.err = w.dst.Write(temp); w.err != nil {
+		return w.err
+	}
+	if _, w.err = w.dst.Write(w.block); w.err != nil {
+		return w.err
+	}
+	if w.header.BlockChecksums {
+		binary.LittleEndian.PutUint32(temp, crc32.ChecksumIEEE(w.block))
+		if _, w.err = w.dst.Write(temp); w.err != nil {
+			return w.err
+		}
+	}
+	w.frames += int64(len(w.block) / w.header.FrameSize())
+	w.block = w.block[:0]
+	return nil
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/MShoaei/quakeADC/driver"
@@ -864,27 +865,32 @@ func newAdcChopControlCommand() *cobra.Command {
 
 	return cmd
 }
-func newAdcHardResetCommand() *cobra.Command {
+func newAdcResetCommand(use string, mode driver.ResetMode) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "HardReset",
-		Short: "Perform hard reset",
-		Long:  `Hard reset is recommended before using the adc`,
+		Use:   use,
+		Short: fmt.Sprintf("Perform %s reset and check the response of the chips", mode),
+		Long: `Every register returns to its default value. A hard reset pulses the reset
+line shared by every ADC and needs --adc 0. Single ADCs can only be soft reset. The server reapplies its acquisition
+profile and calibration only when the reset is made through its API.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			var (
-				err error
-				tx  = make([]byte, 2)
-				rx  = make([]byte, 2)
-			)
-
-			_, _, err = adcConnection.HardReset(nil)
+			var chips []uint8
+			if chipSelect != 0 {
+				chips = []uint8{chipSelect}
+			}
+			x := driver.NewXMega(adcConnection.Connection())
+			results, err := adcConnection.ResetChips(x, mode, chips)
 			if err != nil {
 				return err
 			}
-
-			if debug {
-				log.Println(tx, rx)
+			if err := printJSON(results); err != nil {
+				return err
 			}
-			return err
+			for _, r := range results {
+				if !r.Pass {
+					return fmt.Errorf("chip %d did not reset", r.Chip)
+				}
+			}
+			return nil
 		},
 	}
 	return cmd
@@ -919,7 +925,8 @@ func init() {
 		newAdcDiagnosticMuxControlCommand(),
 		newAdcModulatorDelayControlCommand(),
 		newAdcChopControlCommand(),
-		newAdcHardResetCommand(),
+		newAdcResetCommand("HardReset", driver.ResetHard),
+		newAdcResetCommand("SoftReset", driver.ResetSoft),
 	)
 
 	f = adcCmd.PersistentFlags()
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/MShoaei/quakeADC/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// readAuthConfig reads the auth section of the config file
+func readAuthConfig() (server.AuthConfig, error) {
+	var cfg server.AuthConfig
+	if err := viper.UnmarshalKey("auth", &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid auth config: %v", err)
+	}
+	return cfg, cfg.Validate()
+}
+
+// writeAuthConfig replaces the users and tokens in the config file
+func writeAuthConfig(cfg server.AuthConfig) error {
+	users := make([]map[string]string, 0, len(cfg.Users))
+	for _, u := range cfg.Users {
+		users = append(users, map[string]string{"name": u.Name, "role": string(u.Role), "passwordHash": u.PasswordHash})
+	}
+	tokens := make([]map[string]string, 0, len(cfg.Tokens))
+	for _, t := range cfg.Tokens {
+		tokens = append(tokens, map[string]string{"name": t.Name, "role": string(t.Role), "hash": t.Hash})
+	}
+	viper.Set("auth.users", users)
+	viper.Set("auth.tokens", tokens)
+
+	file := viper.ConfigFileUsed()
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		file = path.Join(home, "rpiGo", ".rpiCMD.yaml")
+		if err := os.MkdirAll(path.Dir(file), 0700); err != nil {
+			return err
+		}
+	}
+	if err := viper.WriteConfigAs(file); err != nil {
+		return err
+	}
+	return os.Chmod(file, 0600)
+}
+
+func newAuthCommand() *cobra.Command {
+	var role string
+	cmd := &cobra.Command{
+		Use:   "auth",
+		Short: "manage the users and tokens of the server",
+		// managing users does not use the spi connection
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return nil
+		},
+	}
+
+	addUser := &cobra.Command{
+		Use:   "add-user name",
+		Short: "add a user or change its password and role. the password is read from stdin",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := readAuthConfig()
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(os.Stderr, "password: ")
+			password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			password = strings.TrimRight(password, "\r\n")
+			if password == "" {
+				return fmt.Errorf("empty password: %v", err)
+			}
+			hash, err := server.HashPassword(password)
+			if err != nil {
+				return err
+			}
+			user := server.User{Name: args[0], Role: server.Role(role), PasswordHash: hash}
+			replaced := false
+			for i := range cfg.Users {
+				if cfg.Users[i].Name == user.Name {
+					cfg.Users[i] = user
+					replaced = true
+				}
+			}
+			if !replaced {
+				cfg.Users = append(cfg.Users, user)
+			}
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			return writeAuthConfig(cfg)
+		},
+	}
+	addUser.Flags().StringVar(&role, "role", "viewer", "role of the user. viewer, operator or admin")
+
+	addToken := &cobra.Command{
+		Use:   "add-token name",
+		Short: "create an API token. the token is printed once and only its hash is stored",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := readAuthConfig()
+			if err != nil {
+				return err
+			}
+			token, err := server.NewToken()
+			if err != nil {
+				return err
+			}
+			cfg.Tokens = append(cfg.Tokens, server.APIToken{Name: args[0], Role: server.Role(role), Hash: server.HashToken(token)})
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			if err := writeAuthConfig(cfg); err != nil {
+				return err
+			}
+			fmt.Println(token)
+			return nil
+		},
+	}
+	addToken.Flags().StringVar(&role, "role", "viewer", "role of the token. viewer, operator or admin")
+
+	remove := &cobra.Command{
+		Use:   "remove name",
+		Short: "remove the users and tokens with name",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := readAuthConfig()
+			if err != nil {
+				return err
+			}
+			users := cfg.Users[:0]
+			for _, u := range cfg.Users {
+				if u.Name != args[0] {
+					users = append(users, u)
+				}
+			}
+			tokens := cfg.Tokens[:0]
+			for _, t := range cfg.Tokens {
+				if t.Name != args[0] {
+					tokens = append(tokens, t)
+				}
+			}
+			cfg.Users, cfg.Tokens = users, tokens
+			return writeAuthConfig(cfg)
+		},
+	}
+
+	cmd.AddCommand(addUser, addToken, remove)
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newAuthCommand())
+}
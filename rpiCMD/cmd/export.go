@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"os"
+	"path"
+
+	"github.com/MShoaei/quakeADC/sensor"
+	"github.com/MShoaei/quakeADC/server"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+func newExportCommand() *cobra.Command {
+	options := struct {
+		data         string
+		output       string
+		unit         string
+		samplingTime float32
+		archive      server.ArchiveOptions
+	}{}
+	cmd := &cobra.Command{
+		Use:   "export project",
+		Short: "export a project as a zip or tar.gz archive",
+		Long: `export every recording of a project converted to raw, seg2, segy or csv
+as a single zip or tar.gz archive with a manifest of the files, their checksums and acquisition metadata.`,
+		Args: cobra.ExactArgs(1),
+		// exporting does not use the spi connection
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			unit, err := sensor.ParseUnit(options.unit)
+			if err != nil {
+				return err
+			}
+			options.archive.Unit = unit
+			options.archive.SamplingTime = options.samplingTime
+			if err := options.archive.Validate(); err != nil {
+				return err
+			}
+
+			if options.output == "" {
+				options.output = path.Base(args[0]) + "." + options.archive.Archive
+			}
+			out, err := os.Create(options.output)
+			if err != nil {
+				return err
+			}
+			dataFS := afero.NewBasePathFs(afero.NewOsFs(), options.data)
+			err = server.WriteProjectArchive(out, dataFS, args[0], options.archive)
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				_ = os.Remove(options.output)
+			}
+			return err
+		},
+	}
+	f := cmd.Flags()
+	f.SortFlags = false
+	f.StringVar(&options.data, "data", "data", "data directory of the server")
+	f.StringVarP(&options.output, "output", "o", "", "output file (default is project name with archive extension)")
+	f.StringVar(&options.archive.Archive, "archive", "zip", "archive type. zip or tar.gz")
+	f.StringVar(&options.archive.Type, "type", "raw", "file type of the recordings. raw, seg2, segy or csv")
+	f.StringVar(&options.unit, "unit", "counts", "unit of csv samples and seg-y transduction constant")
+	f.Float32Var(&options.samplingTime, "sampling-time", 0, "sampling time in microseconds for recordings without one in their header")
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newExportCommand())
+}
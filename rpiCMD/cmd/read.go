@@ -22,9 +22,13 @@ func newMonitorLiveCommand() *cobra.Command {
 	}{}
 	cmd := &cobra.Command{
 		Use: "monitor",
-		Run: func(cmd *cobra.Command, args []string) {
-			f, _ := os.Create("test.raw")
-			driver.MonitorLive(f, options.sample)
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := os.Create("test.raw")
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return driver.MonitorLive(f, options.sample)
 		},
 	}
 	f := cmd.Flags()
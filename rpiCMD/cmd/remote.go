@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+
+	"github.com/MShoaei/quakeADC/client"
+	"github.com/MShoaei/quakeADC/sensor"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func newRemoteCommand() *cobra.Command {
+	var (
+		c        *client.Client
+		insecure bool
+	)
+	cmd := &cobra.Command{
+		Use:   "remote",
+		Short: "control a unit running the server over its HTTP API",
+		Long: `control a unit running the server over its HTTP API.
+the address and token default to remote.url and remote.token of the config file.`,
+		// the remote unit uses its own spi connection
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			c = client.New(viper.GetString("remote.url"), viper.GetString("remote.token"))
+			if c.BaseURL == "" {
+				return fmt.Errorf("no server address. use --url or remote.url in the config file")
+			}
+			if insecure {
+				c.HTTPClient = &http.Client{Transport: &http.Transport{
+					// units serve a self-signed certificate by default
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+				}}
+			}
+			return nil
+		},
+	}
+	pf := cmd.PersistentFlags()
+	pf.String("url", "http://localhost:9090", "address of the server")
+	pf.String("token", "", "API token or session token")
+	pf.BoolVar(&insecure, "insecure", false, "do not verify the certificate of the server")
+	_ = viper.BindPFlag("remote.url", pf.Lookup("url"))
+	_ = viper.BindPFlag("remote.token", pf.Lookup("token"))
+
+	status := &cobra.Command{
+		Use:   "status",
+		Short: "show whether a recording is running and the storage of the unit",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			running, err := c.Recording()
+			if err != nil {
+				return err
+			}
+			project, err := c.ActiveProject()
+			if err != nil {
+				return err
+			}
+			storage, err := c.Storage()
+			if err != nil {
+				return err
+			}
+			return printJSON(struct {
+				Recording     bool           `json:"recording"`
+				ActiveProject string         `json:"activeProject"`
+				Storage       client.Storage `json:"storage"`
+			}{running, project, storage})
+		},
+	}
+
+	ls := &cobra.Command{
+		Use:   "ls [dir]",
+		Short: "list a directory of the data root",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "/"
+			if len(args) == 1 {
+				dir = args[0]
+			}
+			items, err := c.Tree(dir)
+			if err != nil {
+				return err
+			}
+			for _, item := range items {
+				if item.Dir {
+					fmt.Println(item.Name + "/")
+				} else {
+					fmt.Println(item.Name)
+				}
+			}
+			return nil
+		},
+	}
+
+	var setup client.SetupRequest
+	record := &cobra.Command{
+		Use:   "record",
+		Short: "make a recording in the active project and wait for it to finish",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			res, err := c.Setup(setup)
+			if err != nil {
+				return err
+			}
+			return printJSON(res)
+		},
+	}
+	rf := record.Flags()
+	rf.StringVar(&setup.StartMode, "mode", "asap", "start mode. asap or hammer")
+	rf.IntVar(&setup.RecordTime, "time", 1, "record time in seconds")
+	rf.Float32Var(&setup.SamplingTime, "sampling-time", 1000, "sampling time in microseconds")
+	rf.IntVar(&setup.Window, "window", 0, "window of the recording")
+	rf.StringVar(&setup.FileName, "name", "", "file name of the recording")
+	rf.IntVar(&setup.Shot, "shot", 0, "shot number. 0 uses the current shot of the project survey")
+
+	recordings := &cobra.Command{
+		Use:   "recordings [field=value]...",
+		Short: "search the recordings of the unit",
+		Long: `search the recordings of the unit. each argument is a query parameter of
+GET /recordings e.g. project=/line1 shot.min=3 sort=startTime order=desc limit=10`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			q := url.Values{}
+			for _, arg := range args {
+				v, err := url.ParseQuery(arg)
+				if err != nil {
+					return fmt.Errorf("invalid filter %q: %v", arg, err)
+				}
+				for k, vs := range v {
+					q[k] = append(q[k], vs...)
+				}
+			}
+			res, err := c.Recordings(q)
+			if err != nil {
+				return err
+			}
+			return printJSON(res)
+		},
+	}
+
+	var (
+		fileType string
+		unit     string
+		output   string
+	)
+	download := &cobra.Command{
+		Use:   "download path",
+		Short: "download a recording converted to raw, seg2, segy, sac or csv",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			u, err := sensor.ParseUnit(unit)
+			if err != nil {
+				return err
+			}
+			if output == "" {
+				output = path.Base(args[0]) + "." + fileType
+			}
+			out, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			err = c.Download(out, args[0], fileType, u)
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				_ = os.Remove(output)
+			}
+			return err
+		},
+	}
+	df := download.Flags()
+	df.StringVarP(&fileType, "type", "t", "raw", "file type. raw, seg2, segy, sac or csv")
+	df.StringVarP(&unit, "unit", "u", "", "unit of the samples. counts when empty")
+	df.StringVarP(&output, "output", "o", "", "output file. defaults to the name of the recording")
+
+	cmd.AddCommand(status, ls, record, recordings, download)
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newRemoteCommand())
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/MShoaei/quakeADC/driver"
+	"github.com/spf13/cobra"
+)
+
+func newSelfTestCommand() *cobra.Command {
+	var (
+		opts    driver.SelfTestOptions
+		chips   []uint
+		capture bool
+	)
+	cmd := &cobra.Command{
+		Use:   "selftest",
+		Short: "test the ADCs and print a report",
+		Long: `test the status, revision and RAM of the ADCs and capture the internal
+zero-scale, positive full-scale and negative full-scale inputs on every channel.
+the server must be stopped and the hardware initialized by it before.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, cs := range chips {
+				if cs < 1 || cs > 9 {
+					return fmt.Errorf("invalid chip %d. expected 1 to 9", cs)
+				}
+				opts.Chips = append(opts.Chips, uint8(cs))
+			}
+			x := driver.NewXMega(adcConnection.Connection())
+			if capture && opts.Logic == "" {
+				list, err := driver.DetectLogicConnString(x)
+				if err != nil {
+					return err
+				}
+				opts.Logic = list[0]
+			}
+			if !capture {
+				opts.Logic = ""
+			}
+
+			report := adcConnection.SelfTest(x, opts)
+			if err := printJSON(report); err != nil {
+				return err
+			}
+			if !report.Pass {
+				return fmt.Errorf("self test failed")
+			}
+			return nil
+		},
+	}
+	f := cmd.Flags()
+	f.UintSliceVar(&chips, "chips", nil, "chip selects to test. all 9 when empty")
+	f.BoolVar(&capture, "capture", true, "capture the diagnostic inputs with the logic analyzer")
+	f.StringVar(&opts.Logic, "logic", "", "connection of the logic analyzer. detected when empty")
+	f.IntVar(&opts.CaptureTime, "time", 256, "capture time of every input in milliseconds")
+	f.Float64Var(&opts.Tolerance, "tolerance", 0.05, "largest difference from the expected value as a fraction of full scale")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newSelfTestCommand())
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/MShoaei/quakeADC/server"
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // serverCmd represents the server command
@@ -26,15 +27,59 @@ var serverCmd = &cobra.Command{
 		dataFS := afero.NewBasePathFs(afero.NewOsFs(), path.Join(wd, "data"))
 		memFS := afero.NewMemMapFs()
 
-		s := server.NewServer(dataFS, memFS, adcConnection, debug)
+		s := server.NewServer(dataFS, memFS, server.NewStorageManager(), adcConnection, debug)
+		auth, err := readAuthConfig()
+		if err != nil {
+			log.Fatalf("failed to read auth config: %v", err)
+		}
+		if err := s.SetAuth(auth); err != nil {
+			log.Fatalf("invalid auth config: %v", err)
+		}
+		telemetry := server.DefaultTelemetryConfig()
+		if err := viper.UnmarshalKey("telemetry", &telemetry); err != nil {
+			log.Fatalf("invalid telemetry config: %v", err)
+		}
+		if err := s.SetTelemetry(telemetry); err != nil {
+			log.Fatalf("invalid telemetry config: %v", err)
+		}
+		health := server.DefaultHealthConfig()
+		if err := viper.UnmarshalKey("health", &health); err != nil {
+			log.Fatalf("invalid health config: %v", err)
+		}
+		if err := s.SetHealth(health); err != nil {
+			log.Fatalf("invalid health config: %v", err)
+		}
+		events := server.DefaultEventsConfig()
+		if err := viper.UnmarshalKey("events", &events); err != nil {
+			log.Fatalf("invalid events config: %v", err)
+		}
+		if err := s.SetEvents(events); err != nil {
+			log.Fatalf("invalid events config: %v", err)
+		}
 		if runtime.GOARCH == "arm" {
-			if err := s.HardwareInitSeq(); err != nil {
-				log.Fatalf("hardware init failed: %v", err)
-			}
-			log.Println("hardware init SUCCESSFUL")
+			s.InitHardware()
 		}
 
-		_ = s.Run()
+		var tlsConfig server.TLSConfig
+		if err := viper.UnmarshalKey("tls", &tlsConfig); err != nil {
+			log.Fatalf("invalid tls config: %v", err)
+		}
+		if !tlsConfig.Enabled {
+			_ = s.Run()
+			return
+		}
+		if tlsConfig.Addr == "" {
+			tlsConfig.Addr = ":8443"
+		}
+		if tlsConfig.CertFile == "" {
+			tlsConfig.CertFile = path.Join(wd, "tls", "cert.pem")
+		}
+		if tlsConfig.KeyFile == "" {
+			tlsConfig.KeyFile = path.Join(wd, "tls", "key.pem")
+		}
+		if err := s.RunTLS(tlsConfig.Addr, tlsConfig); err != nil {
+			log.Fatalf("https server failed: %v", err)
+		}
 	},
 }
 
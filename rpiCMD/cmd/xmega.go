@@ -11,28 +11,31 @@ import (
 
 // xmegaCmd represents the adc command
 var xmegaCmd = &cobra.Command{
-	Use:   "xmega",
+	Use:   "xmega opcode argument",
 	Short: "command to control XMega over SPI",
-	Long:  "command to control XMega over SPI",
+	Long:  "command to control XMega over SPI. takes the opcode and argument of the frame in hex",
+	Args:  cobra.ExactArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		h, _ := strconv.ParseUint(args[0], 16, 8)
-		l, _ := strconv.ParseUint(args[1], 16, 16)
-
-		tx := []byte{uint8(h), uint8(l), 0}
-		rx := make([]byte, 3)
+		op, err := strconv.ParseUint(args[0], 16, 8)
+		if err != nil {
+			return fmt.Errorf("invalid opcode %q: %v", args[0], err)
+		}
+		arg, err := strconv.ParseUint(args[1], 16, 8)
+		if err != nil {
+			return fmt.Errorf("invalid argument %q: %v", args[1], err)
+		}
 
 		conn, err := spi.GetSpiConnection(0, 0, 0, 8, 50000)
 		if err != nil {
-			return nil
+			return err
 		}
 		defer conn.Close()
 
-		_ = driver.EnableChipSelect(0)
-		if err := conn.Tx(tx, rx); err != nil {
+		rx, err := driver.NewXMega(conn).Command(driver.Opcode(op), uint8(arg))
+		if err != nil {
 			return err
 		}
-		_ = driver.DisableChipSelect(0)
-		fmt.Println(tx, rx)
+		fmt.Printf("%s %02x: % x\n", driver.Opcode(op), arg, rx)
 
 		return nil
 	},
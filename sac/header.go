@@ -0,0 +1,48 @@
+package sac
+
+import "time"
+
+type dependentVariable int32
+
+const (
+	Unknown dependentVariable = 5 + iota
+	Displacement
+	Velocity
+	Acceleration
+	Volts dependentVariable = 50
+)
+
+const (
+	undefinedFloat  float32 = -12345.0
+	undefinedInt    int32   = -12345
+	undefinedString         = "-12345  "
+
+	headerVersion int32 = 6
+
+	// iftype: time series file
+	fileTypeTime int32 = 1
+	// iztype: reference time is the begin time
+	referenceBegin int32 = 9
+)
+
+// Header holds the SAC header variables that can be set for a trace.
+// Every other header variable is written as undefined.
+type Header struct {
+	// sampling interval in seconds (delta)
+	Delta float32
+
+	// begin time relative to the reference time in seconds (b)
+	B float32
+
+	// reference time. zero value leaves nzyear..nzmsec undefined
+	Reference time.Time
+
+	// station name, at most 8 characters (kstnm)
+	Station string
+
+	// component name, at most 8 characters (kcmpnm)
+	Component string
+
+	// type of the dependent variable (idep)
+	Unit dependentVariable
+}
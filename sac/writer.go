@@ -0,0 +1,148 @@
+package sac
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	floatCount  = 70
+	intCount    = 40
+	headerBytes = floatCount*4 + intCount*4 + 24*8
+)
+
+// float header variable indices
+const (
+	delta  = 0
+	depmin = 1
+	depmax = 2
+	b      = 5
+	e      = 6
+	depmen = 56
+)
+
+// integer header variable indices
+const (
+	nzyear = 0 + iota
+	nzjday
+	nzhour
+	nzmin
+	nzsec
+	nzmsec
+	nvhdr
+	_ // norid
+	_ // nevid
+	npts
+	_ // internal
+	_ // nwfid
+	_ // nxsize
+	_ // nysize
+	_ // unused
+	iftype
+	idep
+	iztype
+	leven = 35
+)
+
+// character header variable byte offsets from the start of the character block
+const (
+	kstnm  = 0
+	kevnm  = 8
+	kcmpnm = 160
+)
+
+// Write encodes data as a little-endian evenly spaced SAC binary file.
+func Write(dst io.Writer, h Header, data []float32) error {
+	if h.Delta <= 0 {
+		return fmt.Errorf("invalid sampling interval %v", h.Delta)
+	}
+	if len(h.Station) > 8 {
+		return fmt.Errorf("station name %q is longer than 8 characters", h.Station)
+	}
+	if len(h.Component) > 8 {
+		return fmt.Errorf("component name %q is longer than 8 characters", h.Component)
+	}
+
+	buf := make([]byte, headerBytes+len(data)*4)
+	floats := buf[:floatCount*4]
+	ints := buf[floatCount*4 : (floatCount+intCount)*4]
+	chars := buf[(floatCount+intCount)*4 : headerBytes]
+
+	putFloat := func(index int, v float32) {
+		binary.LittleEndian.PutUint32(floats[index*4:], math.Float32bits(v))
+	}
+	putInt := func(index int, v int32) {
+		binary.LittleEndian.PutUint32(ints[index*4:], uint32(v))
+	}
+	putString := func(offset, size int, s string) {
+		copy(chars[offset:offset+size], fmt.Sprintf("%-*s", size, s))
+	}
+
+	for i := 0; i < floatCount; i++ {
+		putFloat(i, undefinedFloat)
+	}
+	for i := 0; i < intCount; i++ {
+		putInt(i, undefinedInt)
+	}
+	for i := 0; i < len(chars); i += 8 {
+		putString(i, 8, undefinedString)
+	}
+	// kevnm is the only 16 character variable
+	putString(kevnm, 16, undefinedString)
+
+	putFloat(delta, h.Delta)
+	putFloat(b, h.B)
+	putFloat(e, h.B+float32(len(data)-1)*h.Delta)
+	if len(data) > 0 {
+		min, max, sum := data[0], data[0], float64(0)
+		for _, v := range data {
+			if v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
+			sum += float64(v)
+		}
+		putFloat(depmin, min)
+		putFloat(depmax, max)
+		putFloat(depmen, float32(sum/float64(len(data))))
+	}
+
+	if !h.Reference.IsZero() {
+		t := h.Reference.UTC()
+		putInt(nzyear, int32(t.Year()))
+		putInt(nzjday, int32(t.YearDay()))
+		putInt(nzhour, int32(t.Hour()))
+		putInt(nzmin, int32(t.Minute()))
+		putInt(nzsec, int32(t.Second()))
+		putInt(nzmsec, int32(t.Nanosecond()/1e6))
+		putInt(iztype, referenceBegin)
+	}
+	putInt(nvhdr, headerVersion)
+	putInt(npts, int32(len(data)))
+	putInt(iftype, fileTypeTime)
+	unit := h.Unit
+	if unit == 0 {
+		unit = Unknown
+	}
+	putInt(idep, int32(unit))
+	putInt(leven, 1)
+
+	if h.Station != "" {
+		putString(kstnm, 8, h.Station)
+	}
+	if h.Component != "" {
+		putString(kcmpnm, 8, h.Component)
+	}
+
+	samples := buf[headerBytes:]
+	for i, v := range data {
+		binary.LittleEndian.PutUint32(samples[i*4:], math.Float32bits(v))
+	}
+
+	_, err := dst.Write(buf)
+	return err
+}
@@ -0,0 +1,88 @@
+package sac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  Header
+		data    []float32
+		wantErr bool
+	}{
+		{
+			name: "three samples",
+			header: Header{
+				Delta:     0.001,
+				Reference: time.Date(2020, 2, 1, 10, 20, 30, 400e6, time.UTC),
+				Station:   "CH01",
+				Component: "Z",
+				Unit:      Volts,
+			},
+			data: []float32{1, -2, 4},
+		},
+		{
+			name:    "invalid delta",
+			header:  Header{},
+			wantErr: true,
+		},
+		{
+			name:    "long station name",
+			header:  Header{Delta: 1, Station: "STATION01"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			err := Write(buf, tt.header, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			b := buf.Bytes()
+			if len(b) != headerBytes+4*len(tt.data) {
+				t.Fatalf("Write() wrote %d bytes, want %d", len(b), headerBytes+4*len(tt.data))
+			}
+			float := func(i int) float32 { return math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:])) }
+			integer := func(i int) int32 { return int32(binary.LittleEndian.Uint32(b[(floatCount+i)*4:])) }
+			chars := b[(floatCount+intCount)*4:]
+
+			if got := float(delta); got != tt.header.Delta {
+				t.Errorf("delta = %v, want %v", got, tt.header.Delta)
+			}
+			if got := float(depmax); got != 4 {
+				t.Errorf("depmax = %v, want 4", got)
+			}
+			if got := integer(npts); got != int32(len(tt.data)) {
+				t.Errorf("npts = %v, want %v", got, len(tt.data))
+			}
+			if got := integer(nzjday); got != 32 {
+				t.Errorf("nzjday = %v, want 32", got)
+			}
+			if got := integer(nzmsec); got != 400 {
+				t.Errorf("nzmsec = %v, want 400", got)
+			}
+			if got := integer(idep); got != int32(Volts) {
+				t.Errorf("idep = %v, want %v", got, Volts)
+			}
+			if got := string(chars[kstnm : kstnm+8]); got != "CH01    " {
+				t.Errorf("kstnm = %q, want %q", got, "CH01    ")
+			}
+			if got := string(chars[kcmpnm : kcmpnm+8]); got != "Z       " {
+				t.Errorf("kcmpnm = %q, want %q", got, "Z       ")
+			}
+			if got := math.Float32frombits(binary.LittleEndian.Uint32(b[headerBytes+4:])); got != -2 {
+				t.Errorf("second sample = %v, want -2", got)
+			}
+		})
+	}
+}
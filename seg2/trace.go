@@ -1,5 +1,7 @@
 package seg2
 
+import "encoding/binary"
+
 type dataFormat byte
 
 const (
@@ -71,3 +73,19 @@ func NewTraceDescriptor(info []string, data [][]byte, format dataFormat) []*trac
 	}
 	return result
 }
+
+// Strings encodes keyword strings such as "SAMPLE_INTERVAL 0.001" as a SEG2
+// string list which can be used as the info of a trace descriptor block.
+func Strings(keywords ...string) string {
+	b := make([]byte, 0, 64)
+	temp := make([]byte, 2, 2)
+	for _, k := range keywords {
+		binary.LittleEndian.PutUint16(temp, uint16(2+len(k)+int(sizeOfStringTerminator)))
+		b = append(b, temp...)
+		b = append(b, k...)
+		b = append(b, byte(firstStringTerminatorChar))
+	}
+	// a zero offset marks the end of the list
+	b = append(b, 0, 0)
+	return string(b)
+}
@@ -0,0 +1,225 @@
+package segy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+type measurementUnit int16
+
+const (
+	Unknown measurementUnit = 0 + iota
+	Pascal
+	Volts
+	Millivolts
+	Amperes
+	Meters
+	MetersPerSecond
+	MetersPerSecondSquared
+)
+
+const (
+	textHeaderSize   = 3200
+	binaryHeaderSize = 400
+	traceHeaderSize  = 240
+
+	textLines    = 40
+	textLineSize = 80
+
+	// 4-byte two's complement integer
+	formatInt32 int16 = 2
+	// as recorded, no sorting
+	sortingAsRecorded int16 = 1
+	measurementMeters int16 = 1
+	revision1         int16 = 0x0100
+	// seismic data
+	traceIDSeismic   int16 = 1
+	timeBasisUTC     int16 = 4
+	coordinateMeters int16 = 1
+
+	maxSamples = math.MaxUint16
+)
+
+// coordinateScalar stores coordinates and elevations in centimeters. it is a
+// variable so the negative value can be converted to the unsigned header field.
+var coordinateScalar int16 = -100
+
+// FileHeader holds the values written to the textual and binary file headers
+type FileHeader struct {
+	// Text lines are written to the textual header. At most 38 lines of 76 characters are kept.
+	Text []string
+
+	JobID      int32
+	LineNumber int32
+
+	// SampleInterval is rounded to the nearest microsecond and must be smaller than 65.536ms
+	SampleInterval time.Duration
+}
+
+// TraceHeader holds the values written to the header of a single trace
+type TraceHeader struct {
+	// FieldRecord is the original field record or shot number
+	FieldRecord int32
+
+	// Channel is the trace number within the field record
+	Channel int32
+
+	// SourcePoint is the energy source point number
+	SourcePoint int32
+
+	// Source and receiver coordinates and elevations in meters
+	SourceX, SourceY, SourceElevation       float64
+	ReceiverX, ReceiverY, ReceiverElevation float64
+
+	// Offset is the distance from source to receiver in meters
+	Offset float64
+
+	StartTime time.Time
+
+	// Unit of the samples after multiplying by TransductionConstant
+	Unit                 measurementUnit
+	TransductionConstant float64
+}
+
+// Trace is a single trace of 32 bit integer samples
+type Trace struct {
+	Header TraceHeader
+	Data   []int32
+}
+
+// Write encodes traces as a big-endian SEG-Y revision 1 file. Every trace must have the same number of samples.
+func Write(dst io.Writer, h FileHeader, traces []Trace) error {
+	interval := (h.SampleInterval + time.Microsecond/2) / time.Microsecond
+	if interval <= 0 || interval > math.MaxUint16 {
+		return fmt.Errorf("invalid sample interval %v", h.SampleInterval)
+	}
+	samples := 0
+	if len(traces) > 0 {
+		samples = len(traces[0].Data)
+	}
+	if samples > maxSamples {
+		return fmt.Errorf("too many samples per trace. SEG-Y supports at most %d, got %d", maxSamples, samples)
+	}
+	for i := range traces {
+		if len(traces[i].Data) != samples {
+			return fmt.Errorf("trace %d has %d samples, expected %d", i+1, len(traces[i].Data), samples)
+		}
+	}
+
+	w := bufio.NewWriter(dst)
+	if _, err := w.Write(textHeader(h.Text)); err != nil {
+		return err
+	}
+
+	b := make([]byte, binaryHeaderSize)
+	binary.BigEndian.PutUint32(b[0:], uint32(h.JobID))
+	binary.BigEndian.PutUint32(b[4:], uint32(h.LineNumber))
+	binary.BigEndian.PutUint16(b[12:], uint16(len(traces)))
+	binary.BigEndian.PutUint16(b[16:], uint16(interval))
+	binary.BigEndian.PutUint16(b[18:], uint16(interval))
+	binary.BigEndian.PutUint16(b[20:], uint16(samples))
+	binary.BigEndian.PutUint16(b[22:], uint16(samples))
+	binary.BigEndian.PutUint16(b[24:], uint16(formatInt32))
+	binary.BigEndian.PutUint16(b[26:], 1)
+	binary.BigEndian.PutUint16(b[28:], uint16(sortingAsRecorded))
+	binary.BigEndian.PutUint16(b[54:], uint16(measurementMeters))
+	binary.BigEndian.PutUint16(b[300:], uint16(revision1))
+	// fixed length traces
+	binary.BigEndian.PutUint16(b[302:], 1)
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+
+	b = make([]byte, traceHeaderSize)
+	data := make([]byte, samples*4)
+	for i, t := range traces {
+		for j := range b {
+			b[j] = 0
+		}
+		binary.BigEndian.PutUint32(b[0:], uint32(i+1))
+		binary.BigEndian.PutUint32(b[4:], uint32(i+1))
+		binary.BigEndian.PutUint32(b[8:], uint32(t.Header.FieldRecord))
+		binary.BigEndian.PutUint32(b[12:], uint32(t.Header.Channel))
+		binary.BigEndian.PutUint32(b[16:], uint32(t.Header.SourcePoint))
+		binary.BigEndian.PutUint16(b[28:], uint16(traceIDSeismic))
+		binary.BigEndian.PutUint32(b[36:], uint32(int32(math.Round(t.Header.Offset))))
+		binary.BigEndian.PutUint32(b[40:], uint32(scaled(t.Header.ReceiverElevation)))
+		binary.BigEndian.PutUint32(b[44:], uint32(scaled(t.Header.SourceElevation)))
+		binary.BigEndian.PutUint16(b[68:], uint16(coordinateScalar))
+		binary.BigEndian.PutUint16(b[70:], uint16(coordinateScalar))
+		binary.BigEndian.PutUint32(b[72:], uint32(scaled(t.Header.SourceX)))
+		binary.BigEndian.PutUint32(b[76:], uint32(scaled(t.Header.SourceY)))
+		binary.BigEndian.PutUint32(b[80:], uint32(scaled(t.Header.ReceiverX)))
+		binary.BigEndian.PutUint32(b[84:], uint32(scaled(t.Header.ReceiverY)))
+		binary.BigEndian.PutUint16(b[88:], uint16(coordinateMeters))
+		binary.BigEndian.PutUint16(b[114:], uint16(samples))
+		binary.BigEndian.PutUint16(b[116:], uint16(interval))
+		if !t.Header.StartTime.IsZero() {
+			st := t.Header.StartTime.UTC()
+			binary.BigEndian.PutUint16(b[156:], uint16(st.Year()))
+			binary.BigEndian.PutUint16(b[158:], uint16(st.YearDay()))
+			binary.BigEndian.PutUint16(b[160:], uint16(st.Hour()))
+			binary.BigEndian.PutUint16(b[162:], uint16(st.Minute()))
+			binary.BigEndian.PutUint16(b[164:], uint16(st.Second()))
+			binary.BigEndian.PutUint16(b[166:], uint16(timeBasisUTC))
+		}
+		binary.BigEndian.PutUint16(b[202:], uint16(t.Header.Unit))
+		mantissa, exponent := transduction(t.Header.TransductionConstant)
+		binary.BigEndian.PutUint32(b[204:], uint32(mantissa))
+		binary.BigEndian.PutUint16(b[208:], uint16(exponent))
+		binary.BigEndian.PutUint16(b[210:], uint16(t.Header.Unit))
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+
+		for j, v := range t.Data {
+			binary.BigEndian.PutUint32(data[j*4:], uint32(v))
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func textHeader(text []string) []byte {
+	lines := make([]string, textLines)
+	for i := range lines {
+		var line string
+		switch {
+		case i == textLines-2:
+			line = "SEG Y REV1"
+		case i == textLines-1:
+			line = "END TEXTUAL HEADER"
+		case i < len(text):
+			line = text[i]
+		}
+		if len(line) > textLineSize-4 {
+			line = line[:textLineSize-4]
+		}
+		lines[i] = fmt.Sprintf("C%2d %-76s", i+1, line)
+	}
+	b := make([]byte, 0, textHeaderSize)
+	for _, line := range lines {
+		b = append(b, toEBCDIC(line)...)
+	}
+	return b
+}
+
+// scaled converts meters to the integer stored with coordinateScalar
+func scaled(v float64) int32 {
+	return int32(math.Round(v * -float64(coordinateScalar)))
+}
+
+// transduction splits v into mantissa and exponent such that v = mantissa * 10^exponent
+func transduction(v float64) (int32, int16) {
+	if v == 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, 0
+	}
+	exponent := int(math.Floor(math.Log10(math.Abs(v)))) - 8
+	return int32(math.Round(v / math.Pow10(exponent))), int16(exponent)
+}
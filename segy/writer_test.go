@@ -0,0 +1,109 @@
+package segy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	start := time.Date(2020, 2, 1, 10, 20, 30, 0, time.UTC)
+	tests := []struct {
+		name    string
+		header  FileHeader
+		traces  []Trace
+		wantErr bool
+	}{
+		{
+			name:   "two traces",
+			header: FileHeader{Text: []string{"CLIENT TEST"}, SampleInterval: 500 * time.Microsecond},
+			traces: []Trace{
+				{Header: TraceHeader{Channel: 1, StartTime: start, Unit: Volts, TransductionConstant: 4.8828125e-07}, Data: []int32{1, -2, 3}},
+				{Header: TraceHeader{Channel: 3, StartTime: start, Unit: Volts}, Data: []int32{4, 5, -6}},
+			},
+		},
+		{
+			name:    "invalid sample interval",
+			header:  FileHeader{},
+			wantErr: true,
+		},
+		{
+			name:   "different trace lengths",
+			header: FileHeader{SampleInterval: time.Millisecond},
+			traces: []Trace{
+				{Data: []int32{1, 2}},
+				{Data: []int32{1}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			err := Write(buf, tt.header, tt.traces)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			samples := len(tt.traces[0].Data)
+			b := buf.Bytes()
+			want := textHeaderSize + binaryHeaderSize + len(tt.traces)*(traceHeaderSize+samples*4)
+			if len(b) != want {
+				t.Fatalf("Write() wrote %d bytes, want %d", len(b), want)
+			}
+			if !bytes.Equal(b[:4], toEBCDIC("C 1 ")) {
+				t.Errorf("text header starts with %x, want %x", b[:4], toEBCDIC("C 1 "))
+			}
+
+			bin := b[textHeaderSize:]
+			if got := binary.BigEndian.Uint16(bin[16:]); got != uint16(tt.header.SampleInterval/time.Microsecond) {
+				t.Errorf("sample interval = %d, want %d", got, tt.header.SampleInterval/time.Microsecond)
+			}
+			if got := binary.BigEndian.Uint16(bin[24:]); got != uint16(formatInt32) {
+				t.Errorf("format code = %d, want %d", got, formatInt32)
+			}
+
+			trace := b[textHeaderSize+binaryHeaderSize:]
+			for i, tr := range tt.traces {
+				h := trace[:traceHeaderSize]
+				if got := int32(binary.BigEndian.Uint32(h[12:])); got != tr.Header.Channel {
+					t.Errorf("trace %d channel = %d, want %d", i, got, tr.Header.Channel)
+				}
+				if got := binary.BigEndian.Uint16(h[114:]); got != uint16(samples) {
+					t.Errorf("trace %d samples = %d, want %d", i, got, samples)
+				}
+				if got := binary.BigEndian.Uint16(h[156:]); got != uint16(start.Year()) {
+					t.Errorf("trace %d year = %d, want %d", i, got, start.Year())
+				}
+				for j, v := range tr.Data {
+					if got := int32(binary.BigEndian.Uint32(trace[traceHeaderSize+j*4:])); got != v {
+						t.Errorf("trace %d sample %d = %d, want %d", i, j, got, v)
+					}
+				}
+				trace = trace[traceHeaderSize+samples*4:]
+			}
+		})
+	}
+}
+
+func TestTransduction(t *testing.T) {
+	tests := []float64{0, 1, 4.8828125e-07, -25.5, 9.80665}
+	for _, v := range tests {
+		m, e := transduction(v)
+		got := float64(m)
+		for ; e > 0; e-- {
+			got *= 10
+		}
+		for ; e < 0; e++ {
+			got /= 10
+		}
+		if math.Abs(got-v) > 1e-7*math.Abs(v) {
+			t.Errorf("transduction(%v) = %d * 10^e, decodes to %v", v, m, got)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package sensor
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// DefaultFullScale is the ADC reference voltage
+const DefaultFullScale = 4.096
+
+// countsFullScale is the count at positive full scale of the 24-bit ADC output
+const countsFullScale = 1 << 23
+
+type Type string
+
+const (
+	// Voltage is a plain voltage input with no transducer
+	Voltage       Type = "voltage"
+	Geophone      Type = "geophone"
+	Accelerometer Type = "accelerometer"
+)
+
+type Unit string
+
+const (
+	Counts          Unit = "counts"
+	Volts           Unit = "V"
+	Millivolts      Unit = "mV"
+	MetersPerSecond Unit = "m/s"
+	G               Unit = "g"
+
+	// Physical resolves to the unit the sensor measures, see Model.Unit
+	Physical Unit = "physical"
+)
+
+// ParseUnit parses a unit name case insensitively. Empty string is Counts.
+func ParseUnit(s string) (Unit, error) {
+	switch strings.ToLower(s) {
+	case "", "counts", "raw":
+		return Counts, nil
+	case "v", "volts":
+		return Volts, nil
+	case "mv":
+		return Millivolts, nil
+	case "m/s":
+		return MetersPerSecond, nil
+	case "g":
+		return G, nil
+	case "physical":
+		return Physical, nil
+	}
+	return "", fmt.Errorf("invalid unit %q. expected counts, V, mV, m/s, g or physical", s)
+}
+
+// Model describes the sensor connected to a channel and the analog chain in front of the ADC.
+// The zero value is a voltage input with unity gain and the default full scale.
+type Model struct {
+	Type Type `json:"type"`
+
+	// Sensitivity in V/(m/s) for geophones and V/g for accelerometers
+	Sensitivity float64 `json:"sensitivity"`
+	PreampGain  float64 `json:"preampGain"`
+
+	// FullScale is the ADC full scale input in volts
+	FullScale float64 `json:"fullScale"`
+
+	// Offset in volts at the sensor output which is subtracted before converting to physical units
+	Offset float64 `json:"offset"`
+}
+
+// Validate checks the model for values which would make conversions meaningless
+func (m Model) Validate() error {
+	switch m.Type {
+	case "", Voltage:
+	case Geophone, Accelerometer:
+		if m.Sensitivity <= 0 {
+			return fmt.Errorf("sensitivity of %s must be positive, got %v", m.Type, m.Sensitivity)
+		}
+	default:
+		return fmt.Errorf("invalid sensor type %q", m.Type)
+	}
+	if m.PreampGain < 0 {
+		return fmt.Errorf("preamp gain must be positive, got %v", m.PreampGain)
+	}
+	if m.FullScale < 0 {
+		return fmt.Errorf("full scale must be positive, got %v", m.FullScale)
+	}
+	return nil
+}
+
+// Unit returns the physical unit measured by the sensor
+func (m Model) Unit() Unit {
+	switch m.Type {
+	case Geophone:
+		return MetersPerSecond
+	case Accelerometer:
+		return G
+	}
+	return Volts
+}
+
+func (m Model) preampGain() float64 {
+	if m.PreampGain == 0 {
+		return 1
+	}
+	return m.PreampGain
+}
+
+func (m Model) fullScale() float64 {
+	if m.FullScale == 0 {
+		return DefaultFullScale
+	}
+	return m.FullScale
+}
+
+// WithGain returns a copy of the model with an additional gain stage in front of the ADC
+func (m Model) WithGain(gain float64) Model {
+	if gain > 0 {
+		m.PreampGain = m.preampGain() * gain
+	}
+	return m
+}
+
+// VoltsPerCount is the voltage at the sensor output represented by one ADC count
+func (m Model) VoltsPerCount() float64 {
+	return m.fullScale() / countsFullScale / m.preampGain()
+}
+
+// Factor returns scale and offset such that value = count*scale + offset in the given unit
+func (m Model) Factor(unit Unit) (scale float64, offset float64, err error) {
+	if unit == Physical {
+		unit = m.Unit()
+	}
+	switch unit {
+	case Counts:
+		return 1, 0, nil
+	case Volts:
+		return m.VoltsPerCount(), -m.Offset, nil
+	case Millivolts:
+		return m.VoltsPerCount() * 1000, -m.Offset * 1000, nil
+	case MetersPerSecond, G:
+		if m.Unit() != unit {
+			return 0, 0, fmt.Errorf("%s sensor can not be converted to %s", m.typeName(), unit)
+		}
+		return m.VoltsPerCount() / m.Sensitivity, -m.Offset / m.Sensitivity, nil
+	}
+	return 0, 0, fmt.Errorf("invalid unit %q", unit)
+}
+
+// Convert converts a raw ADC count to the given unit
+func (m Model) Convert(count int32, unit Unit) (float64, error) {
+	scale, offset, err := m.Factor(unit)
+	if err != nil {
+		return 0, err
+	}
+	return float64(count)*scale + offset, nil
+}
+
+// Counts converts a value in the given unit back to the nearest ADC count
+func (m Model) Counts(value float64, unit Unit) (int32, error) {
+	scale, offset, err := m.Factor(unit)
+	if err != nil {
+		return 0, err
+	}
+	c := math.Round((value - offset) / scale)
+	if c > math.MaxInt32 {
+		c = math.MaxInt32
+	} else if c < math.MinInt32 {
+		c = math.MinInt32
+	}
+	return int32(c), nil
+}
+
+func (m Model) typeName() string {
+	if m.Type == "" {
+		return string(Voltage)
+	}
+	return string(m.Type)
+}
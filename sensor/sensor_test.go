@@ -0,0 +1,71 @@
+package sensor
+
+import (
+	"math"
+	"testing"
+)
+
+func TestModel_Convert(t *testing.T) {
+	geophone := Model{Type: Geophone, Sensitivity: 28.8, PreampGain: 2}
+	tests := []struct {
+		name    string
+		model   Model
+		count   int32
+		unit    Unit
+		want    float64
+		wantErr bool
+	}{
+		{name: "counts", model: geophone, count: 100, unit: Counts, want: 100},
+		{name: "zero model volts", model: Model{}, count: 1 << 22, unit: Volts, want: 2.048},
+		{name: "millivolts with gain", model: Model{}.WithGain(4), count: 1 << 22, unit: Millivolts, want: 512},
+		{name: "offset", model: Model{Offset: 0.048}, count: 1 << 22, unit: Volts, want: 2},
+		{name: "geophone velocity", model: geophone, count: 1 << 22, unit: MetersPerSecond, want: 1.024 / 28.8},
+		{name: "geophone physical", model: geophone, count: 1 << 22, unit: Physical, want: 1.024 / 28.8},
+		{name: "accelerometer g", model: Model{Type: Accelerometer, Sensitivity: 2}, count: -(1 << 22), unit: G, want: -1.024},
+		{name: "geophone in g", model: geophone, count: 1, unit: G, wantErr: true},
+		{name: "voltage in m/s", model: Model{}, count: 1, unit: MetersPerSecond, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.model.Convert(tt.count, tt.unit)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Convert() = %v, want %v", got, tt.want)
+			}
+
+			count, err := tt.model.Counts(got, tt.unit)
+			if err != nil {
+				t.Fatalf("Counts() error = %v", err)
+			}
+			if count != tt.count {
+				t.Errorf("Counts() = %v, want %v", count, tt.count)
+			}
+		})
+	}
+}
+
+func TestModel_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		model   Model
+		wantErr bool
+	}{
+		{name: "zero", model: Model{}},
+		{name: "geophone", model: Model{Type: Geophone, Sensitivity: 20}},
+		{name: "geophone without sensitivity", model: Model{Type: Geophone}, wantErr: true},
+		{name: "unknown type", model: Model{Type: "hydrophone"}, wantErr: true},
+		{name: "negative gain", model: Model{PreampGain: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.model.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,7 +1,6 @@
 package server
 
 import (
-	"log"
 	"net/http"
 	"strconv"
 	"strings"
@@ -11,17 +10,21 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		log.Println(r.Header["Origin"][0])
-		return true
-	},
-}
-
 func (s *Server) NewAPI() *gin.Engine {
-	api := gin.Default()
+	s.upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     s.auth.checkOrigin,
+	}
+
+	// the default logger would write the tokens of websocket requests
+	api := gin.New()
+	api.Use(gin.LoggerWithFormatter(logFormatter), gin.Recovery())
+	api.Use(s.metrics.middleware, s.recordErrors)
+	api.HandleMethodNotAllowed = true
+	// the login backoff and the event journal use the address of the
+	// connection. forwarding headers are set by the clients.
+	api.ForwardedByClientIP = false
 	if s.Debug {
 		api.Any("/api/:path", func(c *gin.Context) {
 			r := c.Request
@@ -29,59 +32,111 @@ func (s *Server) NewAPI() *gin.Engine {
 			//c.Application().ServeHTTPC(c)
 		})
 	}
-	api.Use(cors.Default())
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowOriginFunc = s.auth.allowedOrigin
+	corsConfig.AddAllowHeaders("Authorization")
+	api.Use(cors.New(corsConfig))
+
+	api.POST("/auth/login", s.LoginHandler)
+	api.POST("/auth/logout", s.LogoutHandler)
+	api.GET("/auth/session", s.SessionHandler)
+	api.GET("/openapi.json", s.OpenAPIHandler)
+
+	viewer := api.Group("", s.require(RoleViewer))
+	operator := api.Group("", s.require(RoleOperator))
+	admin := api.Group("", s.require(RoleAdmin))
+
+	viewer.GET("/status", s.SamplingStatusHandler)
+	viewer.GET("/metrics", s.MetricsHandler)
+	viewer.GET("/events", s.EventsHandler)
+
+	viewer.GET("/tree/*dir", s.TreeHandler)
+	admin.DELETE("/tree/*path", s.TreeDeleteHandler)
+	operator.PATCH("/tree/*path", s.TreePatchHandler)
+	operator.POST("/tree", s.CreateNewProject)
+
+	viewer.GET("/trash", s.ListTrashHandler)
+	admin.DELETE("/trash", s.EmptyTrashHandler)
+	operator.POST("/trash/:id/restore", s.RestoreTrashHandler)
+	admin.DELETE("/trash/:id", s.DeleteTrashItemHandler)
 
-	api.GET("/status", s.SamplingStatusHandler)
+	viewer.GET("/storage", s.StorageHandler)
+	viewer.GET("/storage/estimate", s.EstimateHandler)
+	viewer.GET("/project/active", s.GetActiveProjectPath)
+	operator.PATCH("/project/active", s.SetActiveProjectPath)
 
-	api.GET("/tree/*dir", s.TreeHandler)
-	api.DELETE("/tree/*path", s.TreeDeleteHandler)
-	api.PATCH("/tree/*path", s.TreePatchHandler)
-	api.POST("/tree", s.CreateNewProject)
-	api.GET("/project/active", s.GetActiveProjectPath)
-	api.PATCH("/project/active", s.SetActiveProjectPath)
+	viewer.GET("/wifi/scan", s.ScanNetworks)
+	admin.POST("/wifi/connect", s.Connect)
 
-	api.GET("/wifi/scan", s.ScanNetworks)
-	api.POST("/wifi/connect", s.Connect)
+	viewer.GET("/recordings", s.ListRecordingsHandler)
+	operator.PATCH("/recordings", s.AnnotateRecordingHandler)
+	operator.POST("/recordings/reindex", s.ReindexHandler)
 
-	api.GET("/plot", s.ReadDataHandler)
-	api.POST("/plot", s.ReadDataPostHandler)
+	viewer.GET("/plot", s.ReadDataHandler)
+	viewer.POST("/plot", s.ReadDataPostHandler)
 
-	api.GET("/dl/*path", func(c *gin.Context) {
+	viewer.GET("/dl/*path", func(c *gin.Context) {
 		c.Header("cache-control", "no-store, max-age=0")
 	}, s.DownloadSampleHandler)
+	viewer.GET("/bundle/*path", func(c *gin.Context) {
+		c.Header("cache-control", "no-store, max-age=0")
+	}, s.ProjectArchiveHandler)
 
-	api.POST("/setup", s.SetupHandler)
-	api.POST("/command/:cmd/:adc", s.CommandHandler)
-	api.GET("/getfile", s.GetFileHandler)
-
-	api.GET("/usb", s.GetAllUSBHandler)
-
-	api.POST("/rpi/shutdown", s.ShutdownSequenceHandler)
-	api.POST("/rpi/restart", s.RestartSequenceHandler)
-	api.GET("/channels", s.GetChannelsHandler)
-	api.POST("/channels", s.SetChannelsHandler)
-	api.GET("/gains", s.GetGainsHandler)
-	api.POST("/gains", s.SetGainsHandler)
-	api.GET("/info", s.BoardInfoHandler)
-	api.POST("/calibrate", func(c *gin.Context) {
-		s.adc.CilabrateChOffset(s.logics[0], s.Debug)
-		for i := 0; i < len(s.hd.EnabledChannels); i++ {
-			s.hd.EnabledChannels[i] = true
-			s.hd.Gains[i] = 1000
-		}
-		c.Status(http.StatusOK)
-	})
+	operator.POST("/setup", s.SetupHandler)
+	admin.POST("/command/:cmd/:adc", s.CommandHandler)
+	viewer.GET("/getfile", s.GetFileHandler)
+
+	viewer.GET("/usb", s.GetAllUSBHandler)
+	operator.POST("/usb/:name/mount", s.MountUSBHandler)
+	operator.POST("/usb/:name/unmount", s.UnmountUSBHandler)
+	operator.POST("/usb/:name/eject", s.EjectUSBHandler)
+
+	admin.POST("/rpi/shutdown", s.ShutdownSequenceHandler)
+	admin.POST("/rpi/restart", s.RestartSequenceHandler)
+	viewer.GET("/channels", s.GetChannelsHandler)
+	operator.POST("/channels", s.SetChannelsHandler)
+	viewer.GET("/gains", s.GetGainsHandler)
+	operator.POST("/gains", s.SetGainsHandler)
+	viewer.GET("/sensors", s.GetSensorsHandler)
+	operator.POST("/sensors", s.SetSensorsHandler)
+	viewer.GET("/survey", s.GetSurveyHandler)
+	operator.PUT("/survey", s.SetSurveyHandler)
+	operator.PATCH("/survey/shot", s.SetShotHandler)
+	viewer.GET("/info", s.BoardInfoHandler)
+	viewer.GET("/telemetry", s.TelemetryHandler)
+	viewer.GET("/telemetry/history", s.TelemetryHistoryHandler)
+	viewer.GET("/telemetry/ws", s.TelemetryStreamHandler)
+	viewer.GET("/selftest", s.LastSelfTestHandler)
+	admin.POST("/selftest", s.SelfTestHandler)
+	viewer.GET("/calibration", s.GetCalibrationHandler)
+	admin.POST("/calibrate", s.CalibrateHandler)
+	viewer.GET("/calibration/sync", s.GetSyncCalibrationHandler)
+	admin.POST("/calibrate/sync", s.CalibrateSyncHandler)
+	admin.POST("/adc/reset", s.ResetHandler)
 
-	api.POST("/save/project", s.SaveProjectFolder)
-	api.POST("/save/sample", s.SaveSampleFile)
+	operator.POST("/save/project", s.SaveProjectFolder)
+	operator.POST("/save/sample", s.SaveSampleFile)
+	viewer.GET("/save/jobs", s.GetExportJobsHandler)
+	viewer.GET("/save/jobs/:id", s.GetExportJobHandler)
 
-	api.PATCH("/multiplier", func(c *gin.Context) {
-		val, err := strconv.Atoi(c.Query("val"))
-		if err != nil {
-			c.String(http.StatusBadRequest, "%v", err)
+	admin.PATCH("/multiplier", func(c *gin.Context) {
+		val, err := strconv.ParseUint(c.Query("val"), 10, 32)
+		if err != nil || val < 1 || val > 0xffffff {
+			abortWithError(c, invalidField("val", "expected an integer between 1 and %d", 0xffffff))
+			return
 		}
 		s.GainMultiply = uint32(val)
-		c.String(http.StatusOK, "%d", s.GainMultiply)
+		s.event(c, EventGains, map[string]interface{}{"multiplier": s.GainMultiply}, "gain multiplier changed")
+		c.JSON(http.StatusOK, gin.H{
+			"multiplier": s.GainMultiply,
+		})
+	})
+
+	api.NoRoute(func(c *gin.Context) {
+		abortWithError(c, newError(http.StatusNotFound, "no route %s %s", c.Request.Method, c.Request.URL.Path))
+	})
+	api.NoMethod(func(c *gin.Context) {
+		abortWithError(c, newError(http.StatusMethodNotAllowed, "method %s not allowed", c.Request.Method))
 	})
 	return api
 }
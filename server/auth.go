@@ -0,0 +1,496 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Role grants access to a set of endpoints. Each role can use the endpoints of the roles before it.
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+)
+
+func (r Role) level() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleOperator:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+// User logs in with a password
+type User struct {
+	Name string `json:"name" mapstructure:"name"`
+	Role Role   `json:"role" mapstructure:"role"`
+
+	// PasswordHash is created by HashPassword
+	PasswordHash string `json:"passwordHash" mapstructure:"passwordHash"`
+}
+
+// APIToken is a long lived token for scripts and other devices
+type APIToken struct {
+	Name string `json:"name" mapstructure:"name"`
+	Role Role   `json:"role" mapstructure:"role"`
+
+	// Hash is created by HashToken
+	Hash string `json:"hash" mapstructure:"hash"`
+}
+
+// AuthConfig is the authentication section of the config file.
+// Authentication is disabled when there are no users and no tokens.
+type AuthConfig struct {
+	Users  []User     `json:"users" mapstructure:"users"`
+	Tokens []APIToken `json:"tokens" mapstructure:"tokens"`
+
+	// AllowedOrigins lists the origins of web pages allowed to use the API
+	// e.g. http://192.168.4.1:8080. "*" allows every origin. Pages served from
+	// the same host are always allowed.
+	AllowedOrigins []string `json:"allowedOrigins" mapstructure:"allowedOrigins"`
+
+	// SessionTTL is how long a login stays valid. 0 uses 12 hours.
+	SessionTTL time.Duration `json:"sessionTTL" mapstructure:"sessionTTL"`
+}
+
+// Validate checks every user and token has a known role and a hash
+func (a AuthConfig) Validate() error {
+	names := make(map[string]bool)
+	for _, u := range a.Users {
+		if u.Name == "" || names[u.Name] {
+			return fmt.Errorf("invalid or duplicate user name %q", u.Name)
+		}
+		names[u.Name] = true
+		if u.Role.level() == 0 {
+			return fmt.Errorf("user %s: invalid role %q", u.Name, u.Role)
+		}
+		if _, _, _, err := parsePasswordHash(u.PasswordHash); err != nil {
+			return fmt.Errorf("user %s: %v", u.Name, err)
+		}
+	}
+	for _, t := range a.Tokens {
+		if t.Role.level() == 0 {
+			return fmt.Errorf("token %s: invalid role %q", t.Name, t.Role)
+		}
+		if b, err := hex.DecodeString(t.Hash); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("token %s: invalid hash", t.Name)
+		}
+	}
+	if a.SessionTTL < 0 {
+		return fmt.Errorf("invalid session ttl %v", a.SessionTTL)
+	}
+	return nil
+}
+
+func (a AuthConfig) enabled() bool {
+	return len(a.Users) > 0 || len(a.Tokens) > 0
+}
+
+const (
+	passwordIterations = 100000
+	passwordSaltSize   = 16
+)
+
+// pbkdf2 derives a key from password with HMAC-SHA256 as described in RFC 8018
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	u := make([]byte, 0, sha256.Size)
+	t := make([]byte, sha256.Size)
+	block := make([]byte, 4)
+	for i := 1; len(key) < keyLen; i++ {
+		binary.BigEndian.PutUint32(block, uint32(i))
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(block)
+		u = prf.Sum(u[:0])
+		copy(t, u)
+		for n := 1; n < iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// HashPassword hashes password for the config file in the form pbkdf2-sha256$iterations$salt$key
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2([]byte(password), salt, passwordIterations, sha256.Size)
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+func parsePasswordHash(hash string) (iterations int, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return 0, nil, nil, fmt.Errorf("invalid password hash")
+	}
+	if iterations, err = strconv.Atoi(parts[1]); err != nil || iterations < 1 {
+		return 0, nil, nil, fmt.Errorf("invalid password hash iterations")
+	}
+	enc := base64.RawStdEncoding
+	if salt, err = enc.DecodeString(parts[2]); err != nil {
+		return 0, nil, nil, fmt.Errorf("invalid password hash salt")
+	}
+	if key, err = enc.DecodeString(parts[3]); err != nil || len(key) == 0 {
+		return 0, nil, nil, fmt.Errorf("invalid password hash key")
+	}
+	return iterations, salt, key, nil
+}
+
+// checkPassword reports whether password matches hash
+func checkPassword(hash, password string) bool {
+	iterations, salt, key, err := parsePasswordHash(hash)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(pbkdf2([]byte(password), salt, iterations, len(key)), key) == 1
+}
+
+// NewToken creates a random token for a session or an API token
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashToken hashes an API token for the config file
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// session is a logged in user or an API token
+type session struct {
+	Name    string    `json:"name"`
+	Role    Role      `json:"role"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// Failed logins from an address beyond loginFreeFailures are refused for
+// loginBackoff, doubled by every further failure up to loginMaxBackoff
+const (
+	loginFreeFailures = 3
+	loginBackoff      = time.Second
+	loginMaxBackoff   = 5 * time.Minute
+)
+
+// loginFailures are the failed logins from an address
+type loginFailures struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+// authenticator checks the credentials of requests against an AuthConfig
+type authenticator struct {
+	mu       sync.Mutex
+	config   AuthConfig
+	sessions map[string]session
+	failures map[string]*loginFailures
+}
+
+func newAuthenticator() *authenticator {
+	return &authenticator{sessions: make(map[string]session), failures: make(map[string]*loginFailures)}
+}
+
+// loginAllowed returns how long logins from addr are refused, 0 when they are not
+func (a *authenticator) loginAllowed(addr string, now time.Time) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if f, ok := a.failures[addr]; ok && now.Before(f.until) {
+		return f.until.Sub(now)
+	}
+	return 0
+}
+
+// loginFailed counts a failed login from addr and starts its backoff
+func (a *authenticator) loginFailed(addr string, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, f := range a.failures {
+		if now.Sub(f.last) > loginMaxBackoff {
+			delete(a.failures, k)
+		}
+	}
+	f, ok := a.failures[addr]
+	if !ok {
+		f = &loginFailures{}
+		a.failures[addr] = f
+	}
+	f.count++
+	f.last = now
+	if f.count > loginFreeFailures {
+		backoff := loginMaxBackoff
+		if n := f.count - loginFreeFailures - 1; n < 16 && loginBackoff<<uint(n) < loginMaxBackoff {
+			backoff = loginBackoff << uint(n)
+		}
+		f.until = now.Add(backoff)
+	}
+}
+
+// loginSucceeded forgets the failed logins from addr
+func (a *authenticator) loginSucceeded(addr string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.failures, addr)
+}
+
+// purge removes the expired sessions. a.mu must be held.
+func (a *authenticator) purge(now time.Time) {
+	for token, ss := range a.sessions {
+		if !now.Before(ss.Expires) {
+			delete(a.sessions, token)
+		}
+	}
+}
+
+func (a *authenticator) setConfig(cfg AuthConfig) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.config = cfg
+	a.sessions = make(map[string]session)
+}
+
+func (a *authenticator) login(name, password string) (string, session, error) {
+	a.mu.Lock()
+	cfg := a.config
+	a.mu.Unlock()
+
+	var user *User
+	for i := range cfg.Users {
+		if cfg.Users[i].Name == name {
+			user = &cfg.Users[i]
+			break
+		}
+	}
+	if user == nil || !checkPassword(user.PasswordHash, password) {
+		return "", session{}, fmt.Errorf("invalid user name or password")
+	}
+	token, err := NewToken()
+	if err != nil {
+		return "", session{}, err
+	}
+	ttl := cfg.SessionTTL
+	if ttl == 0 {
+		ttl = 12 * time.Hour
+	}
+	now := time.Now()
+	ss := session{Name: user.Name, Role: user.Role, Expires: now.Add(ttl)}
+	a.mu.Lock()
+	a.purge(now)
+	a.sessions[token] = ss
+	a.mu.Unlock()
+	return token, ss, nil
+}
+
+func (a *authenticator) logout(token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.sessions, token)
+}
+
+// lookup returns the session of token. Every request is an admin session when authentication is disabled.
+func (a *authenticator) lookup(token string) (session, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.config.enabled() {
+		return session{Role: RoleAdmin}, true
+	}
+	if ss, ok := a.sessions[token]; ok {
+		if time.Now().Before(ss.Expires) {
+			return ss, true
+		}
+		delete(a.sessions, token)
+	}
+	if token == "" {
+		return session{}, false
+	}
+	hash := HashToken(token)
+	for _, t := range a.config.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) == 1 {
+			return session{Name: t.Name, Role: t.Role}, true
+		}
+	}
+	return session{}, false
+}
+
+// allowedOrigin reports whether a web page from origin may use the API
+func (a *authenticator) allowedOrigin(origin string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, o := range a.config.AllowedOrigins {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrigin allows websocket connections from the same host and the allowed origins
+func (a *authenticator) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return a.allowedOrigin(origin)
+}
+
+// requestToken is the bearer token of a request. Websockets can not set
+// headers from a browser so the token query parameter is used for websocket
+// upgrades only. It is kept out of the logs by redactPath.
+func requestToken(c *gin.Context) string {
+	if h := c.GetHeader("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		return c.Query("token")
+	}
+	return ""
+}
+
+// redactPath replaces the token in the query of a request path
+func redactPath(p string) string {
+	i := strings.IndexByte(p, '?')
+	if i < 0 {
+		return p
+	}
+	q, err := url.ParseQuery(p[i+1:])
+	if err != nil {
+		return p[:i] + "?REDACTED"
+	}
+	if _, ok := q["token"]; !ok {
+		return p
+	}
+	q.Set("token", "REDACTED")
+	return p[:i+1] + q.Encode()
+}
+
+// logFormatter is the request log of gin with the token redacted
+func logFormatter(param gin.LogFormatterParams) string {
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency - param.Latency%time.Second
+	}
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		redactPath(param.Path),
+		param.ErrorMessage,
+	)
+}
+
+// SetAuth replaces the users, tokens and allowed origins. Every session is logged out.
+func (s *Server) SetAuth(cfg AuthConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if !cfg.enabled() {
+		s.l.Warn("authentication is disabled. add users or tokens to the config file to enable it")
+	}
+	s.auth.setConfig(cfg)
+	return nil
+}
+
+// require aborts requests without a session of at least role
+func (s *Server) require(role Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ss, ok := s.auth.lookup(requestToken(c))
+		if !ok {
+			s.event(c, EventAuth, map[string]interface{}{"method": c.Request.Method, "path": c.Request.URL.Path, "status": http.StatusUnauthorized}, "%s %s refused without a session", c.Request.Method, c.Request.URL.Path)
+			abortWithError(c, newError(http.StatusUnauthorized, "login required"))
+			return
+		}
+		c.Set("user", ss.Name)
+		if ss.Role.level() < role.level() {
+			s.event(c, EventAuth, map[string]interface{}{"method": c.Request.Method, "path": c.Request.URL.Path, "status": http.StatusForbidden, "role": ss.Role}, "%s %s refused to the %s role", c.Request.Method, c.Request.URL.Path, ss.Role)
+			abortWithError(c, newError(http.StatusForbidden, "%s role required", role))
+			return
+		}
+		c.Next()
+	}
+}
+
+func (s *Server) LoginHandler(c *gin.Context) {
+	if wait := s.auth.loginAllowed(c.ClientIP(), time.Now()); wait > 0 {
+		s.event(c, EventAuth, map[string]interface{}{"status": http.StatusTooManyRequests}, "login refused after too many failures")
+		c.Header("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		abortWithError(c, newError(http.StatusTooManyRequests, "too many failed logins. retry in %v", wait.Round(time.Second)))
+		return
+	}
+	data := LoginRequest{}
+	if !bindJSON(c, &data) {
+		return
+	}
+	token, ss, err := s.auth.login(data.Name, data.Password)
+	if err != nil {
+		s.l.Warnf("failed login for %q from %s", data.Name, c.ClientIP())
+		s.auth.loginFailed(c.ClientIP(), time.Now())
+		s.event(c, EventAuth, map[string]interface{}{"name": data.Name, "status": http.StatusUnauthorized}, "failed login for %q", data.Name)
+		abortWithStatus(c, http.StatusUnauthorized, err)
+		return
+	}
+	s.auth.loginSucceeded(c.ClientIP())
+	c.Set("user", ss.Name)
+	s.event(c, EventAuth, map[string]interface{}{"role": ss.Role}, "%s logged in", ss.Name)
+	c.JSON(http.StatusOK, gin.H{
+		"token":   token,
+		"session": ss,
+	})
+}
+
+func (s *Server) LogoutHandler(c *gin.Context) {
+	token := requestToken(c)
+	if ss, ok := s.auth.lookup(token); ok {
+		c.Set("user", ss.Name)
+		s.event(c, EventAuth, nil, "%s logged out", ss.Name)
+	}
+	s.auth.logout(token)
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// SessionHandler returns the user and role of the request
+func (s *Server) SessionHandler(c *gin.Context) {
+	ss, _ := s.auth.lookup(requestToken(c))
+	c.JSON(http.StatusOK, gin.H{
+		"session": ss,
+	})
+}
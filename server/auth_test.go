@@ -0,0 +1,202 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// RFC 7914 section 11
+	want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	if got := hex.EncodeToString(pbkdf2([]byte("passwd"), []byte("salt"), 1, 64)); got != want {
+		t.Errorf("pbkdf2() = %s, want %s", got, want)
+	}
+
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkPassword(hash, "secret") || checkPassword(hash, "Secret") {
+		t.Errorf("checkPassword() does not match HashPassword()")
+	}
+}
+
+func TestRequire(t *testing.T) {
+	hash, _ := HashPassword("secret")
+	s := NewServer(afero.NewMemMapFs(), afero.NewMemMapFs(), &fakeStorage{}, nil, false)
+	err := s.SetAuth(AuthConfig{
+		Users:  []User{{Name: "op", Role: RoleOperator, PasswordHash: hash}},
+		Tokens: []APIToken{{Name: "script", Role: RoleViewer, Hash: HashToken("viewer-token")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := s.auth.login("op", "secret")
+	if err != nil {
+		t.Fatalf("login() error = %v", err)
+	}
+	if _, _, err := s.auth.login("op", "wrong"); err == nil {
+		t.Errorf("login() with wrong password succeeded")
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		token  string
+		ws     bool
+		want   int
+	}{
+		{name: "no token", method: "GET", url: "/project/active", want: http.StatusUnauthorized},
+		{name: "viewer token", method: "GET", url: "/project/active", token: "viewer-token", want: http.StatusOK},
+		{name: "viewer changes project", method: "PATCH", url: "/project/active", token: "viewer-token", want: http.StatusForbidden},
+		{name: "operator session", method: "GET", url: "/project/active", token: token, want: http.StatusOK},
+		{name: "query token", method: "GET", url: "/project/active?token=" + token, want: http.StatusUnauthorized},
+		// the upgrade fails after the token is accepted
+		{name: "websocket query token", method: "GET", url: "/telemetry/ws?token=" + token, ws: true, want: http.StatusBadRequest},
+		{name: "operator restarts", method: "POST", url: "/rpi/restart", token: token, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, strings.NewReader("{}"))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.ws {
+				req.Header.Set("Connection", "Upgrade")
+				req.Header.Set("Upgrade", "websocket")
+			}
+			w := httptest.NewRecorder()
+			s.api.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.url, w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/telemetry/ws", want: "/telemetry/ws"},
+		{path: "/plot?path=a", want: "/plot?path=a"},
+		{path: "/telemetry/ws?token=secret", want: "/telemetry/ws?token=REDACTED"},
+		{path: "/plot?path=a&token=secret", want: "/plot?path=a&token=REDACTED"},
+		{path: "/plot?token=%zz", want: "/plot?REDACTED"},
+	}
+	for _, tt := range tests {
+		if got := redactPath(tt.path); got != tt.want {
+			t.Errorf("redactPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestLoginBackoff(t *testing.T) {
+	hash, _ := HashPassword("secret")
+	s := NewServer(afero.NewMemMapFs(), afero.NewMemMapFs(), &fakeStorage{}, nil, false)
+	if err := s.SetAuth(AuthConfig{Users: []User{{Name: "op", Role: RoleOperator, PasswordHash: hash}}}); err != nil {
+		t.Fatal(err)
+	}
+	forwarded := 0
+	login := func(password string) int {
+		forwarded++
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"name":"op","password":"`+password+`"}`))
+		// a new forwarded address does not get a new backoff
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("10.0.0.%d", forwarded))
+		s.api.ServeHTTP(w, req)
+		return w.Code
+	}
+	for i := 0; i <= loginFreeFailures; i++ {
+		if got := login("wrong"); got != http.StatusUnauthorized {
+			t.Fatalf("failed login %d = %d, want 401", i+1, got)
+		}
+	}
+	if got := login("secret"); got != http.StatusTooManyRequests {
+		t.Errorf("login during the backoff = %d, want 429", got)
+	}
+
+	// the backoff doubles up to the maximum
+	now := time.Now()
+	a := newAuthenticator()
+	for i := 0; i < loginFreeFailures+20; i++ {
+		a.loginFailed("addr", now)
+	}
+	if got := a.loginAllowed("addr", now); got != loginMaxBackoff {
+		t.Errorf("backoff = %v, want %v", got, loginMaxBackoff)
+	}
+	a.loginSucceeded("addr")
+	if got := a.loginAllowed("addr", now); got != 0 {
+		t.Errorf("backoff after a login = %v, want none", got)
+	}
+
+	a.sessions["old"] = session{Expires: now.Add(-time.Second)}
+	a.purge(now)
+	if len(a.sessions) != 0 {
+		t.Errorf("expired sessions were not purged")
+	}
+}
+
+func TestAuthEvents(t *testing.T) {
+	hash, _ := HashPassword("secret")
+	s := NewServer(afero.NewMemMapFs(), afero.NewMemMapFs(), &fakeStorage{}, nil, false)
+	err := s.SetAuth(AuthConfig{
+		Users:  []User{{Name: "op", Role: RoleOperator, PasswordHash: hash}},
+		Tokens: []APIToken{{Name: "script", Role: RoleViewer, Hash: HashToken("viewer-token")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(method, url, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		s.api.ServeHTTP(w, req)
+		return w
+	}
+	request("POST", "/auth/login", "", `{"name":"op","password":"wrong"}`)
+	var res struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(request("POST", "/auth/login", "", `{"name":"op","password":"secret"}`).Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	request("GET", "/project/active", "", "")
+	request("PATCH", "/project/active", "viewer-token", "{}")
+	request("POST", "/auth/logout", res.Token, "")
+
+	events, err := s.events.query(eventQuery{types: map[string]bool{EventAuth: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		user    string
+		message string
+	}{
+		{"", `failed login for "op"`},
+		{"op", "op logged in"},
+		{"", "GET /project/active refused without a session"},
+		{"script", "PATCH /project/active refused to the viewer role"},
+		{"op", "op logged out"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("auth events = %+v, want %d", events, len(want))
+	}
+	for i, e := range events {
+		if e.User != want[i].user || e.Message != want[i].message {
+			t.Errorf("event %d = %q by %q, want %q by %q", i, e.Message, e.User, want[i].message, want[i].user)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/MShoaei/quakeADC/record"
+	"github.com/MShoaei/quakeADC/sensor"
+	"github.com/MShoaei/quakeADC/survey"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/afero"
+)
+
+// bundleManifestName is the file at the root of an archive describing its content
+const bundleManifestName = "manifest.json"
+
+// ArchiveOptions controls how WriteProjectArchive bundles a project
+type ArchiveOptions struct {
+	// Archive is the container format. zip or tar.gz
+	Archive string
+
+	// Type is the format every recording is converted to. raw, seg2, segy or csv
+	Type string
+
+	// Unit of csv samples and of the seg-y transduction constant. Empty is counts.
+	Unit sensor.Unit
+
+	// SamplingTime in microseconds for recordings without one in their header
+	SamplingTime float32
+}
+
+// Validate checks the archive and file types
+func (o ArchiveOptions) Validate() error {
+	switch o.Archive {
+	case "zip", "tar.gz":
+	default:
+		return fmt.Errorf("invalid archive type %q. expected zip or tar.gz", o.Archive)
+	}
+	if _, ok := bundleExtensions[o.Type]; !ok {
+		return fmt.Errorf("invalid file type %q. expected raw, seg2, segy or csv", o.Type)
+	}
+	return nil
+}
+
+// bundleExtensions maps the file types supported in an archive to the extension appended to each recording
+var bundleExtensions = map[string]string{
+	"raw":  ".RAW",
+	"seg2": ".DAT",
+	"segy": ".SGY",
+	"csv":  ".CSV",
+}
+
+type bundleManifest struct {
+	Project string           `json:"project"`
+	Type    string           `json:"type"`
+	Created time.Time        `json:"created"`
+	Sensors [24]sensor.Model `json:"sensors"`
+	Survey  survey.Survey    `json:"survey"`
+	Files   []bundleEntry    `json:"files"`
+}
+
+// bundleEntry describes a single recording in the archive. Recordings which
+// could not be converted are listed with Error set and are not in the archive.
+type bundleEntry struct {
+	Path   string         `json:"path"`
+	Source string         `json:"source"`
+	Size   int64          `json:"size"`
+	SHA256 string         `json:"sha256,omitempty"`
+	Header *record.Header `json:"header,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// archiveWriter adds files to a zip or tar.gz archive
+type archiveWriter interface {
+	// add writes a single file. tar requires the size up front.
+	add(name string, size int64, modTime time.Time, src io.Reader) error
+	Close() error
+}
+
+type zipArchive struct {
+	w *zip.Writer
+}
+
+func (a zipArchive) add(name string, size int64, modTime time.Time, src io.Reader) error {
+	f, err := a.w.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, src)
+	return err
+}
+
+func (a zipArchive) Close() error {
+	return a.w.Close()
+}
+
+type tarArchive struct {
+	gz *gzip.Writer
+	w  *tar.Writer
+}
+
+func (a tarArchive) add(name string, size int64, modTime time.Time, src io.Reader) error {
+	err := a.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(a.w, src, size)
+	return err
+}
+
+func (a tarArchive) Close() error {
+	if err := a.w.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+func newArchiveWriter(dst io.Writer, archive string) archiveWriter {
+	if archive == "tar.gz" {
+		gz := gzip.NewWriter(dst)
+		return tarArchive{gz: gz, w: tar.NewWriter(gz)}
+	}
+	return zipArchive{w: zip.NewWriter(dst)}
+}
+
+// WriteProjectArchive streams every recording of project on fs to dst as a
+// single archive followed by a manifest listing the files, their checksums and
+// the acquisition header of each recording. Only one converted recording is
+// held in memory at a time.
+func WriteProjectArchive(dst io.Writer, fs afero.Fs, project string, opts ArchiveOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	root := path.Join("/", project)
+	if exists, _ := afero.DirExists(fs, root); !exists || root == "/" {
+		return fmt.Errorf("project %q does not exist", project)
+	}
+	pm, err := readProjectManifest(fs, project)
+	if err != nil {
+		return err
+	}
+
+	w := newArchiveWriter(dst, opts.Archive)
+	base := path.Base(root)
+	manifest := bundleManifest{
+		Project: base,
+		Type:    opts.Type,
+		Created: time.Now().UTC(),
+		Sensors: pm.Sensors,
+		Survey:  pm.Survey,
+		Files:   make([]bundleEntry, 0),
+	}
+	exportOpts := exportOptions{unit: opts.Unit, samplingTime: opts.SamplingTime}
+	if exportOpts.unit == "" {
+		exportOpts.unit = sensor.Counts
+	}
+
+	err = afero.Walk(fs, root, func(srcPath string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if isHidden(srcPath) {
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !f.Mode().IsRegular() {
+			return nil
+		}
+
+		rel := strings.TrimPrefix(srcPath, root+"/")
+		entry := bundleEntry{
+			Path:   path.Join(base, rel+bundleExtensions[opts.Type]),
+			Source: rel,
+		}
+		h := sha256.New()
+		entry.Header, entry.Size, err = addRecording(w, entry.Path, fs, srcPath, f, opts.Type, exportOpts, h)
+		if err != nil {
+			if _, ok := err.(conversionError); !ok {
+				return err
+			}
+			entry.Error = err.Error()
+			entry.Size = 0
+		} else {
+			entry.SHA256 = hex.EncodeToString(h.Sum(nil))
+		}
+		manifest.Files = append(manifest.Files, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := w.add(path.Join(base, bundleManifestName), int64(len(b)), manifest.Created, bytes.NewReader(b)); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// conversionError is returned for recordings which can not be converted.
+// They are reported in the manifest instead of failing the whole archive.
+type conversionError struct {
+	err error
+}
+
+func (e conversionError) Error() string {
+	return e.err.Error()
+}
+
+// addRecording converts the recording at srcPath and adds it to the archive as
+// name. The content written to the archive is also written to sum.
+func addRecording(w archiveWriter, name string, fs afero.Fs, srcPath string, info os.FileInfo, fileType string, opts exportOptions, sum io.Writer) (*record.Header, int64, error) {
+	src, err := fs.Open(srcPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer src.Close()
+
+	if fileType == "raw" {
+		r, err := record.NewReader(src)
+		if err != nil {
+			return nil, 0, conversionError{err}
+		}
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return nil, 0, err
+		}
+		err = w.add(name, info.Size(), info.ModTime(), io.TeeReader(src, sum))
+		return &r.Header, info.Size(), err
+	}
+
+	rec, err := readRecording(src)
+	if err != nil {
+		return nil, 0, conversionError{err}
+	}
+	buf := new(bytes.Buffer)
+	switch fileType {
+	case "seg2":
+		err = writeSEG2(buf, rec, opts)
+	case "segy":
+		err = writeSEGY(buf, rec, opts)
+	case "csv":
+		err = writeCSV(buf, rec, opts)
+	}
+	if err != nil {
+		return nil, 0, conversionError{err}
+	}
+	size := int64(buf.Len())
+	err = w.add(name, size, info.ModTime(), io.TeeReader(buf, sum))
+	return &rec.header, size, err
+}
+
+// ProjectArchiveHandler streams a project as a zip or tar.gz archive
+func (s *Server) ProjectArchiveHandler(c *gin.Context) {
+	opts := ArchiveOptions{
+		Archive: strings.ToLower(c.DefaultQuery("archive", "zip")),
+		Type:    strings.ToLower(c.DefaultQuery("type", "raw")),
+	}
+	if err := opts.Validate(); err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	exportOpts, err := exportOptionsFromQuery(c)
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	opts.Unit = exportOpts.unit
+	opts.SamplingTime = exportOpts.samplingTime
+
+	project, err := existingPath(s.dataFS, c.Param("path"), true)
+	if err == nil && project == "/" {
+		err = &pathError{Path: project, Reason: pathRoot}
+	}
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+
+	contentType := "application/zip"
+	if opts.Archive == "tar.gz" {
+		contentType = "application/gzip"
+	}
+	c.Writer.Header().Set("content-type", contentType)
+	c.Writer.Header().Set("content-disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", path.Base(project), opts.Archive))
+	if err := WriteProjectArchive(c.Writer, s.dataFS, project, opts); err != nil {
+		s.l.Errorf("failed to write project archive: %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/MShoaei/quakeADC/driver"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/afero"
+)
+
+// calibrationDir is the directory at the root of the data file system
+// holding the calibration of every board in calibrationDir/<serial>.json
+const calibrationDir = "/.calibration"
+
+// startupGain is the gain of every channel after the hardware init
+const startupGain = 1000
+
+// CalibrationRecord is the stored calibration of a board
+type CalibrationRecord struct {
+	Serial      string             `json:"serial"`
+	Time        time.Time          `json:"time"`
+	Source      string             `json:"source"`
+	Calibration driver.Calibration `json:"calibration"`
+}
+
+func calibrationPath(name string) string {
+	return path.Join(calibrationDir, name+".json")
+}
+
+// readCalibrationFile decodes calibrationDir/<name>.json into v. v is
+// unchanged when the file does not exist.
+func (s *Server) readCalibrationFile(name string, v interface{}) error {
+	data, err := afero.ReadFile(s.dataFS, calibrationPath(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid calibration %s: %v", name, err)
+	}
+	return nil
+}
+
+func (s *Server) writeCalibrationFile(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := s.dataFS.MkdirAll(calibrationDir, os.ModeDir|0755); err != nil {
+		return err
+	}
+	// the file is replaced so a failed write keeps the previous calibration
+	tmp := calibrationPath(name) + ".tmp"
+	if err := afero.WriteFile(s.dataFS, tmp, data, 0644); err != nil {
+		return err
+	}
+	return s.dataFS.Rename(tmp, calibrationPath(name))
+}
+
+// loadCalibration reads the calibration of the board. A board which was
+// never calibrated has the default calibration and a zero time.
+func (s *Server) loadCalibration() (CalibrationRecord, error) {
+	rec := CalibrationRecord{Serial: s.serial, Calibration: driver.DefaultCalibration()}
+	err := s.readCalibrationFile(s.serial, &rec)
+	return rec, err
+}
+
+func (s *Server) saveCalibration(rec CalibrationRecord) error {
+	return s.writeCalibrationFile(s.serial, rec)
+}
+
+// gainRegisters are the gain registers of the channel gains without calibration
+func (s *Server) gainRegisters() (res [24]uint32) {
+	for i, g := range s.hd.Gains {
+		res[i] = g * s.GainMultiply
+	}
+	return res
+}
+
+// applyCalibration writes the stored calibration of the board with the
+// channel gains to the ADCs
+func (s *Server) applyCalibration() error {
+	rec, err := s.loadCalibration()
+	if err != nil {
+		return err
+	}
+	if rec.Time.IsZero() {
+		s.l.Warnf("board %s is not calibrated", s.serial)
+	} else {
+		s.l.Infof("applying calibration of board %s from %s", s.serial, rec.Time.Format(time.RFC3339))
+	}
+	s.calibration = rec
+	return s.adc.ApplyCalibration(rec.Calibration, s.gainRegisters(), 0)
+}
+
+// GetCalibrationHandler returns the calibration of the board and its age
+func (s *Server) GetCalibrationHandler(c *gin.Context) {
+	rec := s.calibration
+	res := gin.H{
+		"calibrated":  !rec.Time.IsZero(),
+		"serial":      s.serial,
+		"calibration": rec.Calibration,
+	}
+	if !rec.Time.IsZero() {
+		res["time"] = rec.Time
+		res["source"] = rec.Source
+		res["age"] = time.Since(rec.Time).Round(time.Second)
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// CalibrateHandler calibrates the offsets and gains of every channel. The
+// calibration wakes every channel so the enabled channels are put back
+// afterwards. The calibration is stored when it converged.
+func (s *Server) CalibrateHandler(c *gin.Context) {
+	source := driver.CalibrationSource(c.DefaultQuery("source", string(driver.CalibrateDiagnostic)))
+	if source != driver.CalibrateDiagnostic && source != driver.CalibrateFixture {
+		abortWithError(c, invalidField("source", "expected %s or %s", driver.CalibrateDiagnostic, driver.CalibrateFixture))
+		return
+	}
+	if s.adc == nil || s.xmega == nil || len(s.logics) == 0 {
+		abortWithError(c, hardwareError(fmt.Errorf("no ADC connection")))
+		return
+	}
+	if s.shuttingDown() {
+		abortWithError(c, errShuttingDown())
+		return
+	}
+	if !s.acquireHardware(c, workCalibration) {
+		return
+	}
+	defer s.hw.release()
+
+	res, err := s.adc.Calibrate(s.xmega, s.calibration.Calibration, driver.CalibrationOptions{
+		Source: source,
+		Logic:  s.logics[0],
+	})
+
+	var saveErr error
+	if err == nil && res.Converged {
+		rec := CalibrationRecord{Serial: s.serial, Time: time.Now(), Source: string(source), Calibration: res.Calibration}
+		if saveErr = s.saveCalibration(rec); saveErr == nil {
+			s.calibration = rec
+		}
+	}
+	// the registers hold the calibration gain and maybe an unfinished calibration
+	if applyErr := s.adc.ApplyCalibration(s.calibration.Calibration, s.gainRegisters(), 0); err == nil {
+		err = applyErr
+	}
+	if standbyErr := s.applyChannelStandby(); err == nil && standbyErr != nil {
+		err = fmt.Errorf("failed to restore the enabled channels: %v", standbyErr)
+	}
+	if err != nil {
+		abortWithError(c, hardwareError(err))
+		return
+	}
+	if saveErr != nil {
+		abortWithError(c, fmt.Errorf("failed to save calibration: %v", saveErr))
+		return
+	}
+	s.event(c, EventCalibration, map[string]interface{}{"source": source, "converged": res.Converged}, "offsets and gains calibrated from %s", source)
+	c.JSON(http.StatusOK, gin.H{
+		"result": res,
+		"saved":  res.Converged,
+	})
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MShoaei/quakeADC/driver"
+	"github.com/spf13/afero"
+)
+
+func TestCalibrationStore(t *testing.T) {
+	s := NewServer(afero.NewMemMapFs(), afero.NewMemMapFs(), &fakeStorage{}, nil, false)
+	s.serial = "1e9702"
+
+	rec, err := s.loadCalibration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Time.IsZero() || rec.Calibration != driver.DefaultCalibration() {
+		t.Fatalf("uncalibrated board = %+v, want the default calibration", rec)
+	}
+
+	want := CalibrationRecord{Serial: s.serial, Time: time.Now().UTC().Round(time.Second), Source: "fixture", Calibration: driver.DefaultCalibration()}
+	want.Calibration.Offsets[3] = -1200
+	want.Calibration.GainCorrections[20] = 1.0021
+	if err := s.saveCalibration(want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.loadCalibration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Time.Equal(want.Time) || got.Source != want.Source || got.Calibration != want.Calibration {
+		t.Errorf("loaded %+v, want %+v", got, want)
+	}
+	if g := got.Calibration.GainRegister(20, 1000); g != 1002 {
+		t.Errorf("corrected gain register = %d, want 1002", g)
+	}
+
+	// other boards keep their own calibration
+	s.serial = "1e9703"
+	if other, err := s.loadCalibration(); err != nil || !other.Time.IsZero() {
+		t.Errorf("other board = %+v, %v, want uncalibrated", other, err)
+	}
+}
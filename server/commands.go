@@ -1,36 +1,51 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/MShoaei/quakeADC/driver"
 	"github.com/gin-gonic/gin"
 )
 
 func (s *Server) CommandHandler(c *gin.Context) {
+	// adc is the chip select of a single ADC or 0 for all of them
 	adc, err := strconv.ParseUint(c.Param("adc"), 10, 8)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+	if err != nil || adc > 9 {
+		abortWithError(c, invalidField("adc", "expected a chip select between 1 and 9 or 0 for every ADC"))
 		return
 	}
+	if c.Request.Body != nil {
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			abortWithStatus(c, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		defer s.recordRegisterWrite(c, body)
+	}
+	// the resets hold the hardware themselves
+	if cmd := c.Param("cmd"); cmd != "HardReset" && cmd != "SoftReset" {
+		if !s.acquireHardware(c, workCommand) {
+			return
+		}
+		defer s.hw.release()
+	}
 	switch c.Param("cmd") {
 	case "ChStandby":
 		opts := driver.ChStandbyOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.ChStandby(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -44,9 +59,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.ChStandby(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -59,18 +72,13 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		return
 	case "ChModeA":
 		opts := driver.ChModeOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.ChModeA(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -84,9 +92,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.ChModeA(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -99,18 +105,13 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		return
 	case "ChModeB":
 		opts := driver.ChModeOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.ChModeB(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -124,9 +125,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.ChModeB(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -139,18 +138,13 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		return
 	case "ChModeSel":
 		opts := driver.ChModeSelectOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.ChModeSel(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -164,9 +158,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.ChModeSel(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -179,18 +171,13 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		return
 	case "PowerMode":
 		opts := driver.PowerModeOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.PowerMode(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -204,9 +191,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.PowerMode(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -219,18 +204,13 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		return
 	case "GeneralConf":
 		opts := driver.GeneralConfOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.GeneralConf(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -244,9 +224,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.GeneralConf(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -259,18 +237,13 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		return
 	case "DataControl":
 		opts := driver.DataControlOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.DataControl(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -284,9 +257,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.DataControl(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -299,18 +270,13 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		return
 	case "InterfaceConf":
 		opts := driver.InterfaceConfOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.InterfaceConf(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -324,9 +290,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.InterfaceConf(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -339,18 +303,13 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		return
 	case "BISTControl":
 		opts := driver.BISTControlOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.BISTControl(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -364,9 +323,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.BISTControl(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -378,12 +335,10 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		})
 		return
 	case "DeviceStatus":
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.DeviceStatus(uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -397,9 +352,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.DeviceStatus(i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -411,12 +364,10 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		})
 		return
 	case "RevisionID":
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.RevisionID(uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -430,9 +381,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.RevisionID(i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -445,18 +394,13 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		return
 	case "GPIOControl":
 		opts := driver.GPIOControlOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.GPIOControl(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -470,9 +414,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.GPIOControl(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -485,18 +427,13 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		return
 	case "GPIOWriteData":
 		opts := driver.GPIOWriteDataOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.GPIOWriteData(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -510,9 +447,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.GPIOWriteData(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -524,12 +459,10 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		})
 		return
 	case "GPIOReadData":
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.GPIOReadData(uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -543,9 +476,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.GPIOReadData(i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -558,18 +489,13 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		return
 	case "PrechargeBuffer1":
 		opts := driver.PreChargeBufferOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.PrechargeBuffer1(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -583,9 +509,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.PrechargeBuffer1(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -598,18 +522,13 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		return
 	case "PrechargeBuffer2":
 		opts := driver.PreChargeBufferOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.PrechargeBuffer2(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -623,9 +542,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.PrechargeBuffer2(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -638,18 +555,13 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		return
 	case "PositiveRefPrechargeBuf":
 		opts := driver.ReferencePrechargeBufOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.PositiveRefPrechargeBuf(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -663,9 +575,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.PositiveRefPrechargeBuf(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -678,18 +588,13 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		return
 	case "NegativeRefPrechargeBuf":
 		opts := driver.ReferencePrechargeBufOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.NegativeRefPrechargeBuf(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -703,9 +608,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.NegativeRefPrechargeBuf(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -718,104 +621,81 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		return
 	case "ChannelOffset":
 		opts := driver.ChannelOffsetOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			err := s.adc.ChannelOffset(opts, uint8(adc), s.Debug)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
-			c.JSON(http.StatusOK, nil)
+			c.JSON(http.StatusOK, gin.H{})
 			return
 		}
 		for i := uint8(1); i < 10; i++ {
 			err := s.adc.ChannelOffset(opts, i, s.Debug)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 		}
-		c.JSON(http.StatusOK, nil)
+		c.JSON(http.StatusOK, gin.H{})
 		return
 
 	case "ChannelGain":
 		opts := driver.ChannelGainOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			_, err := s.adc.ChannelGain(opts, uint8(adc), s.Debug)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
-			c.JSON(http.StatusOK, nil)
+			c.JSON(http.StatusOK, gin.H{})
 			return
 		}
 		for i := uint8(1); i < 10; i++ {
 			_, err := s.adc.ChannelGain(opts, i, s.Debug)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 		}
+		c.JSON(http.StatusOK, gin.H{})
+		return
 	case "ChannelSyncOffset":
 		opts := driver.ChannelSyncOffsetOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			err := s.adc.ChannelSyncOffset(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
+				return
 			}
-			c.JSON(http.StatusOK, nil)
+			c.JSON(http.StatusOK, gin.H{})
 			return
 		}
 		for i := uint8(1); i < 10; i++ {
 			err := s.adc.ChannelSyncOffset(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 		}
 	case "DiagnosticRX":
 		opts := driver.DiagnosticRXOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.DiagnosticRX(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -829,9 +709,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.DiagnosticRX(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -844,18 +722,13 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		return
 	case "DiagnosticMuxControl":
 		opts := driver.DiagnosticMuxControlOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.DiagnosticMuxControl(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -869,9 +742,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.DiagnosticMuxControl(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -884,18 +755,13 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		return
 	case "ModulatorDelayControl":
 		opts := driver.ModulatorDelayControlOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.ModulatorDelayControl(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -909,9 +775,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.ModulatorDelayControl(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -924,18 +788,13 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		return
 	case "ChopControl":
 		opts := driver.ChopControlOpts{}
-		if err := c.BindJSON(&opts); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
+		if !bindJSON(c, &opts) {
 			return
 		}
-		if adc != 0 && adc < 10 {
+		if adc != 0 {
 			tx, rx, err := s.adc.ChopControl(opts, uint8(adc))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
@@ -949,9 +808,7 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		for i := uint8(1); i < 10; i++ {
 			tx, rx, err := s.adc.ChopControl(opts, i)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+				abortWithError(c, hardwareError(err))
 				return
 			}
 			txResp = append(txResp, tx)
@@ -963,10 +820,38 @@ func (s *Server) CommandHandler(c *gin.Context) {
 		})
 		return
 	case "HardReset":
+		s.resetChips(c, driver.ResetHard, uint8(adc))
+	case "SoftReset":
+		s.resetChips(c, driver.ResetSoft, uint8(adc))
 	default:
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "command not found",
-		})
+		abortWithError(c, newError(http.StatusNotFound, "unknown command %q", c.Param("cmd")))
 		return
 	}
 }
+
+// recordRegisterWrite records a successful command which wrote a register.
+// Reads of the registers are not recorded.
+func (s *Server) recordRegisterWrite(c *gin.Context, body []byte) {
+	if c.Writer.Status() != http.StatusOK {
+		return
+	}
+	var opts map[string]interface{}
+	if err := json.Unmarshal(body, &opts); err != nil {
+		return
+	}
+	write := false
+	for k, v := range opts {
+		if strings.EqualFold(k, "write") {
+			write, _ = v.(bool)
+		}
+	}
+	if !write {
+		return
+	}
+	data := map[string]interface{}{
+		"command": c.Param("cmd"),
+		"adc":     c.Param("adc"),
+		"options": opts,
+	}
+	s.event(c, EventRegister, data, "%s written to adc %s", c.Param("cmd"), c.Param("adc"))
+}
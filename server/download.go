@@ -1,94 +1,68 @@
 package server
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"path"
-	"regexp"
+	"path/filepath"
+	"strconv"
 	"strings"
-	"time"
 
-	"github.com/MShoaei/quakeADC/seg2"
 	"github.com/gin-gonic/gin"
-	"github.com/go-cmd/cmd"
 	"github.com/spf13/afero"
-	"github.com/spf13/viper"
 )
 
 func (s *Server) DownloadSampleHandler(c *gin.Context) {
 	fileType, exists := c.GetQuery("type")
 	if !exists {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "requested file type not specified",
-		})
+		abortWithError(c, newError(http.StatusBadRequest, "requested file type not specified"))
 		return
 	}
 	fileType = strings.ToLower(fileType)
 	switch fileType {
-	case "seg2", "raw":
+	case "seg2", "segy", "raw", "sac", "csv":
 		break
 	default:
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid file type",
-		})
+		abortWithError(c, newError(http.StatusBadRequest, "invalid file type"))
 		return
 	}
-
-	dir, err := afero.IsDir(s.dataFS, "/"+c.Param("path"))
+	opts, err := exportOptionsFromQuery(c)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": err.Error(),
-		})
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
-	if dir {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid path. path is a directory",
-		})
+
+	p, err := existingPath(s.dataFS, c.Param("path"), false)
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
 
-	requestedFile, err := s.dataFS.Open("/" + c.Param("path"))
+	requestedFile, err := s.dataFS.Open(p)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": err.Error(),
-		})
+		abortWithStatus(c, http.StatusNotFound, err)
 		return
 	}
 	defer requestedFile.Close()
 
 	switch fileType {
 	case "seg2":
-		byteRes, err := extractData(requestedFile)
+		rec, err := readRecording(requestedFile)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": err.Error(),
-			})
+			abortWithError(c, err)
 			return
 		}
-		traces := seg2.NewTraceDescriptor(make([]string, len(byteRes)), byteRes, seg2.Fixed32)
-		w := seg2.NewWriter(time.Now(), int16(len(traces)), "")
 		f, err := s.memFS.Create(requestedFile.Name() + ".DAT")
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": err.Error(),
-			})
+			abortWithError(c, err)
 			return
 		}
 		defer f.Close()
 
-		err = w.Write(f, traces)
+		err = writeSEG2(f, rec, opts)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": err.Error(),
-			})
+			abortWithError(c, err)
 			return
 		}
 		var fs http.FileSystem = afero.NewHttpFs(s.memFS)
@@ -101,329 +75,183 @@ func (s *Server) DownloadSampleHandler(c *gin.Context) {
 		c.Writer.Header().Set("content-disposition", fmt.Sprintf("attachment; filename=\"%s\"", path.Base(requestedFile.Name())+".RAW"))
 		c.FileFromFS(requestedFile.Name(), fs)
 		return
-	}
-}
-
-func (s *Server) GetAllUSBHandler(c *gin.Context) {
-	devices, err := getAllUSB()
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": err.Error(),
-		})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{
-		"devices": devices,
-	})
-}
-
-func extractData(src io.Reader) ([][]byte, error) {
-	var header HeaderData
-	b, _ := ioutil.ReadAll(src)
-	infoBytes, _ := bufio.NewReader(bytes.NewReader(b)).ReadBytes('\n')
-	if err := json.Unmarshal(infoBytes, &header); err != nil {
-		return nil, err
-	}
-	b = b[len(infoBytes):]
-	count := 0
-	for i := 0; i < len(header.EnabledChannels); i++ {
-		if !header.EnabledChannels[i] {
-			continue
+	case "segy", "sac", "csv":
+		rec, err := readRecording(requestedFile)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if _, err := rec.interval(opts); err != nil {
+			abortWithStatus(c, http.StatusBadRequest, err)
+			return
 		}
-		count++
-	}
-
-	res := make([][]byte, count)
-	for i := 0; i < len(res); i++ {
-		res[i] = make([]byte, 0, len(b)/4)
-	}
 
-	for i := 0; i < count; i++ {
-		for j := i * 4; j < len(b); j += count * 4 {
-			res[i] = append(res[i], b[j], b[j+1], b[j+2], b[j+3])
+		base := path.Base(requestedFile.Name())
+		switch fileType {
+		case "segy":
+			c.Writer.Header().Set("content-type", "application/octet-stream")
+			c.Writer.Header().Set("content-disposition", fmt.Sprintf("attachment; filename=\"%s\"", base+".SGY"))
+			if err := writeSEGY(c.Writer, rec, opts); err != nil {
+				s.l.Errorf("failed to write seg-y: %v", err)
+			}
+			return
+		case "csv":
+			c.Writer.Header().Set("content-type", "text/csv")
+			c.Writer.Header().Set("content-disposition", fmt.Sprintf("attachment; filename=\"%s\"", base+".CSV"))
+			if err := writeCSV(c.Writer, rec, opts); err != nil {
+				s.l.Errorf("failed to write csv: %v", err)
+			}
+			return
 		}
+		c.Writer.Header().Set("content-type", "application/zip")
+		c.Writer.Header().Set("content-disposition", fmt.Sprintf("attachment; filename=\"%s\"", base+".SAC.zip"))
+		if err := writeSACZip(c.Writer, base, rec, opts); err != nil {
+			s.l.Errorf("failed to write sac archive: %v", err)
+		}
+		return
 	}
-	return res, nil
 }
 
 func (s *Server) SaveSampleFile(c *gin.Context) {
-	const pathPrefix = "HITECH"
-	data := struct {
-		File string `json:"file"`
-	}{}
-	if err := c.BindJSON(&data); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
-		return
-	}
-
-	dir, err := afero.IsDir(s.dataFS, data.File)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": err.Error(),
-		})
-		return
-	}
-	if dir {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid path. path is a directory",
-		})
-		return
-	}
-
-	fileType, exists := c.GetQuery("type")
-	if !exists {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "requested file type not specified",
-		})
-		return
-	}
-	var fileExtension string
-	fileType = strings.ToLower(fileType)
-	switch fileType {
-	case "seg2":
-		fileExtension = ".DAT"
-	case "raw":
-		fileExtension = ".RAW"
-	default:
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid file type",
-		})
-		return
-	}
-
-	connectedUSB, err := getAllUSB()
-	if connectedUSB.MountPoint == "" || err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "No USB connected",
-		})
+	data := FileRequest{}
+	if !bindJSON(c, &data) {
 		return
 	}
 
-	requestedFile, err := s.dataFS.Open(data.File)
+	p, err := existingPath(s.dataFS, data.File, false)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": err.Error(),
-		})
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
-	defer requestedFile.Close()
+	s.startUSBExport(c, []string{p})
+}
 
-	usbFS := afero.NewBasePathFs(afero.NewOsFs(), connectedUSB.MountPoint)
-	_ = usbFS.Mkdir(pathPrefix, os.ModeDir|0755)
-	if exists, _ := afero.DirExists(usbFS, pathPrefix); !exists {
-		c.JSON(http.StatusConflict, gin.H{
-			"error": "a file with name 'HITECH' exists on USB device.",
-		})
+func (s *Server) SaveProjectFolder(c *gin.Context) {
+	data := SaveProjectRequest{}
+	if !bindJSON(c, &data) {
 		return
 	}
 
-	force := strings.ToLower(c.Query("force")) != "" && strings.ToLower(c.Query("force")) == "true"
-	usbFS = afero.NewBasePathFs(usbFS, pathPrefix)
-	if fileExists, _ := afero.Exists(usbFS, data.File+fileExtension); fileExists && !force {
-		c.JSON(http.StatusConflict, gin.H{
-			"error": "file exists and not forced",
-		})
-		return
-	}
-	usbFS.MkdirAll(path.Dir(data.File), os.ModeDir|0755)
-	dst, err := usbFS.Create(data.File + fileExtension)
+	project, err := existingPath(s.dataFS, data.Project, true)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
-	defer dst.Close()
 
-	switch fileType {
-	case "seg2":
-		byteRes, err := extractData(requestedFile)
+	sources := make([]string, 0)
+	err = afero.Walk(s.dataFS, project, func(srcPath string, f os.FileInfo, err error) error {
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": err.Error(),
-			})
-			return
+			return err
 		}
-
-		traces := seg2.NewTraceDescriptor(make([]string, len(byteRes)), byteRes, seg2.Fixed32)
-		w := seg2.NewWriter(time.Now(), int16(len(traces)), "")
-		_ = w.Write(dst, traces)
-		c.JSON(http.StatusOK, gin.H{
-			"files": []string{data.File},
-		})
-	case "raw":
-		io.Copy(dst, requestedFile)
-		c.JSON(http.StatusOK, nil)
+		if isHidden(srcPath) {
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if f.Mode().IsRegular() {
+			sources = append(sources, srcPath)
+		}
+		return nil
+	})
+	if err != nil {
+		abortWithError(c, err)
+		return
 	}
+	s.startUSBExport(c, sources)
 }
 
-func (s *Server) SaveProjectFolder(c *gin.Context) {
+// startUSBExport checks the request and the USB device and starts copying
+// sources in the background. The response holds the job to poll for progress.
+func (s *Server) startUSBExport(c *gin.Context, sources []string) {
 	const pathPrefix = "HITECH"
-	data := struct {
-		Project string `json:"project"`
-	}{}
-	if err := c.BindJSON(&data); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
-		return
-	}
-
 	fileType, exists := c.GetQuery("type")
 	if !exists {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "requested file type not specified",
-		})
+		abortWithError(c, newError(http.StatusBadRequest, "requested file type not specified"))
 		return
 	}
-	var fileExtension string
 	fileType = strings.ToLower(fileType)
 	switch fileType {
-	case "seg2":
-		fileExtension = ".DAT"
-	case "raw":
-		fileExtension = ".RAW"
+	case "seg2", "segy", "raw", "csv", "sac":
+		break
 	default:
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid file type",
-		})
+		abortWithError(c, newError(http.StatusBadRequest, "invalid file type"))
 		return
 	}
-
-	connectedUSB, err := getAllUSB()
-	if connectedUSB.MountPoint == "" || err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "No USB connected",
-		})
+	opts, err := exportOptionsFromQuery(c)
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
+	force := strings.ToLower(c.Query("force")) == "true"
 
-	if exists, _ := afero.DirExists(s.dataFS, data.Project); !exists {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "requested folder does not exist",
-		})
+	connectedUSB, err := s.exportDevice(c.Query("device"))
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
 
 	usbFS := afero.NewBasePathFs(afero.NewOsFs(), connectedUSB.MountPoint)
 	_ = usbFS.Mkdir(pathPrefix, os.ModeDir|0755)
 	if exists, _ := afero.DirExists(usbFS, pathPrefix); !exists {
-		c.JSON(http.StatusConflict, gin.H{
-			"error": "a file with name 'HITECH' exists on USB device.",
-		})
+		abortWithError(c, newError(http.StatusConflict, "a file with name 'HITECH' exists on USB device."))
 		return
 	}
-
-	force := strings.ToLower(c.Query("force")) != "" && strings.ToLower(c.Query("force")) == "true"
 	usbFS = afero.NewBasePathFs(usbFS, pathPrefix)
-	usbFS.MkdirAll(path.Dir(data.Project+"/"), os.ModeDir|0755)
-	if empty, _ := afero.IsEmpty(usbFS, data.Project); !empty && !force {
-		c.JSON(http.StatusConflict, gin.H{
-			"error": "project path already exists and not forced",
-		})
-		return
-	}
 
-	copiedList := make([]string, 0)
-	err = afero.Walk(s.dataFS, path.Dir(data.Project+"/"), func(srcPath string, f os.FileInfo, err error) error {
+	sizes := make([]int64, len(sources))
+	var required int64
+	for i, src := range sources {
+		info, err := s.dataFS.Stat(src)
 		if err != nil {
-			return err
+			abortWithStatus(c, http.StatusNotFound, err)
+			return
 		}
-		switch mode := f.Mode(); {
-		case mode.IsRegular():
-			src, _ := s.dataFS.Open(srcPath)
-			dst, _ := usbFS.Create(srcPath + fileExtension)
-			defer src.Close()
-			defer dst.Close()
-
-			switch fileType {
-			case "seg2":
-				byteRes, err := extractData(src)
-				if err != nil {
-					return err
-				}
-				traces := seg2.NewTraceDescriptor(make([]string, len(byteRes)), byteRes, seg2.Fixed32)
-				w := seg2.NewWriter(time.Now(), int16(len(traces)), "")
-				_ = w.Write(dst, traces)
-			case "raw":
-				_, err := io.Copy(dst, src)
-				if err != nil {
-					return err
-				}
-			}
-			copiedList = append(copiedList, srcPath)
+		sizes[i] = info.Size()
+		required += info.Size() * exportSizeFactor(fileType)
+	}
+	if free, err := freeSpace(path.Join(connectedUSB.MountPoint, pathPrefix)); err != nil {
+		s.l.Warnf("skipping free space check: %v", err)
+	} else if uint64(required) > free {
+		abortWithError(c, newError(http.StatusInsufficientStorage, "not enough space on USB device. %d bytes required, %d bytes available", required, free))
+		return
+	}
 
-		case mode.IsDir():
-			if err := usbFS.Mkdir(srcPath, f.Mode()); err != nil && !os.IsExist(err) {
-				return err
-			}
-		}
-		return nil
-	})
+	if s.shuttingDown() {
+		abortWithError(c, errShuttingDown())
+		return
+	}
+	job, err := s.exports.start(fileType, sources, sizes)
 	if err != nil {
-		c.JSON(http.StatusConflict, gin.H{
-			"error": err.Error,
-		})
+		abortWithStatus(c, http.StatusConflict, err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"files": copiedList,
+	go job.run(s.dataFS, usbFS, fileType, opts, force)
+	c.JSON(http.StatusAccepted, gin.H{
+		"job": job.Status(),
 	})
 }
 
-func getAllUSB() (usbDevice, error) {
-	var allDevices []usbDevice
-	status := <-cmd.NewCmd("lsblk", "-o", "NAME,LABEL,SIZE,MOUNTPOINT", "-J").Start()
-	str := strings.Builder{}
-	for _, s := range status.Stdout {
-		str.WriteString(s)
-	}
-
-	v := viper.New()
-
-	v.SetConfigType("json")
-	_ = v.ReadConfig(strings.NewReader(str.String()))
-	if err := v.UnmarshalKey("blockdevices", &allDevices); err != nil {
-		log.Printf("unmarshal error: %v", err)
-	}
-
-	var connectedUSB usbDevice
-	for _, dev := range allDevices {
-		for _, child := range dev.Children {
-			if match, _ := regexp.MatchString(`^/(media|mnt/USB).*`, child.MountPoint); match {
-				connectedUSB = child
-				return connectedUSB, nil
-			}
-		}
-	}
+// GetExportJobsHandler returns the status of every USB export
+func (s *Server) GetExportJobsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"jobs": s.exports.all(),
+	})
+}
 
-	if connectedUSB.MountPoint == "" {
-		for _, dev := range allDevices {
-			if strings.Contains(dev.Name, "mmcblk0") {
-				continue
-			}
-			for _, child := range dev.Children {
-				if child.Children != nil {
-					continue
-				}
-				status = <-cmd.NewCmd("/usr/bin/sudo", "mount", "-o", "uid=pi,gid=pi", path.Join("/", "dev", child.Name), "/mnt/USB").Start()
-				if status.Exit == 0 {
-					child.MountPoint = "/mnt"
-					connectedUSB = child
-					return connectedUSB, nil
-				}
-			}
-		}
+// GetExportJobHandler returns the progress of a single USB export
+func (s *Server) GetExportJobHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		abortWithError(c, newError(http.StatusBadRequest, "invalid job id"))
+		return
 	}
-	if connectedUSB.MountPoint == "" {
-		return connectedUSB, fmt.Errorf("USB not found")
+	job, ok := s.exports.get(id)
+	if !ok {
+		abortWithError(c, newError(http.StatusNotFound, "job not found"))
+		return
 	}
-	return connectedUSB, status.Error
-}
-
-func init() {
-	<-cmd.NewCmd("/usr/bin/sudo", "umount", "/mnt/USB").Start()
-	_, _ = getAllUSB()
+	c.JSON(http.StatusOK, gin.H{
+		"job": job.Status(),
+	})
 }
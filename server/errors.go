@@ -0,0 +1,156 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorCode identifies the kind of failure of a request so clients do not
+// have to parse error messages
+type ErrorCode string
+
+const (
+	CodeInvalidRequest ErrorCode = "invalid_request"
+	CodeInvalidField   ErrorCode = "invalid_field"
+	CodeInvalidPath    ErrorCode = "invalid_path"
+	CodeUnauthorized   ErrorCode = "unauthorized"
+	CodeForbidden      ErrorCode = "forbidden"
+	CodeNotFound       ErrorCode = "not_found"
+	CodeMethod         ErrorCode = "method_not_allowed"
+	CodeConflict       ErrorCode = "conflict"
+	CodeBusy           ErrorCode = "busy"
+	CodeTooMany        ErrorCode = "too_many_requests"
+	CodeStorageFull    ErrorCode = "storage_full"
+	CodeHardware       ErrorCode = "hardware_error"
+	CodeNotImplemented ErrorCode = "not_implemented"
+	CodeInternal       ErrorCode = "internal_error"
+)
+
+// APIError is the body of every error response
+type APIError struct {
+	Status  int       `json:"-"`
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"error"`
+
+	// Field is the request field which failed validation
+	Field string `json:"field,omitempty"`
+
+	// Path and Reason are set for refused paths
+	Path   string `json:"path,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// statusCode is the code of errors responded to with status
+func statusCode(status int) ErrorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethod
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusServiceUnavailable:
+		return CodeBusy
+	case http.StatusTooManyRequests:
+		return CodeTooMany
+	case http.StatusInsufficientStorage:
+		return CodeStorageFull
+	case http.StatusNotImplemented:
+		return CodeNotImplemented
+	}
+	return CodeInternal
+}
+
+// newError creates an error responded to with status
+func newError(status int, format string, args ...interface{}) *APIError {
+	return &APIError{Status: status, Code: statusCode(status), Message: fmt.Sprintf(format, args...)}
+}
+
+// hardwareError is a failure to talk to the ADCs or the board
+func hardwareError(err error) *APIError {
+	return &APIError{Status: http.StatusInternalServerError, Code: CodeHardware, Message: err.Error()}
+}
+
+// fieldError is a request field with an invalid value
+type fieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *fieldError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Message)
+}
+
+func invalidField(field, format string, args ...interface{}) error {
+	return &fieldError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// toAPIError converts err to the response for it. Errors of unknown type are
+// responded to with status.
+func toAPIError(err error, status int) *APIError {
+	switch e := err.(type) {
+	case *APIError:
+		return e
+	case *pathError:
+		res := newError(e.status(), "%s", e.Error())
+		if res.Status == http.StatusBadRequest {
+			res.Code = CodeInvalidPath
+		}
+		res.Path, res.Reason = e.Path, e.Reason
+		return res
+	case *fieldError:
+		return &APIError{Status: http.StatusBadRequest, Code: CodeInvalidField, Message: e.Error(), Field: e.Field}
+	case *storageError:
+		return newError(http.StatusInsufficientStorage, "%s", e.Error())
+	}
+	return newError(status, "%s", err.Error())
+}
+
+// abortWithError stops the request with the response for err. Errors of
+// unknown type are internal errors.
+func abortWithError(c *gin.Context, err error) {
+	abortWithStatus(c, http.StatusInternalServerError, err)
+}
+
+// abortWithStatus is abortWithError for errors of unknown type caused by the request
+func abortWithStatus(c *gin.Context, status int, err error) {
+	e := toAPIError(err, status)
+	_ = c.Error(e)
+	c.AbortWithStatusJSON(e.Status, e)
+}
+
+// validator is implemented by request bodies which check their values
+type validator interface {
+	Validate() error
+}
+
+// bindJSON decodes the request body into v and validates it. It writes the
+// error response and returns false when the body is invalid.
+func bindJSON(c *gin.Context, v interface{}) bool {
+	if err := c.ShouldBindJSON(v); err != nil {
+		if _, ok := err.(*fieldError); !ok {
+			err = fmt.Errorf("invalid request body: %v", err)
+		}
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return false
+	}
+	if r, ok := v.(validator); ok {
+		if err := r.Validate(); err != nil {
+			abortWithStatus(c, http.StatusBadRequest, err)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestErrorResponses(t *testing.T) {
+	s := NewServer(afero.NewMemMapFs(), afero.NewMemMapFs(), &fakeStorage{}, nil, false)
+	tests := []struct {
+		name      string
+		method    string
+		url       string
+		body      string
+		wantCode  ErrorCode
+		wantField string
+		want      int
+	}{
+		{name: "malformed body", method: "POST", url: "/setup", body: "{", want: http.StatusBadRequest, wantCode: CodeInvalidRequest},
+		{name: "window out of range", method: "POST", url: "/setup", want: http.StatusBadRequest, wantCode: CodeInvalidField, wantField: "window",
+			body: `{"startMode":"asap","recordTime":1,"samplingTime":1000,"window":101,"fileName":"a"}`},
+		{name: "unsupported sampling time", method: "POST", url: "/setup", want: http.StatusBadRequest, wantCode: CodeInvalidField, wantField: "samplingTime",
+			body: `{"startMode":"asap","recordTime":1,"samplingTime":3,"window":1,"fileName":"a"}`},
+		{name: "short channel list", method: "POST", url: "/channels", body: "[true,false]", want: http.StatusBadRequest, wantCode: CodeInvalidField, wantField: "channels"},
+		{name: "chip select out of range", method: "POST", url: "/command/DeviceStatus/10", want: http.StatusBadRequest, wantCode: CodeInvalidField, wantField: "adc"},
+		{name: "multiplier not a number", method: "PATCH", url: "/multiplier?val=x", want: http.StatusBadRequest, wantCode: CodeInvalidField, wantField: "val"},
+		{name: "missing file", method: "POST", url: "/plot", body: `{"file":"/missing"}`, want: http.StatusNotFound, wantCode: CodeNotFound},
+		{name: "reserved name", method: "POST", url: "/tree", body: `{"name":".trash"}`, want: http.StatusBadRequest, wantCode: CodeInvalidField, wantField: "name"},
+		{name: "self test without ADCs", method: "POST", url: "/selftest", want: http.StatusInternalServerError, wantCode: CodeHardware},
+		{name: "no self test report", method: "GET", url: "/selftest", want: http.StatusNotFound, wantCode: CodeNotFound},
+		{name: "invalid calibration source", method: "POST", url: "/calibrate?source=sun", want: http.StatusBadRequest, wantCode: CodeInvalidField, wantField: "source"},
+		{name: "calibrate without ADCs", method: "POST", url: "/calibrate", want: http.StatusInternalServerError, wantCode: CodeHardware},
+		{name: "sync recording without file", method: "POST", url: "/calibrate/sync", body: `{"source":"recording"}`, want: http.StatusBadRequest, wantCode: CodeInvalidField, wantField: "file"},
+		{name: "sync capture without ADCs", method: "POST", url: "/calibrate/sync", body: `{"source":"capture"}`, want: http.StatusInternalServerError, wantCode: CodeHardware},
+		{name: "invalid reset mode", method: "POST", url: "/adc/reset?mode=warm", want: http.StatusBadRequest, wantCode: CodeInvalidField, wantField: "mode"},
+		{name: "hard reset of one chip", method: "POST", url: "/adc/reset?mode=hard&adc=3", want: http.StatusBadRequest, wantCode: CodeInvalidField, wantField: "adc"},
+		{name: "reset without ADCs", method: "POST", url: "/command/SoftReset/0", want: http.StatusInternalServerError, wantCode: CodeHardware},
+		{name: "unknown route", method: "GET", url: "/nothing", want: http.StatusNotFound, wantCode: CodeNotFound},
+		{name: "wrong method", method: "PUT", url: "/channels", want: http.StatusMethodNotAllowed, wantCode: CodeMethod},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.api.ServeHTTP(w, httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body)))
+			var got APIError
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("%s %s body %q is not an error: %v", tt.method, tt.url, w.Body.String(), err)
+			}
+			if w.Code != tt.want || got.Code != tt.wantCode || got.Field != tt.wantField || got.Message == "" {
+				t.Errorf("%s %s = %d %+v, want %d %s %q", tt.method, tt.url, w.Code, got, tt.want, tt.wantCode, tt.wantField)
+			}
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/afero"
+)
+
+// eventsDir is the directory at the root of the data file system holding
+// the event journal. New events are appended to eventsFile which is renamed
+// to events-<time>.jsonl when it is full.
+const (
+	eventsDir  = "/.events"
+	eventsFile = "events.jsonl"
+)
+
+// eventsTimeFormat is the rotation time in the name of full journal files
+const eventsTimeFormat = "20060102T150405.000000000"
+
+// Types of the events in the journal
+const (
+	EventStartup     = "startup"
+	EventPower       = "power"
+	EventRegister    = "register"
+	EventGains       = "gains"
+	EventChannels    = "channels"
+	EventRecording   = "recording"
+	EventTrigger     = "trigger"
+	EventCalibration = "calibration"
+	EventReset       = "reset"
+	EventHealth      = "health"
+	EventTelemetry   = "telemetry"
+	EventWifi        = "wifi"
+	EventStorage     = "storage"
+	EventAuth        = "auth"
+	EventError       = "error"
+)
+
+// EventsConfig configures the event journal
+type EventsConfig struct {
+	// MaxSize is the size in bytes of a journal file after which a new one is started
+	MaxSize int64 `json:"maxSize" mapstructure:"maxSize"`
+
+	// MaxFiles is the number of full journal files kept
+	MaxFiles int `json:"maxFiles" mapstructure:"maxFiles"`
+}
+
+func DefaultEventsConfig() EventsConfig {
+	return EventsConfig{
+		MaxSize:  1 << 20,
+		MaxFiles: 20,
+	}
+}
+
+func (cfg EventsConfig) Validate() error {
+	if cfg.MaxSize < 4096 {
+		return fmt.Errorf("invalid events maxSize %d. expected at least 4096", cfg.MaxSize)
+	}
+	if cfg.MaxFiles < 1 {
+		return fmt.Errorf("invalid events maxFiles %d. expected at least 1", cfg.MaxFiles)
+	}
+	return nil
+}
+
+// Event is an entry of the journal
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Message string    `json:"message"`
+
+	// User and Remote are the user and address of the request causing the
+	// event. Both are empty for events of the server itself.
+	User   string `json:"user,omitempty"`
+	Remote string `json:"remote,omitempty"`
+
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// journal appends events to JSON lines files in the data file system
+type journal struct {
+	mu  sync.Mutex
+	fs  afero.Fs
+	cfg EventsConfig
+}
+
+func newJournal(fs afero.Fs, cfg EventsConfig) *journal {
+	return &journal{fs: fs, cfg: cfg}
+}
+
+func (j *journal) setConfig(cfg EventsConfig) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.cfg = cfg
+}
+
+// add appends e to the journal and starts a new file when it does not fit
+func (j *journal) add(e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.fs.MkdirAll(eventsDir, os.ModeDir|0755); err != nil {
+		return err
+	}
+	current := path.Join(eventsDir, eventsFile)
+	if info, err := j.fs.Stat(current); err == nil && info.Size() > 0 && info.Size()+int64(len(line)) > j.cfg.MaxSize {
+		if err := j.rotate(current); err != nil {
+			return err
+		}
+	}
+	f, err := j.fs.OpenFile(current, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	// a line cut by a power loss is ended so the event is not lost with it
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			line = append([]byte{'\n'}, line...)
+		}
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotate renames the current file and removes the oldest full files
+func (j *journal) rotate(current string) error {
+	name := "events-" + time.Now().UTC().Format(eventsTimeFormat) + ".jsonl"
+	if err := j.fs.Rename(current, path.Join(eventsDir, name)); err != nil {
+		return err
+	}
+	files, err := j.rotated()
+	if err != nil {
+		return err
+	}
+	for len(files) > j.cfg.MaxFiles {
+		if err := j.fs.Remove(path.Join(eventsDir, files[0])); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// rotated lists the full journal files from the oldest
+func (j *journal) rotated() ([]string, error) {
+	infos, err := afero.ReadDir(j.fs, eventsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var res []string
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), "events-") && strings.HasSuffix(info.Name(), ".jsonl") {
+			res = append(res, info.Name())
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// eventQuery selects events of the journal. Zero fields match every event.
+type eventQuery struct {
+	since time.Time
+	until time.Time
+	types map[string]bool
+}
+
+func (q eventQuery) match(e Event) bool {
+	if !q.since.IsZero() && e.Time.Before(q.since) {
+		return false
+	}
+	if !q.until.IsZero() && e.Time.After(q.until) {
+		return false
+	}
+	return len(q.types) == 0 || q.types[e.Type]
+}
+
+// query returns the events matching q from the oldest. Full files rotated
+// before q.since are not read.
+func (j *journal) query(q eventQuery) ([]Event, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	files, err := j.rotated()
+	if err != nil {
+		return nil, err
+	}
+	var res []Event
+	for _, name := range append(files, eventsFile) {
+		if !q.since.IsZero() && name != eventsFile {
+			stamp := strings.TrimSuffix(strings.TrimPrefix(name, "events-"), ".jsonl")
+			if rotated, err := time.Parse(eventsTimeFormat, stamp); err == nil && rotated.Before(q.since) {
+				continue
+			}
+		}
+		if res, err = j.read(path.Join(eventsDir, name), q, res); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// read appends the events of the file p matching q to res. Lines which are
+// not events, e.g. one cut by a power loss, are skipped.
+func (j *journal) read(p string, q eventQuery, res []Event) ([]Event, error) {
+	f, err := j.fs.Open(p)
+	if os.IsNotExist(err) {
+		return res, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), int(j.cfg.MaxSize)+1)
+	for sc.Scan() {
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			continue
+		}
+		if q.match(e) {
+			res = append(res, e)
+		}
+	}
+	return res, sc.Err()
+}
+
+// SetEvents configures the event journal
+func (s *Server) SetEvents(cfg EventsConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	s.events.setConfig(cfg)
+	return nil
+}
+
+// event records an event in the journal. c is the request causing it or nil
+// for events of the server itself. A failure to record it is only logged.
+func (s *Server) event(c *gin.Context, typ string, data map[string]interface{}, format string, args ...interface{}) {
+	e := Event{Time: time.Now().UTC(), Type: typ, Message: fmt.Sprintf(format, args...), Data: data}
+	if c != nil {
+		e.User = c.GetString("user")
+		e.Remote = c.ClientIP()
+	}
+	if err := s.events.add(e); err != nil {
+		s.l.Errorf("failed to record %s event: %v", typ, err)
+	}
+}
+
+// recordErrors records the server errors of the API requests
+func (s *Server) recordErrors(c *gin.Context) {
+	c.Next()
+	if c.Writer.Status() < http.StatusInternalServerError || len(c.Errors) == 0 {
+		return
+	}
+	data := map[string]interface{}{
+		"method": c.Request.Method,
+		"path":   c.Request.URL.Path,
+		"status": c.Writer.Status(),
+	}
+	if e, ok := c.Errors.Last().Err.(*APIError); ok {
+		data["code"] = e.Code
+	}
+	s.event(c, EventError, data, "%s", c.Errors.Last().Error())
+}
+
+// EventsHandler lists the events of the journal from the oldest. since and
+// until limit the time range, type the event types and limit keeps the
+// newest events.
+func (s *Server) EventsHandler(c *gin.Context) {
+	var q eventQuery
+	for _, f := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &q.since}, {"until", &q.until}} {
+		if v := c.Query(f.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				abortWithError(c, invalidField(f.name, "expected an RFC 3339 time"))
+				return
+			}
+			*f.t = t
+		}
+	}
+	if v := c.Query("type"); v != "" {
+		q.types = map[string]bool{}
+		for _, t := range strings.Split(v, ",") {
+			q.types[strings.TrimSpace(t)] = true
+		}
+	}
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			abortWithError(c, invalidField("limit", "expected a positive integer"))
+			return
+		}
+	}
+
+	events, err := s.events.query(q)
+	if err != nil {
+		abortWithError(c, fmt.Errorf("failed to read events: %v", err))
+		return
+	}
+	if limit > 0 && len(events) > limit {
+		events = events[len(events)-limit:]
+	}
+	if events == nil {
+		events = []Event{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+	})
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestJournalRotation(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	j := newJournal(fs, EventsConfig{MaxSize: 4096, MaxFiles: 2})
+	start := time.Now().UTC()
+	for i := 0; i < 200; i++ {
+		e := Event{Time: start.Add(time.Duration(i) * time.Second), Type: EventGains, Message: strings.Repeat("x", 40)}
+		if i%2 == 1 {
+			e.Type = EventChannels
+		}
+		if err := j.add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := j.rotated()
+	if err != nil || len(files) != 2 {
+		t.Fatalf("rotated files = %v, %v, want 2", files, err)
+	}
+	all, err := j.query(eventQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) == 0 || len(all) >= 200 || !all[len(all)-1].Time.Equal(start.Add(199*time.Second)) {
+		t.Fatalf("kept %d events ending at %v, want the newest ones", len(all), all[len(all)-1].Time)
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].Time.Before(all[i-1].Time) {
+			t.Fatalf("events are not from the oldest at %d", i)
+		}
+	}
+
+	// a line cut by a power loss is skipped
+	f, _ := fs.OpenFile(path.Join(eventsDir, eventsFile), os.O_WRONLY|os.O_APPEND, 0644)
+	_, _ = f.Write([]byte(`{"time":"2020`))
+	_ = f.Close()
+	q := eventQuery{since: start.Add(190 * time.Second), until: start.Add(195 * time.Second), types: map[string]bool{EventGains: true}}
+	got, err := j.query(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0].Type != EventGains {
+		t.Errorf("query = %+v, want the 3 gains events from 190s to 195s", got)
+	}
+
+	if err := j.add(Event{Time: start.Add(200 * time.Second), Type: EventGains}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := j.query(eventQuery{since: start.Add(200 * time.Second)}); len(got) != 1 {
+		t.Errorf("events after a cut line = %+v, want the new one", got)
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	s := NewServer(afero.NewMemMapFs(), afero.NewMemMapFs(), &fakeStorage{}, nil, false)
+	for _, r := range []*http.Request{
+		httptest.NewRequest("PATCH", "/multiplier?val=500", nil),
+		// no board controller
+		httptest.NewRequest("GET", "/telemetry", nil),
+		// refused requests are not errors of the server
+		httptest.NewRequest("PATCH", "/multiplier?val=0", nil),
+	} {
+		s.api.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	w := httptest.NewRecorder()
+	s.api.ServeHTTP(w, httptest.NewRequest("GET", "/events?type=gains,error", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("events = %d %s", w.Code, w.Body.String())
+	}
+	var res struct {
+		Events []Event `json:"events"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Events) != 2 {
+		t.Fatalf("events = %+v, want a gains and an error event", res.Events)
+	}
+	if e := res.Events[0]; e.Type != EventGains || e.Data["multiplier"] != float64(500) || e.Remote == "" {
+		t.Errorf("first event = %+v, want the multiplier change", e)
+	}
+	if e := res.Events[1]; e.Type != EventError || e.Data["path"] != "/telemetry" || e.Data["code"] != string(CodeHardware) {
+		t.Errorf("second event = %+v, want the telemetry hardware error", e)
+	}
+
+	w = httptest.NewRecorder()
+	s.api.ServeHTTP(w, httptest.NewRequest("GET", "/events?since=yesterday", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid since = %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,323 @@
+package server
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MShoaei/quakeADC/record"
+	"github.com/MShoaei/quakeADC/sac"
+	"github.com/MShoaei/quakeADC/seg2"
+	"github.com/MShoaei/quakeADC/segy"
+	"github.com/MShoaei/quakeADC/sensor"
+	"github.com/gin-gonic/gin"
+)
+
+// recording is a decoded data file with one slice of samples per recorded channel
+type recording struct {
+	header  record.Header
+	samples [][]int32
+}
+
+func readRecording(src io.Reader) (*recording, error) {
+	r, err := record.NewReader(src)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	return &recording{header: r.Header, samples: samples}, nil
+}
+
+// exportOptions controls how a recording is converted for SAC and CSV output
+type exportOptions struct {
+	unit sensor.Unit
+
+	// samplingTime in microseconds. overrides the value in the file header
+	// which is missing from files recorded before it was added.
+	samplingTime float32
+}
+
+// scale converts a raw ADC count of recorded channel i to the requested unit
+func (r *recording) scale(i int, value int32, unit sensor.Unit) (float64, error) {
+	return r.header.Channels[i].Model().Convert(value, unit)
+}
+
+// unit resolves the unit channel i is exported in
+func (r *recording) unit(i int, unit sensor.Unit) sensor.Unit {
+	if unit == sensor.Physical {
+		return r.header.Channels[i].Model().Unit()
+	}
+	return unit
+}
+
+func exportOptionsFromQuery(c *gin.Context) (exportOptions, error) {
+	var opts exportOptions
+	unit, err := sensor.ParseUnit(c.Query("unit"))
+	if err != nil {
+		return opts, err
+	}
+	opts.unit = unit
+	if st := c.Query("samplingTime"); st != "" {
+		v, err := strconv.ParseFloat(st, 32)
+		if err != nil || v <= 0 {
+			return opts, fmt.Errorf("invalid sampling time %q", st)
+		}
+		opts.samplingTime = float32(v)
+	}
+	return opts, nil
+}
+
+func (r *recording) interval(opts exportOptions) (float64, error) {
+	if opts.samplingTime > 0 {
+		return float64(opts.samplingTime) / 1e6, nil
+	}
+	if r.header.SampleRate <= 0 {
+		return 0, fmt.Errorf("sampling time is not recorded in file header and was not specified")
+	}
+	return 1 / r.header.SampleRate, nil
+}
+
+// writeCSV writes a time column in seconds followed by one column per enabled channel
+func writeCSV(dst io.Writer, r *recording, opts exportOptions) error {
+	interval, err := r.interval(opts)
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(dst)
+	row := make([]string, 0, len(r.header.Channels)+1)
+	row = append(row, "time (s)")
+	for i, ch := range r.header.Channels {
+		if _, _, err := ch.Model().Factor(opts.unit); err != nil {
+			return fmt.Errorf("channel %d: %v", ch.Index+1, err)
+		}
+		row = append(row, fmt.Sprintf("ch%02d (%s)", ch.Index+1, r.unit(i, opts.unit)))
+	}
+	if err := w.Write(row); err != nil {
+		return err
+	}
+
+	count := 0
+	if len(r.samples) > 0 {
+		count = len(r.samples[0])
+	}
+	for j := 0; j < count; j++ {
+		row = row[:0]
+		row = append(row, strconv.FormatFloat(float64(j)*interval, 'f', -1, 64))
+		for i := range r.samples {
+			if opts.unit == sensor.Counts {
+				row = append(row, strconv.FormatInt(int64(r.samples[i][j]), 10))
+				continue
+			}
+			v, _ := r.scale(i, r.samples[i][j], opts.unit)
+			row = append(row, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// sacFileName returns the name of the SAC file holding channel ch of the recording at base
+func sacFileName(base string, ch int) string {
+	return fmt.Sprintf("%s.CH%02d.SAC", base, ch+1)
+}
+
+// writeSAC writes channel index i of the recording as a single SAC trace
+func writeSAC(dst io.Writer, r *recording, i int, opts exportOptions) error {
+	interval, err := r.interval(opts)
+	if err != nil {
+		return err
+	}
+	ch := r.header.Channels[i].Index
+	h := sac.Header{
+		Delta:     float32(interval),
+		Reference: r.header.StartTime,
+		Station:   fmt.Sprintf("CH%02d", ch+1),
+		Component: "Z",
+		Unit:      sac.Unknown,
+	}
+	switch r.unit(i, opts.unit) {
+	case sensor.Volts:
+		h.Unit = sac.Volts
+	case sensor.MetersPerSecond:
+		h.Unit = sac.Velocity
+	case sensor.G:
+		h.Unit = sac.Acceleration
+	}
+
+	scale, offset, err := r.header.Channels[i].Model().Factor(opts.unit)
+	if err != nil {
+		return fmt.Errorf("channel %d: %v", ch+1, err)
+	}
+	data := make([]float32, len(r.samples[i]))
+	for j, v := range r.samples[i] {
+		data[j] = float32(float64(v)*scale + offset)
+	}
+	return sac.Write(dst, h, data)
+}
+
+// writeSACZip writes every channel of the recording as a SAC file into a zip archive
+func writeSACZip(dst io.Writer, base string, r *recording, opts exportOptions) error {
+	zw := zip.NewWriter(dst)
+	for i, ch := range r.header.Channels {
+		f, err := zw.Create(sacFileName(base, ch.Index))
+		if err != nil {
+			return err
+		}
+		if err := writeSAC(f, r, i, opts); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// writeSEG2 writes the recording as a SEG2 file of 32 bit integer traces with
+// the descaling factor of every channel so readers can recover millivolts
+func writeSEG2(dst io.Writer, r *recording, opts exportOptions) error {
+	interval, err := r.interval(opts)
+	if err != nil {
+		// sample interval is optional in SEG2
+		interval = 0
+	}
+
+	info := make([]string, len(r.samples))
+	data := make([][]byte, len(r.samples))
+	for i, ch := range r.header.Channels {
+		scale, _, _ := ch.Model().Factor(sensor.Millivolts)
+		keywords := []string{
+			fmt.Sprintf("CHANNEL_NUMBER %d", ch.Index+1),
+			fmt.Sprintf("DESCALING_FACTOR %g", scale),
+		}
+		if interval > 0 {
+			keywords = append(keywords, fmt.Sprintf("SAMPLE_INTERVAL %g", interval))
+		}
+		if rc := ch.Receiver; rc != nil && rc.Position != nil {
+			keywords = append(keywords,
+				fmt.Sprintf("RECEIVER_STATION_NUMBER %d", rc.Station),
+				fmt.Sprintf("RECEIVER_LOCATION %g %g %g", rc.Position.X, rc.Position.Y, rc.Position.Elevation),
+			)
+		}
+		if shot := r.header.Shot; shot != nil {
+			keywords = append(keywords,
+				fmt.Sprintf("SHOT_SEQUENCE_NUMBER %d", shot.Number),
+				fmt.Sprintf("SOURCE_LOCATION %g %g %g", shot.Source.X, shot.Source.Y, shot.Source.Elevation),
+			)
+			if shot.Station != 0 {
+				keywords = append(keywords, fmt.Sprintf("SOURCE_STATION_NUMBER %d", shot.Station))
+			}
+		}
+		info[i] = seg2.Strings(keywords...)
+
+		data[i] = make([]byte, len(r.samples[i])*4)
+		for j, v := range r.samples[i] {
+			binary.LittleEndian.PutUint32(data[i][j*4:], uint32(v))
+		}
+	}
+
+	start := r.header.StartTime
+	if start.IsZero() {
+		start = time.Now()
+	}
+	keywords := []string{
+		"ACQUISITION_DATE " + strings.ToUpper(start.Format("02/Jan/2006")),
+		"ACQUISITION_TIME " + start.Format("15:04:05"),
+		"UNITS METERS",
+	}
+	if r.header.Line != "" {
+		keywords = append(keywords, "LINE_ID "+r.header.Line)
+	}
+	if r.header.Operator != "" {
+		keywords = append(keywords, "OBSERVER "+r.header.Operator)
+	}
+	traces := seg2.NewTraceDescriptor(info, data, seg2.Fixed32)
+	w := seg2.NewWriter(start, int16(len(traces)), seg2.Strings(keywords...))
+	return w.Write(dst, traces)
+}
+
+// standardGravity converts g to m/s² for SEG-Y which has no unit for g
+const standardGravity = 9.80665
+
+// writeSEGY writes the recording as a SEG-Y file of 32 bit integer traces. The
+// transduction constant of every trace converts counts to the requested unit,
+// or to volts when exporting counts.
+func writeSEGY(dst io.Writer, r *recording, opts exportOptions) error {
+	interval, err := r.interval(opts)
+	if err != nil {
+		return err
+	}
+
+	traces := make([]segy.Trace, len(r.header.Channels))
+	for i, ch := range r.header.Channels {
+		unit := r.unit(i, opts.unit)
+		if unit == sensor.Counts {
+			unit = sensor.Volts
+		}
+		scale, _, err := ch.Model().Factor(unit)
+		if err != nil {
+			return fmt.Errorf("channel %d: %v", ch.Index+1, err)
+		}
+		h := segy.TraceHeader{
+			Channel:              int32(ch.Index + 1),
+			StartTime:            r.header.StartTime,
+			TransductionConstant: scale,
+		}
+		if shot := r.header.Shot; shot != nil {
+			h.FieldRecord = int32(shot.Number)
+			h.SourcePoint = int32(shot.Station)
+			h.SourceX, h.SourceY, h.SourceElevation = shot.Source.X, shot.Source.Y, shot.Source.Elevation
+		}
+		if rc := ch.Receiver; rc != nil && rc.Position != nil {
+			h.ReceiverX, h.ReceiverY, h.ReceiverElevation = rc.Position.X, rc.Position.Y, rc.Position.Elevation
+			if r.header.Shot != nil {
+				h.Offset = r.header.Shot.Source.Distance(*rc.Position)
+			}
+		}
+		switch unit {
+		case sensor.Volts:
+			h.Unit = segy.Volts
+		case sensor.Millivolts:
+			h.Unit = segy.Millivolts
+		case sensor.MetersPerSecond:
+			h.Unit = segy.MetersPerSecond
+		case sensor.G:
+			h.Unit = segy.MetersPerSecondSquared
+			h.TransductionConstant *= standardGravity
+		}
+		traces[i] = segy.Trace{Header: h, Data: r.samples[i]}
+	}
+
+	h := segy.FileHeader{
+		Text: []string{
+			"QUAKEADC RECORDING",
+			fmt.Sprintf("START TIME %s", r.header.StartTime.UTC().Format(time.RFC3339Nano)),
+			fmt.Sprintf("SAMPLE INTERVAL %g US", interval*1e6),
+			fmt.Sprintf("CHANNELS %d", len(traces)),
+		},
+		SampleInterval: time.Duration(interval * float64(time.Second)),
+	}
+	if r.header.Line != "" {
+		h.Text = append(h.Text, "LINE "+r.header.Line)
+		// the binary header only holds numeric line names
+		if n, err := strconv.Atoi(r.header.Line); err == nil {
+			h.LineNumber = int32(n)
+		}
+	}
+	if r.header.Operator != "" {
+		h.Text = append(h.Text, "OPERATOR "+r.header.Operator)
+	}
+	if shot := r.header.Shot; shot != nil {
+		h.Text = append(h.Text, fmt.Sprintf("SHOT %d", shot.Number))
+	}
+	return segy.Write(dst, h, traces)
+}
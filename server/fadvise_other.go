@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import "os"
+
+// dropCache is only implemented on linux
+func dropCache(f *os.File) error {
+	return nil
+}
@@ -1,21 +1,25 @@
 package server
 
 import (
+	"fmt"
 	"net/http"
 	"os"
 	"path"
-	"path/filepath"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/afero"
 )
 
 func (s *Server) TreeHandler(c *gin.Context) {
-	list, err := afero.ReadDir(s.dataFS, "/"+c.Param("dir"))
+	dir, err := existingPath(s.dataFS, c.Param("dir"), true)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid path parameter in url",
-		})
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	list, err := afero.ReadDir(s.dataFS, dir)
+	if err != nil {
+		abortWithError(c, newError(http.StatusBadRequest, "invalid path parameter in url"))
 		return
 	}
 	type item struct {
@@ -24,65 +28,113 @@ func (s *Server) TreeHandler(c *gin.Context) {
 	}
 	fd := make([]item, 0)
 	for _, info := range list {
+		if isHidden(info.Name()) {
+			continue
+		}
 		fd = append(fd, item{Name: info.Name(), Dir: info.IsDir()})
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"directory": path.Clean("/" + c.Param("dir")),
+		"directory": dir,
 		"items":     fd,
 	})
 }
 
+// TreeDeleteHandler moves a file or directory to the trash. It is deleted
+// immediately when the permanent query parameter is true.
 func (s *Server) TreeDeleteHandler(c *gin.Context) {
-	if err := s.dataFS.RemoveAll(c.Param("path")); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+	p, err := s.modifiablePath(c.Param("path"))
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{})
+	if strings.ToLower(c.Query("permanent")) == "true" {
+		if err := s.dataFS.RemoveAll(p); err != nil {
+			abortWithStatus(c, http.StatusBadRequest, err)
+			return
+		}
+		if _, err := s.index.remove(p); err != nil {
+			s.l.Errorf("failed to update recording index: %v", err)
+		}
+		c.JSON(http.StatusOK, gin.H{})
+		return
+	}
+	item, err := s.moveToTrash(p)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	s.purgeTrash()
+	c.JSON(http.StatusOK, gin.H{
+		"trash": item,
+	})
 }
 
 // TreePatchHandler renames a file or directory with the NewName
 func (s *Server) TreePatchHandler(c *gin.Context) {
-	patchReq := struct {
-		NewName string `json:"newName"`
-	}{}
-	if err := c.BindJSON(&patchReq); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+	patchReq := RenameRequest{}
+	if !bindJSON(c, &patchReq) {
 		return
 	}
-	p := c.Param("path")
-	if err := s.dataFS.Rename(p, path.Join(path.Dir(p), patchReq.NewName)); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+	p, err := s.modifiablePath(c.Param("path"))
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := validName(patchReq.NewName); err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
+	newPath := path.Join(path.Dir(p), patchReq.NewName)
+	if exists, _ := afero.Exists(s.dataFS, newPath); exists {
+		abortWithError(c, newError(http.StatusConflict, "a file with the new name already exists"))
+		return
+	}
+	if err := s.dataFS.Rename(p, newPath); err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.index.rename(p, newPath); err != nil {
+		s.l.Errorf("failed to update recording index: %v", err)
+	}
+	if s.activePath != "/" && under(s.activePath, p) {
+		s.activePath = newPath + strings.TrimPrefix(s.activePath, p)
+		s.activeFS = afero.NewBasePathFs(s.dataFS, s.activePath)
+	}
 	c.JSON(http.StatusOK, gin.H{})
 }
 
+// GetFileHandler sends the last recording made
 func (s *Server) GetFileHandler(c *gin.Context) {
-	c.FileAttachment(path.Base(s.dataFile.Name()), s.dataFile.Name())
+	if s.dataFile == nil {
+		abortWithError(c, newError(http.StatusNotFound, "no recording was made"))
+		return
+	}
+	name := path.Join("/", s.dataFile.Name())
+	c.Writer.Header().Set("content-disposition", fmt.Sprintf("attachment; filename=\"%s\"", path.Base(name)))
+	c.FileFromFS(name, afero.NewHttpFs(s.dataFS))
 }
 
 func (s *Server) CreateNewProject(c *gin.Context) {
-	data := struct {
-		Name string `json:"name" bind:"required"`
-	}{}
-	if err := c.ShouldBindJSON(&data); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+	data := ProjectRequest{}
+	if !bindJSON(c, &data) {
+		return
+	}
+	p, err := cleanPath(data.Name)
+	if err == nil && p == "/" {
+		err = &pathError{Path: data.Name, Reason: pathRoot}
+	}
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	if exists, _ := afero.Exists(s.dataFS, p); exists {
+		abortWithError(c, newError(http.StatusConflict, "project already exists"))
 		return
 	}
 
-	err := s.dataFS.Mkdir(data.Name, os.ModeDir|0755)
+	err = s.dataFS.Mkdir(p, os.ModeDir|0755)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{})
@@ -95,26 +147,23 @@ func (s *Server) GetActiveProjectPath(c *gin.Context) {
 }
 
 func (s *Server) SetActiveProjectPath(c *gin.Context) {
-	data := struct {
-		Path string `json:"path"`
-	}{}
-	if err := c.ShouldBindJSON(&data); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+	data := PathRequest{}
+	if !bindJSON(c, &data) {
 		return
 	}
-	p, err := s.dataFS.(*afero.BasePathFs).RealPath(data.Path)
+	p, err := existingPath(s.dataFS, data.Path, true)
 	if err != nil {
 		s.l.Debugf("invalid path result: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	if s.recording() {
+		abortWithStatus(c, http.StatusBadRequest, &pathError{Path: s.activePath, Reason: pathInUse})
 		return
 	}
 
-	s.activePath = filepath.Clean(data.Path)
-	s.activeFS = afero.NewBasePathFs(afero.NewOsFs(), p)
+	s.activePath = p
+	s.activeFS = afero.NewBasePathFs(s.dataFS, p)
 
 	c.JSON(http.StatusOK, gin.H{})
 }
@@ -0,0 +1,12 @@
+package server
+
+import "syscall"
+
+// freeSpace returns the number of bytes available to unprivileged users on the file system holding dir
+func freeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import "errors"
+
+// freeSpace is only implemented on linux
+func freeSpace(dir string) (uint64, error) {
+	return 0, errors.New("free space is not available on this platform")
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Work which holds the hardware
+const (
+	workRecording   = "a recording"
+	workCalibration = "a calibration"
+	workSelfTest    = "a self test"
+	workReset       = "an ADC reset"
+	workCommand     = "an ADC command"
+	workRecovery    = "a recovery"
+	workHealth      = "a health probe"
+	workTelemetry   = "a telemetry measurement"
+)
+
+// backgroundWork is short work of the pollers which the shutdown does not wait for
+var backgroundWork = map[string]bool{
+	workHealth:    true,
+	workTelemetry: true,
+}
+
+// hardwareLock gives the ADCs, the XMega and the logic analyzers to one
+// piece of work at a time. Work which finds them held by other work is
+// refused instead of waiting, except that work of the users waits for
+// background work, which stops at its next step. Background work skips its
+// turn when the hardware is held or wanted.
+type hardwareLock struct {
+	mu   sync.Mutex
+	work string
+
+	// waiting is the number of users waiting for background work. released
+	// is closed when the work holding the hardware changes.
+	waiting  int
+	released chan struct{}
+}
+
+// errPreempted stops background work which users wait for
+var errPreempted = errors.New("stopped for other work")
+
+// tryAcquire holds the hardware for work. It returns false when other work holds it.
+func (h *hardwareLock) tryAcquire(work string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if backgroundWork[work] {
+		if h.work != "" || h.waiting > 0 {
+			return false
+		}
+		h.work = work
+		return true
+	}
+	for backgroundWork[h.work] {
+		if h.released == nil {
+			h.released = make(chan struct{})
+		}
+		released := h.released
+		h.waiting++
+		h.mu.Unlock()
+		<-released
+		h.mu.Lock()
+		h.waiting--
+	}
+	if h.work != "" {
+		return false
+	}
+	h.work = work
+	return true
+}
+
+// preempted reports whether users wait for the background work holding the hardware
+func (h *hardwareLock) preempted() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.waiting > 0
+}
+
+// notify wakes the users waiting for background work. h.mu is held.
+func (h *hardwareLock) notify() {
+	if h.released != nil {
+		close(h.released)
+		h.released = nil
+	}
+}
+
+// change names the work the hardware is held for without releasing it
+func (h *hardwareLock) change(work string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.work = work
+	h.notify()
+}
+
+func (h *hardwareLock) release() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.work = ""
+	h.notify()
+}
+
+// current is the work holding the hardware or "" when it is free
+func (h *hardwareLock) current() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.work
+}
+
+func (s *Server) recording() bool {
+	return s.hw.current() == workRecording
+}
+
+// errHardwareBusy is the response to work refused because the hardware is in use
+func errHardwareBusy(work string) error {
+	if work == "" {
+		// released since the failed acquire
+		work = "other work"
+	}
+	return newError(http.StatusServiceUnavailable, "%s is running", work)
+}
+
+// acquireHardware holds the hardware for work or responds with 503 and
+// returns false. The caller releases it with s.hw.release.
+func (s *Server) acquireHardware(c *gin.Context, work string) bool {
+	if !s.hw.tryAcquire(work) {
+		abortWithError(c, errHardwareBusy(s.hw.current()))
+		return false
+	}
+	return true
+}
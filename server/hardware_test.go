@@ -0,0 +1,119 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/afero"
+)
+
+func TestHardwareLock(t *testing.T) {
+	s := NewServer(afero.NewMemMapFs(), afero.NewMemMapFs(), &fakeStorage{}, nil, false)
+	x := &fakeXMega{}
+	s.xmega = x
+	if err := s.SetHealth(HealthConfig{Interval: time.Minute, Failures: 1, Backoff: time.Minute, MaxBackoff: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	if !s.hw.tryAcquire(workRecording) {
+		t.Fatal("free hardware was not acquired")
+	}
+	if s.hw.tryAcquire(workCalibration) {
+		t.Fatal("held hardware was acquired twice")
+	}
+
+	// the pollers skip held hardware
+	s.checkHealth()
+	stop := make(chan struct{})
+	close(stop)
+	s.watchTelemetry(stop)
+	if len(x.commands) != 0 {
+		t.Errorf("commands while recording = %v, want none", x.commands)
+	}
+	for _, r := range []*http.Request{
+		httptest.NewRequest("GET", "/telemetry", nil),
+		httptest.NewRequest("POST", "/gains", strings.NewReader(`[1,1,1,1,1,1,1,1,1,1,1,1,1,1,1,1,1,1,1,1,1,1,1,1]`)),
+		httptest.NewRequest("POST", "/command/RevisionID/1", strings.NewReader(`{}`)),
+	} {
+		w := httptest.NewRecorder()
+		s.api.ServeHTTP(w, r)
+		if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "a recording is running") {
+			t.Errorf("%s %s = %d %s, want 503", r.Method, r.URL.Path, w.Code, w.Body.String())
+		}
+	}
+
+	// the shutdown waits for work but not for the pollers
+	if got := s.busy(); got != "a recording is running" {
+		t.Errorf("busy = %q while recording", got)
+	}
+	s.hw.release()
+	s.hw.tryAcquire(workTelemetry)
+	if got := s.busy(); got != "" {
+		t.Errorf("busy = %q while measuring telemetry, want not busy", got)
+	}
+	s.hw.release()
+
+	s.checkHealth()
+	if len(x.commands) == 0 || s.hw.current() != "" {
+		t.Errorf("health probe sent %v and left %q holding the hardware", x.commands, s.hw.current())
+	}
+}
+
+func TestRecordingDuringTelemetry(t *testing.T) {
+	s := NewServer(afero.NewMemMapFs(), afero.NewMemMapFs(), &fakeStorage{}, nil, false)
+	polling, resume := make(chan struct{}), make(chan struct{})
+	x := &fakeXMega{rail: func(rail int) {
+		if rail == 0 {
+			close(polling)
+			<-resume
+		}
+	}}
+	s.xmega = x
+	stop := make(chan struct{})
+	close(stop)
+	done := make(chan struct{})
+	go func() {
+		s.watchTelemetry(stop)
+		close(done)
+	}()
+	<-polling
+
+	// the recording waits for the rail being read and the poll stops
+	started := make(chan bool)
+	go func() {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		started <- s.acquireHardware(c, workRecording)
+	}()
+	for !s.hw.preempted() {
+		time.Sleep(time.Millisecond)
+	}
+	close(resume)
+	if !<-started {
+		t.Fatalf("recording during a telemetry poll was refused")
+	}
+	<-done
+	if s.hw.current() != workRecording {
+		t.Errorf("hardware held by %q, want the recording", s.hw.current())
+	}
+	if got := len(x.commands); got != 1 {
+		t.Errorf("read %d rails, want the poll stopped after the first", got)
+	}
+	if _, ok := s.telemetry.latest(); ok {
+		t.Errorf("stopped poll stored a sample")
+	}
+
+	// the pollers skip their turn while users wait
+	s.hw.release()
+	s.hw.tryAcquire(workHealth)
+	go s.hw.tryAcquire(workCalibration)
+	for !s.hw.preempted() {
+		time.Sleep(time.Millisecond)
+	}
+	if s.hw.tryAcquire(workTelemetry) {
+		t.Errorf("poll acquired the hardware wanted by a user")
+	}
+	s.hw.release()
+}
@@ -0,0 +1,409 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MShoaei/quakeADC/driver"
+)
+
+// xmegaBootDelay is the wait after a reset of the board controller before it answers
+const xmegaBootDelay = 2 * time.Second
+
+// Parts of the acquisition chain probed by the health monitor
+const (
+	componentXMega = "xmega"
+	componentADC   = "adc"
+	componentLogic = "logic"
+
+	// componentInit fails from a failed hardware init until an init succeeds
+	componentInit = "init"
+)
+
+// stepInit is the recovery step running the hardware init
+const stepInit = "hardware init"
+
+// States of the acquisition chain
+const (
+	healthOK         = "ok"
+	healthFailing    = "failing"
+	healthRecovering = "recovering"
+)
+
+// HealthConfig configures the health monitor of the acquisition chain
+type HealthConfig struct {
+	// Interval between probes of the board controller, the ADCs and the
+	// logic analyzer. 0 disables the monitor.
+	Interval time.Duration `json:"interval" mapstructure:"interval"`
+
+	// Failures is the number of failed probes in a row which start a recovery
+	Failures int `json:"failures" mapstructure:"failures"`
+
+	// Backoff is the wait after a failed recovery. It doubles after every
+	// failed recovery up to MaxBackoff.
+	Backoff    time.Duration `json:"backoff" mapstructure:"backoff"`
+	MaxBackoff time.Duration `json:"maxBackoff" mapstructure:"maxBackoff"`
+}
+
+func DefaultHealthConfig() HealthConfig {
+	return HealthConfig{
+		Interval:   30 * time.Second,
+		Failures:   2,
+		Backoff:    30 * time.Second,
+		MaxBackoff: 10 * time.Minute,
+	}
+}
+
+func (cfg HealthConfig) Validate() error {
+	if cfg.Interval < 0 || (cfg.Interval > 0 && cfg.Interval < time.Second) {
+		return fmt.Errorf("invalid health interval %s. expected 0 or at least 1s", cfg.Interval)
+	}
+	if cfg.Failures < 1 {
+		return fmt.Errorf("invalid health failures %d. expected at least 1", cfg.Failures)
+	}
+	if cfg.Backoff <= 0 || cfg.MaxBackoff < cfg.Backoff {
+		return fmt.Errorf("invalid health backoff %s up to %s", cfg.Backoff, cfg.MaxBackoff)
+	}
+	return nil
+}
+
+// ComponentHealth is the last probe of a part of the acquisition chain
+type ComponentHealth struct {
+	Name    string    `json:"name"`
+	OK      bool      `json:"ok"`
+	Error   string    `json:"error,omitempty"`
+	Checked time.Time `json:"checked"`
+
+	// Failures is the number of failed probes in a row
+	Failures int `json:"failures"`
+}
+
+// RecoveryAttempt is a run of the recovery steps
+type RecoveryAttempt struct {
+	Time  time.Time `json:"time"`
+	Steps []string  `json:"steps"`
+	OK    bool      `json:"ok"`
+	Error string    `json:"error,omitempty"`
+}
+
+// HealthStatus is the state of the acquisition chain
+type HealthStatus struct {
+	State        string            `json:"state"`
+	Components   []ComponentHealth `json:"components"`
+	Recoveries   int               `json:"recoveries"`
+	LastRecovery *RecoveryAttempt  `json:"lastRecovery,omitempty"`
+
+	// NextRecovery is the earliest time of a recovery after a failed one
+	NextRecovery *time.Time `json:"nextRecovery,omitempty"`
+}
+
+// health keeps the probes and recoveries of the acquisition chain
+type health struct {
+	mu         sync.Mutex
+	cfg        HealthConfig
+	components []ComponentHealth
+	recovering bool
+	recoveries int
+	last       *RecoveryAttempt
+	next       time.Time
+	backoff    time.Duration
+	initErr    error
+}
+
+func newHealth(cfg HealthConfig) *health {
+	return &health{cfg: cfg, backoff: cfg.Backoff}
+}
+
+func (h *health) config() HealthConfig {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cfg
+}
+
+func (h *health) setConfig(cfg HealthConfig) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cfg = cfg
+	h.backoff = cfg.Backoff
+}
+
+// setInitError stores the failure of the hardware init. nil clears it.
+func (h *health) setInitError(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.initErr = err
+}
+
+func (h *health) initError() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.initErr
+}
+
+// update stores the probes and returns the components which failed often
+// enough to be recovered
+func (h *health) update(probes []ComponentHealth) (failed map[string]bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	failed = map[string]bool{}
+	for i, p := range probes {
+		for _, c := range h.components {
+			if c.Name == p.Name && !p.OK {
+				probes[i].Failures = c.Failures + 1
+			}
+		}
+		if !p.OK && probes[i].Failures == 0 {
+			probes[i].Failures = 1
+		}
+		if probes[i].Failures >= h.cfg.Failures {
+			failed[p.Name] = true
+		}
+	}
+	h.components = probes
+	return failed
+}
+
+// startRecovery reports whether a recovery may run at now and marks it running
+func (h *health) startRecovery(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.recovering || now.Before(h.next) {
+		return false
+	}
+	h.recovering = true
+	return true
+}
+
+// finishRecovery stores the attempt and schedules the next one after a failure
+func (h *health) finishRecovery(a RecoveryAttempt) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.recovering = false
+	h.recoveries++
+	h.last = &a
+	if a.OK {
+		h.next = time.Time{}
+		h.backoff = h.cfg.Backoff
+		return
+	}
+	h.next = time.Now().Add(h.backoff)
+	h.backoff *= 2
+	if h.backoff > h.cfg.MaxBackoff {
+		h.backoff = h.cfg.MaxBackoff
+	}
+}
+
+func (h *health) status() HealthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := HealthStatus{
+		State:        healthOK,
+		Components:   append([]ComponentHealth{}, h.components...),
+		Recoveries:   h.recoveries,
+		LastRecovery: h.last,
+	}
+	for _, c := range h.components {
+		if !c.OK {
+			st.State = healthFailing
+		}
+	}
+	if h.recovering {
+		st.State = healthRecovering
+	}
+	if !h.next.IsZero() {
+		next := h.next
+		st.NextRecovery = &next
+	}
+	return st
+}
+
+// SetHealth configures the health monitor
+func (s *Server) SetHealth(cfg HealthConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	s.health.setConfig(cfg)
+	return nil
+}
+
+// probeHealth probes every part of the acquisition chain the server uses
+func (s *Server) probeHealth() []ComponentHealth {
+	var res []ComponentHealth
+	probe := func(name string, err error) {
+		c := ComponentHealth{Name: name, OK: err == nil, Checked: time.Now()}
+		if err != nil {
+			c.Error = err.Error()
+		}
+		res = append(res, c)
+	}
+	if err := s.health.initError(); err != nil {
+		probe(componentInit, err)
+	}
+	if s.xmega != nil {
+		_, err := s.xmega.ID()
+		probe(componentXMega, err)
+	}
+	if s.adc != nil {
+		probe(componentADC, s.probeADCs())
+	}
+	if len(s.logics) != 0 {
+		probe(componentLogic, driver.LogicAnalyzerPresent(s.logics[0]))
+	}
+	return res
+}
+
+// probeADCs checks every chip answers with its revision and no chip error
+func (s *Server) probeADCs() error {
+	for cs := uint8(1); cs <= 9; cs++ {
+		rev, err := s.adc.ReadRevision(cs)
+		if err != nil {
+			return fmt.Errorf("chip %d: %v", cs, err)
+		}
+		if rev != driver.ExpectedRevisionID {
+			return fmt.Errorf("chip %d: revision is 0x%02x, expected 0x%02x", cs, rev, driver.ExpectedRevisionID)
+		}
+		status, err := s.adc.ReadStatus(cs)
+		if err != nil {
+			return fmt.Errorf("chip %d: %v", cs, err)
+		}
+		if status&driver.StatusChipError != 0 {
+			return fmt.Errorf("chip %d: chip error", cs)
+		}
+	}
+	return nil
+}
+
+// recoveryStep is a named action of a recovery
+type recoveryStep struct {
+	name string
+	run  func() error
+}
+
+// recoverySteps are the steps recovering the failed components. A board
+// controller which does not answer is reset before the hardware init.
+func (s *Server) recoverySteps(failed map[string]bool) []recoveryStep {
+	switch {
+	case failed[componentXMega]:
+		return []recoveryStep{
+			{name: "reset board controller", run: s.resetXMega},
+			{name: stepInit, run: s.initHardware},
+		}
+	case failed[componentADC], failed[componentInit]:
+		return []recoveryStep{{name: stepInit, run: s.initHardware}}
+	}
+	return []recoveryStep{{name: "enumerate logic analyzers", run: s.enumerateLogic}}
+}
+
+// checkHealth probes the acquisition chain and runs the recovery steps when
+// a part failed often enough and no recent recovery failed. Nothing is
+// probed while other work holds the hardware.
+func (s *Server) checkHealth() HealthStatus {
+	if !s.hw.tryAcquire(workHealth) {
+		return s.health.status()
+	}
+	defer s.hw.release()
+	failed := s.health.update(s.probeHealth())
+	// the recovery waits for the next check when users wait for the hardware
+	if len(failed) == 0 || s.hw.preempted() || !s.health.startRecovery(time.Now()) {
+		return s.health.status()
+	}
+
+	s.hw.change(workRecovery)
+	a := RecoveryAttempt{Time: time.Now()}
+	var err error
+	for _, step := range s.recoverySteps(failed) {
+		s.l.Warnf("health: %s", step.name)
+		a.Steps = append(a.Steps, step.name)
+		if err = step.run(); err != nil {
+			err = fmt.Errorf("%s: %v", step.name, err)
+			break
+		}
+		if step.name == stepInit {
+			s.health.setInitError(nil)
+		}
+	}
+	if err == nil {
+		probes := s.probeHealth()
+		for _, p := range probes {
+			if !p.OK {
+				err = fmt.Errorf("%s still failing: %s", p.Name, p.Error)
+				break
+			}
+		}
+		s.health.update(probes)
+	}
+	a.OK = err == nil
+	data := map[string]interface{}{"steps": a.Steps, "failed": failed}
+	if err != nil {
+		a.Error = err.Error()
+		s.l.Errorf("health: recovery failed: %v", err)
+		data["error"] = a.Error
+		s.event(nil, EventHealth, data, "recovery failed: %v", err)
+	} else {
+		s.l.Infof("health: recovered")
+		s.event(nil, EventHealth, data, "recovered")
+	}
+	s.health.finishRecovery(a)
+	return s.health.status()
+}
+
+// watchHealth probes the acquisition chain every interval while no other
+// work holds the hardware
+func (s *Server) watchHealth(stop <-chan struct{}) {
+	for {
+		interval := s.health.config().Interval
+		if interval == 0 {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+		if !s.shuttingDown() {
+			s.checkHealth()
+		}
+	}
+}
+
+// InitHardware runs the hardware init. A failure does not stop the server:
+// it is logged and the health monitor reports it and runs the init again.
+func (s *Server) InitHardware() {
+	if err := s.HardwareInitSeq(); err != nil {
+		err = fmt.Errorf("hardware init failed: %v", err)
+		s.l.Error(err)
+		s.health.setInitError(err)
+		s.health.update(s.probeHealth())
+		s.event(nil, EventHealth, map[string]interface{}{"error": err.Error()}, "%v", err)
+		return
+	}
+	s.l.Info("hardware init successful")
+}
+
+func (s *Server) resetXMegaBoard() error {
+	if err := driver.Reset(); err != nil {
+		return err
+	}
+	time.Sleep(xmegaBootDelay)
+	return nil
+}
+
+// reinitHardware runs the hardware init again and restores the settings it changes
+func (s *Server) reinitHardware() error {
+	gains := s.hd.Gains
+	if err := s.HardwareInitSeq(); err != nil {
+		return err
+	}
+	s.hd.Gains = gains
+	return s.restoreADCs()
+}
+
+func (s *Server) enumerateLogic() error {
+	list, err := driver.DetectLogicConnString(s.xmega)
+	if err != nil {
+		return err
+	}
+	s.logics = list
+	return nil
+}
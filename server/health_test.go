@@ -0,0 +1,90 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestHealthRecovery(t *testing.T) {
+	s := NewServer(afero.NewMemMapFs(), afero.NewMemMapFs(), &fakeStorage{}, nil, false)
+	x := &fakeXMega{err: errors.New("no answer")}
+	s.xmega = x
+	if err := s.SetHealth(HealthConfig{Interval: time.Minute, Failures: 2, Backoff: time.Hour, MaxBackoff: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	var steps []string
+	s.resetXMega = func() error {
+		steps = append(steps, "reset")
+		return nil
+	}
+	fixed := false
+	s.initHardware = func() error {
+		steps = append(steps, "init")
+		if fixed {
+			x.err = nil
+		}
+		return nil
+	}
+
+	// the first failure does not recover
+	if st := s.checkHealth(); st.State != healthFailing || st.Recoveries != 0 {
+		t.Fatalf("first failure = %+v, want failing without recovery", st)
+	}
+
+	// a recovery which does not fix the board backs off
+	st := s.checkHealth()
+	if st.Recoveries != 1 || st.LastRecovery == nil || st.LastRecovery.OK || st.NextRecovery == nil {
+		t.Fatalf("failed recovery = %+v, want a failed attempt and a next recovery", st)
+	}
+	if !strings.Contains(st.LastRecovery.Error, "xmega still failing") {
+		t.Errorf("recovery error = %q", st.LastRecovery.Error)
+	}
+	if st := s.checkHealth(); st.Recoveries != 1 {
+		t.Errorf("recoveries during backoff = %d, want 1", st.Recoveries)
+	}
+	if got := strings.Join(steps, " "); got != "reset init" {
+		t.Errorf("steps = %q, want reset init", got)
+	}
+
+	s.health.next = time.Time{}
+	fixed = true
+	st = s.checkHealth()
+	if st.State != healthOK || st.Recoveries != 2 || !st.LastRecovery.OK || st.NextRecovery != nil {
+		t.Errorf("recovery = %+v, want ok", st)
+	}
+	if w := s.hw.current(); w != "" {
+		t.Errorf("hardware left held by %s after recovery", w)
+	}
+}
+
+func TestHardwareInitFailure(t *testing.T) {
+	s := NewServer(afero.NewMemMapFs(), afero.NewMemMapFs(), &fakeStorage{}, nil, false)
+	x := &fakeXMega{err: errors.New("no answer")}
+	s.xmega = x
+	if err := s.SetHealth(HealthConfig{Interval: time.Minute, Failures: 2, Backoff: time.Hour, MaxBackoff: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	s.InitHardware()
+	st := s.health.status()
+	if st.State != healthFailing || len(st.Components) == 0 || st.Components[0].Name != componentInit {
+		t.Fatalf("status after a failed init = %+v, want the init failing", st)
+	}
+
+	// the board answers again and the next probe runs the init
+	x.err = nil
+	inits := 0
+	s.initHardware = func() error {
+		inits++
+		return nil
+	}
+	if st := s.checkHealth(); st.State != healthOK || inits != 1 || !st.LastRecovery.OK {
+		t.Errorf("status after the recovery = %+v with %d inits, want ok after 1", st, inits)
+	}
+	if st := s.checkHealth(); st.State != healthOK || inits != 1 {
+		t.Errorf("init ran again once recovered")
+	}
+}
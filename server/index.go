@@ -0,0 +1,648 @@
+package server
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MShoaei/quakeADC/record"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/afero"
+)
+
+// indexName is the file at the root of the data file system holding the notes and tags of recordings
+const indexName = ".index.json"
+
+// recordingInfo is the metadata of a single recording in the index
+type recordingInfo struct {
+	Path       string    `json:"path"`
+	Project    string    `json:"project"`
+	Size       int64     `json:"size"`
+	Version    uint16    `json:"version"`
+	StartTime  time.Time `json:"startTime"`
+	SampleRate float64   `json:"sampleRate"`
+	Frames     int64     `json:"frames"`
+
+	// Duration in seconds. 0 when the sample rate is unknown
+	Duration float64 `json:"duration"`
+
+	// Channels holds the one based numbers of the recorded channels
+	Channels []int  `json:"channels"`
+	Mode     string `json:"mode"`
+	Line     string `json:"line"`
+	Shot     int    `json:"shot"`
+
+	// Peak is the largest absolute sample in counts and PeakChannel the channel it was recorded on
+	Peak        int64 `json:"peak"`
+	PeakChannel int   `json:"peakChannel"`
+
+	Notes string   `json:"notes"`
+	Tags  []string `json:"tags"`
+
+	// Error is set for files which are not readable recordings
+	Error string `json:"error,omitempty"`
+}
+
+// annotation is the part of recordingInfo entered by users
+type annotation struct {
+	Notes string   `json:"notes,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+// recordingIndex holds the metadata of every recording on the data file system.
+// Metadata is read from the recordings while notes and tags are stored in indexName.
+type recordingIndex struct {
+	fs afero.Fs
+
+	mu          sync.RWMutex
+	recordings  map[string]*recordingInfo
+	annotations map[string]annotation
+	building    bool
+}
+
+func newRecordingIndex(fs afero.Fs) *recordingIndex {
+	return &recordingIndex{
+		fs:          fs,
+		recordings:  make(map[string]*recordingInfo),
+		annotations: make(map[string]annotation),
+	}
+}
+
+// scanRecording reads the metadata of the recording at p
+func scanRecording(fs afero.Fs, p string) recordingInfo {
+	info := recordingInfo{
+		Path:     p,
+		Project:  path.Dir(p),
+		Channels: make([]int, 0),
+		Tags:     make([]string, 0),
+	}
+	f, err := fs.Open(p)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	defer f.Close()
+	if st, err := f.Stat(); err == nil {
+		info.Size = st.Size()
+	}
+
+	r, err := record.NewReader(f)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	h := r.Header
+	info.Version = h.Version
+	info.StartTime = h.StartTime
+	info.SampleRate = h.SampleRate
+	info.Mode = h.Mode
+	info.Line = h.Line
+	if h.Shot != nil {
+		info.Shot = h.Shot.Number
+	}
+	for _, ch := range h.Channels {
+		info.Channels = append(info.Channels, ch.Index+1)
+	}
+
+	frame := make([]byte, h.FrameSize())
+	for {
+		err := r.ReadFrameBytes(frame)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			info.Error = err.Error()
+			break
+		}
+		info.Frames++
+		for i, ch := range h.Channels {
+			v := int64(int32(binary.LittleEndian.Uint32(frame[i*4:])))
+			if v < 0 {
+				v = -v
+			}
+			if v > info.Peak {
+				info.Peak = v
+				info.PeakChannel = ch.Index + 1
+			}
+		}
+	}
+	if h.SampleRate > 0 {
+		info.Duration = float64(info.Frames) / h.SampleRate
+	}
+	return info
+}
+
+func (x *recordingIndex) loadAnnotations() error {
+	b, err := afero.ReadFile(x.fs, "/"+indexName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	annotations := make(map[string]annotation)
+	if err := json.Unmarshal(b, &annotations); err != nil {
+		return fmt.Errorf("invalid recording index: %v", err)
+	}
+	x.mu.Lock()
+	x.annotations = annotations
+	x.mu.Unlock()
+	return nil
+}
+
+// saveAnnotations must be called with mu held
+func (x *recordingIndex) saveAnnotations() error {
+	b, err := json.MarshalIndent(x.annotations, "", "  ")
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(x.fs, "/"+indexName, b, 0644)
+}
+
+// rebuild scans every recording on the data file system
+func (x *recordingIndex) rebuild() error {
+	x.mu.Lock()
+	x.building = true
+	x.mu.Unlock()
+	defer func() {
+		x.mu.Lock()
+		x.building = false
+		x.mu.Unlock()
+	}()
+
+	if err := x.loadAnnotations(); err != nil {
+		return err
+	}
+	recordings := make(map[string]*recordingInfo)
+	err := afero.Walk(x.fs, "/", func(p string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if isHidden(p) {
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if f.Mode().IsRegular() {
+			info := scanRecording(x.fs, p)
+			recordings[p] = &info
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.recordings = recordings
+	for _, info := range x.recordings {
+		x.annotate(info)
+	}
+	return nil
+}
+
+// annotate copies the notes and tags of info from the annotations. must be called with mu held
+func (x *recordingIndex) annotate(info *recordingInfo) {
+	a := x.annotations[info.Path]
+	info.Notes = a.Notes
+	info.Tags = make([]string, len(a.Tags))
+	copy(info.Tags, a.Tags)
+}
+
+// update scans the recording at p after it was written
+func (x *recordingIndex) update(p string) {
+	p = path.Join("/", p)
+	info := scanRecording(x.fs, p)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.annotate(&info)
+	x.recordings[p] = &info
+}
+
+// under reports whether p is dir or inside it
+func under(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// remove drops p and every recording inside it from the index and returns
+// their notes and tags so they can be restored along with the recordings
+func (x *recordingIndex) remove(p string) (map[string]annotation, error) {
+	p = path.Join("/", p)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for k := range x.recordings {
+		if under(k, p) {
+			delete(x.recordings, k)
+		}
+	}
+	removed := make(map[string]annotation)
+	for k, a := range x.annotations {
+		if under(k, p) {
+			delete(x.annotations, k)
+			removed[k] = a
+		}
+	}
+	if len(removed) == 0 {
+		return removed, nil
+	}
+	return removed, x.saveAnnotations()
+}
+
+// addTree scans every recording in p and restores their notes and tags from annotations
+func (x *recordingIndex) addTree(p string, annotations map[string]annotation) error {
+	p = path.Join("/", p)
+	if len(annotations) > 0 {
+		x.mu.Lock()
+		for k, a := range annotations {
+			x.annotations[k] = a
+		}
+		err := x.saveAnnotations()
+		x.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return afero.Walk(x.fs, p, func(name string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if isHidden(name) {
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if f.Mode().IsRegular() {
+			x.update(name)
+		}
+		return nil
+	})
+}
+
+// rename moves the recordings in oldPath to newPath keeping their notes and tags
+func (x *recordingIndex) rename(oldPath, newPath string) error {
+	oldPath, newPath = path.Join("/", oldPath), path.Join("/", newPath)
+	move := func(k string) string {
+		return newPath + strings.TrimPrefix(k, oldPath)
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var moved []*recordingInfo
+	for k, info := range x.recordings {
+		if under(k, oldPath) {
+			delete(x.recordings, k)
+			moved = append(moved, info)
+		}
+	}
+	for _, info := range moved {
+		info.Path = move(info.Path)
+		info.Project = path.Dir(info.Path)
+		x.recordings[info.Path] = info
+	}
+	annotations := make(map[string]annotation)
+	for k, a := range x.annotations {
+		if under(k, oldPath) {
+			delete(x.annotations, k)
+			annotations[move(k)] = a
+		}
+	}
+	for k, a := range annotations {
+		x.annotations[k] = a
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+	return x.saveAnnotations()
+}
+
+// setAnnotation replaces the notes and tags of the recording at p. nil values are left unchanged.
+func (x *recordingIndex) setAnnotation(p string, notes *string, tags []string) (recordingInfo, error) {
+	p = path.Join("/", p)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	info, ok := x.recordings[p]
+	if !ok {
+		return recordingInfo{}, fmt.Errorf("recording %s not found", p)
+	}
+	a := x.annotations[p]
+	if notes != nil {
+		a.Notes = *notes
+	}
+	if tags != nil {
+		a.Tags = tags
+	}
+	if a.Notes == "" && len(a.Tags) == 0 {
+		delete(x.annotations, p)
+	} else {
+		x.annotations[p] = a
+	}
+	if err := x.saveAnnotations(); err != nil {
+		return recordingInfo{}, err
+	}
+	x.annotate(info)
+	return *info, nil
+}
+
+// field returns the value of the field with the given JSON name.
+// values are float64, string, time.Time or a slice of them.
+func (r recordingInfo) field(name string) (interface{}, bool) {
+	switch name {
+	case "path":
+		return r.Path, true
+	case "project":
+		return r.Project, true
+	case "size":
+		return float64(r.Size), true
+	case "version":
+		return float64(r.Version), true
+	case "startTime":
+		return r.StartTime, true
+	case "sampleRate":
+		return r.SampleRate, true
+	case "frames":
+		return float64(r.Frames), true
+	case "duration":
+		return r.Duration, true
+	case "channels":
+		res := make([]interface{}, len(r.Channels))
+		for i, ch := range r.Channels {
+			res[i] = float64(ch)
+		}
+		return res, true
+	case "mode":
+		return r.Mode, true
+	case "line":
+		return r.Line, true
+	case "shot":
+		return float64(r.Shot), true
+	case "peak":
+		return float64(r.Peak), true
+	case "peakChannel":
+		return float64(r.PeakChannel), true
+	case "notes":
+		return r.Notes, true
+	case "tags":
+		res := make([]interface{}, len(r.Tags))
+		for i, tag := range r.Tags {
+			res[i] = tag
+		}
+		return res, true
+	case "error":
+		return r.Error, true
+	}
+	return nil, false
+}
+
+// parseLike parses s to the type of v
+func parseLike(v interface{}, s string) (interface{}, error) {
+	switch v.(type) {
+	case float64:
+		return strconv.ParseFloat(s, 64)
+	case time.Time:
+		return time.Parse(time.RFC3339, s)
+	}
+	return s, nil
+}
+
+// compareValues returns -1, 0 or 1 comparing two values of the same type
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case float64:
+		b := b.(float64)
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+	case string:
+		return strings.Compare(a, b.(string))
+	case time.Time:
+		b := b.(time.Time)
+		if a.Before(b) {
+			return -1
+		} else if a.After(b) {
+			return 1
+		}
+	}
+	return 0
+}
+
+// recordingFilter matches a single field against a value. op is one of = min max
+type recordingFilter struct {
+	field string
+	op    string
+	value string
+}
+
+func (f recordingFilter) match(r recordingInfo) (bool, error) {
+	v, ok := r.field(f.field)
+	if !ok {
+		return false, fmt.Errorf("unknown field %q", f.field)
+	}
+	values, isList := v.([]interface{})
+	if !isList {
+		values = []interface{}{v}
+	}
+	for _, v := range values {
+		want, err := parseLike(v, f.value)
+		if err != nil {
+			return false, fmt.Errorf("invalid value %q for %s: %v", f.value, f.field, err)
+		}
+		cmp := compareValues(v, want)
+		switch {
+		case f.op == "=" && cmp == 0,
+			f.op == "min" && cmp >= 0,
+			f.op == "max" && cmp <= 0:
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// filtersFromQuery reads field=value, field.min=value and field.max=value filters
+func filtersFromQuery(c *gin.Context) []recordingFilter {
+	var filters []recordingFilter
+	for key, values := range c.Request.URL.Query() {
+		switch key {
+		case "sort", "order", "limit", "offset", "token":
+			continue
+		}
+		op := "="
+		field := key
+		if strings.HasSuffix(key, ".min") {
+			op, field = "min", strings.TrimSuffix(key, ".min")
+		} else if strings.HasSuffix(key, ".max") {
+			op, field = "max", strings.TrimSuffix(key, ".max")
+		}
+		for _, v := range values {
+			filters = append(filters, recordingFilter{field: field, op: op, value: v})
+		}
+	}
+	return filters
+}
+
+// list returns the recordings matching every filter sorted by the given field
+func (x *recordingIndex) list(filters []recordingFilter, sortBy string, desc bool) ([]recordingInfo, error) {
+	x.mu.RLock()
+	res := make([]recordingInfo, 0, len(x.recordings))
+	for _, info := range x.recordings {
+		res = append(res, *info)
+	}
+	x.mu.RUnlock()
+
+	matched := res[:0]
+	for _, info := range res {
+		ok := true
+		for _, f := range filters {
+			m, err := f.match(info)
+			if err != nil {
+				return nil, err
+			}
+			if !m {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matched = append(matched, info)
+		}
+	}
+
+	if v, ok := (recordingInfo{}).field(sortBy); !ok {
+		return nil, fmt.Errorf("unknown field %q", sortBy)
+	} else if _, isList := v.([]interface{}); isList {
+		return nil, fmt.Errorf("can not sort by %s", sortBy)
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, _ := matched[i].field(sortBy)
+		b, _ := matched[j].field(sortBy)
+		cmp := compareValues(a, b)
+		if cmp == 0 {
+			return matched[i].Path < matched[j].Path
+		}
+		return (cmp < 0) != desc
+	})
+	return matched, nil
+}
+
+// projectUsage is the storage used by the recordings of a project
+type projectUsage struct {
+	Project    string `json:"project"`
+	Recordings int    `json:"recordings"`
+	Size       int64  `json:"size"`
+}
+
+// usage sums the size of the recordings of every project sorted by project
+func (x *recordingIndex) usage() []projectUsage {
+	x.mu.RLock()
+	projects := make(map[string]*projectUsage)
+	for _, info := range x.recordings {
+		u, ok := projects[info.Project]
+		if !ok {
+			u = &projectUsage{Project: info.Project}
+			projects[info.Project] = u
+		}
+		u.Recordings++
+		u.Size += info.Size
+	}
+	x.mu.RUnlock()
+
+	res := make([]projectUsage, 0, len(projects))
+	for _, u := range projects {
+		res = append(res, *u)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Project < res[j].Project
+	})
+	return res
+}
+
+// ListRecordingsHandler lists the recordings matching the query filters.
+// Every field can be filtered with field=value, field.min=value and field.max=value.
+// The result is sorted by the sort field in the given order and paged with limit and offset.
+func (s *Server) ListRecordingsHandler(c *gin.Context) {
+	desc := false
+	switch strings.ToLower(c.DefaultQuery("order", "asc")) {
+	case "asc":
+	case "desc":
+		desc = true
+	default:
+		abortWithError(c, newError(http.StatusBadRequest, "invalid order. expected asc or desc"))
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		abortWithError(c, newError(http.StatusBadRequest, "invalid offset"))
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil || limit < 0 {
+		abortWithError(c, newError(http.StatusBadRequest, "invalid limit"))
+		return
+	}
+
+	list, err := s.index.list(filtersFromQuery(c), c.DefaultQuery("sort", "startTime"), desc)
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	total := len(list)
+	if offset > len(list) {
+		offset = len(list)
+	}
+	list = list[offset:]
+	if limit > 0 && limit < len(list) {
+		list = list[:limit]
+	}
+
+	s.index.mu.RLock()
+	building := s.index.building
+	s.index.mu.RUnlock()
+	c.JSON(http.StatusOK, gin.H{
+		"recordings": list,
+		"total":      total,
+		"indexing":   building,
+	})
+}
+
+// AnnotateRecordingHandler sets the notes and tags of a recording
+func (s *Server) AnnotateRecordingHandler(c *gin.Context) {
+	data := AnnotateRequest{}
+	if !bindJSON(c, &data) {
+		return
+	}
+	p, err := cleanPath(data.Path)
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	info, err := s.index.setAnnotation(p, data.Notes, data.Tags)
+	if err != nil {
+		abortWithStatus(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"recording": info,
+	})
+}
+
+// ReindexHandler rescans every recording on the data file system
+func (s *Server) ReindexHandler(c *gin.Context) {
+	if err := s.index.rebuild(); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
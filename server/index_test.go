@@ -0,0 +1,107 @@
+package server
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MShoaei/quakeADC/record"
+	"github.com/MShoaei/quakeADC/survey"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/afero"
+)
+
+func writeTestRecording(t *testing.T, fs afero.Fs, name string, h record.Header, frames [][]int32) {
+	t.Helper()
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := record.NewWriter(f, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, frame := range frames {
+		b := make([]byte, 0, len(frame)*4)
+		for _, v := range frame {
+			b = append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+		}
+		if _, err := w.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordingIndex(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	start := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	channels := []record.Channel{{Index: 0, Gain: 1}, {Index: 4, Gain: 1}}
+	writeTestRecording(t, fs, "/p1/shot1", record.Header{Mode: "hammer", SampleRate: 1000, StartTime: start, Channels: channels, Shot: &survey.Shot{Number: 1}},
+		[][]int32{{1, -2}, {3, -40}})
+	writeTestRecording(t, fs, "/p1/shot2", record.Header{Mode: "asap", SampleRate: 1000, StartTime: start.Add(time.Hour), Channels: channels[:1]},
+		[][]int32{{7}, {-8}, {9}, {10}})
+	_ = afero.WriteFile(fs, "/p1/.project.json", []byte("{}"), 0644)
+
+	x := newRecordingIndex(fs)
+	if err := x.rebuild(); err != nil {
+		t.Fatalf("rebuild() error = %v", err)
+	}
+	if _, err := x.setAnnotation("p1/shot1", nil, []string{"good"}); err != nil {
+		t.Fatalf("setAnnotation() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		filters []recordingFilter
+		sort    string
+		desc    bool
+		want    []string
+		wantErr bool
+	}{
+		{name: "all by start time", sort: "startTime", want: []string{"/p1/shot1", "/p1/shot2"}},
+		{name: "desc", sort: "startTime", desc: true, want: []string{"/p1/shot2", "/p1/shot1"}},
+		{name: "peak", sort: "peak", filters: []recordingFilter{{field: "peak", op: "min", value: "20"}}, want: []string{"/p1/shot1"}},
+		{name: "channel", sort: "path", filters: []recordingFilter{{field: "channels", op: "=", value: "5"}}, want: []string{"/p1/shot1"}},
+		{name: "tag", sort: "path", filters: []recordingFilter{{field: "tags", op: "=", value: "good"}}, want: []string{"/p1/shot1"}},
+		{name: "duration", sort: "path", filters: []recordingFilter{{field: "duration", op: "max", value: "0.002"}}, want: []string{"/p1/shot1"}},
+		{name: "unknown field", sort: "path", filters: []recordingFilter{{field: "foo", op: "=", value: "1"}}, wantErr: true},
+		{name: "invalid sort", sort: "tags", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := x.list(tt.filters, tt.sort, tt.desc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("list() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("list() returned %d recordings, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i].Path != tt.want[i] {
+					t.Errorf("list()[%d] = %s, want %s", i, got[i].Path, tt.want[i])
+				}
+			}
+		})
+	}
+
+	if err := x.rename("/p1", "/p2"); err != nil {
+		t.Fatalf("rename() error = %v", err)
+	}
+	got, _ := x.list([]recordingFilter{{field: "tags", op: "=", value: "good"}}, "path", false)
+	if len(got) != 1 || got[0].Path != "/p2/shot1" || got[0].Peak != 40 || got[0].PeakChannel != 5 {
+		t.Errorf("after rename got %+v", got)
+	}
+}
+
+func TestFiltersFromQuery(t *testing.T) {
+	c := &gin.Context{Request: httptest.NewRequest("GET", "/recordings?peak.min=20&sort=path&limit=5&token=secret", nil)}
+	want := []recordingFilter{{field: "peak", op: "min", value: "20"}}
+	if got := filtersFromQuery(c); !reflect.DeepEqual(got, want) {
+		t.Errorf("filtersFromQuery() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MShoaei/quakeADC/driver"
+	"github.com/MShoaei/quakeADC/record"
+	"github.com/gin-gonic/gin"
+)
+
+// metricsContentType is the version 0.0.4 Prometheus text format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// latencyBuckets are the upper bounds in seconds of the request latency histogram
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// requestKey identifies the requests of a latency histogram
+type requestKey struct {
+	method string
+	route  string
+	code   string
+}
+
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, le := range latencyBuckets {
+		if v <= le {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// metrics counts the recordings and the API requests
+type metrics struct {
+	mu         sync.Mutex
+	recordings map[string]uint64
+	triggers   uint64
+	requests   map[requestKey]*histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		recordings: map[string]uint64{},
+		requests:   map[requestKey]*histogram{},
+	}
+}
+
+// recorded counts a finished recording of mode
+func (m *metrics) recorded(mode string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recordings[mode]++
+}
+
+// triggered counts a trigger threshold reached
+func (m *metrics) triggered() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.triggers++
+}
+
+func (m *metrics) observe(key requestKey, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.requests[key]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(latencyBuckets))}
+		m.requests[key] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// middleware measures the latency of every request by route so paths with
+// parameters do not create a series per value
+func (m *metrics) middleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	m.observe(requestKey{method: c.Request.Method, route: route, code: strconv.Itoa(c.Writer.Status())}, time.Since(start))
+}
+
+// promWriter writes metrics in the Prometheus text format
+type promWriter struct {
+	bytes.Buffer
+}
+
+func (w *promWriter) family(name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes a value of name with the label names and values in labels
+func (w *promWriter) sample(name string, value float64, labels ...string) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatValue(value))
+	w.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func (m *metrics) write(w *promWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.family("quake_recordings_total", "counter", "Recordings written by start mode.")
+	modes := make([]string, 0, len(m.recordings))
+	for mode := range m.recordings {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
+	for _, mode := range modes {
+		w.sample("quake_recordings_total", float64(m.recordings[mode]), "mode", mode)
+	}
+	w.family("quake_triggers_total", "counter", "Trigger thresholds reached.")
+	w.sample("quake_triggers_total", float64(m.triggers))
+
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.code < b.code
+	})
+	name := "quake_http_request_duration_seconds"
+	w.family(name, "histogram", "Latency of the API requests by route.")
+	for _, k := range keys {
+		h := m.requests[k]
+		for i, le := range latencyBuckets {
+			w.sample(name+"_bucket", float64(h.buckets[i]), "method", k.method, "route", k.route, "code", k.code, "le", formatValue(le))
+		}
+		w.sample(name+"_bucket", float64(h.count), "method", k.method, "route", k.route, "code", k.code, "le", "+Inf")
+		w.sample(name+"_sum", h.sum, "method", k.method, "route", k.route, "code", k.code)
+		w.sample(name+"_count", float64(h.count), "method", k.method, "route", k.route, "code", k.code)
+	}
+}
+
+// writeAcquisitionMetrics writes the counters of the driver and the recordings read
+func writeAcquisitionMetrics(w *promWriter) {
+	st := driver.ReadStats()
+	w.family("quake_captured_bytes_total", "counter", "Bytes captured from the logic analyzer.")
+	w.sample("quake_captured_bytes_total", float64(st.CapturedBytes))
+	w.family("quake_dropped_frames_total", "counter", "Frames cut by the end of a capture.")
+	w.sample("quake_dropped_frames_total", float64(st.DroppedFrames))
+	w.family("quake_crc_failures_total", "counter", "Recording blocks read which did not match their checksum.")
+	w.sample("quake_crc_failures_total", float64(record.ChecksumFailures()))
+	w.family("quake_usb_stream_bytes_total", "counter", "Bytes read from the USB stream of the logic analyzer.")
+	w.sample("quake_usb_stream_bytes_total", float64(st.StreamBytes))
+	w.family("quake_usb_stream_underruns_total", "counter", "Reads of the USB stream which failed or returned less than a packet.")
+	w.sample("quake_usb_stream_underruns_total", float64(st.StreamUnderruns))
+}
+
+// writePowerMetrics writes the last measurement of the power rails
+func (s *Server) writePowerMetrics(w *promWriter) {
+	sample, ok := s.telemetry.latest()
+	if !ok || len(sample.Rails) == 0 {
+		return
+	}
+	w.family("quake_rail_voltage_volts", "gauge", "Voltage of the power rails.")
+	for i, r := range sample.Rails {
+		for j, v := range r.Voltages {
+			w.sample("quake_rail_voltage_volts", v, "rail", strconv.Itoa(i), "channel", strconv.Itoa(j))
+		}
+	}
+	w.family("quake_rail_current_amps", "gauge", "Current of the power rails.")
+	for i, r := range sample.Rails {
+		for j, v := range r.Currents {
+			w.sample("quake_rail_current_amps", v, "rail", strconv.Itoa(i), "channel", strconv.Itoa(j))
+		}
+	}
+	w.family("quake_battery_voltage_volts", "gauge", "Voltage of the battery.")
+	w.sample("quake_battery_voltage_volts", sample.Battery.Voltage)
+	w.family("quake_battery_current_amps", "gauge", "Current drawn from the battery.")
+	w.sample("quake_battery_current_amps", sample.Battery.Current)
+	w.family("quake_battery_charge_percent", "gauge", "State of charge of the battery estimated from its voltage.")
+	w.sample("quake_battery_charge_percent", sample.Battery.Charge)
+	w.family("quake_telemetry_timestamp_seconds", "gauge", "Time of the last measurement of the power rails.")
+	w.sample("quake_telemetry_timestamp_seconds", float64(sample.Time.UnixNano())/1e9)
+}
+
+// writeStorageMetrics writes the disk usage of the data root
+func (s *Server) writeStorageMetrics(w *promWriter) error {
+	items, err := s.trashItems()
+	if err != nil {
+		return err
+	}
+	var trash int64
+	for _, item := range items {
+		trash += item.Size
+	}
+	usage := s.index.usage()
+	w.family("quake_data_used_bytes", "gauge", "Size of the recordings of each project.")
+	for _, u := range usage {
+		w.sample("quake_data_used_bytes", float64(u.Size), "project", u.Project)
+	}
+	w.family("quake_data_recordings", "gauge", "Recordings of each project.")
+	for _, u := range usage {
+		w.sample("quake_data_recordings", float64(u.Recordings), "project", u.Project)
+	}
+	w.family("quake_trash_bytes", "gauge", "Size of the files in the trash.")
+	w.sample("quake_trash_bytes", float64(trash))
+	w.family("quake_data_reserve_bytes", "gauge", "Free space recordings never use.")
+	w.sample("quake_data_reserve_bytes", float64(s.DiskReserve))
+	if free, ok := s.dataFree(); ok {
+		w.family("quake_data_free_bytes", "gauge", "Free space of the file system holding the data root.")
+		w.sample("quake_data_free_bytes", float64(free))
+	}
+	return nil
+}
+
+// MetricsHandler exposes the acquisition, power, storage and API metrics in
+// the Prometheus text format
+func (s *Server) MetricsHandler(c *gin.Context) {
+	w := &promWriter{}
+	writeAcquisitionMetrics(w)
+	s.metrics.write(w)
+	s.writePowerMetrics(w)
+	if err := s.writeStorageMetrics(w); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, metricsContentType, w.Bytes())
+}
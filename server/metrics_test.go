@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	s := NewServer(afero.NewMemMapFs(), afero.NewMemMapFs(), &fakeStorage{}, nil, false)
+	s.metrics.recorded("asap")
+	s.metrics.recorded("hammer")
+	s.metrics.recorded("hammer")
+	s.metrics.triggered()
+	s.telemetry.add(TelemetrySample{Time: time.Unix(100, 0), Rails: []Rail{{Voltages: [3]float64{12.5, 5}, Currents: [3]float64{0.25}}}})
+	s.api.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/save/jobs/x%22y", nil))
+	s.api.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/no/route", nil))
+
+	w := httptest.NewRecorder()
+	s.api.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("metrics = %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE quake_recordings_total counter",
+		`quake_recordings_total{mode="asap"} 1`,
+		`quake_recordings_total{mode="hammer"} 2`,
+		"quake_triggers_total 1",
+		"quake_crc_failures_total ",
+		"quake_usb_stream_underruns_total ",
+		`quake_rail_voltage_volts{rail="0",channel="0"} 12.5`,
+		`quake_rail_current_amps{rail="0",channel="0"} 0.25`,
+		"quake_telemetry_timestamp_seconds 100",
+		"quake_trash_bytes 0",
+		"# TYPE quake_http_request_duration_seconds histogram",
+		// parameters are not labels of their own
+		`quake_http_request_duration_seconds_count{method="GET",route="/save/jobs/:id",code="400"} 1`,
+		`quake_http_request_duration_seconds_bucket{method="GET",route="unmatched",code="404",le="+Inf"} 1`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("metrics are missing %q", line)
+		}
+	}
+}
@@ -0,0 +1,533 @@
+package server
+
+import (
+	"encoding"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MShoaei/quakeADC/driver"
+	"github.com/MShoaei/quakeADC/sensor"
+	"github.com/MShoaei/quakeADC/survey"
+	"github.com/gin-gonic/gin"
+)
+
+// apiParam is a query parameter of a route
+type apiParam struct {
+	Name        string
+	Description string
+	Type        string
+	Required    bool
+}
+
+// apiRoute documents a route of NewAPI. Body and Response are zero values of
+// the Go types sent and received as JSON.
+type apiRoute struct {
+	Method  string
+	Path    string
+	Summary string
+
+	// Role required to use the route. Empty for public routes.
+	Role     Role
+	Query    []apiParam
+	Body     interface{}
+	Response interface{}
+
+	// Content is the media type of responses which are not JSON
+	Content string
+}
+
+// responses without a JSON body besides errors
+type empty struct{}
+
+type powerResponse struct {
+	Action string      `json:"action"`
+	Steps  []PowerStep `json:"steps"`
+}
+
+var (
+	unitParam          = apiParam{Name: "unit", Type: "string", Description: "unit of the samples. counts, volts, millivolts or the unit of the sensor e.g. m/s"}
+	forceShutdownParam = apiParam{Name: "force", Type: "boolean", Description: "wait for a running recording or export instead of refusing"}
+	samplingTimeParam  = apiParam{Name: "samplingTime", Type: "number", Description: "sampling time in microseconds for recordings without one in their header"}
+	exportTypeParam    = apiParam{Name: "type", Type: "string", Required: true, Description: "raw, seg2, segy, sac or csv"}
+)
+
+// apiRoutes documents every route registered by NewAPI
+var apiRoutes = []apiRoute{
+	{Method: "POST", Path: "/auth/login", Summary: "log in and get a session token", Body: LoginRequest{}, Response: struct {
+		Token   string  `json:"token"`
+		Session session `json:"session"`
+	}{}},
+	{Method: "POST", Path: "/auth/logout", Summary: "end the session of the request token", Response: empty{}},
+	{Method: "GET", Path: "/auth/session", Summary: "user and role of the request token", Response: struct {
+		Session session `json:"session"`
+	}{}},
+	{Method: "GET", Path: "/openapi.json", Summary: "this document", Content: "application/json"},
+
+	{Method: "GET", Path: "/status", Summary: "whether a recording is running and the health of the acquisition chain. 503 while recording", Role: RoleViewer, Response: struct {
+		Message string       `json:"message"`
+		Health  HealthStatus `json:"health"`
+	}{}},
+	{Method: "GET", Path: "/events", Summary: "events of the journal from the oldest", Role: RoleViewer,
+		Query: []apiParam{
+			{Name: "since", Type: "string", Description: "RFC 3339 time. only events after it are listed"},
+			{Name: "until", Type: "string", Description: "RFC 3339 time. only events before it are listed"},
+			{Name: "type", Type: "string", Description: "comma separated event types e.g. recording,trigger"},
+			{Name: "limit", Type: "integer", Description: "list only the newest events"},
+		},
+		Response: struct {
+			Events []Event `json:"events"`
+		}{}},
+	{Method: "GET", Path: "/metrics", Summary: "acquisition, USB stream, power rail, storage and request latency metrics in the Prometheus text format", Role: RoleViewer, Content: "text/plain"},
+
+	{Method: "GET", Path: "/tree/*dir", Summary: "list a directory of the data root", Role: RoleViewer, Response: struct {
+		Directory string `json:"directory"`
+		Items     []struct {
+			Name string `json:"name"`
+			Dir  bool   `json:"dir"`
+		} `json:"items"`
+	}{}},
+	{Method: "DELETE", Path: "/tree/*path", Summary: "move a file or directory to the trash", Role: RoleAdmin,
+		Query: []apiParam{{Name: "permanent", Type: "boolean", Description: "delete without moving to the trash"}},
+		Response: struct {
+			Trash *trashItem `json:"trash,omitempty"`
+		}{}},
+	{Method: "PATCH", Path: "/tree/*path", Summary: "rename a file or directory", Role: RoleOperator, Body: RenameRequest{}, Response: empty{}},
+	{Method: "POST", Path: "/tree", Summary: "create a project", Role: RoleOperator, Body: ProjectRequest{}, Response: empty{}},
+
+	{Method: "GET", Path: "/trash", Summary: "list the trash oldest first", Role: RoleViewer, Response: struct {
+		Items []trashItem `json:"items"`
+		Size  int64       `json:"size"`
+	}{}},
+	{Method: "DELETE", Path: "/trash", Summary: "permanently delete everything in the trash", Role: RoleAdmin, Response: struct {
+		Purged int `json:"purged"`
+	}{}},
+	{Method: "POST", Path: "/trash/:id/restore", Summary: "restore an item to its original path or to path", Role: RoleOperator, Body: PathRequest{}, Response: struct {
+		Path string `json:"path"`
+	}{}},
+	{Method: "DELETE", Path: "/trash/:id", Summary: "permanently delete an item of the trash", Role: RoleAdmin, Response: empty{}},
+
+	{Method: "GET", Path: "/storage", Summary: "free space, space used by projects and the trash and the latest removable disk hot-plug events", Role: RoleViewer, Response: struct {
+		Projects []projectUsage `json:"projects"`
+		Trash    int64          `json:"trash"`
+		Reserve  uint64         `json:"reserve"`
+		Hotplug  []StorageEvent `json:"hotplug"`
+		Free     uint64         `json:"free,omitempty"`
+	}{}},
+	{Method: "GET", Path: "/storage/estimate", Summary: "estimate the size of a recording with the enabled channels", Role: RoleViewer,
+		Query: []apiParam{
+			{Name: "recordTime", Type: "integer", Required: true, Description: "seconds"},
+			{Name: "samplingTime", Type: "number", Required: true, Description: "microseconds"},
+			{Name: "mode", Type: "string", Description: "asap or hammer"},
+		},
+		Response: struct {
+			Size    int64  `json:"size"`
+			Scratch int64  `json:"scratch"`
+			Fits    bool   `json:"fits"`
+			Warning string `json:"warning,omitempty"`
+		}{}},
+	{Method: "GET", Path: "/project/active", Summary: "the project new recordings are written to", Role: RoleViewer, Response: struct {
+		Path string `json:"path"`
+	}{}},
+	{Method: "PATCH", Path: "/project/active", Summary: "select the project new recordings are written to", Role: RoleOperator, Body: PathRequest{}, Response: empty{}},
+
+	{Method: "GET", Path: "/wifi/scan", Summary: "scan for WiFi networks", Role: RoleViewer, Response: struct {
+		AccessPoints []accessPoint `json:"accessPoints"`
+	}{}},
+	{Method: "POST", Path: "/wifi/connect", Summary: "connect to a WiFi network", Role: RoleAdmin, Body: WifiRequest{}, Response: empty{}},
+
+	{Method: "GET", Path: "/recordings", Summary: "search the recordings. every field can be filtered with field=value, field.min and field.max", Role: RoleViewer,
+		Query: []apiParam{
+			{Name: "sort", Type: "string", Description: "field to sort by. default startTime"},
+			{Name: "order", Type: "string", Description: "asc or desc"},
+			{Name: "limit", Type: "integer"},
+			{Name: "offset", Type: "integer"},
+		},
+		Response: struct {
+			Recordings []recordingInfo `json:"recordings"`
+			Total      int             `json:"total"`
+			Indexing   bool            `json:"indexing"`
+		}{}},
+	{Method: "PATCH", Path: "/recordings", Summary: "set the notes and tags of a recording", Role: RoleOperator, Body: AnnotateRequest{}, Response: struct {
+		Recording recordingInfo `json:"recording"`
+	}{}},
+	{Method: "POST", Path: "/recordings/reindex", Summary: "rescan every recording", Role: RoleOperator, Response: empty{}},
+
+	{Method: "GET", Path: "/plot", Summary: "websocket streaming the frames of a recording as binary messages", Role: RoleViewer,
+		Query: []apiParam{{Name: "file", Type: "string", Required: true}, unitParam}, Content: "application/octet-stream"},
+	{Method: "POST", Path: "/plot", Summary: "header of a recording", Role: RoleViewer, Body: FileRequest{}, Response: struct {
+		Units      []sensor.Unit `json:"units"`
+		Channels   [24]bool      `json:"channels"`
+		Window     int           `json:"window"`
+		Size       int64         `json:"size"`
+		Version    uint16        `json:"version"`
+		SampleRate float64       `json:"sampleRate"`
+		StartTime  time.Time     `json:"startTime"`
+	}{}},
+
+	{Method: "GET", Path: "/dl/*path", Summary: "download a recording converted to type", Role: RoleViewer,
+		Query: []apiParam{exportTypeParam, unitParam, samplingTimeParam}, Content: "application/octet-stream"},
+	{Method: "GET", Path: "/bundle/*path", Summary: "download a project as an archive with a manifest", Role: RoleViewer,
+		Query: []apiParam{
+			{Name: "archive", Type: "string", Description: "zip or tar.gz"},
+			{Name: "type", Type: "string", Description: "raw, seg2, segy or csv"},
+			unitParam, samplingTimeParam,
+		}, Content: "application/octet-stream"},
+
+	{Method: "POST", Path: "/setup", Summary: "configure and make a recording in the active project", Role: RoleOperator, Body: SetupRequest{}, Response: struct {
+		Frames  int64  `json:"frames"`
+		Warning string `json:"warning,omitempty"`
+	}{}},
+	{Method: "POST", Path: "/command/:cmd/:adc", Summary: "send a register command to an ADC. adc 0 sends it to every ADC", Role: RoleAdmin,
+		Body: map[string]interface{}{}, Response: struct {
+			TX interface{} `json:"tx"`
+			RX interface{} `json:"rx"`
+		}{}},
+	{Method: "GET", Path: "/getfile", Summary: "download the last recording", Role: RoleViewer, Content: "application/octet-stream"},
+
+	{Method: "GET", Path: "/usb", Summary: "list removable disks", Role: RoleViewer, Response: struct {
+		Devices []StorageDevice `json:"devices"`
+	}{}},
+	{Method: "POST", Path: "/usb/:name/mount", Summary: "mount a removable partition", Role: RoleOperator, Response: struct {
+		Device StorageDevice `json:"device"`
+	}{}},
+	{Method: "POST", Path: "/usb/:name/unmount", Summary: "flush and unmount a removable partition", Role: RoleOperator, Response: empty{}},
+	{Method: "POST", Path: "/usb/:name/eject", Summary: "unmount every partition of a disk so it can be removed", Role: RoleOperator, Response: empty{}},
+
+	{Method: "POST", Path: "/rpi/shutdown", Summary: "stop recordings, flush data, unmount USB and power the unit off", Role: RoleAdmin,
+		Query: []apiParam{forceShutdownParam}, Response: powerResponse{}},
+	{Method: "POST", Path: "/rpi/restart", Summary: "stop recordings, flush data, unmount USB and reboot the unit", Role: RoleAdmin,
+		Query: []apiParam{forceShutdownParam}, Response: powerResponse{}},
+	{Method: "GET", Path: "/channels", Summary: "enabled channels", Role: RoleViewer, Response: struct {
+		Channels [24]bool `json:"channels"`
+	}{}},
+	{Method: "POST", Path: "/channels", Summary: "enable channels. the body is one boolean per channel", Role: RoleOperator, Body: ChannelsRequest{}, Response: empty{}},
+	{Method: "GET", Path: "/gains", Summary: "gain of every channel", Role: RoleViewer, Response: struct {
+		Gains [24]uint32 `json:"gains"`
+	}{}},
+	{Method: "POST", Path: "/gains", Summary: "set the gains. the body is one gain per channel", Role: RoleOperator, Body: GainsRequest{}, Response: empty{}},
+	{Method: "GET", Path: "/sensors", Summary: "sensor of every channel in the active project", Role: RoleViewer, Response: struct {
+		Sensors [24]sensor.Model `json:"sensors"`
+	}{}},
+	{Method: "POST", Path: "/sensors", Summary: "set the sensors of the active project. the body is one model per channel", Role: RoleOperator, Body: [24]sensor.Model{}, Response: empty{}},
+	{Method: "GET", Path: "/survey", Summary: "survey of the active project", Role: RoleViewer, Response: struct {
+		Survey survey.Survey `json:"survey"`
+	}{}},
+	{Method: "PUT", Path: "/survey", Summary: "replace the survey of the active project", Role: RoleOperator, Body: survey.Survey{}, Response: struct {
+		Survey survey.Survey `json:"survey"`
+	}{}},
+	{Method: "PATCH", Path: "/survey/shot", Summary: "set the shot number of new recordings", Role: RoleOperator, Body: ShotRequest{}, Response: struct {
+		Survey survey.Survey `json:"survey"`
+	}{}},
+	{Method: "GET", Path: "/info", Summary: "raw board voltages and currents of the last measurement", Role: RoleViewer, Response: struct {
+		Voltage []int16 `json:"voltage"`
+		Current []int16 `json:"current"`
+	}{}},
+	{Method: "GET", Path: "/telemetry", Summary: "last measurement of the power rails and the battery", Role: RoleViewer, Response: TelemetrySample{}},
+	{Method: "GET", Path: "/telemetry/history", Summary: "kept measurements of the power rails from the oldest", Role: RoleViewer,
+		Query: []apiParam{
+			{Name: "since", Type: "string", Description: "RFC 3339 time. only measurements after it are listed"},
+			{Name: "limit", Type: "integer", Description: "list only the newest measurements"},
+		},
+		Response: struct {
+			Samples []TelemetrySample `json:"samples"`
+		}{}},
+	{Method: "GET", Path: "/telemetry/ws", Summary: "websocket sending every new measurement of the power rails as JSON", Role: RoleViewer, Content: "application/json"},
+	{Method: "GET", Path: "/selftest", Summary: "report of the last self test", Role: RoleViewer, Response: driver.SelfTestReport{}},
+	{Method: "POST", Path: "/selftest", Summary: "test the status, revision and RAM of every ADC and capture the diagnostic inputs on every channel", Role: RoleAdmin,
+		Query: []apiParam{{Name: "capture", Type: "boolean", Description: "false skips the capture checks"}}, Response: driver.SelfTestReport{}},
+	{Method: "GET", Path: "/calibration", Summary: "stored calibration of the board and its age", Role: RoleViewer, Response: struct {
+		Calibrated  bool               `json:"calibrated"`
+		Serial      string             `json:"serial"`
+		Calibration driver.Calibration `json:"calibration"`
+		Time        time.Time          `json:"time,omitempty"`
+		Source      string             `json:"source,omitempty"`
+		Age         time.Duration      `json:"age,omitempty"`
+	}{}},
+	{Method: "GET", Path: "/calibration/sync", Summary: "stored sync offsets of the board and their age", Role: RoleViewer, Response: struct {
+		Calibrated bool                          `json:"calibrated"`
+		Serial     string                        `json:"serial"`
+		Offsets    driver.SyncOffsets            `json:"offsets"`
+		Time       time.Time                     `json:"time,omitempty"`
+		Source     string                        `json:"source,omitempty"`
+		Age        time.Duration                 `json:"age,omitempty"`
+		Result     *driver.SyncCalibrationResult `json:"result,omitempty"`
+	}{}},
+	{Method: "POST", Path: "/calibrate/sync", Summary: "align the sampling of the channels recording a common signal with their sync offsets and store them for the board", Role: RoleAdmin,
+		Body: SyncCalibrationRequest{}, Response: struct {
+			Serial  string                       `json:"serial"`
+			Result  driver.SyncCalibrationResult `json:"result"`
+			Applied bool                         `json:"applied"`
+		}{}},
+	{Method: "POST", Path: "/adc/reset", Summary: "reset the ADCs, check their response and restore the acquisition profile and calibration", Role: RoleAdmin,
+		Query: []apiParam{
+			{Name: "mode", Type: "string", Description: "soft writes the SPI reset sequence. hard pulses the reset line shared by every ADC"},
+			{Name: "adc", Type: "integer", Description: "chip select of a single ADC or 0 for all of them. a hard reset needs 0 because single ADCs can not be hard reset"},
+		},
+		Response: struct {
+			Mode    driver.ResetMode     `json:"mode"`
+			Pass    bool                 `json:"pass"`
+			Results []driver.ResetResult `json:"results"`
+		}{}},
+	{Method: "POST", Path: "/calibrate", Summary: "calibrate the channel offsets and gains, store them when converged and enable every channel", Role: RoleAdmin,
+		Query: []apiParam{{Name: "source", Type: "string", Description: "diagnostic measures the internal inputs. fixture measures the shorted channel inputs and keeps the gains"}},
+		Response: struct {
+			Result driver.CalibrationResult `json:"result"`
+			Saved  bool                     `json:"saved"`
+		}{}},
+
+	{Method: "POST", Path: "/save/project", Summary: "export every recording of a project to USB in the background", Role: RoleOperator,
+		Query: []apiParam{exportTypeParam, {Name: "device", Type: "string"}, {Name: "force", Type: "boolean"}, unitParam, samplingTimeParam},
+		Body:  SaveProjectRequest{}, Response: struct {
+			Job exportStatus `json:"job"`
+		}{}},
+	{Method: "POST", Path: "/save/sample", Summary: "export a recording to USB in the background", Role: RoleOperator,
+		Query: []apiParam{exportTypeParam, {Name: "device", Type: "string"}, {Name: "force", Type: "boolean"}, unitParam, samplingTimeParam},
+		Body:  FileRequest{}, Response: struct {
+			Job exportStatus `json:"job"`
+		}{}},
+	{Method: "GET", Path: "/save/jobs", Summary: "status of every USB export", Role: RoleViewer, Response: struct {
+		Jobs []exportStatus `json:"jobs"`
+	}{}},
+	{Method: "GET", Path: "/save/jobs/:id", Summary: "status of a USB export", Role: RoleViewer, Response: struct {
+		Job exportStatus `json:"job"`
+	}{}},
+
+	{Method: "PATCH", Path: "/multiplier", Summary: "set the gain multiplier", Role: RoleAdmin,
+		Query: []apiParam{{Name: "val", Type: "integer", Required: true}}, Response: struct {
+			Multiplier uint32 `json:"multiplier"`
+		}{}},
+}
+
+var routeParam = regexp.MustCompile(`[:*]([A-Za-z]+)`)
+
+// openAPIPath converts a gin path to an OpenAPI path and its parameters
+func openAPIPath(p string) (string, []string) {
+	var params []string
+	for _, m := range routeParam.FindAllStringSubmatch(p, -1) {
+		params = append(params, m[1])
+	}
+	return routeParam.ReplaceAllString(p, "{$1}"), params
+}
+
+// schemaBuilder creates JSON schemas from Go types. Named structs are added
+// to the components of the document and referenced.
+type schemaBuilder struct {
+	components map[string]interface{}
+	types      map[string]reflect.Type
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	durationType      = reflect.TypeOf(time.Duration(0))
+	rxType            = reflect.TypeOf(RXResponse{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// componentName is the exported name of t unique among the components
+func (b *schemaBuilder) componentName(t reflect.Type) string {
+	name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+	if other, ok := b.types[name]; ok && other != t {
+		pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	return name
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case durationType:
+		return map[string]interface{}{"type": "integer", "format": "int64", "description": "nanoseconds"}
+	case rxType:
+		return map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "integer"}}
+	}
+	if t.Kind() != reflect.Ptr && t.Kind() != reflect.String && t.Implements(textMarshalerType) {
+		return map[string]interface{}{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := b.schema(t.Elem())
+		if _, ok := s["$ref"]; ok {
+			return map[string]interface{}{"allOf": []interface{}{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32", "minimum": 0}
+	case reflect.Uint, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64", "minimum": 0}
+	case reflect.Float32:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem()), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		name := b.componentName(t)
+		if _, ok := b.types[name]; !ok {
+			b.types[name] = t
+			b.components[name] = b.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+// object is the schema of the JSON fields of the struct t
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		if f.Anonymous && tag[0] == "" && f.Type.Kind() == reflect.Struct {
+			for k, v := range b.object(f.Type)["properties"].(map[string]interface{}) {
+				props[k] = v
+			}
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = b.schema(f.Type)
+	}
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+// openAPIDocument builds the OpenAPI 3 document of apiRoutes
+func openAPIDocument() map[string]interface{} {
+	b := &schemaBuilder{components: make(map[string]interface{}), types: make(map[string]reflect.Type)}
+	errorResponse := map[string]interface{}{
+		"description": "error",
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": b.schema(reflect.TypeOf(APIError{}))},
+		},
+	}
+
+	paths := make(map[string]interface{})
+	for _, r := range apiRoutes {
+		p, pathParams := openAPIPath(r.Path)
+		params := make([]interface{}, 0)
+		for _, name := range pathParams {
+			params = append(params, map[string]interface{}{
+				"name": name, "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, q := range r.Query {
+			params = append(params, map[string]interface{}{
+				"name": q.Name, "in": "query", "required": q.Required, "description": q.Description,
+				"schema": map[string]interface{}{"type": q.Type},
+			})
+		}
+
+		ok := map[string]interface{}{"description": "success"}
+		switch {
+		case r.Content != "":
+			ok["content"] = map[string]interface{}{r.Content: map[string]interface{}{}}
+		case r.Response != nil && reflect.TypeOf(r.Response) != reflect.TypeOf(empty{}):
+			ok["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": b.schema(reflect.TypeOf(r.Response))},
+			}
+		}
+		op := map[string]interface{}{
+			"summary":    r.Summary,
+			"parameters": params,
+			"responses": map[string]interface{}{
+				"200":     ok,
+				"default": errorResponse,
+			},
+		}
+		if r.Role != "" {
+			op["security"] = []interface{}{map[string]interface{}{"bearer": []string{}}, map[string]interface{}{"token": []string{}}}
+			op["x-role"] = r.Role
+		} else {
+			op["security"] = []interface{}{}
+		}
+		if r.Body != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": b.schema(reflect.TypeOf(r.Body))},
+				},
+			}
+		}
+		item, _ := paths[p].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[p] = item
+		}
+		item[strings.ToLower(r.Method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "quakeADC",
+			"description": "control and data API of the quakeADC seismograph. roles are viewer, operator and admin. each role can use the routes of the roles before it.",
+			"version":     "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": b.components,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"token":  map[string]interface{}{"type": "apiKey", "in": "query", "name": "token"},
+			},
+		},
+	}
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  map[string]interface{}
+)
+
+// OpenAPIHandler serves the OpenAPI 3 document of the API
+func (s *Server) OpenAPIHandler(c *gin.Context) {
+	openAPIOnce.Do(func() {
+		openAPIDoc = openAPIDocument()
+	})
+	c.JSON(http.StatusOK, openAPIDoc)
+}
+
+// documentedRoutes lists the method and path of every documented route sorted
+func documentedRoutes() []string {
+	res := make([]string, 0, len(apiRoutes))
+	for _, r := range apiRoutes {
+		res = append(res, r.Method+" "+r.Path)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// reasons a path from a request is refused
+const (
+	pathInvalid  = "invalid"
+	pathReserved = "reserved"
+	pathRoot     = "root"
+	pathInUse    = "in use"
+	pathNotFound = "not found"
+	pathNotDir   = "not a directory"
+	pathIsDir    = "is a directory"
+)
+
+// pathError is returned for paths from requests which are refused
+type pathError struct {
+	Path   string
+	Reason string
+}
+
+func (e *pathError) Error() string {
+	return fmt.Sprintf("invalid path %q: %s", e.Path, e.Reason)
+}
+
+func (e *pathError) status() int {
+	switch e.Reason {
+	case pathNotFound:
+		return http.StatusNotFound
+	case pathRoot, pathReserved:
+		return http.StatusForbidden
+	case pathInUse:
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+// cleanPath validates a path from a request and returns it as an absolute path
+// inside the data root. Paths with .. elements or naming files kept by the
+// server are refused instead of being cleaned so a request can never reach
+// outside the directory it names.
+func cleanPath(p string) (string, error) {
+	if strings.ContainsAny(p, "\x00\\") {
+		return "", &pathError{Path: p, Reason: pathInvalid}
+	}
+	for _, elem := range strings.Split(p, "/") {
+		if elem == ".." {
+			return "", &pathError{Path: p, Reason: pathInvalid}
+		}
+		if isHidden(elem) {
+			return "", &pathError{Path: p, Reason: pathReserved}
+		}
+	}
+	return path.Join("/", p), nil
+}
+
+// validName checks a single file or directory name chosen by a user
+func validName(name string) error {
+	switch {
+	case name == "", name == ".", name == "..", strings.ContainsAny(name, "/\\\x00"):
+		return &pathError{Path: name, Reason: pathInvalid}
+	case isHidden(name):
+		return &pathError{Path: name, Reason: pathReserved}
+	}
+	return nil
+}
+
+// existingPath cleans p and checks it exists on fs. dir selects whether p
+// must be a directory or a regular file.
+func existingPath(fs afero.Fs, p string, dir bool) (string, error) {
+	clean, err := cleanPath(p)
+	if err != nil {
+		return "", err
+	}
+	info, err := fs.Stat(clean)
+	if os.IsNotExist(err) {
+		return "", &pathError{Path: clean, Reason: pathNotFound}
+	}
+	if err != nil {
+		return "", err
+	}
+	if dir && !info.IsDir() {
+		return "", &pathError{Path: clean, Reason: pathNotDir}
+	}
+	if !dir && info.IsDir() {
+		return "", &pathError{Path: clean, Reason: pathIsDir}
+	}
+	return clean, nil
+}
+
+// modifiablePath cleans p and checks it may be deleted or renamed. The data
+// root can never be modified and the active project, the file being recorded
+// and its directories can not be modified while recording.
+func (s *Server) modifiablePath(p string) (string, error) {
+	clean, err := cleanPath(p)
+	if err != nil {
+		return "", err
+	}
+	if clean == "/" {
+		return "", &pathError{Path: clean, Reason: pathRoot}
+	}
+	if exists, _ := afero.Exists(s.dataFS, clean); !exists {
+		return "", &pathError{Path: clean, Reason: pathNotFound}
+	}
+	if s.recording() {
+		file, _ := s.recordingFile.Load().(string)
+		if under(path.Join("/", s.activePath), clean) || (file != "" && under(file, clean)) {
+			return "", &pathError{Path: clean, Reason: pathInUse}
+		}
+	}
+	return clean, nil
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string
+		wantErr string
+	}{
+		{path: "", want: "/"},
+		{path: "project/rec1", want: "/project/rec1"},
+		{path: "/project//rec1/", want: "/project/rec1"},
+		{path: "../etc/passwd", wantErr: pathInvalid},
+		{path: "project/../../etc", wantErr: pathInvalid},
+		{path: "project/.project.json", wantErr: pathReserved},
+		{path: "/.index.json", wantErr: pathReserved},
+		{path: "project\\rec1", wantErr: pathInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := cleanPath(tt.path)
+			if tt.wantErr != "" {
+				e, ok := err.(*pathError)
+				if !ok || e.Reason != tt.wantErr {
+					t.Fatalf("cleanPath() error = %v, want reason %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("cleanPath() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestModifiablePath(t *testing.T) {
+	s := NewServer(afero.NewMemMapFs(), afero.NewMemMapFs(), &fakeStorage{}, nil, false)
+	for _, f := range []string{"/p1/shot1.dat", "/p1/shot2.dat", "/p2/rec/shot3.dat"} {
+		afero.WriteFile(s.dataFS, f, []byte("data"), 0644)
+	}
+	s.activePath = "/p1"
+	s.hw.tryAcquire(workRecording)
+	// the active project changed since the recording started
+	s.recordingFile.Store("/p2/rec/shot3.dat")
+
+	tests := []struct {
+		path    string
+		wantErr string
+	}{
+		{path: "/", wantErr: pathRoot},
+		{path: "/p1", wantErr: pathInUse},
+		{path: "/p2/rec/shot3.dat", wantErr: pathInUse},
+		{path: "/p2/rec", wantErr: pathInUse},
+		{path: "/p2", wantErr: pathInUse},
+		{path: "/p3", wantErr: pathNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			_, err := s.modifiablePath(tt.path)
+			if e, ok := err.(*pathError); !ok || e.Reason != tt.wantErr {
+				t.Errorf("modifiablePath() error = %v, want reason %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	s.hw.release()
+	if _, err := s.modifiablePath("/p2/rec/shot3.dat"); err != nil {
+		t.Errorf("modifiablePath() after the recording error = %v", err)
+	}
+}
@@ -1,17 +1,180 @@
 package server
 
 import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
 	"github.com/MShoaei/quakeADC/driver"
 	"github.com/gin-gonic/gin"
-	"github.com/go-cmd/cmd"
 )
 
-func (s *Server) RestartSequenceHandler(c *gin.Context) {
-	driver.Reset()
-	cmd.NewCmd("/usr/bin/sudo", "/sbin/shutdown", "-r", "now").Start()
+const (
+	// shutdownWait is how long a forced shutdown waits for a running recording or export
+	shutdownWait = 5 * time.Minute
+
+	// powerOffDelay leaves time to send the response before the power is cut
+	powerOffDelay = 2 * time.Second
+)
+
+// PowerStep is a step of the shutdown sequence
+type PowerStep struct {
+	Step  string `json:"step"`
+	Error string `json:"error,omitempty"`
+}
+
+// shuttingDown reports whether the shutdown sequence started. Recordings and
+// exports are refused afterwards.
+func (s *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&s.shutdown) != 0
+}
+
+func errShuttingDown() error {
+	return newError(http.StatusServiceUnavailable, "the unit is shutting down")
+}
+
+// busy is the work which has to end before the power is cut
+func (s *Server) busy() string {
+	if w := s.hw.current(); w != "" && !backgroundWork[w] {
+		return w + " is running"
+	}
+	if s.exports.running() != nil {
+		return "an export is running"
+	}
+	return ""
+}
+
+// prepareShutdown stops the work of the server and leaves the board ready to
+// be powered off. A running recording or export is refused unless wait is
+// set, in which case it is waited for because sigrok can not be interrupted
+// without losing the recording. Failed steps do not stop the sequence.
+func (s *Server) prepareShutdown(wait bool) ([]PowerStep, error) {
+	if !atomic.CompareAndSwapInt32(&s.shutdown, 0, 1) {
+		return nil, newError(http.StatusConflict, "the unit is already shutting down")
+	}
+	if reason := s.busy(); reason != "" {
+		if !wait {
+			atomic.StoreInt32(&s.shutdown, 0)
+			return nil, newError(http.StatusConflict, "%s. use force to wait for it", reason)
+		}
+		s.l.Infof("shutdown is waiting: %s", reason)
+		for deadline := time.Now().Add(shutdownWait); s.busy() != "" && time.Now().Before(deadline); {
+			time.Sleep(time.Second)
+		}
+	}
 
+	var steps []PowerStep
+	step := func(name string, err error) {
+		res := PowerStep{Step: name}
+		if err != nil {
+			res.Error = err.Error()
+			s.l.Errorf("shutdown: failed to %s: %v", name, err)
+		}
+		steps = append(steps, res)
+	}
+	if reason := s.busy(); reason != "" {
+		step("wait for recordings", fmt.Errorf("%s after %s", reason, shutdownWait))
+	}
+	if s.xmega != nil {
+		step("stop sampling", s.xmega.StopSampling())
+	}
+	syscall.Sync()
+	step("flush data", nil)
+	step("unmount usb", s.unmountAll())
+	if s.xmega != nil {
+		step("turn off status led", s.xmega.SetLED(false))
+	}
+	return steps, nil
+}
+
+// unmountAll unmounts every mounted removable file system
+func (s *Server) unmountAll() error {
+	devices, err := s.storage.Devices()
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, dev := range devices {
+		if dev.MountPoint == "" {
+			continue
+		}
+		if err := s.storage.Unmount(dev.Name); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", dev.Name, err))
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("%s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// powerDown restarts the host or has the XMega cut the power
+func (s *Server) powerDown(restart bool) error {
+	if restart {
+		if err := driver.Reset(); err != nil {
+			s.l.Errorf("failed to reset xmega: %v", err)
+		}
+		_, err := run("/usr/bin/sudo", "/sbin/shutdown", "-r", "now")
+		return err
+	}
+	if s.xmega == nil {
+		return fmt.Errorf("no board controller")
+	}
+	return s.xmega.Shutdown()
+}
+
+// shutdownSequence runs the shutdown sequence and powers down after powerOffDelay
+func (s *Server) shutdownSequence(restart, wait bool) ([]PowerStep, error) {
+	steps, err := s.prepareShutdown(wait)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		time.Sleep(powerOffDelay)
+		if err := s.powerOff(restart); err != nil {
+			s.l.Errorf("failed to power down: %v", err)
+			atomic.StoreInt32(&s.shutdown, 0)
+		}
+	}()
+	return steps, nil
+}
+
+// lowBatteryShutdown shuts the unit down waiting for a running recording
+func (s *Server) lowBatteryShutdown(voltage float64) {
+	s.l.Warnf("battery at %.2fV. shutting down", voltage)
+	s.event(nil, EventPower, map[string]interface{}{"action": "shutdown", "voltage": voltage}, "battery at %.2fV. shutting down", voltage)
+	if _, err := s.shutdownSequence(false, true); err != nil {
+		s.l.Errorf("low battery shutdown failed: %v", err)
+	}
+}
+
+func (s *Server) powerHandler(c *gin.Context, restart bool) {
+	force := strings.ToLower(c.Query("force")) == "true"
+	steps, err := s.shutdownSequence(restart, force)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	action := "shutdown"
+	if restart {
+		action = "restart"
+	}
+	s.event(c, EventPower, map[string]interface{}{"action": action, "force": force, "steps": steps}, "%s requested", action)
+	c.JSON(http.StatusAccepted, gin.H{
+		"action": action,
+		"steps":  steps,
+	})
+}
+
+// RestartSequenceHandler stops the work of the server and reboots the host
+func (s *Server) RestartSequenceHandler(c *gin.Context) {
+	s.powerHandler(c, true)
 }
 
+// ShutdownSequenceHandler stops the work of the server and powers the unit off
 func (s *Server) ShutdownSequenceHandler(c *gin.Context) {
-	driver.Shutdown(s.adc.Connection())
+	s.powerHandler(c, false)
 }
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestShutdownSequence(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       string
+		recording bool
+		shutdown  int32
+		want      int
+		sent      string
+		restart   bool
+		powered   bool
+	}{
+		{name: "shutdown", url: "/rpi/shutdown", want: http.StatusAccepted, sent: "stop led", powered: true},
+		{name: "restart", url: "/rpi/restart", want: http.StatusAccepted, sent: "stop led", restart: true, powered: true},
+		{name: "recording", url: "/rpi/shutdown", recording: true, want: http.StatusConflict},
+		{name: "already shutting down", url: "/rpi/shutdown", shutdown: 1, want: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x := &fakeXMega{}
+			s := NewServer(afero.NewMemMapFs(), afero.NewMemMapFs(), &fakeStorage{}, nil, false)
+			s.xmega = x
+			if tt.recording {
+				s.hw.tryAcquire(workRecording)
+			}
+			s.shutdown = tt.shutdown
+			powered := make(chan bool, 1)
+			s.powerOff = func(restart bool) error {
+				powered <- restart
+				return nil
+			}
+
+			w := httptest.NewRecorder()
+			s.api.ServeHTTP(w, httptest.NewRequest("POST", tt.url, nil))
+			if w.Code != tt.want {
+				t.Errorf("POST %s = %d %s, want %d", tt.url, w.Code, w.Body.String(), tt.want)
+			}
+			if got := strings.Join(x.commands, " "); got != tt.sent {
+				t.Errorf("POST %s sent %q, want %q", tt.url, got, tt.sent)
+			}
+			if tt.powered {
+				select {
+				case restart := <-powered:
+					if restart != tt.restart {
+						t.Errorf("POST %s powered down with restart %v, want %v", tt.url, restart, tt.restart)
+					}
+				case <-time.After(powerOffDelay + time.Second):
+					t.Errorf("POST %s did not power down", tt.url)
+				}
+			}
+			if tt.recording && s.shuttingDown() {
+				t.Errorf("refused shutdown left the server shutting down")
+			}
+		})
+	}
+}
+
+func TestLowBatteryShutdown(t *testing.T) {
+	s := NewServer(afero.NewMemMapFs(), afero.NewMemMapFs(), &fakeStorage{}, nil, false)
+	s.xmega = &fakeXMega{battery: 10800}
+	cfg := DefaultTelemetryConfig()
+	cfg.VoltsPerCount = 0.001
+	cfg.AmpsPerCount = 0.001
+	cfg.ShutdownVoltage = 11
+	if err := s.SetTelemetry(cfg); err != nil {
+		t.Fatal(err)
+	}
+	powered := make(chan bool, 1)
+	s.powerOff = func(restart bool) error {
+		powered <- restart
+		return nil
+	}
+
+	for i := 0; i < 2; i++ {
+		if s.shuttingDown() {
+			t.Fatalf("shut down after %d low measurements, want 2", i)
+		}
+		if _, err := s.measureTelemetry(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case restart := <-powered:
+		if restart {
+			t.Errorf("low battery restarted the unit")
+		}
+	case <-time.After(powerOffDelay + time.Second):
+		t.Errorf("low battery did not power down")
+	}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/MShoaei/quakeADC/sensor"
+	"github.com/MShoaei/quakeADC/survey"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/afero"
+)
+
+// projectManifestName is the file in a project directory holding the project settings
+const projectManifestName = ".project.json"
+
+type projectManifest struct {
+	// Sensors connected to each of the 24 channels
+	Sensors [24]sensor.Model `json:"sensors"`
+
+	Survey survey.Survey `json:"survey"`
+}
+
+// isHidden reports whether name is a file kept by the server which should
+// not be listed or exported as a recording
+func isHidden(name string) bool {
+	base := path.Base(name)
+	return base != "." && base != ".." && strings.HasPrefix(base, ".")
+}
+
+// readProjectManifest reads the manifest of project. A project without
+// a manifest has the zero manifest.
+func readProjectManifest(fs afero.Fs, project string) (projectManifest, error) {
+	var m projectManifest
+	b, err := afero.ReadFile(fs, path.Join("/", project, projectManifestName))
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("invalid project manifest: %v", err)
+	}
+	return m, nil
+}
+
+func writeProjectManifest(fs afero.Fs, project string, m projectManifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(fs, path.Join("/", project, projectManifestName), b, 0644)
+}
+
+// GetSensorsHandler returns the sensor model of every channel in the active project
+func (s *Server) GetSensorsHandler(c *gin.Context) {
+	m, err := readProjectManifest(s.dataFS, s.activePath)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"sensors": m.Sensors,
+	})
+}
+
+// SetSensorsHandler replaces the sensor model of every channel in the active project
+func (s *Server) SetSensorsHandler(c *gin.Context) {
+	sensors := [24]sensor.Model{}
+	if !bindJSON(c, &sensors) {
+		return
+	}
+	for i, model := range sensors {
+		if err := model.Validate(); err != nil {
+			abortWithError(c, newError(http.StatusBadRequest, "channel %d: %v", i+1, err))
+			return
+		}
+	}
+
+	m, err := readProjectManifest(s.dataFS, s.activePath)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	m.Sensors = sensors
+	if err := writeProjectManifest(s.dataFS, s.activePath, m); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// GetSurveyHandler returns the survey of the active project
+func (s *Server) GetSurveyHandler(c *gin.Context) {
+	m, err := readProjectManifest(s.dataFS, s.activePath)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"survey": m.Survey,
+	})
+}
+
+// SetSurveyHandler replaces the survey of the active project
+func (s *Server) SetSurveyHandler(c *gin.Context) {
+	var sv survey.Survey
+	if !bindJSON(c, &sv) {
+		return
+	}
+	if err := sv.Validate(); err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	s.updateSurvey(c, func(m *survey.Survey) {
+		*m = sv
+	})
+}
+
+// SetShotHandler sets the shot number assigned to new recordings in the active project
+func (s *Server) SetShotHandler(c *gin.Context) {
+	data := ShotRequest{}
+	if !bindJSON(c, &data) {
+		return
+	}
+	s.updateSurvey(c, func(m *survey.Survey) {
+		m.CurrentShot = data.Shot
+	})
+}
+
+func (s *Server) updateSurvey(c *gin.Context, update func(m *survey.Survey)) {
+	m, err := readProjectManifest(s.dataFS, s.activePath)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	update(&m.Survey)
+	if err := writeProjectManifest(s.dataFS, s.activePath, m); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"survey": m.Survey,
+	})
+}
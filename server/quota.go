@@ -0,0 +1,191 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/MShoaei/quakeADC/driver"
+	"github.com/MShoaei/quakeADC/record"
+	"github.com/gin-gonic/gin"
+)
+
+// errStorageFull is returned by frameLimit once the recording would not leave DiskReserve bytes free
+var errStorageFull = errors.New("storage is full")
+
+// storageError is returned when a recording does not fit on the data file system
+type storageError struct {
+	Required uint64
+	Free     uint64
+}
+
+func (e *storageError) Error() string {
+	return fmt.Sprintf("not enough space for the recording. %d bytes required, %d bytes available", e.Required, e.Free)
+}
+
+// frameLimit passes whole frames to w until left bytes were written and then
+// refuses every write so the recording ends on a frame boundary
+type frameLimit struct {
+	w         io.Writer
+	frameSize int
+	left      int64
+	stopped   bool
+}
+
+func (l *frameLimit) Write(p []byte) (int, error) {
+	if l.stopped {
+		return 0, errStorageFull
+	}
+	if int64(len(p)) <= l.left {
+		n, err := l.w.Write(p)
+		l.left -= int64(n)
+		return n, err
+	}
+	l.stopped = true
+	c := l.left - l.left%int64(l.frameSize)
+	n, err := l.w.Write(p[:c])
+	l.left -= int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, errStorageFull
+}
+
+// recordingEstimate is the size of a recording of recordTime seconds with the
+// enabled channels sampled every samplingTime microseconds. scratch is the
+// space needed while recording besides the recording itself.
+func (s *Server) recordingEstimate(mode string, recordTime int, samplingTime float32) (size, scratch int64) {
+	h := s.recordHeader(mode, record.Profile{SamplingTime: samplingTime}, 0)
+	var frames int64
+	if samplingTime > 0 {
+		frames = int64(float64(recordTime) * 1e6 / float64(samplingTime))
+	}
+	if strings.ToLower(mode) == "asap" {
+		// sigrok-cli captures to a temporary file next to the data before it is converted
+		scratch = int64(recordTime) * driver.LogicSampleRate
+	}
+	return record.EstimateSize(h, frames), scratch
+}
+
+// checkStorage checks size bytes can be written while keeping DiskReserve
+// bytes free. The trash is purged first when purge is set and there is not
+// enough space. A warning is returned when another recording of the same size
+// would not fit afterwards.
+func (s *Server) checkStorage(size int64, purge bool) (string, error) {
+	free, ok := s.dataFree()
+	if !ok {
+		return "", nil
+	}
+	if purge && free < uint64(size)+s.DiskReserve {
+		s.purgeTrash()
+		free, _ = s.dataFree()
+	}
+	required := uint64(size) + s.DiskReserve
+	if free < required {
+		return "", &storageError{Required: required, Free: free}
+	}
+	if free-uint64(size) < required {
+		return fmt.Sprintf("storage is low. %d bytes will be available after this recording", free-uint64(size)), nil
+	}
+	return "", nil
+}
+
+// limitRecording wraps w so the recording stops before the free space drops below DiskReserve
+func (s *Server) limitRecording(w io.Writer, h record.Header) *frameLimit {
+	l := &frameLimit{w: w, frameSize: h.FrameSize(), left: math.MaxInt64}
+	free, ok := s.dataFree()
+	if !ok {
+		return l
+	}
+	var budget int64
+	if free > s.DiskReserve {
+		budget = int64(free - s.DiskReserve)
+	}
+	// the header was already written so only the blocks need to fit
+	l.left = record.MaxFrames(h, budget+record.EstimateSize(h, 0)) * int64(h.FrameSize())
+	return l
+}
+
+// writeRecording converts the raw samples in src to a recording in the data
+// file. The recording is stopped early and closed when the storage is full.
+func (s *Server) writeRecording(src io.Reader, size int, header record.Header) (frames int64, full bool, err error) {
+	w, err := record.NewWriter(s.dataFile, header)
+	if err != nil {
+		return 0, false, fmt.Errorf("error while writing file header: %v", err)
+	}
+	l := s.limitRecording(w, header)
+	convErr := driver.Convert(src, l, size, s.hd.EnabledChannels)
+	err = w.Close()
+	if convErr != nil && !l.stopped {
+		return w.Frames(), false, fmt.Errorf("failed to convert the samples: %v", convErr)
+	}
+	return w.Frames(), l.stopped, err
+}
+
+// recordingResult is the response to a finished recording
+func recordingResult(frames int64, full bool, warning string) gin.H {
+	res := gin.H{
+		"frames": frames,
+	}
+	if full {
+		res["warning"] = fmt.Sprintf("recording stopped after %d frames because the storage is full", frames)
+	} else if warning != "" {
+		res["warning"] = warning
+	}
+	return res
+}
+
+// StorageHandler reports the free space and the space used by each project and the trash
+func (s *Server) StorageHandler(c *gin.Context) {
+	items, err := s.trashItems()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	var trash int64
+	for _, item := range items {
+		trash += item.Size
+	}
+	res := gin.H{
+		"projects": s.index.usage(),
+		"trash":    trash,
+		"reserve":  s.DiskReserve,
+		"hotplug":  s.hotplug.list(),
+	}
+	if free, ok := s.dataFree(); ok {
+		res["free"] = free
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// EstimateHandler estimates the size of a recording with the enabled channels
+// from the mode, recordTime and samplingTime query parameters
+func (s *Server) EstimateHandler(c *gin.Context) {
+	recordTime, err := strconv.Atoi(c.Query("recordTime"))
+	if err != nil || recordTime < 0 {
+		abortWithError(c, newError(http.StatusBadRequest, "invalid recordTime"))
+		return
+	}
+	samplingTime, err := strconv.ParseFloat(c.Query("samplingTime"), 32)
+	if err != nil || samplingTime <= 0 {
+		abortWithError(c, newError(http.StatusBadRequest, "invalid samplingTime"))
+		return
+	}
+	size, scratch := s.recordingEstimate(c.DefaultQuery("mode", "asap"), recordTime, float32(samplingTime))
+	res := gin.H{
+		"size":    size,
+		"scratch": scratch,
+	}
+	warning, err := s.checkStorage(size+scratch, false)
+	res["fits"] = err == nil
+	if err != nil {
+		res["warning"] = err.Error()
+	} else if warning != "" {
+		res["warning"] = warning
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFrameLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		left     int64
+		writes   int
+		wantSize int
+		wantStop bool
+	}{
+		{name: "fits", left: 100, writes: 3, wantSize: 24},
+		{name: "exact", left: 24, writes: 3, wantSize: 24},
+		{name: "partial frame", left: 20, writes: 3, wantSize: 16, wantStop: true},
+		{name: "full", left: 0, writes: 1, wantStop: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			l := &frameLimit{w: &buf, frameSize: 8, left: tt.left}
+			for i := 0; i < tt.writes; i++ {
+				_, _ = l.Write(make([]byte, 8))
+			}
+			if buf.Len() != tt.wantSize {
+				t.Errorf("wrote %d bytes, want %d", buf.Len(), tt.wantSize)
+			}
+			if tt.wantStop && !l.stopped {
+				t.Errorf("frameLimit did not stop")
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/MShoaei/quakeADC/driver"
+	"github.com/gin-gonic/gin"
+)
+
+// restoreADCs writes the acquisition profile, channel standby, calibration
+// and sync offsets which a reset returned to their defaults
+func (s *Server) restoreADCs() error {
+	if s.profile.SamplingTime != 0 {
+		s.configureProfile(s.profile.SamplingTime)
+	}
+	if err := s.applyChannelStandby(); err != nil {
+		return fmt.Errorf("channel standby: %v", err)
+	}
+	if err := s.adc.ApplyCalibration(s.calibration.Calibration, s.gainRegisters(), 0); err != nil {
+		return fmt.Errorf("calibration: %v", err)
+	}
+	if err := s.adc.ApplySyncOffsets(s.syncCalibration.Offsets); err != nil {
+		return fmt.Errorf("sync offsets: %v", err)
+	}
+	driver.SendSyncSignal()
+	return nil
+}
+
+// resetChips resets the chip cs or every chip when cs is 0 and restores the
+// settings of the server
+func (s *Server) resetChips(c *gin.Context, mode driver.ResetMode, cs uint8) {
+	if mode == driver.ResetHard && cs != 0 {
+		abortWithError(c, invalidField("adc", "a hard reset resets every chip because the ADCs share the reset line. use 0 or a soft reset"))
+		return
+	}
+	if s.adc == nil {
+		abortWithError(c, hardwareError(fmt.Errorf("no ADC connection")))
+		return
+	}
+	if s.shuttingDown() {
+		abortWithError(c, errShuttingDown())
+		return
+	}
+	if !s.acquireHardware(c, workReset) {
+		return
+	}
+	defer s.hw.release()
+
+	var chips []uint8
+	if cs != 0 {
+		chips = []uint8{cs}
+	}
+	results, err := s.adc.ResetChips(s.xmega, mode, chips)
+	if err != nil {
+		abortWithError(c, hardwareError(err))
+		return
+	}
+	pass := true
+	for _, r := range results {
+		if !r.Pass {
+			pass = false
+			s.l.Warnf("chip %d did not reset: %v", r.Chip, r.Errors)
+		}
+	}
+	if err := s.restoreADCs(); err != nil {
+		abortWithError(c, hardwareError(fmt.Errorf("failed to restore the ADC settings: %v", err)))
+		return
+	}
+	s.event(c, EventReset, map[string]interface{}{"mode": mode, "adc": cs, "pass": pass}, "%s reset of adc %d", mode, cs)
+	c.JSON(http.StatusOK, gin.H{
+		"mode":    mode,
+		"pass":    pass,
+		"results": results,
+	})
+}
+
+// ResetHandler resets the ADCs. mode is soft or hard and adc is the chip
+// select of a single ADC or 0 for all of them.
+func (s *Server) ResetHandler(c *gin.Context) {
+	mode := driver.ResetMode(c.DefaultQuery("mode", string(driver.ResetSoft)))
+	if mode != driver.ResetSoft && mode != driver.ResetHard {
+		abortWithError(c, invalidField("mode", "expected %s or %s", driver.ResetSoft, driver.ResetHard))
+		return
+	}
+	cs, err := strconv.ParseUint(c.DefaultQuery("adc", "0"), 10, 8)
+	if err != nil || cs > 9 {
+		abortWithError(c, invalidField("adc", "expected a chip select between 1 and 9 or 0 for every ADC"))
+		return
+	}
+	s.resetChips(c, mode, uint8(cs))
+}
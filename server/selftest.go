@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/MShoaei/quakeADC/driver"
+	"github.com/gin-gonic/gin"
+)
+
+// selfTests keeps the report of the last self test
+type selfTests struct {
+	mu   sync.Mutex
+	last *driver.SelfTestReport
+}
+
+func (t *selfTests) set(r driver.SelfTestReport) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.last = &r
+}
+
+func (t *selfTests) get() *driver.SelfTestReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last
+}
+
+// SelfTestHandler tests the ADCs. The capture checks record the diagnostic
+// inputs with the logic analyzer unless capture is false.
+func (s *Server) SelfTestHandler(c *gin.Context) {
+	if s.adc == nil || s.xmega == nil {
+		abortWithError(c, hardwareError(fmt.Errorf("no ADC connection")))
+		return
+	}
+	if s.shuttingDown() {
+		abortWithError(c, errShuttingDown())
+		return
+	}
+	if !s.acquireHardware(c, workSelfTest) {
+		return
+	}
+	opts := driver.SelfTestOptions{}
+	if strings.ToLower(c.Query("capture")) != "false" && len(s.logics) != 0 {
+		opts.Logic = s.logics[0]
+	}
+
+	report := s.adc.SelfTest(s.xmega, opts)
+	s.hw.release()
+
+	s.selfTests.set(report)
+	if !report.Pass {
+		s.l.Warnf("self test failed")
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// LastSelfTestHandler returns the report of the last self test
+func (s *Server) LastSelfTestHandler(c *gin.Context) {
+	report := s.selfTests.get()
+	if report == nil {
+		abortWithError(c, newError(http.StatusNotFound, "no self test was run"))
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -2,10 +2,16 @@ package server
 
 import (
 	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/MShoaei/quakeADC/driver"
+	"github.com/MShoaei/quakeADC/record"
+	"github.com/MShoaei/quakeADC/sensor"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/afero"
 )
@@ -17,12 +23,27 @@ type HeaderData struct {
 }
 
 type Server struct {
-	l             *logrus.Logger
-	api           *gin.Engine
-	adc           *driver.Adc7768
-	hd            HeaderData
-	logics        []string
-	sigrokRunning bool
+	l               *logrus.Logger
+	api             *gin.Engine
+	adc             *driver.Adc7768
+	xmega           driver.XMegaController
+	hd              HeaderData
+	profile         record.Profile
+	calibration     CalibrationRecord
+	syncCalibration SyncCalibrationRecord
+	serial          string
+	logics          []string
+
+	// hw is held by the work using the ADCs, the XMega or the logic analyzers
+	hw hardwareLock
+
+	// shutdown is set once the shutdown sequence started
+	shutdown int32
+	powerOff func(restart bool) error
+
+	// resetXMega and initHardware are the recovery steps of the health monitor
+	resetXMega   func() error
+	initHardware func() error
 
 	activePath string
 	activeFS   afero.Fs
@@ -31,13 +52,98 @@ type Server struct {
 	memFS    afero.Fs
 	dataFile afero.File
 
+	// recordingFile is the path of the file being recorded or ""
+	recordingFile atomic.Value
+
+	storage   StorageManager
+	hotplug   hotplugLog
+	exports   exportJobs
+	index     *recordingIndex
+	telemetry *telemetry
+	health    *health
+	metrics   *metrics
+	events    *journal
+	selfTests selfTests
+	trashMu   sync.Mutex
+
+	auth     *authenticator
+	upgrader websocket.Upgrader
+
 	Debug        bool
 	GainMultiply uint32
+
+	// TrashRetention is how long deleted files are kept in the trash. 0 keeps them until space is needed.
+	TrashRetention time.Duration
+
+	// DiskReserve is the free space in bytes recordings never use
+	DiskReserve uint64
+
+	// TrashMinFree is the free space in bytes below which the oldest files in the trash are purged
+	TrashMinFree uint64
+}
+
+// recordHeader describes the current acquisition settings for a new recording.
+// shot overrides the current shot of the project survey when it is not zero.
+func (s *Server) recordHeader(mode string, profile record.Profile, shot int) record.Header {
+	h := record.Header{
+		Mode:           mode,
+		Profile:        profile,
+		StartTime:      time.Now(),
+		TriggerIndex:   -1,
+		Window:         s.hd.Window,
+		BlockChecksums: true,
+	}
+	if profile.SamplingTime > 0 {
+		h.SampleRate = 1e6 / float64(profile.SamplingTime)
+	}
+	if s.serial != "" {
+		h.BoardSerials = []string{s.serial}
+	}
+	m, err := readProjectManifest(s.dataFS, s.activePath)
+	if err != nil {
+		s.l.Errorf("recording without sensor models and survey: %v", err)
+	}
+	sv := m.Survey
+	if shot == 0 {
+		shot = sv.CurrentShot
+	}
+	if sv.Defined() || shot != 0 {
+		h.Line = sv.Line
+		h.Operator = sv.Operator
+		if shot != 0 {
+			st, _ := sv.Shot(shot)
+			h.Shot = &st
+		}
+	}
+	for i, enabled := range s.hd.EnabledChannels {
+		if enabled {
+			ch := record.Channel{
+				Index:      i,
+				Gain:       s.hd.Gains[i],
+				Offset:     s.calibration.Calibration.Offsets[i],
+				SyncOffset: s.syncCalibration.Offsets[i],
+			}
+			if m.Sensors[i] != (sensor.Model{}) {
+				model := m.Sensors[i]
+				ch.Sensor = &model
+			}
+			if sv.Defined() {
+				r := sv.Receiver(i)
+				ch.Receiver = &r
+			}
+			h.Channels = append(h.Channels, ch)
+		}
+	}
+	return h
 }
 
 // NewServer creates a new server instance with the provided paths
-// and attaches a gin engine to it wich can listen for http requests
-func NewServer(dataFS, memFS afero.Fs, adcConnection *driver.Adc7768, debug bool) *Server {
+// and attaches a gin engine to it wich can listen for http requests.
+// storage manages the USB devices recordings are exported to. nil uses the devices of the host.
+func NewServer(dataFS, memFS afero.Fs, storage StorageManager, adcConnection *driver.Adc7768, debug bool) *Server {
+	if storage == nil {
+		storage = NewStorageManager()
+	}
 	s := &Server{
 		l:   logrus.New(),
 		adc: adcConnection,
@@ -48,12 +154,31 @@ func NewServer(dataFS, memFS afero.Fs, adcConnection *driver.Adc7768, debug bool
 				1, 1, 1, 1, 1, 1, 1, 1,
 			},
 		},
-		activePath: "/",
-		activeFS:   dataFS,
+		calibration: CalibrationRecord{Calibration: driver.DefaultCalibration()},
+		activePath:  "/",
+		activeFS:    dataFS,
 
 		dataFS:       dataFS,
 		memFS:        memFS,
+		storage:      storage,
+		index:        newRecordingIndex(dataFS),
+		telemetry:    newTelemetry(DefaultTelemetryConfig()),
+		health:       newHealth(DefaultHealthConfig()),
+		metrics:      newMetrics(),
+		events:       newJournal(dataFS, DefaultEventsConfig()),
+		auth:         newAuthenticator(),
 		GainMultiply: 1000,
+
+		TrashRetention: 30 * 24 * time.Hour,
+		TrashMinFree:   512 << 20,
+		DiskReserve:    64 << 20,
+	}
+
+	s.powerOff = s.powerDown
+	s.resetXMega = s.resetXMegaBoard
+	s.initHardware = s.reinitHardware
+	if adcConnection != nil {
+		s.xmega = driver.NewXMega(adcConnection.Connection())
 	}
 
 	if debug {
@@ -63,43 +188,77 @@ func NewServer(dataFS, memFS afero.Fs, adcConnection *driver.Adc7768, debug bool
 	return s
 }
 
+// start runs the background work of the server
+func (s *Server) start() {
+	s.event(nil, EventStartup, nil, "server started")
+	go s.watchStorage(nil)
+	go s.watchTrash(nil)
+	if s.xmega != nil {
+		go s.watchTelemetry(nil)
+		go s.watchHealth(nil)
+	}
+	go func() {
+		if err := s.index.rebuild(); err != nil {
+			s.l.Errorf("failed to build recording index: %v", err)
+		}
+	}()
+}
+
+// Handler is the HTTP handler of the API
+func (s *Server) Handler() http.Handler {
+	return s.api
+}
+
 func (s *Server) Run(addr ...string) error {
+	s.start()
 	return s.api.Run(addr...)
 }
 
 func (s *Server) HardwareInitSeq() error {
-	driver.ReadID(s.adc.Connection())
+	id, err := s.xmega.ID()
+	if err != nil {
+		return fmt.Errorf("failed to read board id: %v", err)
+	}
+	s.serial = fmt.Sprintf("%x", id)
 	time.Sleep(100 * time.Millisecond)
 
-	if err := driver.TurnOnAllADC(s.adc.Connection()); err != nil {
-		return fmt.Errorf("failed to reset ADCs: %v", err)
+	if err := s.xmega.PowerADCs(); err != nil {
+		return fmt.Errorf("failed to power ADCs: %v", err)
 	}
 	time.Sleep(100 * time.Millisecond)
 
-	if err := driver.ResetAllADC(s.adc.Connection()); err != nil {
+	if err := s.xmega.ResetADCs(); err != nil {
 		return fmt.Errorf("failed to reset ADCs: %v", err)
 	}
 	time.Sleep(100 * time.Millisecond)
 
-	list, err := driver.DetectLogicConnString(s.adc.Connection())
+	list, err := driver.DetectLogicConnString(s.xmega)
 	if err != nil {
 		return fmt.Errorf("failed to detect logic analyzers conn string: %v", err)
 	}
 	s.logics = list
 	time.Sleep(100 * time.Millisecond)
 
-	if err := driver.EnableMCLK(s.adc.Connection()); err != nil {
+	if err := s.xmega.EnableMCLK(); err != nil {
 		return fmt.Errorf("failed to enable MCLK: %v", err)
 	}
 	time.Sleep(100 * time.Millisecond)
 
-	if err := driver.StatusLED(s.adc.Connection(), driver.On); err != nil {
+	if err := s.xmega.SetLED(true); err != nil {
 		return fmt.Errorf("failed to turn on LED: %v", err)
 	}
 	time.Sleep(5000 * time.Millisecond)
 
+	for i := range s.hd.Gains {
+		s.hd.Gains[i] = startupGain
+	}
+	if err := s.applyCalibration(); err != nil {
+		return fmt.Errorf("failed to apply calibration: %v", err)
+	}
+	if err := s.applySyncCalibration(); err != nil {
+		return fmt.Errorf("failed to apply sync offsets: %v", err)
+	}
 	driver.SendSyncSignal()
-	s.adc.CilabrateChOffset(s.logics[0], s.Debug)
 
 	return nil
 }
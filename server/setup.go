@@ -1,19 +1,22 @@
 package server
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/json"
+	"encoding/binary"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/MShoaei/quakeADC/driver"
+	"github.com/MShoaei/quakeADC/record"
+	"github.com/MShoaei/quakeADC/sensor"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/spf13/afero"
@@ -23,33 +26,38 @@ func (s *Server) SetGainsHandler(c *gin.Context) {
 	const MSBMask uint32 = 0x00ff0000
 	const MidMask uint32 = 0x0000ff00
 	const LSBMask uint32 = 0x000000ff
-	gains := [24]uint32{}
-	if err := c.BindJSON(&gains); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+	gains := GainsRequest{}
+	if !bindJSON(c, &gains) {
 		return
 	}
+	if !s.acquireHardware(c, workCommand) {
+		return
+	}
+	defer s.hw.release()
+	for i, g := range gains {
+		if g < 1 || uint64(g)*uint64(s.GainMultiply) > 0xffffff {
+			abortWithError(c, invalidField(fmt.Sprintf("gains[%d]", i), "must be between 1 and %d", 0xffffff/s.GainMultiply))
+			return
+		}
+	}
 	// for i := 0; i < len(gains); i++ {
 	// 	gains[i] *= gainMultiply
 	// }
 	opts := driver.ChannelGainOpts{Write: true}
 	for i := 0; i < len(gains); i++ {
 		opts.Channel = uint8(i) % 8
-		val := gains[i] * s.GainMultiply
+		val := s.calibration.Calibration.GainRegister(i, gains[i]*s.GainMultiply)
 		opts.Offset[0] = uint8((val & MSBMask) >> 16)
 		opts.Offset[1] = uint8((val & MidMask) >> 8)
 		opts.Offset[2] = uint8(val & LSBMask)
-		log.Println(opts.Offset)
 		if _, err := s.adc.ChannelGain(opts, uint8(i/8)+1, s.Debug); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": err.Error(),
-			})
+			abortWithError(c, hardwareError(err))
 			return
 		}
 	}
 	s.hd.Gains = gains
-	c.JSON(http.StatusOK, nil)
+	s.event(c, EventGains, map[string]interface{}{"gains": gains, "multiplier": s.GainMultiply}, "gains changed")
+	c.JSON(http.StatusOK, gin.H{})
 }
 
 func (s *Server) GetGainsHandler(c *gin.Context) {
@@ -59,41 +67,52 @@ func (s *Server) GetGainsHandler(c *gin.Context) {
 }
 
 func (s *Server) SetChannelsHandler(c *gin.Context) {
-	ch := [24]bool{}
-	if err := c.BindJSON(&ch); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+	ch := ChannelsRequest{}
+	if !bindJSON(c, &ch) {
 		return
 	}
+	if !s.acquireHardware(c, workCommand) {
+		return
+	}
+	defer s.hw.release()
 
 	force := false
 	if !(ch[0] || ch[1] || ch[2] || ch[3]) {
 		ch[0] = true
 		force = true
 	}
-	s.hd.EnabledChannels = [24]bool{}
-	opts := driver.ChStandbyOpts{
-		Write:    true,
-		Channels: [8]bool{},
+	s.hd.EnabledChannels = [24]bool(ch)
+	err := s.applyChannelStandby()
+	if force {
+		s.hd.EnabledChannels[0] = false
+	}
+	if err != nil {
+		abortWithError(c, hardwareError(err))
+		return
 	}
-	for i, enable := range ch {
-		s.hd.EnabledChannels[i] = enable
+	s.event(c, EventChannels, map[string]interface{}{"channels": s.hd.EnabledChannels}, "enabled channels changed")
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// applyChannelStandby puts the disabled channels in standby. The first
+// channel is never in standby.
+func (s *Server) applyChannelStandby() error {
+	opts := driver.ChStandbyOpts{Write: true}
+	for i, enable := range s.hd.EnabledChannels {
 		opts.Channels[i%8] = !enable
 		if i%8 == 7 {
 			if i < 8 {
 				opts.Channels[0] = false
 			}
 			log.Println(opts, uint8(i/8)+1)
-			s.adc.ChStandby(opts, uint8(i/8)+1)
+			if _, _, err := s.adc.ChStandby(opts, uint8(i/8)+1); err != nil {
+				return err
+			}
 			opts.Channels = [8]bool{}
 			time.Sleep(100 * time.Millisecond)
 		}
 	}
-	if force {
-		s.hd.EnabledChannels[0] = false
-	}
-	c.Status(http.StatusOK)
+	return nil
 }
 
 func (s *Server) GetChannelsHandler(c *gin.Context) {
@@ -104,219 +123,298 @@ func (s *Server) GetChannelsHandler(c *gin.Context) {
 }
 
 func (s *Server) SetupHandler(c *gin.Context) {
-	if s.sigrokRunning {
-		c.JSON(http.StatusServiceUnavailable, gin.H{
-			"error": "sampling is already running",
-		})
+	if s.shuttingDown() {
+		abortWithError(c, errShuttingDown())
+		return
+	}
+	setupData := SetupRequest{}
+	if !bindJSON(c, &setupData) {
 		return
 	}
-	setupData := struct {
-		StartMode        string  `json:"startMode"`
-		TriggerThreshold int     `json:"threshold"`
-		TriggerChannel   int     `json:"triggerChannel"`
-		RecordTime       int     `json:"recordTime"`
-		SamplingTime     float32 `json:"samplingTime"`
-		Window           int     `json:"window"`
-		FileName         string  `json:"fileName"`
-	}{}
-	if err := c.BindJSON(&setupData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+	if strings.ToLower(setupData.StartMode) == "trigger" {
+		abortWithError(c, newError(http.StatusNotImplemented, "trigger start mode is not implemented"))
 		return
 	}
-	log.Println(setupData)
-	if setupData.Window < 1 || setupData.Window > 100 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid window size. Should be between 1 and 100",
-		})
+	// the active project can not be changed, renamed or deleted while recording
+	if !s.acquireHardware(c, workRecording) {
 		return
 	}
+	defer s.hw.release()
 	s.hd.Window = setupData.Window
 
-	if setupData.FileName == "" || s.activePath == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid file name or project name",
-		})
+	if err := s.dataFS.MkdirAll(s.activePath, os.ModeDir|0755); err != nil {
+		abortWithError(c, err)
 		return
 	}
-	if err := s.dataFS.MkdirAll(s.activePath, os.ModeDir|0755); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid file project path",
-		})
+	p := filepath.Join(s.activePath, setupData.FileName)
+	if exists, _ := afero.Exists(s.dataFS, p); exists {
+		abortWithError(c, &pathError{Path: p, Reason: pathInUse})
 		return
 	}
 
-	if exists, _ := afero.Exists(s.dataFS, filepath.Join(s.activePath, setupData.FileName)); exists {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "file already exists",
-		})
+	size, scratch := s.recordingEstimate(setupData.StartMode, setupData.RecordTime, setupData.SamplingTime)
+	warning, err := s.checkStorage(size+scratch, true)
+	if err != nil {
+		abortWithStatus(c, http.StatusInsufficientStorage, err)
 		return
 	}
 
-	s.dataFile, _ = s.dataFS.Create(filepath.Join(s.activePath, setupData.FileName))
+	dataFile, err := s.dataFS.Create(filepath.Join(s.activePath, setupData.FileName))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	s.dataFile = dataFile
 	defer s.dataFile.Close()
+	s.recordingFile.Store(path.Join("/", dataFile.Name()))
+	defer s.recordingFile.Store("")
+
+	s.event(c, EventRecording, map[string]interface{}{"file": p, "setup": setupData}, "recording %s started", p)
+	stopped := map[string]interface{}{"file": p}
+	defer func() {
+		stopped["status"] = c.Writer.Status()
+		s.event(c, EventRecording, stopped, "recording %s stopped", p)
+	}()
 
 	switch strings.ToLower(setupData.StartMode) {
 	case "asap":
-		configureSamplingTime(s.adc, setupData.SamplingTime)
+		profile := s.configureProfile(setupData.SamplingTime)
 		driver.SendSyncSignal()
-		driver.SamplingStart(s.adc.Connection())
-		defer driver.SamplingEnd(s.adc.Connection())
+		if err := s.xmega.StartSampling(); err != nil {
+			abortWithError(c, hardwareError(err))
+			return
+		}
+		defer s.stopSampling()
+		header := s.recordHeader("asap", profile, setupData.Shot)
 		f, size, err := driver.ExecSigrokCLI(s.logics[0], setupData.RecordTime)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": err.Error(),
-			})
+			abortWithError(c, hardwareError(err))
 			return
 		}
 		defer f.Close()
 
-		if err := json.NewEncoder(s.dataFile).Encode(s.hd); err != nil {
-			// this should never happen!
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": fmt.Errorf("error while encoding enabled channels: %v", err).Error(),
-			})
+		frames, full, err := s.writeRecording(f, int(size), header)
+		if err != nil {
+			abortWithError(c, err)
 			return
 		}
-		driver.Convert(f, s.dataFile, int(size), s.hd.EnabledChannels)
-		c.JSON(http.StatusOK, nil)
+		s.index.update(p)
+		s.metrics.recorded("asap")
+		stopped["frames"], stopped["full"] = frames, full
+		c.JSON(http.StatusOK, recordingResult(frames, full, warning))
 		return
 	case "hammer":
-		configureSamplingTime(s.adc, setupData.SamplingTime)
+		profile := s.configureProfile(setupData.SamplingTime)
 		driver.SendSyncSignal()
-		driver.SamplingStart(s.adc.Connection())
-		defer driver.SamplingEnd(s.adc.Connection())
+		if err := s.xmega.StartSampling(); err != nil {
+			abortWithError(c, hardwareError(err))
+			return
+		}
+		defer s.stopSampling()
 
-		rawData := driver.ReadWithThreshold(setupData.TriggerThreshold, setupData.RecordTime, setupData.TriggerChannel)
+		threshold, err := s.thresholdCounts(setupData.TriggerThreshold, setupData.ThresholdUnit, setupData.TriggerChannel)
+		if err != nil {
+			abortWithStatus(c, http.StatusBadRequest, err)
+			return
+		}
+		rawData, err := driver.ReadWithThreshold(int(threshold), setupData.RecordTime, setupData.TriggerChannel)
+		if err != nil {
+			abortWithError(c, hardwareError(err))
+			return
+		}
 		if rawData == nil {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": "did not reach threshold",
-			})
+			abortWithError(c, newError(http.StatusNotFound, "did not reach threshold"))
 			return
 		}
-		if err := json.NewEncoder(s.dataFile).Encode(s.hd); err != nil {
-			// this should never happen!
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": fmt.Errorf("error while encoding enabled channels: %v", err).Error(),
-			})
+		s.metrics.triggered()
+		s.event(c, EventTrigger, map[string]interface{}{
+			"channel":   setupData.TriggerChannel,
+			"threshold": setupData.TriggerThreshold,
+			"unit":      setupData.ThresholdUnit,
+			"counts":    threshold,
+		}, "threshold reached on channel %d", setupData.TriggerChannel+1)
+		// recording starts right after the threshold is reached
+		header := s.recordHeader("hammer", profile, setupData.Shot)
+		header.StartTime = header.StartTime.Add(-time.Duration(setupData.RecordTime) * time.Second)
+		header.TriggerIndex = 0
+		frames, full, err := s.writeRecording(bytes.NewReader(rawData), len(rawData), header)
+		if err != nil {
+			abortWithError(c, err)
 			return
 		}
-		driver.Convert(bytes.NewReader(rawData), s.dataFile, len(rawData), s.hd.EnabledChannels)
-		c.JSON(http.StatusOK, nil)
-		return
-	case "trigger":
-		c.JSON(http.StatusNotImplemented, gin.H{
-			"error": "not implemented",
-		})
+		s.index.update(p)
+		s.metrics.recorded("hammer")
+		stopped["frames"], stopped["full"] = frames, full
+		c.JSON(http.StatusOK, recordingResult(frames, full, warning))
 		return
 	}
 }
 
+// thresholdCounts converts a trigger threshold in unit to ADC counts of channel ch
+// using the sensor connected to it in the active project
+func (s *Server) thresholdCounts(value float64, unit string, ch int) (int32, error) {
+	u, err := sensor.ParseUnit(unit)
+	if err != nil {
+		return 0, err
+	}
+	if u == sensor.Counts {
+		return int32(value), nil
+	}
+	if ch < 0 || ch >= len(s.hd.Gains) {
+		return 0, fmt.Errorf("invalid trigger channel %d", ch)
+	}
+	m, err := readProjectManifest(s.dataFS, s.activePath)
+	if err != nil {
+		return 0, err
+	}
+	return m.Sensors[ch].WithGain(float64(s.hd.Gains[ch])).Counts(value, u)
+}
+
+// ReadDataHandler streams the frames of a recording over a websocket. The
+// request is checked before upgrading so failures are plain error responses.
 func (s *Server) ReadDataHandler(c *gin.Context) {
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	file := c.Query("file")
+	if file == "" {
+		abortWithError(c, invalidField("file", "required"))
+		return
+	}
+	unit, err := sensor.ParseUnit(c.Query("unit"))
 	if err != nil {
-		log.Println("WebSocket creation error: ", err)
+		abortWithError(c, invalidField("unit", "%v", err))
 		return
 	}
-	var file string
-	if file = c.Query("file"); file == "" {
-		_ = conn.WriteJSON(gin.H{
-			"error": "invalid query parameter value",
-		})
-		_ = conn.Close()
+	p, err := existingPath(s.dataFS, file, false)
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
-
-	f, err := s.dataFS.Open(file)
+	f, err := s.dataFS.Open(p)
 	if err != nil {
-		_ = conn.WriteJSON(gin.H{
-			"error": fmt.Errorf("failed to open file: %v", err),
-		})
-		_ = conn.Close()
+		abortWithError(c, fmt.Errorf("failed to open file: %v", err))
 		return
 	}
 	defer f.Close()
 
-	var header HeaderData
-	b, _ := ioutil.ReadAll(f)
-	infoBytes, _ := bufio.NewReader(bytes.NewReader(b)).ReadBytes('\n')
-	if err := json.Unmarshal(infoBytes, &header); err != nil {
-		_ = conn.WriteJSON(gin.H{
-			"error": err.Error(),
-		})
-		_ = conn.Close()
+	r, err := record.NewReader(f)
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
-
-	count := 0
-	for _, enabled := range header.EnabledChannels {
-		if enabled {
-			count++
+	// samples are sent as int32 counts or as float32 in the requested unit
+	scales := make([]float64, len(r.Header.Channels))
+	offsets := make([]float64, len(r.Header.Channels))
+	for i, ch := range r.Header.Channels {
+		if scales[i], offsets[i], err = ch.Model().Factor(unit); err != nil {
+			abortWithError(c, invalidField("unit", "channel %d: %v", ch.Index+1, err))
+			return
 		}
 	}
 
-	b = b[len(infoBytes):]
-
-	dataLength := count * 4
-	for i := 0; i < len(b); i += dataLength {
-		_ = conn.WriteMessage(websocket.BinaryMessage, b[i:i+dataLength])
+	conn, err := s.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Println("WebSocket creation error: ", err)
+		return
+	}
+	frame := make([]byte, r.Header.FrameSize())
+	for {
+		if err := r.ReadFrameBytes(frame); err != nil {
+			if err != io.EOF {
+				s.l.Errorf("failed to read %s: %v", file, err)
+			}
+			break
+		}
+		if unit != sensor.Counts {
+			for i := range scales {
+				v := float64(int32(binary.LittleEndian.Uint32(frame[i*4:])))*scales[i] + offsets[i]
+				binary.LittleEndian.PutUint32(frame[i*4:], math.Float32bits(float32(v)))
+			}
+		}
+		_ = conn.WriteMessage(websocket.BinaryMessage, frame)
 	}
 	_ = conn.Close()
 }
 
 func (s *Server) ReadDataPostHandler(c *gin.Context) {
-	form := struct {
-		File string `json:"file"`
-	}{}
-
-	if err := c.BindJSON(&form); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "failed with error",
-		})
+	form := FileRequest{}
+	if !bindJSON(c, &form) {
 		return
 	}
 
-	info, err := s.dataFS.Stat(form.File)
+	p, err := existingPath(s.dataFS, form.File, false)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": fmt.Errorf("failed to open file: %v", err),
-		})
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
-
-	f, _ := s.dataFS.Open(form.File)
-	b, _ := bufio.NewReader(f).ReadBytes('\n')
-	var header HeaderData
-	if err := json.Unmarshal(b, &header); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
+	f, err := s.dataFS.Open(p)
+	if err != nil {
+		abortWithError(c, fmt.Errorf("failed to open file: %v", err))
 		return
 	}
 	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	r, err := record.NewReader(f)
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
 
-	count := int64(0)
-	for _, enabled := range header.EnabledChannels {
-		if enabled {
-			count++
-		}
+	units := make([]sensor.Unit, len(r.Header.Channels))
+	for i, ch := range r.Header.Channels {
+		units[i] = ch.Model().Unit()
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"channels": header.EnabledChannels,
-		"window":   header.Window,
-		"size":     (info.Size() - int64(len(b))) / (count * 4),
+		"units":      units,
+		"channels":   r.Header.EnabledChannels(),
+		"window":     r.Header.Window,
+		"size":       r.FrameCount(info.Size()),
+		"version":    r.Header.Version,
+		"sampleRate": r.Header.SampleRate,
+		"startTime":  r.Header.StartTime,
 	})
 }
 
-func configureSamplingTime(adc *driver.Adc7768, st float32) {
+// samplingTimes are the sampling intervals in microseconds supported by configureSamplingTime
+var samplingTimes = []float32{16, 31.25, 62.5, 125, 250, 500, 1000, 2000}
+
+func validSamplingTime(st float32) bool {
+	for _, t := range samplingTimes {
+		if st == t {
+			return true
+		}
+	}
+	return false
+}
+
+// stopSampling stops the ADCs after a recording. The recording is already
+// written so a failure is only logged.
+func (s *Server) stopSampling() {
+	if err := s.xmega.StopSampling(); err != nil {
+		s.l.Errorf("failed to stop sampling: %v", err)
+	}
+}
+
+// configureProfile configures the ADCs for the sampling interval st in
+// microseconds and keeps the profile to restore it after a reset
+func (s *Server) configureProfile(st float32) record.Profile {
+	s.profile = configureSamplingTime(s.adc, st)
+	return s.profile
+}
+
+// configureSamplingTime sets the decimation rate and power mode of every ADC
+// for the sampling interval st in microseconds and returns the applied profile
+func configureSamplingTime(adc *driver.Adc7768, st float32) record.Profile {
 	chOpt := driver.ChModeOpts{Write: true, FType: 1}
 	powerOpt := driver.PowerModeOpts{Write: true}
 	interfaceOpt := driver.InterfaceConfOpts{Write: true, CRCSelect: 0}
+	profile := record.Profile{SamplingTime: st}
 	switch st {
 	case 16:
-		return
+		return profile
 	case 31.25:
 		chOpt.DecRate = 128
 		powerOpt.Power = 2
@@ -348,7 +446,7 @@ func configureSamplingTime(adc *driver.Adc7768, st float32) {
 		powerOpt.MCLKDiv = 0
 		interfaceOpt.DclkDiv = 0
 	case 2000:
-		return
+		return profile
 	}
 
 	for i := uint8(1); i < 10; i++ {
@@ -357,4 +455,10 @@ func configureSamplingTime(adc *driver.Adc7768, st float32) {
 		adc.PowerMode(powerOpt, i)
 		adc.InterfaceConf(interfaceOpt, i)
 	}
+	profile.DecRate = chOpt.DecRate
+	profile.FType = chOpt.FType
+	profile.PowerMode = powerOpt.Power
+	profile.MCLKDiv = powerOpt.MCLKDiv
+	profile.DclkDiv = interfaceOpt.DclkDiv
+	return profile
 }
@@ -0,0 +1,326 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-cmd/cmd"
+)
+
+// usbMountRoot is the directory removable devices are mounted under
+const usbMountRoot = "/mnt/USB"
+
+// StorageDevice is a file system on a removable block device
+type StorageDevice struct {
+	// Name is the kernel name of the partition e.g. sda1
+	Name string `json:"name"`
+
+	// Disk is the kernel name of the device holding the partition e.g. sda
+	Disk       string `json:"disk"`
+	Label      string `json:"label"`
+	FSType     string `json:"fsType"`
+	Size       uint64 `json:"size"`
+	MountPoint string `json:"mountPoint"`
+
+	// Free is the number of bytes available and is only known while mounted
+	Free uint64 `json:"free"`
+}
+
+type StorageEventType string
+
+const (
+	StorageAdded   StorageEventType = "added"
+	StorageRemoved StorageEventType = "removed"
+)
+
+// StorageEvent reports a removable disk being plugged in or removed
+type StorageEvent struct {
+	Time time.Time        `json:"time"`
+	Type StorageEventType `json:"type"`
+	Disk string           `json:"disk"`
+}
+
+// StorageManager lists, mounts and ejects removable mass storage devices
+type StorageManager interface {
+	Devices() ([]StorageDevice, error)
+	Mount(name string) (StorageDevice, error)
+
+	// Unmount flushes pending writes and unmounts a single file system
+	Unmount(name string) error
+
+	// Eject unmounts every file system of the disk holding name and powers it off
+	Eject(name string) error
+
+	// Watch polls for disks being plugged in or removed until stop is closed
+	Watch(interval time.Duration, stop <-chan struct{}) <-chan StorageEvent
+}
+
+// lsblkStorage manages devices with lsblk, mount and eject. The commands
+// which change the system are run with sudo.
+type lsblkStorage struct {
+	sysfs string
+
+	// uid and gid own the files of the file systems without owners
+	uid, gid int
+}
+
+// NewStorageManager returns the StorageManager of the host system. Devices
+// are mounted for the user running the server.
+func NewStorageManager() StorageManager {
+	return lsblkStorage{sysfs: "/sys/block", uid: os.Getuid(), gid: os.Getgid()}
+}
+
+// run executes a command and returns its output or an error holding its stderr
+func run(name string, args ...string) ([]string, error) {
+	status := <-cmd.NewCmd(name, args...).Start()
+	if status.Error != nil {
+		return nil, status.Error
+	}
+	if status.Exit != 0 {
+		return nil, fmt.Errorf("%s %s failed: %s", name, strings.Join(args, " "), strings.Join(status.Stderr, " "))
+	}
+	return status.Stdout, nil
+}
+
+func (l lsblkStorage) Devices() ([]StorageDevice, error) {
+	out, err := run("lsblk", "-J", "-b", "-o", "NAME,LABEL,FSTYPE,SIZE,MOUNTPOINT,RM,HOTPLUG,TYPE")
+	if err != nil {
+		return nil, err
+	}
+	devices, err := parseLsblk([]byte(strings.Join(out, "\n")))
+	if err != nil {
+		return nil, err
+	}
+	for i := range devices {
+		if devices[i].MountPoint == "" {
+			continue
+		}
+		if free, err := freeSpace(devices[i].MountPoint); err == nil {
+			devices[i].Free = free
+		}
+	}
+	return devices, nil
+}
+
+func (l lsblkStorage) device(name string) (StorageDevice, error) {
+	devices, err := l.Devices()
+	if err != nil {
+		return StorageDevice{}, err
+	}
+	for _, dev := range devices {
+		if dev.Name == name {
+			return dev, nil
+		}
+	}
+	return StorageDevice{}, fmt.Errorf("removable device %q not found", name)
+}
+
+// mountOptions are the options of mount for a file system type
+func (l lsblkStorage) mountOptions(fsType string) []string {
+	switch fsType {
+	case "vfat", "exfat", "ntfs":
+		// these file systems have no owners so files are given to the user running the server
+		return []string{"-o", fmt.Sprintf("uid=%d,gid=%d", l.uid, l.gid)}
+	}
+	return nil
+}
+
+func (l lsblkStorage) Mount(name string) (StorageDevice, error) {
+	dev, err := l.device(name)
+	if err != nil {
+		return dev, err
+	}
+	if dev.MountPoint != "" {
+		return dev, fmt.Errorf("%s is already mounted on %s", name, dev.MountPoint)
+	}
+	if dev.FSType == "" {
+		return dev, fmt.Errorf("%s has no file system", name)
+	}
+
+	dir := path.Join(usbMountRoot, name)
+	if _, err := run("/usr/bin/sudo", "mkdir", "-p", dir); err != nil {
+		return dev, err
+	}
+	args := append([]string{"mount"}, l.mountOptions(dev.FSType)...)
+	args = append(args, path.Join("/dev", name), dir)
+	if _, err := run("/usr/bin/sudo", args...); err != nil {
+		return dev, err
+	}
+	return l.device(name)
+}
+
+func (l lsblkStorage) Unmount(name string) error {
+	dev, err := l.device(name)
+	if err != nil {
+		return err
+	}
+	if dev.MountPoint == "" {
+		return fmt.Errorf("%s is not mounted", name)
+	}
+	return unmount(dev)
+}
+
+func unmount(dev StorageDevice) error {
+	if _, err := run("sync", "-f", dev.MountPoint); err != nil {
+		return err
+	}
+	_, err := run("/usr/bin/sudo", "umount", path.Join("/dev", dev.Name))
+	return err
+}
+
+func (l lsblkStorage) Eject(name string) error {
+	devices, err := l.Devices()
+	if err != nil {
+		return err
+	}
+	disk := ""
+	for _, dev := range devices {
+		if dev.Name == name || dev.Disk == name {
+			disk = dev.Disk
+		}
+	}
+	if disk == "" {
+		return fmt.Errorf("removable device %q not found", name)
+	}
+	for _, dev := range devices {
+		if dev.Disk == disk && dev.MountPoint != "" {
+			if err := unmount(dev); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = run("/usr/bin/sudo", "eject", path.Join("/dev", disk))
+	return err
+}
+
+// removableDisks lists the removable disks in sysfs
+func (l lsblkStorage) removableDisks() map[string]bool {
+	res := make(map[string]bool)
+	entries, err := ioutil.ReadDir(l.sysfs)
+	if err != nil {
+		return res
+	}
+	for _, e := range entries {
+		b, err := ioutil.ReadFile(path.Join(l.sysfs, e.Name(), "removable"))
+		if err == nil && strings.TrimSpace(string(b)) == "1" {
+			res[e.Name()] = true
+		}
+	}
+	return res
+}
+
+func (l lsblkStorage) Watch(interval time.Duration, stop <-chan struct{}) <-chan StorageEvent {
+	events := make(chan StorageEvent)
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		known := l.removableDisks()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			current := l.removableDisks()
+			var changes []StorageEvent
+			now := time.Now().UTC()
+			for disk := range current {
+				if !known[disk] {
+					changes = append(changes, StorageEvent{Time: now, Type: StorageAdded, Disk: disk})
+				}
+			}
+			for disk := range known {
+				if !current[disk] {
+					changes = append(changes, StorageEvent{Time: now, Type: StorageRemoved, Disk: disk})
+				}
+			}
+			known = current
+			for _, e := range changes {
+				select {
+				case events <- e:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+	return events
+}
+
+// lsblkValue accepts the strings written by older lsblk versions as well as
+// numbers and booleans written by newer ones
+type lsblkValue string
+
+func (v *lsblkValue) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*v = lsblkValue(s)
+		return nil
+	}
+	if string(b) == "null" {
+		*v = ""
+		return nil
+	}
+	*v = lsblkValue(b)
+	return nil
+}
+
+func (v lsblkValue) bool() bool {
+	return v == "1" || v == "true"
+}
+
+type lsblkDevice struct {
+	Name       lsblkValue    `json:"name"`
+	Label      lsblkValue    `json:"label"`
+	FSType     lsblkValue    `json:"fstype"`
+	Size       lsblkValue    `json:"size"`
+	MountPoint lsblkValue    `json:"mountpoint"`
+	RM         lsblkValue    `json:"rm"`
+	Hotplug    lsblkValue    `json:"hotplug"`
+	Type       lsblkValue    `json:"type"`
+	Children   []lsblkDevice `json:"children"`
+}
+
+func (d lsblkDevice) device(disk string) StorageDevice {
+	size, _ := strconv.ParseUint(string(d.Size), 10, 64)
+	return StorageDevice{
+		Name:       string(d.Name),
+		Disk:       disk,
+		Label:      string(d.Label),
+		FSType:     string(d.FSType),
+		Size:       size,
+		MountPoint: string(d.MountPoint),
+	}
+}
+
+// parseLsblk returns the file systems on removable disks in the output of lsblk -J -b.
+// Disks formatted without a partition table are listed as a single file system.
+func parseLsblk(b []byte) ([]StorageDevice, error) {
+	var out struct {
+		BlockDevices []lsblkDevice `json:"blockdevices"`
+	}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("invalid lsblk output: %v", err)
+	}
+	res := make([]StorageDevice, 0)
+	for _, disk := range out.BlockDevices {
+		if disk.Type != "disk" || !(disk.RM.bool() || disk.Hotplug.bool()) {
+			continue
+		}
+		if len(disk.Children) == 0 && disk.FSType != "" {
+			res = append(res, disk.device(string(disk.Name)))
+		}
+		for _, part := range disk.Children {
+			res = append(res, part.device(string(disk.Name)))
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestParseLsblk(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []StorageDevice
+	}{
+		{
+			name: "typed values",
+			output: `{"blockdevices": [
+				{"name":"sda", "label":null, "fstype":null, "size":31440502784, "mountpoint":null, "rm":true, "hotplug":true, "type":"disk",
+					"children": [
+						{"name":"sda1", "label":"DATA", "fstype":"vfat", "size":31439454208, "mountpoint":"/mnt/USB/sda1", "rm":true, "hotplug":true, "type":"part"}
+					]
+				},
+				{"name":"mmcblk0", "label":null, "fstype":null, "size":15931539456, "mountpoint":null, "rm":false, "hotplug":false, "type":"disk",
+					"children": [
+						{"name":"mmcblk0p1", "label":"boot", "fstype":"vfat", "size":268435456, "mountpoint":"/boot", "rm":false, "hotplug":false, "type":"part"}
+					]
+				}
+			]}`,
+			want: []StorageDevice{
+				{Name: "sda1", Disk: "sda", Label: "DATA", FSType: "vfat", Size: 31439454208, MountPoint: "/mnt/USB/sda1"},
+			},
+		},
+		{
+			name: "string values without partition table",
+			output: `{"blockdevices": [
+				{"name":"sdb", "label":"STICK", "fstype":"exfat", "size":"8004304896", "mountpoint":null, "rm":"1", "hotplug":"1", "type":"disk"}
+			]}`,
+			want: []StorageDevice{
+				{Name: "sdb", Disk: "sdb", Label: "STICK", FSType: "exfat", Size: 8004304896},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLsblk([]byte(tt.output))
+			if err != nil {
+				t.Fatalf("parseLsblk() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLsblk() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+type fakeStorage struct {
+	devices []StorageDevice
+
+	// hotplug is returned by Watch when it is set
+	hotplug chan StorageEvent
+}
+
+func (f fakeStorage) Devices() ([]StorageDevice, error)        { return f.devices, nil }
+func (f fakeStorage) Mount(name string) (StorageDevice, error) { return StorageDevice{}, nil }
+func (f fakeStorage) Unmount(name string) error                { return nil }
+func (f fakeStorage) Eject(name string) error                  { return nil }
+func (f fakeStorage) Watch(interval time.Duration, stop <-chan struct{}) <-chan StorageEvent {
+	if f.hotplug != nil {
+		return f.hotplug
+	}
+	return make(chan StorageEvent)
+}
+
+func TestMountOptions(t *testing.T) {
+	l := lsblkStorage{uid: 1001, gid: 1002}
+	if got := l.mountOptions("vfat"); !reflect.DeepEqual(got, []string{"-o", "uid=1001,gid=1002"}) {
+		t.Errorf("mountOptions(vfat) = %v", got)
+	}
+	if got := l.mountOptions("ext4"); got != nil {
+		t.Errorf("mountOptions(ext4) = %v, want none", got)
+	}
+}
+
+func TestWatchStorage(t *testing.T) {
+	storage := fakeStorage{hotplug: make(chan StorageEvent, maxHotplugEvents+2)}
+	s := NewServer(afero.NewMemMapFs(), afero.NewMemMapFs(), storage, nil, false)
+	start := time.Now().UTC()
+	for i := 0; i < maxHotplugEvents+2; i++ {
+		storage.hotplug <- StorageEvent{Time: start.Add(time.Duration(i) * time.Second), Type: StorageAdded, Disk: "sda"}
+	}
+	close(storage.hotplug)
+	s.watchStorage(nil)
+
+	w := httptest.NewRecorder()
+	s.api.ServeHTTP(w, httptest.NewRequest("GET", "/storage", nil))
+	var res struct {
+		Hotplug []StorageEvent `json:"hotplug"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Hotplug) != maxHotplugEvents || !res.Hotplug[0].Time.Equal(start.Add((maxHotplugEvents+1)*time.Second)) {
+		t.Errorf("hotplug = %+v, want the %d newest events from the newest", res.Hotplug, maxHotplugEvents)
+	}
+	if events, _ := s.events.query(eventQuery{types: map[string]bool{EventStorage: true}}); len(events) != maxHotplugEvents+2 {
+		t.Errorf("journaled %d storage events, want %d", len(events), maxHotplugEvents+2)
+	}
+}
+
+func TestExportDevice(t *testing.T) {
+	storage := fakeStorage{devices: []StorageDevice{
+		{Name: "sda1", Disk: "sda"},
+		{Name: "sdb1", Disk: "sdb", MountPoint: "/mnt/USB/sdb1"},
+	}}
+	s := NewServer(afero.NewMemMapFs(), afero.NewMemMapFs(), storage, nil, false)
+
+	if dev, err := s.exportDevice(""); err != nil || dev.Name != "sdb1" {
+		t.Errorf("exportDevice(\"\") = %v, %v, want sdb1", dev.Name, err)
+	}
+	if _, err := s.exportDevice("sda1"); err == nil {
+		t.Errorf("exportDevice(\"sda1\") expected error for unmounted device")
+	}
+}
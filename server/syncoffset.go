@@ -0,0 +1,241 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/MShoaei/quakeADC/driver"
+	"github.com/MShoaei/quakeADC/record"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultSyncSamplingTime is the sampling interval in microseconds of sync offset captures
+const defaultSyncSamplingTime = 31.25
+
+// SyncCalibrationRecord is the stored sync offset calibration of a board
+type SyncCalibrationRecord struct {
+	Serial string    `json:"serial"`
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+
+	// SamplingTime the skew was measured with in microseconds
+	SamplingTime float32 `json:"samplingTime"`
+
+	driver.SyncCalibrationResult
+}
+
+func syncCalibrationName(serial string) string {
+	return serial + ".sync"
+}
+
+// loadSyncCalibration reads the sync offsets of the board. A board which was
+// never calibrated has zero offsets and a zero time.
+func (s *Server) loadSyncCalibration() (SyncCalibrationRecord, error) {
+	rec := SyncCalibrationRecord{Serial: s.serial}
+	err := s.readCalibrationFile(syncCalibrationName(s.serial), &rec)
+	return rec, err
+}
+
+// applySyncCalibration writes the stored sync offsets of the board to the
+// ADCs. They take effect on the next sync pulse.
+func (s *Server) applySyncCalibration() error {
+	rec, err := s.loadSyncCalibration()
+	if err != nil {
+		return err
+	}
+	if rec.Time.IsZero() {
+		s.l.Warnf("sync offsets of board %s are not calibrated", s.serial)
+	}
+	s.syncCalibration = rec
+	return s.adc.ApplySyncOffsets(rec.Offsets)
+}
+
+// GetSyncCalibrationHandler returns the sync offsets of the board and their age
+func (s *Server) GetSyncCalibrationHandler(c *gin.Context) {
+	rec := s.syncCalibration
+	res := gin.H{
+		"calibrated": !rec.Time.IsZero(),
+		"serial":     s.serial,
+		"offsets":    rec.Offsets,
+	}
+	if !rec.Time.IsZero() {
+		res["time"] = rec.Time
+		res["source"] = rec.Source
+		res["age"] = time.Since(rec.Time).Round(time.Second)
+		res["result"] = rec.SyncCalibrationResult
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// CalibrateSyncHandler measures the skew between the channels recording a
+// common signal and stores the sync offsets aligning them. A recording made
+// by this board is applied at once, otherwise at the next start of its board.
+func (s *Server) CalibrateSyncHandler(c *gin.Context) {
+	req := SyncCalibrationRequest{}
+	if !bindJSON(c, &req) {
+		return
+	}
+	if s.shuttingDown() {
+		abortWithError(c, errShuttingDown())
+		return
+	}
+
+	rec := SyncCalibrationRecord{Serial: s.serial, Time: time.Now(), Source: req.Source}
+	var err error
+	if req.Source == syncSourceRecording {
+		if err = s.planSyncFromRecording(&rec, req); err != nil {
+			abortWithStatus(c, http.StatusBadRequest, err)
+			return
+		}
+	} else if err = s.captureSyncCalibration(&rec, req); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	if err := s.writeCalibrationFile(syncCalibrationName(rec.Serial), rec); err != nil {
+		abortWithError(c, fmt.Errorf("failed to save sync offsets: %v", err))
+		return
+	}
+	applied := false
+	if rec.Serial == s.serial {
+		s.syncCalibration = rec
+		// a capture already left the offsets in the registers
+		applied = req.Source == syncSourceCapture
+		if !applied && s.adc != nil && s.hw.tryAcquire(workCalibration) {
+			err := s.adc.ApplySyncOffsets(rec.Offsets)
+			s.hw.release()
+			if err != nil {
+				abortWithError(c, hardwareError(err))
+				return
+			}
+			applied = true
+		}
+	}
+	s.event(c, EventCalibration, map[string]interface{}{
+		"source":  rec.Source,
+		"serial":  rec.Serial,
+		"offsets": rec.Offsets,
+		"applied": applied,
+	}, "sync offsets of board %s calibrated from %s", rec.Serial, rec.Source)
+	c.JSON(http.StatusOK, gin.H{
+		"serial":  rec.Serial,
+		"result":  rec.SyncCalibrationResult,
+		"applied": applied,
+	})
+}
+
+// captureSyncCalibration records the test signal on every channel, programs
+// the offsets aligning the channels and records it again to verify them. The
+// acquisition profile and the enabled channels are restored afterwards.
+func (s *Server) captureSyncCalibration(rec *SyncCalibrationRecord, req SyncCalibrationRequest) (err error) {
+	if s.adc == nil || s.xmega == nil || len(s.logics) == 0 {
+		return hardwareError(fmt.Errorf("no ADC connection"))
+	}
+	if !s.hw.tryAcquire(workCalibration) {
+		return errHardwareBusy(s.hw.current())
+	}
+	defer s.hw.release()
+	rec.SamplingTime = req.SamplingTime
+	if rec.SamplingTime == 0 {
+		rec.SamplingTime = defaultSyncSamplingTime
+	}
+
+	previous := s.profile
+	defer func() {
+		// recordings and resets use the profile of the last setup
+		if previous.SamplingTime != 0 {
+			s.configureProfile(previous.SamplingTime)
+		}
+		s.profile = previous
+		if standbyErr := s.applyChannelStandby(); err == nil && standbyErr != nil {
+			err = hardwareError(fmt.Errorf("failed to restore the enabled channels: %v", standbyErr))
+		}
+	}()
+	profile := s.configureProfile(rec.SamplingTime)
+	fMod, err := driver.ModulatorFrequency(profile.MCLKDiv)
+	if err != nil {
+		return err
+	}
+	opts := driver.SyncOptions{
+		Logic:              s.logics[0],
+		CaptureTime:        req.CaptureTime,
+		SampleRate:         1e6 / float64(rec.SamplingTime),
+		ModulatorFrequency: fMod,
+	}
+	if req.Reference > 0 {
+		opts.Reference = req.Reference - 1
+	}
+	res, err := s.adc.CalibrateSync(s.xmega, s.syncCalibration.Offsets, opts)
+	if err != nil {
+		// the registers may hold offsets which were not verified
+		if applyErr := s.adc.ApplySyncOffsets(s.syncCalibration.Offsets); applyErr != nil {
+			s.l.Errorf("failed to restore sync offsets: %v", applyErr)
+		}
+		return hardwareError(err)
+	}
+	rec.SyncCalibrationResult = res
+	return nil
+}
+
+// planSyncFromRecording measures the skew between the channels of a
+// recording of an impulse. The offsets are for the board which made it.
+func (s *Server) planSyncFromRecording(rec *SyncCalibrationRecord, req SyncCalibrationRequest) error {
+	p, err := existingPath(s.dataFS, req.File, false)
+	if err != nil {
+		return err
+	}
+	f, err := s.dataFS.Open(p)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	defer f.Close()
+	r, err := record.NewReader(f)
+	if err != nil {
+		return invalidField("file", "%v", err)
+	}
+
+	h := r.Header
+	if len(h.BoardSerials) > 0 {
+		rec.Serial = h.BoardSerials[0]
+	}
+	if rec.Serial == "" {
+		return invalidField("file", "the recording does not name its board")
+	}
+	fMod, err := driver.ModulatorFrequency(h.Profile.MCLKDiv)
+	if err != nil || h.Profile.DecRate == 0 || h.SampleRate <= 0 || len(h.Channels) == 0 {
+		return invalidField("file", "the recording does not describe its ADC clock")
+	}
+	rec.SamplingTime = h.Profile.SamplingTime
+
+	data, err := r.ReadAll()
+	if err != nil {
+		return invalidField("file", "%v", err)
+	}
+	var (
+		samples  [24][]float64
+		recorded [24]bool
+		current  driver.SyncOffsets
+	)
+	for i, ch := range h.Channels {
+		if ch.Index < 0 || ch.Index >= len(samples) {
+			continue
+		}
+		samples[ch.Index] = make([]float64, len(data[i]))
+		for j, v := range data[i] {
+			samples[ch.Index][j] = float64(v)
+		}
+		recorded[ch.Index] = true
+		current[ch.Index] = ch.SyncOffset
+	}
+	opts := driver.SyncOptions{SampleRate: h.SampleRate, ModulatorFrequency: fMod, Reference: req.Reference - 1}
+	if req.Reference == 0 {
+		opts.Reference = h.Channels[0].Index
+	}
+	res, err := driver.PlanSyncCalibration(samples, recorded, current, opts)
+	if err != nil {
+		return invalidField("reference", "%v", err)
+	}
+	rec.SyncCalibrationResult = res
+	return nil
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MShoaei/quakeADC/record"
+	"github.com/spf13/afero"
+)
+
+func TestCalibrateSyncFromRecording(t *testing.T) {
+	s := NewServer(afero.NewMemMapFs(), afero.NewMemMapFs(), &fakeStorage{}, nil, false)
+	h := record.Header{
+		Profile:      record.Profile{SamplingTime: 31.25, DecRate: 128, MCLKDiv: 2},
+		SampleRate:   32000,
+		BoardSerials: []string{"1e9702"},
+		Channels:     []record.Channel{{Index: 0, SyncOffset: 10}, {Index: 1, SyncOffset: 10}, {Index: 8, SyncOffset: 10}},
+	}
+	// one sample is 128 modulator clock periods so channel 9 is 64 periods late
+	delays := []float64{0, 0, 0.5}
+	frames := make([][]int32, 256)
+	for i := range frames {
+		for _, d := range delays {
+			x := (float64(i) - 100 - d) / 3
+			frames[i] = append(frames[i], int32(1e5*math.Exp(-x*x/2)))
+		}
+	}
+	writeTestRecording(t, s.dataFS, "/impulse.qadc", h, frames)
+
+	w := httptest.NewRecorder()
+	body := `{"source":"recording","file":"/impulse.qadc"}`
+	s.api.ServeHTTP(w, httptest.NewRequest("POST", "/calibrate/sync", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("calibrate = %d %s", w.Code, w.Body.String())
+	}
+	var res struct {
+		Serial  string                `json:"serial"`
+		Applied bool                  `json:"applied"`
+		Result  SyncCalibrationRecord `json:"result"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Serial != "1e9702" || res.Applied {
+		t.Errorf("serial %q applied %v, want 1e9702 and not applied to another board", res.Serial, res.Applied)
+	}
+	// channel 9 samples 64 periods later than channels 1 and 2
+	for ch, want := range map[int]uint8{0: 0, 1: 0, 8: 64, 2: 0} {
+		if got := res.Result.Offsets[ch]; math.Abs(float64(got)-float64(want)) > 3 {
+			t.Errorf("channel %d offset = %d, want %d", ch+1, got, want)
+		}
+	}
+
+	s.serial = "1e9702"
+	rec, err := s.loadSyncCalibration()
+	if err != nil || rec.Time.IsZero() || rec.Offsets != res.Result.Offsets || rec.SamplingTime != 31.25 {
+		t.Errorf("stored %+v, %v, want the calibration of the recording", rec, err)
+	}
+}
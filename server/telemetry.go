@@ -0,0 +1,496 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/MShoaei/quakeADC/driver"
+	"github.com/gin-gonic/gin"
+)
+
+// TelemetryConfig configures the measurement of the power rails of the board
+type TelemetryConfig struct {
+	// Interval between measurements of every rail. A measurement takes about
+	// two seconds per rail.
+	Interval time.Duration `json:"interval" mapstructure:"interval"`
+
+	// History is the number of measurements kept
+	History int `json:"history" mapstructure:"history"`
+
+	// VoltsPerCount and AmpsPerCount convert the raw readings. They depend
+	// on the board and are 0 until measured, which keeps only the raw readings
+	// and disables the battery state, the alerts and the low battery shutdown.
+	VoltsPerCount float64 `json:"voltsPerCount" mapstructure:"voltsPerCount"`
+	AmpsPerCount  float64 `json:"ampsPerCount" mapstructure:"ampsPerCount"`
+
+	// BatteryRail is the rail whose first voltage and current are the battery input
+	BatteryRail int `json:"batteryRail" mapstructure:"batteryRail"`
+
+	// BatteryEmpty and BatteryFull are the battery voltages at 0% and 100% charge
+	BatteryEmpty float64 `json:"batteryEmpty" mapstructure:"batteryEmpty"`
+	BatteryFull  float64 `json:"batteryFull" mapstructure:"batteryFull"`
+
+	// BatteryCapacity in amp hours. 0 disables the runtime estimate.
+	BatteryCapacity float64 `json:"batteryCapacity" mapstructure:"batteryCapacity"`
+
+	// MinVoltage is the battery voltage below which an undervoltage alert is raised. 0 disables it.
+	MinVoltage float64 `json:"minVoltage" mapstructure:"minVoltage"`
+
+	// MaxCurrent is the current of any rail above which an overcurrent alert is raised. 0 disables it.
+	MaxCurrent float64 `json:"maxCurrent" mapstructure:"maxCurrent"`
+
+	// ShutdownVoltage is the battery voltage below which the unit shuts down
+	// after two measurements in a row. 0 disables it.
+	ShutdownVoltage float64 `json:"shutdownVoltage" mapstructure:"shutdownVoltage"`
+}
+
+// DefaultTelemetryConfig is the telemetry of a unit with a 12V lead acid
+// battery. The scales, the limits and the shutdown are not set because a
+// wrong scale would power the unit off in the field.
+func DefaultTelemetryConfig() TelemetryConfig {
+	return TelemetryConfig{
+		Interval:     time.Minute,
+		History:      24 * 60,
+		BatteryEmpty: 11.8,
+		BatteryFull:  12.7,
+	}
+}
+
+func (cfg TelemetryConfig) Validate() error {
+	if cfg.Interval < time.Second {
+		return fmt.Errorf("invalid telemetry interval %s. expected at least 1s", cfg.Interval)
+	}
+	if cfg.History < 1 {
+		return fmt.Errorf("invalid telemetry history %d. expected at least 1", cfg.History)
+	}
+	if cfg.VoltsPerCount < 0 || cfg.AmpsPerCount < 0 {
+		return fmt.Errorf("invalid telemetry scale. voltsPerCount and ampsPerCount can not be negative")
+	}
+	if cfg.BatteryRail < 0 || cfg.BatteryRail >= driver.TelemetryRails {
+		return fmt.Errorf("invalid battery rail %d. expected 0 to %d", cfg.BatteryRail, driver.TelemetryRails-1)
+	}
+	if cfg.BatteryFull <= cfg.BatteryEmpty {
+		return fmt.Errorf("invalid battery voltages. batteryFull must be above batteryEmpty")
+	}
+	if cfg.BatteryCapacity < 0 || cfg.MinVoltage < 0 || cfg.MaxCurrent < 0 || cfg.ShutdownVoltage < 0 {
+		return fmt.Errorf("invalid telemetry config. capacity and limits can not be negative")
+	}
+	if !cfg.scaled() && (cfg.MinVoltage > 0 || cfg.MaxCurrent > 0 || cfg.ShutdownVoltage > 0) {
+		return fmt.Errorf("invalid telemetry config. minVoltage, maxCurrent and shutdownVoltage need voltsPerCount and ampsPerCount")
+	}
+	return nil
+}
+
+// scaled reports whether the raw readings can be converted
+func (cfg TelemetryConfig) scaled() bool {
+	return cfg.VoltsPerCount > 0 && cfg.AmpsPerCount > 0
+}
+
+// Rail is a measurement of a power rail in volts and amps
+type Rail struct {
+	Voltages [3]float64 `json:"voltages"`
+	Currents [3]float64 `json:"currents"`
+}
+
+// Battery is the state of the battery
+type Battery struct {
+	Voltage float64 `json:"voltage"`
+	Current float64 `json:"current"`
+
+	// Charge is the state of charge in percent estimated from the voltage
+	Charge float64 `json:"charge"`
+
+	// Runtime is the estimated time until the battery is empty at the current
+	// draw. 0 when it is unknown.
+	Runtime time.Duration `json:"runtime,omitempty"`
+}
+
+const (
+	AlertUndervoltage = "undervoltage"
+	AlertOvercurrent  = "overcurrent"
+)
+
+// TelemetryAlert is a measurement outside its limit
+type TelemetryAlert struct {
+	Type    string  `json:"type"`
+	Rail    int     `json:"rail"`
+	Channel int     `json:"channel"`
+	Value   float64 `json:"value"`
+	Limit   float64 `json:"limit"`
+}
+
+func (a TelemetryAlert) String() string {
+	unit := "A"
+	if a.Type == AlertUndervoltage {
+		unit = "V"
+	}
+	return fmt.Sprintf("%s on rail %d channel %d: %.3f%s, limit %.3f%s", a.Type, a.Rail, a.Channel, a.Value, unit, a.Limit, unit)
+}
+
+// TelemetrySample is a measurement of every rail
+type TelemetrySample struct {
+	Time    time.Time            `json:"time"`
+	Rails   []Rail               `json:"rails"`
+	Raw     []driver.RailReading `json:"raw"`
+	Battery Battery              `json:"battery"`
+	Alerts  []TelemetryAlert     `json:"alerts,omitempty"`
+}
+
+// sample converts raw readings of every rail taken at t. Only the raw
+// readings are kept without the scales.
+func (cfg TelemetryConfig) sample(t time.Time, raw []driver.RailReading) TelemetrySample {
+	res := TelemetrySample{Time: t, Raw: raw}
+	if !cfg.scaled() {
+		return res
+	}
+	res.Rails = make([]Rail, len(raw))
+	for i, r := range raw {
+		for j := range r.Voltages {
+			res.Rails[i].Voltages[j] = float64(r.Voltages[j]) * cfg.VoltsPerCount
+			res.Rails[i].Currents[j] = float64(r.Currents[j]) * cfg.AmpsPerCount
+		}
+	}
+	if cfg.BatteryRail < len(res.Rails) {
+		res.Battery = cfg.battery(res.Rails[cfg.BatteryRail])
+	}
+	res.Alerts = cfg.alerts(res)
+	return res
+}
+
+func (cfg TelemetryConfig) battery(r Rail) Battery {
+	b := Battery{Voltage: r.Voltages[0], Current: r.Currents[0]}
+	charge := (b.Voltage - cfg.BatteryEmpty) / (cfg.BatteryFull - cfg.BatteryEmpty)
+	if charge < 0 {
+		charge = 0
+	} else if charge > 1 {
+		charge = 1
+	}
+	b.Charge = charge * 100
+	if cfg.BatteryCapacity > 0 && b.Current > 0 {
+		hours := cfg.BatteryCapacity * charge / b.Current
+		b.Runtime = time.Duration(hours * float64(time.Hour)).Round(time.Minute)
+	}
+	return b
+}
+
+func (cfg TelemetryConfig) alerts(t TelemetrySample) (res []TelemetryAlert) {
+	if cfg.MinVoltage > 0 && t.Battery.Voltage < cfg.MinVoltage {
+		res = append(res, TelemetryAlert{Type: AlertUndervoltage, Rail: cfg.BatteryRail, Value: t.Battery.Voltage, Limit: cfg.MinVoltage})
+	}
+	if cfg.MaxCurrent > 0 {
+		for i, r := range t.Rails {
+			for j, a := range r.Currents {
+				if a > cfg.MaxCurrent {
+					res = append(res, TelemetryAlert{Type: AlertOvercurrent, Rail: i, Channel: j, Value: a, Limit: cfg.MaxCurrent})
+				}
+			}
+		}
+	}
+	return res
+}
+
+// lowBattery reports whether t is below the shutdown voltage. A battery
+// reading 0V is not measured.
+func (cfg TelemetryConfig) lowBattery(t TelemetrySample) bool {
+	v := t.Battery.Voltage
+	return cfg.ShutdownVoltage > 0 && v > 0 && v < cfg.ShutdownVoltage
+}
+
+// telemetry keeps the recent measurements and sends new ones to subscribers
+type telemetry struct {
+	mu          sync.Mutex
+	cfg         TelemetryConfig
+	history     []TelemetrySample
+	next        int
+	subscribers map[chan TelemetrySample]struct{}
+
+	// active are the alerts raised by the last measurement
+	active map[string]bool
+}
+
+func newTelemetry(cfg TelemetryConfig) *telemetry {
+	return &telemetry{
+		cfg:         cfg,
+		subscribers: map[chan TelemetrySample]struct{}{},
+		active:      map[string]bool{},
+	}
+}
+
+func (t *telemetry) config() TelemetryConfig {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cfg
+}
+
+func (t *telemetry) setConfig(cfg TelemetryConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.history = t.samplesSince(time.Time{})
+	t.cfg = cfg
+	if len(t.history) > cfg.History {
+		t.history = t.history[len(t.history)-cfg.History:]
+	}
+	t.next = len(t.history) % cfg.History
+}
+
+// add stores s and returns the alerts which were not raised by the previous
+// measurement and the ones which cleared
+func (t *telemetry) add(s TelemetrySample) (raised []TelemetryAlert, cleared []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.history) < t.cfg.History {
+		t.history = append(t.history, s)
+	} else {
+		t.history[t.next] = s
+	}
+	t.next = (t.next + 1) % t.cfg.History
+
+	active := map[string]bool{}
+	for _, a := range s.Alerts {
+		key := fmt.Sprintf("%s/%d/%d", a.Type, a.Rail, a.Channel)
+		active[key] = true
+		if !t.active[key] {
+			raised = append(raised, a)
+		}
+	}
+	for key := range t.active {
+		if !active[key] {
+			cleared = append(cleared, key)
+		}
+	}
+	t.active = active
+
+	for ch := range t.subscribers {
+		select {
+		case ch <- s:
+		default:
+			// slow subscribers miss measurements
+		}
+	}
+	return raised, cleared
+}
+
+func (t *telemetry) latest() (TelemetrySample, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.history) == 0 {
+		return TelemetrySample{}, false
+	}
+	return t.history[(t.next+len(t.history)-1)%len(t.history)], true
+}
+
+// since returns the measurements after since from the oldest
+func (t *telemetry) since(since time.Time) []TelemetrySample {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.samplesSince(since)
+}
+
+func (t *telemetry) samplesSince(since time.Time) []TelemetrySample {
+	res := make([]TelemetrySample, 0, len(t.history))
+	start := 0
+	if len(t.history) == t.cfg.History {
+		start = t.next
+	}
+	for i := range t.history {
+		s := t.history[(start+i)%len(t.history)]
+		if s.Time.After(since) {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+func (t *telemetry) subscribe() chan TelemetrySample {
+	ch := make(chan TelemetrySample, 1)
+	t.mu.Lock()
+	t.subscribers[ch] = struct{}{}
+	t.mu.Unlock()
+	return ch
+}
+
+func (t *telemetry) unsubscribe(ch chan TelemetrySample) {
+	t.mu.Lock()
+	delete(t.subscribers, ch)
+	t.mu.Unlock()
+}
+
+// SetTelemetry changes the telemetry config. The interval applies after the next measurement.
+func (s *Server) SetTelemetry(cfg TelemetryConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	s.telemetry.setConfig(cfg)
+	return nil
+}
+
+// measureTelemetry measures every rail and stores the result
+func (s *Server) measureTelemetry() (TelemetrySample, error) {
+	if s.xmega == nil {
+		return TelemetrySample{}, fmt.Errorf("no board controller")
+	}
+	raw := make([]driver.RailReading, driver.TelemetryRails)
+	for i := range raw {
+		if s.hw.preempted() {
+			return TelemetrySample{}, errPreempted
+		}
+		var err error
+		if raw[i], err = s.xmega.ReadRail(i); err != nil {
+			return TelemetrySample{}, fmt.Errorf("failed to read rail %d: %v", i, err)
+		}
+	}
+	cfg := s.telemetry.config()
+	sample := cfg.sample(time.Now(), raw)
+	previous, _ := s.telemetry.latest()
+	raised, cleared := s.telemetry.add(sample)
+	for _, a := range raised {
+		s.l.Warnf("power alert: %s", a)
+		s.event(nil, EventTelemetry, map[string]interface{}{"alert": a}, "power alert: %s", a)
+	}
+	for _, key := range cleared {
+		s.l.Infof("power alert cleared: %s", key)
+		s.event(nil, EventTelemetry, map[string]interface{}{"cleared": key}, "power alert cleared: %s", key)
+	}
+	if cfg.lowBattery(previous) && cfg.lowBattery(sample) && !s.shuttingDown() {
+		go s.lowBatteryShutdown(sample.Battery.Voltage)
+	}
+	return sample, nil
+}
+
+// watchTelemetry measures the rails every interval. Measurements are skipped
+// while other work holds the hardware because they share the SPI bus with the
+// ADCs, and stopped between rails for users waiting for the hardware.
+func (s *Server) watchTelemetry(stop <-chan struct{}) {
+	for {
+		if s.hw.tryAcquire(workTelemetry) {
+			_, err := s.measureTelemetry()
+			s.hw.release()
+			if err != nil && err != errPreempted {
+				s.l.Errorf("telemetry: %v", err)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(s.telemetry.config().Interval):
+		}
+	}
+}
+
+// currentTelemetry is the last measurement. The rails are measured when
+// there is none yet.
+func (s *Server) currentTelemetry() (TelemetrySample, error) {
+	if sample, ok := s.telemetry.latest(); ok {
+		return sample, nil
+	}
+	if !s.hw.tryAcquire(workTelemetry) {
+		return TelemetrySample{}, errHardwareBusy(s.hw.current())
+	}
+	sample, err := s.measureTelemetry()
+	s.hw.release()
+	if err == errPreempted {
+		return sample, errHardwareBusy(s.hw.current())
+	}
+	if err != nil {
+		return sample, hardwareError(err)
+	}
+	return sample, nil
+}
+
+func (s *Server) TelemetryHandler(c *gin.Context) {
+	sample, err := s.currentTelemetry()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, sample)
+}
+
+// TelemetryHistoryHandler lists the kept measurements after the optional
+// since time from the oldest. limit keeps the newest ones.
+func (s *Server) TelemetryHistoryHandler(c *gin.Context) {
+	var since time.Time
+	if v := c.Query("since"); v != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, v); err != nil {
+			abortWithError(c, invalidField("since", "expected an RFC 3339 time"))
+			return
+		}
+	}
+	samples := s.telemetry.since(since)
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			abortWithError(c, invalidField("limit", "expected a positive integer"))
+			return
+		}
+		if len(samples) > limit {
+			samples = samples[len(samples)-limit:]
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"samples": samples,
+	})
+}
+
+// TelemetryStreamHandler is a websocket sending every new measurement as JSON
+func (s *Server) TelemetryStreamHandler(c *gin.Context) {
+	conn, err := s.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		s.l.Errorf("websocket creation error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	samples := s.telemetry.subscribe()
+	defer s.telemetry.unsubscribe(samples)
+
+	// the client only closes the connection
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if sample, ok := s.telemetry.latest(); ok {
+		if err := conn.WriteJSON(sample); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case <-closed:
+			return
+		case sample := <-samples:
+			if err := conn.WriteJSON(sample); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// BoardInfoHandler returns the raw readings of the last measurement
+func (s *Server) BoardInfoHandler(c *gin.Context) {
+	type message struct {
+		Voltage []int16 `json:"voltage"`
+		Current []int16 `json:"current"`
+	}
+	sample, err := s.currentTelemetry()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	var m message
+	for _, r := range sample.Raw {
+		m.Voltage = append(m.Voltage, r.Voltages[:]...)
+		m.Current = append(m.Current, r.Currents[:]...)
+	}
+	c.JSON(http.StatusOK, &m)
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MShoaei/quakeADC/driver"
+)
+
+func TestTelemetrySample(t *testing.T) {
+	cfg := DefaultTelemetryConfig()
+	cfg.VoltsPerCount = 0.01
+	cfg.AmpsPerCount = 0.01
+	cfg.BatteryCapacity = 10
+	cfg.MinVoltage = 11.5
+	cfg.MaxCurrent = 3
+	tests := []struct {
+		name    string
+		battery driver.RailReading
+		want    Battery
+		alerts  []string
+	}{
+		{name: "full", battery: driver.RailReading{Voltages: [3]int16{1300}, Currents: [3]int16{100}},
+			want: Battery{Voltage: 13, Current: 1, Charge: 100, Runtime: 10 * time.Hour}},
+		{name: "half", battery: driver.RailReading{Voltages: [3]int16{1225}, Currents: [3]int16{200}},
+			want: Battery{Voltage: 12.25, Current: 2, Charge: 50, Runtime: 2*time.Hour + 30*time.Minute}},
+		{name: "no draw", battery: driver.RailReading{Voltages: [3]int16{1225}},
+			want: Battery{Voltage: 12.25, Charge: 50}},
+		{name: "undervoltage", battery: driver.RailReading{Voltages: [3]int16{1100}, Currents: [3]int16{100}},
+			want: Battery{Voltage: 11, Current: 1}, alerts: []string{AlertUndervoltage}},
+		{name: "overcurrent", battery: driver.RailReading{Voltages: [3]int16{1300}, Currents: [3]int16{100, 0, 400}},
+			want: Battery{Voltage: 13, Current: 1, Charge: 100, Runtime: 10 * time.Hour}, alerts: []string{AlertOvercurrent}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := make([]driver.RailReading, driver.TelemetryRails)
+			raw[0] = tt.battery
+			got := cfg.sample(time.Now(), raw)
+			if got.Battery != tt.want {
+				t.Errorf("battery = %+v, want %+v", got.Battery, tt.want)
+			}
+			var alerts []string
+			for _, a := range got.Alerts {
+				alerts = append(alerts, a.Type)
+			}
+			if !reflect.DeepEqual(alerts, tt.alerts) {
+				t.Errorf("alerts = %v, want %v", alerts, tt.alerts)
+			}
+		})
+	}
+}
+
+func TestTelemetryConfigValidate(t *testing.T) {
+	scaled := DefaultTelemetryConfig()
+	scaled.VoltsPerCount = 0.001
+	scaled.AmpsPerCount = 0.001
+	tests := []struct {
+		name    string
+		change  func(*TelemetryConfig)
+		wantErr bool
+	}{
+		{name: "default", change: func(*TelemetryConfig) {}},
+		{name: "unscaled limit", change: func(cfg *TelemetryConfig) { cfg.MinVoltage = 11.5 }, wantErr: true},
+		{name: "unscaled shutdown", change: func(cfg *TelemetryConfig) { cfg.ShutdownVoltage = 11 }, wantErr: true},
+		{name: "voltage scale only", change: func(cfg *TelemetryConfig) { cfg.VoltsPerCount = 0.001; cfg.ShutdownVoltage = 11 }, wantErr: true},
+		{name: "scaled shutdown", change: func(cfg *TelemetryConfig) { *cfg = scaled; cfg.ShutdownVoltage = 11 }},
+		{name: "negative scale", change: func(cfg *TelemetryConfig) { cfg.AmpsPerCount = -1 }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultTelemetryConfig()
+			tt.change(&cfg)
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	raw := make([]driver.RailReading, driver.TelemetryRails)
+	raw[0] = driver.RailReading{Voltages: [3]int16{10000}}
+	if got := DefaultTelemetryConfig().sample(time.Now(), raw); got.Rails != nil || got.Battery != (Battery{}) || got.Alerts != nil {
+		t.Errorf("unscaled sample = %+v, want only the raw readings", got)
+	}
+}
+
+func TestTelemetryHistory(t *testing.T) {
+	cfg := DefaultTelemetryConfig()
+	cfg.History = 3
+	tel := newTelemetry(cfg)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		tel.add(TelemetrySample{Time: start.Add(time.Duration(i) * time.Second)})
+	}
+	seconds := func(samples []TelemetrySample) (res []int) {
+		for _, s := range samples {
+			res = append(res, int(s.Time.Sub(start)/time.Second))
+		}
+		return res
+	}
+	if got := seconds(tel.since(time.Time{})); !reflect.DeepEqual(got, []int{2, 3, 4}) {
+		t.Errorf("history = %v, want [2 3 4]", got)
+	}
+	if got := seconds(tel.since(start.Add(3 * time.Second))); !reflect.DeepEqual(got, []int{4}) {
+		t.Errorf("history since 3s = %v, want [4]", got)
+	}
+	if s, _ := tel.latest(); !s.Time.Equal(start.Add(4 * time.Second)) {
+		t.Errorf("latest = %v, want 4s", s.Time.Sub(start))
+	}
+
+	cfg.History = 2
+	tel.setConfig(cfg)
+	tel.add(TelemetrySample{Time: start.Add(5 * time.Second)})
+	if got := seconds(tel.since(time.Time{})); !reflect.DeepEqual(got, []int{4, 5}) {
+		t.Errorf("history after resize = %v, want [4 5]", got)
+	}
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TLSConfig is the tls section of the config file
+type TLSConfig struct {
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+
+	// Addr is the HTTPS listen address e.g. :8443
+	Addr string `json:"addr" mapstructure:"addr"`
+
+	// CertFile and KeyFile are PEM files. A self-signed certificate is created
+	// and stored in them when they do not exist.
+	CertFile string `json:"certFile" mapstructure:"certFile"`
+	KeyFile  string `json:"keyFile" mapstructure:"keyFile"`
+
+	// Hosts are extra host names and IP addresses of a created certificate
+	// besides the host name and the addresses of the network interfaces
+	Hosts []string `json:"hosts" mapstructure:"hosts"`
+
+	// RedirectAddr is the address of a plain HTTP listener redirecting to HTTPS. Empty disables it.
+	RedirectAddr string `json:"redirectAddr" mapstructure:"redirectAddr"`
+}
+
+// certificateHosts are the names and addresses a created certificate is valid for
+func certificateHosts(extra []string) (names []string, ips []net.IP) {
+	names = []string{"localhost"}
+	if h, err := os.Hostname(); err == nil {
+		names = append(names, h, h+".local")
+	}
+	ips = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && !n.IP.IsLoopback() {
+				ips = append(ips, n.IP)
+			}
+		}
+	}
+	for _, h := range extra {
+		if ip := net.ParseIP(h); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			names = append(names, h)
+		}
+	}
+	return names, ips
+}
+
+// createCertificate writes a self-signed certificate and its key to certFile
+// and keyFile. It is a leaf which can not sign other certificates so trusting
+// it does not trust anything else.
+func createCertificate(certFile, keyFile string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	names, ips := certificateHosts(hosts)
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"quakeADC"}, CommonName: "quakeADC"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+		DNSNames:              names,
+		IPAddresses:           ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.MkdirAll(filepath.Dir(f), 0700); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// LoadOrCreateCertificate loads the certificate in certFile and keyFile. A
+// self-signed certificate is created first when neither file exists.
+func LoadOrCreateCertificate(certFile, keyFile string, hosts []string) (tls.Certificate, error) {
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if os.IsNotExist(certErr) && os.IsNotExist(keyErr) {
+		if err := createCertificate(certFile, keyFile, hosts); err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to create certificate: %v", err)
+		}
+	}
+	return tls.LoadX509KeyPair(certFile, keyFile)
+}
+
+// fingerprint is the SHA-256 hash of the certificate so clients can pin it
+func fingerprint(cert tls.Certificate) string {
+	if len(cert.Certificate) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	return hex.EncodeToString(sum[:])
+}
+
+// redirectHandler redirects every request to the same URL on the HTTPS port
+func redirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		u := *r.URL
+		u.Scheme = "https"
+		u.Host = host
+		http.Redirect(w, r, u.String(), http.StatusPermanentRedirect)
+	})
+}
+
+// RunTLS serves the API over HTTPS on addr with the certificate of cfg
+func (s *Server) RunTLS(addr string, cfg TLSConfig) error {
+	cert, err := LoadOrCreateCertificate(cfg.CertFile, cfg.KeyFile, cfg.Hosts)
+	if err != nil {
+		return err
+	}
+	s.l.Infof("serving https on %s with certificate sha256 fingerprint %s", addr, fingerprint(cert))
+
+	if cfg.RedirectAddr != "" {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("invalid address %q: %v", addr, err)
+		}
+		go func() {
+			if err := http.ListenAndServe(cfg.RedirectAddr, redirectHandler(port)); err != nil {
+				s.l.Errorf("http redirect stopped: %v", err)
+			}
+		}()
+	}
+
+	s.start()
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: s.api,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+	return srv.ListenAndServeTLS("", "")
+}
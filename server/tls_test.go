@@ -0,0 +1,65 @@
+package server
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadOrCreateCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "tls", "cert.pem"), filepath.Join(dir, "tls", "key.pem")
+
+	created, err := LoadOrCreateCertificate(certFile, keyFile, []string{"10.0.0.1", "quake.lan"})
+	if err != nil {
+		t.Fatalf("LoadOrCreateCertificate() error = %v", err)
+	}
+	loaded, err := LoadOrCreateCertificate(certFile, keyFile, nil)
+	if err != nil {
+		t.Fatalf("LoadOrCreateCertificate() error = %v", err)
+	}
+	if fingerprint(created) != fingerprint(loaded) {
+		t.Errorf("certificate was created again instead of loaded")
+	}
+	cert, err := x509.ParseCertificate(created.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.IsCA || cert.KeyUsage != x509.KeyUsageDigitalSignature {
+		t.Errorf("certificate IsCA = %v and key usage %v, want a leaf for signatures only", cert.IsCA, cert.KeyUsage)
+	}
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("key file mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		port string
+		host string
+		want string
+	}{
+		{port: "8443", host: "192.168.4.1:8080", want: "https://192.168.4.1:8443/tree/p1?x=1"},
+		{port: "443", host: "quake.local", want: "https://quake.local/tree/p1?x=1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/tree/p1?x=1", nil)
+		req.Host = tt.host
+		w := httptest.NewRecorder()
+		redirectHandler(tt.port).ServeHTTP(w, req)
+		if got := w.Header().Get("Location"); got != tt.want {
+			t.Errorf("redirect from %s = %s, want %s", tt.host, got, tt.want)
+		}
+	}
+}
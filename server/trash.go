@@ -0,0 +1,335 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/afero"
+)
+
+// trashDir is the directory at the root of the data file system holding deleted files.
+// Each deleted item is kept in trashDir/<id>/<name> next to its metadata in trashDir/<id>.json.
+const trashDir = "/.trash"
+
+// trashPurgeInterval is how often the trash is checked for items to purge
+const trashPurgeInterval = time.Hour
+
+// trashItem is a file or directory moved to the trash
+type trashItem struct {
+	ID           string    `json:"id"`
+	OriginalPath string    `json:"originalPath"`
+	Name         string    `json:"name"`
+	Deleted      time.Time `json:"deleted"`
+	Size         int64     `json:"size"`
+	Dir          bool      `json:"dir"`
+
+	// Annotations are the notes and tags of the recordings in the item
+	Annotations map[string]annotation `json:"annotations,omitempty"`
+}
+
+func trashItemDir(id string) string {
+	return path.Join(trashDir, id)
+}
+
+func trashItemMeta(id string) string {
+	return path.Join(trashDir, id+".json")
+}
+
+// treeSize is the total size of the regular files in p
+func treeSize(fs afero.Fs, p string) (int64, error) {
+	var size int64
+	err := afero.Walk(fs, p, func(_ string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.Mode().IsRegular() {
+			size += f.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// dataFree returns the free space on the file system holding the data root.
+// ok is false when the data root is not on the host file system.
+func (s *Server) dataFree() (free uint64, ok bool) {
+	b, isBase := s.dataFS.(*afero.BasePathFs)
+	if !isBase {
+		return 0, false
+	}
+	root, err := b.RealPath("/")
+	if err != nil {
+		return 0, false
+	}
+	free, err = freeSpace(root)
+	if err != nil {
+		return 0, false
+	}
+	return free, true
+}
+
+// moveToTrash moves p into the trash and drops it from the recording index
+func (s *Server) moveToTrash(p string) (trashItem, error) {
+	s.trashMu.Lock()
+	defer s.trashMu.Unlock()
+
+	info, err := s.dataFS.Stat(p)
+	if err != nil {
+		return trashItem{}, err
+	}
+	size, err := treeSize(s.dataFS, p)
+	if err != nil {
+		return trashItem{}, err
+	}
+	now := time.Now().UTC()
+	id := now.Format("20060102T150405.000000000")
+	for i := 1; ; i++ {
+		if exists, _ := afero.Exists(s.dataFS, trashItemDir(id)); !exists {
+			break
+		}
+		id = fmt.Sprintf("%s-%d", now.Format("20060102T150405.000000000"), i)
+	}
+	item := trashItem{
+		ID:           id,
+		OriginalPath: p,
+		Name:         path.Base(p),
+		Deleted:      now,
+		Size:         size,
+		Dir:          info.IsDir(),
+	}
+	if err := s.dataFS.MkdirAll(trashItemDir(id), 0755); err != nil {
+		return trashItem{}, err
+	}
+	if err := s.dataFS.Rename(p, path.Join(trashItemDir(id), item.Name)); err != nil {
+		s.dataFS.Remove(trashItemDir(id))
+		return trashItem{}, err
+	}
+	item.Annotations, err = s.index.remove(p)
+	if err != nil {
+		s.l.Errorf("failed to update recording index: %v", err)
+	}
+	b, err := json.MarshalIndent(item, "", "  ")
+	if err != nil {
+		return trashItem{}, err
+	}
+	if err := afero.WriteFile(s.dataFS, trashItemMeta(id), b, 0644); err != nil {
+		return trashItem{}, fmt.Errorf("failed to write trash metadata: %v", err)
+	}
+	return item, nil
+}
+
+// readTrashItem reads the metadata of the trashed item id
+func (s *Server) readTrashItem(id string) (trashItem, error) {
+	var item trashItem
+	if err := validName(id); err != nil || strings.HasSuffix(id, ".json") {
+		return item, &pathError{Path: id, Reason: pathInvalid}
+	}
+	b, err := afero.ReadFile(s.dataFS, trashItemMeta(id))
+	if os.IsNotExist(err) {
+		return item, &pathError{Path: id, Reason: pathNotFound}
+	}
+	if err != nil {
+		return item, err
+	}
+	if err := json.Unmarshal(b, &item); err != nil {
+		return item, fmt.Errorf("invalid trash metadata for %s: %v", id, err)
+	}
+	return item, nil
+}
+
+// trashItems lists the trash oldest first
+func (s *Server) trashItems() ([]trashItem, error) {
+	items := make([]trashItem, 0)
+	list, err := afero.ReadDir(s.dataFS, trashDir)
+	if os.IsNotExist(err) {
+		return items, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range list {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".json") {
+			continue
+		}
+		item, err := s.readTrashItem(strings.TrimSuffix(info.Name(), ".json"))
+		if err != nil {
+			s.l.Errorf("skipping trash item: %v", err)
+			continue
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Deleted.Before(items[j].Deleted)
+	})
+	return items, nil
+}
+
+// restoreFromTrash moves the item id back to target or to its original path when target is empty
+func (s *Server) restoreFromTrash(id, target string) (trashItem, error) {
+	s.trashMu.Lock()
+	defer s.trashMu.Unlock()
+
+	item, err := s.readTrashItem(id)
+	if err != nil {
+		return item, err
+	}
+	if target == "" {
+		target = item.OriginalPath
+	}
+	if target, err = cleanPath(target); err != nil {
+		return item, err
+	}
+	if target == "/" {
+		return item, &pathError{Path: target, Reason: pathRoot}
+	}
+	if exists, _ := afero.Exists(s.dataFS, target); exists {
+		return item, &pathError{Path: target, Reason: pathInUse}
+	}
+	if err := s.dataFS.MkdirAll(path.Dir(target), 0755); err != nil {
+		return item, err
+	}
+	if err := s.dataFS.Rename(path.Join(trashItemDir(id), item.Name), target); err != nil {
+		return item, err
+	}
+	s.dataFS.RemoveAll(trashItemDir(id))
+	s.dataFS.Remove(trashItemMeta(id))
+
+	annotations := make(map[string]annotation, len(item.Annotations))
+	for k, a := range item.Annotations {
+		annotations[target+strings.TrimPrefix(k, item.OriginalPath)] = a
+	}
+	if err := s.index.addTree(target, annotations); err != nil {
+		s.l.Errorf("failed to update recording index: %v", err)
+	}
+	item.OriginalPath = target
+	return item, nil
+}
+
+// purgeTrashItem permanently deletes the item id
+func (s *Server) purgeTrashItem(id string) error {
+	s.trashMu.Lock()
+	defer s.trashMu.Unlock()
+
+	if _, err := s.readTrashItem(id); err != nil {
+		return err
+	}
+	if err := s.dataFS.RemoveAll(trashItemDir(id)); err != nil {
+		return err
+	}
+	return s.dataFS.Remove(trashItemMeta(id))
+}
+
+// purgeTrash permanently deletes items older than TrashRetention and, while
+// the free space is below TrashMinFree, the oldest items. It returns the
+// purged items.
+func (s *Server) purgeTrash() []trashItem {
+	items, err := s.trashItems()
+	if err != nil {
+		s.l.Errorf("failed to list trash: %v", err)
+		return nil
+	}
+	purged := make([]trashItem, 0)
+	purge := func(item trashItem) {
+		if err := s.purgeTrashItem(item.ID); err != nil {
+			s.l.Errorf("failed to purge %s from trash: %v", item.OriginalPath, err)
+			return
+		}
+		s.l.Infof("purged %s deleted at %s from trash", item.OriginalPath, item.Deleted.Format(time.RFC3339))
+		purged = append(purged, item)
+	}
+	for len(items) > 0 && s.TrashRetention > 0 && time.Since(items[0].Deleted) > s.TrashRetention {
+		purge(items[0])
+		items = items[1:]
+	}
+	for len(items) > 0 {
+		free, ok := s.dataFree()
+		if !ok || free >= s.TrashMinFree {
+			break
+		}
+		purge(items[0])
+		items = items[1:]
+	}
+	return purged
+}
+
+// watchTrash purges the trash periodically until stop is closed
+func (s *Server) watchTrash(stop <-chan struct{}) {
+	t := time.NewTicker(trashPurgeInterval)
+	defer t.Stop()
+	for {
+		s.purgeTrash()
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (s *Server) ListTrashHandler(c *gin.Context) {
+	items, err := s.trashItems()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	var size int64
+	for _, item := range items {
+		size += item.Size
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"items": items,
+		"size":  size,
+	})
+}
+
+// RestoreTrashHandler moves an item out of the trash. The optional path in
+// the body restores it somewhere other than where it was deleted from.
+func (s *Server) RestoreTrashHandler(c *gin.Context) {
+	data := PathRequest{}
+	if c.Request.ContentLength != 0 {
+		if !bindJSON(c, &data) {
+			return
+		}
+	}
+	item, err := s.restoreFromTrash(c.Param("id"), data.Path)
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"path": item.OriginalPath,
+	})
+}
+
+func (s *Server) DeleteTrashItemHandler(c *gin.Context) {
+	if err := s.purgeTrashItem(c.Param("id")); err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// EmptyTrashHandler permanently deletes everything in the trash
+func (s *Server) EmptyTrashHandler(c *gin.Context) {
+	items, err := s.trashItems()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	for _, item := range items {
+		if err := s.purgeTrashItem(item.ID); err != nil {
+			abortWithError(c, err)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"purged": len(items),
+	})
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/MShoaei/quakeADC/record"
+	"github.com/spf13/afero"
+)
+
+func TestTrash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs := afero.NewBasePathFs(afero.NewOsFs(), dir)
+	_ = fs.Mkdir("/p1", 0755)
+	writeTestRecording(t, fs, "/p1/shot1", record.Header{Mode: "hammer", SampleRate: 1000, StartTime: time.Now(), Channels: []record.Channel{{Index: 0, Gain: 1}}},
+		[][]int32{{1}, {2}})
+	s := NewServer(fs, afero.NewMemMapFs(), &fakeStorage{}, nil, false)
+	if err := s.index.rebuild(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.index.setAnnotation("/p1/shot1", nil, []string{"good"}); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := s.moveToTrash("/p1")
+	if err != nil {
+		t.Fatalf("moveToTrash() error = %v", err)
+	}
+	if exists, _ := afero.Exists(fs, "/p1"); exists {
+		t.Errorf("moveToTrash() left /p1 in place")
+	}
+	if list, _ := s.index.list(nil, "path", false); len(list) != 0 {
+		t.Errorf("index has %d recordings after delete, want 0", len(list))
+	}
+	if items, _ := s.trashItems(); len(items) != 1 || items[0].OriginalPath != "/p1" || !items[0].Dir {
+		t.Fatalf("trashItems() = %+v", items)
+	}
+
+	if _, err := s.restoreFromTrash(item.ID, "/restored"); err != nil {
+		t.Fatalf("restoreFromTrash() error = %v", err)
+	}
+	list, _ := s.index.list(nil, "path", false)
+	if len(list) != 1 || list[0].Path != "/restored/shot1" || len(list[0].Tags) != 1 {
+		t.Errorf("index after restore = %+v", list)
+	}
+	if items, _ := s.trashItems(); len(items) != 0 {
+		t.Errorf("trashItems() after restore = %+v", items)
+	}
+
+	item, _ = s.moveToTrash("/restored")
+	s.TrashRetention = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if purged := s.purgeTrash(); len(purged) != 1 || purged[0].ID != item.ID {
+		t.Errorf("purgeTrash() = %+v", purged)
+	}
+	if exists, _ := afero.Exists(fs, trashItemDir(item.ID)); exists {
+		t.Errorf("purgeTrash() left %s", item.ID)
+	}
+}
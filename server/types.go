@@ -1,17 +1,12 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
-)
 
-type usbDevice struct {
-	Name       string      `json:"name"`
-	Label      string      `json:"label"`
-	MountPoint string      `json:"mountpoint"`
-	Size       string      `json:"size"`
-	Children   []usbDevice `json:"children"`
-}
+	"github.com/MShoaei/quakeADC/sensor"
+)
 
 type RXResponse []byte
 
@@ -24,3 +19,244 @@ func (r RXResponse) MarshalJSON() ([]byte, error) {
 	}
 	return []byte(result), nil
 }
+
+// SetupRequest configures and starts a recording in the active project
+type SetupRequest struct {
+	// StartMode is asap, hammer or trigger
+	StartMode        string  `json:"startMode"`
+	TriggerThreshold float64 `json:"threshold"`
+	ThresholdUnit    string  `json:"thresholdUnit"`
+	TriggerChannel   int     `json:"triggerChannel"`
+
+	// RecordTime in seconds
+	RecordTime int `json:"recordTime"`
+
+	// SamplingTime in microseconds
+	SamplingTime float32 `json:"samplingTime"`
+	Window       int     `json:"window"`
+	FileName     string  `json:"fileName"`
+
+	// Shot number of the recording. 0 uses the current shot of the project survey
+	Shot int `json:"shot"`
+}
+
+func (r SetupRequest) Validate() error {
+	switch strings.ToLower(r.StartMode) {
+	case "asap", "hammer", "trigger":
+	default:
+		return invalidField("startMode", "expected asap, hammer or trigger")
+	}
+	if r.RecordTime < 1 {
+		return invalidField("recordTime", "must be at least 1 second")
+	}
+	if !validSamplingTime(r.SamplingTime) {
+		return invalidField("samplingTime", "expected one of %v microseconds", samplingTimes)
+	}
+	if r.Window < 1 || r.Window > 100 {
+		return invalidField("window", "must be between 1 and 100")
+	}
+	if r.TriggerChannel < 0 || r.TriggerChannel >= channelCount {
+		return invalidField("triggerChannel", "must be between 0 and %d", channelCount-1)
+	}
+	if _, err := sensor.ParseUnit(r.ThresholdUnit); err != nil {
+		return invalidField("thresholdUnit", "%v", err)
+	}
+	if r.Shot < 0 {
+		return invalidField("shot", "must not be negative")
+	}
+	if err := validName(r.FileName); err != nil {
+		return invalidField("fileName", "%v", err)
+	}
+	return nil
+}
+
+// LoginRequest logs in a user of the auth config
+type LoginRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+func (r LoginRequest) Validate() error {
+	if r.Name == "" {
+		return invalidField("name", "required")
+	}
+	return nil
+}
+
+// RenameRequest renames a file or directory
+type RenameRequest struct {
+	NewName string `json:"newName"`
+}
+
+func (r RenameRequest) Validate() error {
+	if err := validName(r.NewName); err != nil {
+		return invalidField("newName", "%v", err)
+	}
+	return nil
+}
+
+// ProjectRequest creates a project
+type ProjectRequest struct {
+	Name string `json:"name"`
+}
+
+func (r ProjectRequest) Validate() error {
+	if err := validName(r.Name); err != nil {
+		return invalidField("name", "%v", err)
+	}
+	return nil
+}
+
+// PathRequest selects a directory in the data root
+type PathRequest struct {
+	Path string `json:"path"`
+}
+
+// FileRequest selects a recording in the data root
+type FileRequest struct {
+	File string `json:"file"`
+}
+
+func (r FileRequest) Validate() error {
+	if r.File == "" {
+		return invalidField("file", "required")
+	}
+	return nil
+}
+
+// Sources of the common signal of a sync offset calibration
+const (
+	syncSourceCapture   = "capture"
+	syncSourceRecording = "recording"
+)
+
+// SyncCalibrationRequest selects the signal common to every channel the
+// channels are aligned with
+type SyncCalibrationRequest struct {
+	// Source is capture to record a test signal applied to every input or
+	// recording to use the impulse recorded in File
+	Source string `json:"source"`
+	File   string `json:"file,omitempty"`
+
+	// SamplingTime of the capture in microseconds. 0 uses 31.25.
+	SamplingTime float32 `json:"samplingTime,omitempty"`
+
+	// CaptureTime of the capture in milliseconds
+	CaptureTime int `json:"captureTime,omitempty"`
+
+	// Reference is the channel the other channels are aligned to. 0 selects
+	// the first recorded channel.
+	Reference int `json:"reference,omitempty"`
+}
+
+func (r SyncCalibrationRequest) Validate() error {
+	switch r.Source {
+	case syncSourceCapture:
+		// the profiles of 16 and 2000 keep the power mode so the modulator clock is unknown
+		if r.SamplingTime != 0 && (!validSamplingTime(r.SamplingTime) || r.SamplingTime == 16 || r.SamplingTime == 2000) {
+			return invalidField("samplingTime", "unsupported sampling time %v", r.SamplingTime)
+		}
+	case syncSourceRecording:
+		if r.File == "" {
+			return invalidField("file", "required")
+		}
+	default:
+		return invalidField("source", "expected %s or %s", syncSourceCapture, syncSourceRecording)
+	}
+	if r.Reference < 0 || r.Reference > 24 {
+		return invalidField("reference", "expected 0 to 24")
+	}
+	if r.CaptureTime < 0 {
+		return invalidField("captureTime", "must not be negative")
+	}
+	return nil
+}
+
+// SaveProjectRequest exports every recording of a project to USB
+type SaveProjectRequest struct {
+	Project string `json:"project"`
+}
+
+func (r SaveProjectRequest) Validate() error {
+	if r.Project == "" {
+		return invalidField("project", "required")
+	}
+	return nil
+}
+
+// ShotRequest sets the shot number of new recordings
+type ShotRequest struct {
+	Shot int `json:"shot"`
+}
+
+func (r ShotRequest) Validate() error {
+	if r.Shot < 0 {
+		return invalidField("shot", "must not be negative")
+	}
+	return nil
+}
+
+// AnnotateRequest sets the notes and tags of a recording. nil values are left unchanged.
+type AnnotateRequest struct {
+	Path  string   `json:"path"`
+	Notes *string  `json:"notes"`
+	Tags  []string `json:"tags"`
+}
+
+func (r AnnotateRequest) Validate() error {
+	if r.Path == "" {
+		return invalidField("path", "required")
+	}
+	for _, t := range r.Tags {
+		if strings.TrimSpace(t) == "" {
+			return invalidField("tags", "empty tag")
+		}
+	}
+	return nil
+}
+
+// WifiRequest connects to a WiFi network
+type WifiRequest struct {
+	ESSID    string `json:"essid"`
+	Password string `json:"password"`
+}
+
+func (r WifiRequest) Validate() error {
+	if r.ESSID == "" {
+		return invalidField("essid", "required")
+	}
+	return nil
+}
+
+// channelCount is the number of channels of the board
+const channelCount = 24
+
+// ChannelsRequest enables channels. It is a JSON array of one boolean per channel.
+type ChannelsRequest [channelCount]bool
+
+func (r *ChannelsRequest) UnmarshalJSON(b []byte) error {
+	var ch []bool
+	if err := json.Unmarshal(b, &ch); err != nil {
+		return err
+	}
+	if len(ch) != channelCount {
+		return invalidField("channels", "expected %d values, got %d", channelCount, len(ch))
+	}
+	copy(r[:], ch)
+	return nil
+}
+
+// GainsRequest sets the gains. It is a JSON array of one gain per channel.
+type GainsRequest [channelCount]uint32
+
+func (r *GainsRequest) UnmarshalJSON(b []byte) error {
+	var gains []uint32
+	if err := json.Unmarshal(b, &gains); err != nil {
+		return err
+	}
+	if len(gains) != channelCount {
+		return invalidField("gains", "expected %d values, got %d", channelCount, len(gains))
+	}
+	copy(r[:], gains)
+	return nil
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// storagePollInterval is how often removable disks are checked for hot-plug
+const storagePollInterval = 2 * time.Second
+
+// maxHotplugEvents is the number of hot-plug events listed in /storage
+const maxHotplugEvents = 20
+
+// hotplugLog holds the latest hot-plug events
+type hotplugLog struct {
+	mu     sync.Mutex
+	events []StorageEvent
+}
+
+func (h *hotplugLog) add(e StorageEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, e)
+	if len(h.events) > maxHotplugEvents {
+		h.events = h.events[len(h.events)-maxHotplugEvents:]
+	}
+}
+
+// list returns the events from the newest
+func (h *hotplugLog) list() []StorageEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	res := make([]StorageEvent, len(h.events))
+	for i, e := range h.events {
+		res[len(res)-1-i] = e
+	}
+	return res
+}
+
+// exportDevice returns the mounted device named name or the first mounted device if name is empty
+func (s *Server) exportDevice(name string) (StorageDevice, error) {
+	devices, err := s.storage.Devices()
+	if err != nil {
+		return StorageDevice{}, err
+	}
+	for _, dev := range devices {
+		if dev.MountPoint != "" && (name == "" || dev.Name == name) {
+			return dev, nil
+		}
+	}
+	if name != "" {
+		return StorageDevice{}, errors.New("requested USB device is not mounted")
+	}
+	return StorageDevice{}, errors.New("No USB mounted")
+}
+
+// watchStorage keeps and journals removable disks being plugged in or removed
+func (s *Server) watchStorage(stop <-chan struct{}) {
+	for e := range s.storage.Watch(storagePollInterval, stop) {
+		s.l.Infof("removable disk %s %s", e.Disk, e.Type)
+		s.hotplug.add(e)
+		s.event(nil, EventStorage, map[string]interface{}{"disk": e.Disk, "type": e.Type}, "removable disk %s %s", e.Disk, e.Type)
+	}
+}
+
+func (s *Server) GetAllUSBHandler(c *gin.Context) {
+	devices, err := s.storage.Devices()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"devices": devices,
+	})
+}
+
+func (s *Server) MountUSBHandler(c *gin.Context) {
+	dev, err := s.storage.Mount(c.Param("name"))
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"device": dev,
+	})
+}
+
+func (s *Server) UnmountUSBHandler(c *gin.Context) {
+	if s.exports.running() != nil {
+		abortWithError(c, newError(http.StatusConflict, "an export is running"))
+		return
+	}
+	if err := s.storage.Unmount(c.Param("name")); err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// EjectUSBHandler flushes and unmounts every file system of a disk so it can be removed safely
+func (s *Server) EjectUSBHandler(c *gin.Context) {
+	if s.exports.running() != nil {
+		abortWithError(c, newError(http.StatusConflict, "an export is running"))
+		return
+	}
+	if err := s.storage.Eject(c.Param("name")); err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
@@ -0,0 +1,457 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+type jobState string
+
+const (
+	jobRunning  jobState = "running"
+	jobFinished jobState = "finished"
+	jobFailed   jobState = "failed"
+)
+
+type fileState string
+
+const (
+	filePending fileState = "pending"
+	fileCopying fileState = "copying"
+	fileCopied  fileState = "copied"
+	fileSkipped fileState = "skipped"
+	fileFailed  fileState = "failed"
+)
+
+// exportStatus is the progress report of a USB export job
+type exportStatus struct {
+	ID       int          `json:"id"`
+	Type     string       `json:"type"`
+	State    jobState     `json:"state"`
+	Started  time.Time    `json:"started"`
+	Finished *time.Time   `json:"finished,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	Files    []exportFile `json:"files"`
+
+	// Progress is the fraction of files done including the file being copied
+	Progress float64 `json:"progress"`
+	Copied   int     `json:"copied"`
+	Skipped  int     `json:"skipped"`
+	Failed   int     `json:"failed"`
+}
+
+// exportFile is the progress of a single recording. A recording is written
+// to more than one file when exporting SAC.
+type exportFile struct {
+	Source  string         `json:"source"`
+	State   fileState      `json:"state"`
+	Size    int64          `json:"size"`
+	Written int64          `json:"written"`
+	Outputs []exportedFile `json:"outputs,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+type exportedFile struct {
+	Path    string `json:"path"`
+	SHA256  string `json:"sha256"`
+	Skipped bool   `json:"skipped"`
+}
+
+type exportJob struct {
+	mu     sync.Mutex
+	status exportStatus
+}
+
+// Status returns a copy of the job status which is safe to use while the job runs
+func (j *exportJob) Status() exportStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	st := j.status
+	st.Files = make([]exportFile, len(j.status.Files))
+	copy(st.Files, j.status.Files)
+
+	done := 0.0
+	for _, f := range st.Files {
+		switch f.State {
+		case fileCopied, fileSkipped, fileFailed:
+			done++
+		case fileCopying:
+			if f.Size > 0 {
+				done += float64(f.Written) / float64(f.Size)
+			}
+		}
+	}
+	if len(st.Files) == 0 {
+		st.Progress = 1
+	} else {
+		st.Progress = done / float64(len(st.Files))
+	}
+	return st
+}
+
+func (j *exportJob) update(i int, fn func(f *exportFile)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(&j.status.Files[i])
+}
+
+// progressWriter counts the bytes of file i written to the USB device
+type progressWriter struct {
+	job *exportJob
+	i   int
+}
+
+func (w progressWriter) Write(p []byte) (int, error) {
+	w.job.update(w.i, func(f *exportFile) {
+		f.Written += int64(len(p))
+	})
+	return len(p), nil
+}
+
+// exportJobs keeps every USB export started since the server started.
+// Only one export runs at a time since they share the same device.
+type exportJobs struct {
+	mu   sync.Mutex
+	jobs []*exportJob
+}
+
+var errExportRunning = errors.New("another export is running")
+
+func (e *exportJobs) start(fileType string, sources []string, sizes []int64) (*exportJob, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, j := range e.jobs {
+		if j.Status().State == jobRunning {
+			return nil, errExportRunning
+		}
+	}
+	j := &exportJob{status: exportStatus{
+		ID:      len(e.jobs) + 1,
+		Type:    fileType,
+		State:   jobRunning,
+		Started: time.Now(),
+		Files:   make([]exportFile, len(sources)),
+	}}
+	for i, src := range sources {
+		j.status.Files[i] = exportFile{Source: src, State: filePending, Size: sizes[i]}
+	}
+	e.jobs = append(e.jobs, j)
+	return j, nil
+}
+
+// running returns the export in progress or nil
+func (e *exportJobs) running() *exportJob {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, j := range e.jobs {
+		if j.Status().State == jobRunning {
+			return j
+		}
+	}
+	return nil
+}
+
+func (e *exportJobs) get(id int) (*exportJob, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if id < 1 || id > len(e.jobs) {
+		return nil, false
+	}
+	return e.jobs[id-1], true
+}
+
+func (e *exportJobs) all() []exportStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	res := make([]exportStatus, len(e.jobs))
+	for i, j := range e.jobs {
+		res[i] = j.Status()
+	}
+	return res
+}
+
+// exportOutput is a single file written to the USB device
+type exportOutput struct {
+	name string
+	size int64
+	open func() (io.ReadCloser, error)
+}
+
+// exportOutputs converts the recording at src to the files written for fileType.
+// Raw files are streamed from src while converted files are held in memory.
+func exportOutputs(fs afero.Fs, src string, fileType string, opts exportOptions) ([]exportOutput, error) {
+	if fileType == "raw" {
+		info, err := fs.Stat(src)
+		if err != nil {
+			return nil, err
+		}
+		return []exportOutput{{
+			name: src + ".RAW",
+			size: info.Size(),
+			open: func() (io.ReadCloser, error) { return fs.Open(src) },
+		}}, nil
+	}
+
+	f, err := fs.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := readRecording(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	inMemory := func(name string, b []byte) exportOutput {
+		return exportOutput{
+			name: name,
+			size: int64(len(b)),
+			open: func() (io.ReadCloser, error) { return ioutil.NopCloser(bytes.NewReader(b)), nil },
+		}
+	}
+	if fileType == "sac" {
+		res := make([]exportOutput, 0, len(rec.header.Channels))
+		for i, ch := range rec.header.Channels {
+			buf := new(bytes.Buffer)
+			if err := writeSAC(buf, rec, i, opts); err != nil {
+				return nil, err
+			}
+			res = append(res, inMemory(sacFileName(src, ch.Index), buf.Bytes()))
+		}
+		return res, nil
+	}
+
+	buf := new(bytes.Buffer)
+	switch fileType {
+	case "seg2":
+		err = writeSEG2(buf, rec, opts)
+	case "segy":
+		err = writeSEGY(buf, rec, opts)
+	case "csv":
+		err = writeCSV(buf, rec, opts)
+	default:
+		err = fmt.Errorf("invalid file type %q", fileType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []exportOutput{inMemory(src+bundleExtensions[fileType], buf.Bytes())}, nil
+}
+
+// exportSizeFactor estimates the size of a converted file relative to the recording
+func exportSizeFactor(fileType string) int64 {
+	if fileType == "csv" {
+		// a decimal count and separator take up to 4 times the 4 bytes of a sample
+		return 4
+	}
+	return 1
+}
+
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(fs afero.Fs, name string) (string, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return hashReader(f)
+}
+
+func hashOutput(out exportOutput) (string, error) {
+	r, err := out.open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	return hashReader(r)
+}
+
+// osFile returns the file on disk under f or nil when f is not on disk
+func osFile(f afero.File) *os.File {
+	for {
+		switch v := f.(type) {
+		case *os.File:
+			return v
+		case *afero.BasePathFile:
+			f = v.File
+		default:
+			return nil
+		}
+	}
+}
+
+// syncDir makes a rename in dir durable
+func syncDir(fs afero.Fs, dir string) error {
+	d, err := fs.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// copyVerified writes out to dst through a temporary file which is synced and
+// verified against the SHA-256 of out before it is renamed. The cached pages
+// of the file are dropped first so the device is read back instead of the
+// memory. Files which already exist with the same content are skipped so an
+// interrupted export can be resumed by starting it again.
+func copyVerified(dst afero.Fs, out exportOutput, force bool, progress io.Writer) (exportedFile, error) {
+	res := exportedFile{Path: out.name}
+	sum, err := hashOutput(out)
+	if err != nil {
+		return res, err
+	}
+	res.SHA256 = sum
+
+	if info, err := dst.Stat(out.name); err == nil {
+		if info.Size() == out.size {
+			if existing, err := hashFile(dst, out.name); err == nil && existing == sum {
+				res.Skipped = true
+				return res, nil
+			}
+		}
+		if !force {
+			return res, fmt.Errorf("%s exists with different content and not forced", out.name)
+		}
+	}
+
+	if err := dst.MkdirAll(path.Dir(out.name), os.ModeDir|0755); err != nil {
+		return res, err
+	}
+	tmp := out.name + ".part"
+	f, err := dst.Create(tmp)
+	if err != nil {
+		return res, err
+	}
+	src, err := out.open()
+	if err != nil {
+		f.Close()
+		return res, err
+	}
+	_, err = io.Copy(io.MultiWriter(f, progress), src)
+	src.Close()
+	if err == nil {
+		err = f.Sync()
+	}
+	if of := osFile(f); err == nil && of != nil {
+		if err = dropCache(of); err != nil {
+			err = fmt.Errorf("failed to drop cached pages of %s: %v", tmp, err)
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = dst.Remove(tmp)
+		return res, err
+	}
+
+	written, err := hashFile(dst, tmp)
+	if err != nil {
+		_ = dst.Remove(tmp)
+		return res, err
+	}
+	if written != sum {
+		_ = dst.Remove(tmp)
+		return res, fmt.Errorf("verification of %s failed. checksum mismatch", out.name)
+	}
+	if err := dst.Rename(tmp, out.name); err != nil {
+		return res, err
+	}
+	return res, syncDir(dst, path.Dir(out.name))
+}
+
+// run converts and copies every file of the job to dst
+func (j *exportJob) run(src, dst afero.Fs, fileType string, opts exportOptions, force bool) {
+	j.mu.Lock()
+	files := len(j.status.Files)
+	j.mu.Unlock()
+
+	for i := 0; i < files; i++ {
+		var source string
+		j.update(i, func(f *exportFile) {
+			source = f.Source
+		})
+
+		outputs, err := exportOutputs(src, source, fileType, opts)
+		if err != nil {
+			j.fail(i, err)
+			continue
+		}
+		var size int64
+		for _, out := range outputs {
+			size += out.size
+		}
+		j.update(i, func(f *exportFile) {
+			f.State = fileCopying
+			f.Size = size
+		})
+
+		skipped := true
+		for _, out := range outputs {
+			res, err := copyVerified(dst, out, force, progressWriter{job: j, i: i})
+			if err != nil {
+				j.fail(i, err)
+				break
+			}
+			skipped = skipped && res.Skipped
+			j.update(i, func(f *exportFile) {
+				if res.Skipped {
+					f.Written += out.size
+				}
+				f.Outputs = append(f.Outputs, res)
+			})
+		}
+		j.update(i, func(f *exportFile) {
+			if f.State == fileFailed {
+				return
+			}
+			if skipped {
+				f.State = fileSkipped
+				j.status.Skipped++
+			} else {
+				f.State = fileCopied
+				j.status.Copied++
+			}
+		})
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	j.status.Finished = &now
+	j.status.State = jobFinished
+	if j.status.Failed > 0 {
+		j.status.State = jobFailed
+		j.status.Error = fmt.Sprintf("%d of %d files failed", j.status.Failed, files)
+	}
+}
+
+func (j *exportJob) fail(i int, err error) {
+	j.update(i, func(f *exportFile) {
+		f.State = fileFailed
+		f.Error = err.Error()
+		j.status.Failed++
+	})
+}
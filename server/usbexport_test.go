@@ -0,0 +1,136 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// corruptFs flips the first byte of every write like a failing device
+type corruptFs struct {
+	afero.Fs
+}
+
+type corruptFile struct {
+	afero.File
+}
+
+func (f corruptFile) Write(p []byte) (int, error) {
+	q := append([]byte{}, p...)
+	if len(q) > 0 {
+		q[0] ^= 0xff
+	}
+	return f.File.Write(q)
+}
+
+func (fs corruptFs) Create(name string) (afero.File, error) {
+	f, err := fs.Fs.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return corruptFile{f}, nil
+}
+
+func TestCopyVerified(t *testing.T) {
+	src := afero.NewMemMapFs()
+	afero.WriteFile(src, "/p1/shot1", []byte("samples"), 0644)
+	out, err := exportOutputs(src, "/p1/shot1", "raw", exportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := afero.NewMemMapFs()
+	res, err := copyVerified(dst, out[0], false, ioutil.Discard)
+	if err != nil || res.Skipped {
+		t.Fatalf("copyVerified() = %+v, %v", res, err)
+	}
+	if b, _ := afero.ReadFile(dst, "/p1/shot1.RAW"); string(b) != "samples" {
+		t.Errorf("copied %q, want samples", b)
+	}
+	if exists, _ := afero.Exists(dst, "/p1/shot1.RAW.part"); exists {
+		t.Errorf("temporary file was left behind")
+	}
+	if res, err := copyVerified(dst, out[0], false, ioutil.Discard); err != nil || !res.Skipped {
+		t.Errorf("second copy = %+v, %v, want skipped", res, err)
+	}
+
+	afero.WriteFile(dst, "/p1/shot1.RAW", []byte("other"), 0644)
+	if _, err := copyVerified(dst, out[0], false, ioutil.Discard); err == nil {
+		t.Errorf("different file was overwritten without force")
+	}
+	if _, err := copyVerified(dst, out[0], true, ioutil.Discard); err != nil {
+		t.Errorf("forced copy error = %v", err)
+	}
+
+	// files on disk are synced and read back from the device
+	dir, err := ioutil.TempDir("", "usb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	disk := afero.NewBasePathFs(afero.NewOsFs(), dir)
+	if _, err := copyVerified(disk, out[0], false, ioutil.Discard); err != nil {
+		t.Errorf("copy to disk error = %v", err)
+	}
+
+	bad := corruptFs{afero.NewMemMapFs()}
+	if _, err := copyVerified(bad, out[0], false, ioutil.Discard); err == nil || !strings.Contains(err.Error(), "verification") {
+		t.Errorf("copy to a corrupting device error = %v, want a verification failure", err)
+	}
+	for _, name := range []string{"/p1/shot1.RAW", "/p1/shot1.RAW.part"} {
+		if exists, _ := afero.Exists(bad, name); exists {
+			t.Errorf("failed copy left %s", name)
+		}
+	}
+}
+
+func TestExportJob(t *testing.T) {
+	src := afero.NewMemMapFs()
+	afero.WriteFile(src, "/p1/shot1", []byte("12345678"), 0644)
+	afero.WriteFile(src, "/p1/shot2", []byte("1234"), 0644)
+
+	var jobs exportJobs
+	j, err := jobs.start("raw", []string{"/p1/shot1", "/p1/shot2", "/p1/missing"}, []int64{8, 4, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jobs.start("raw", nil, nil); err != errExportRunning {
+		t.Errorf("second start error = %v, want %v", err, errExportRunning)
+	}
+	if st := j.Status(); st.Progress != 0 || st.Files[0].State != filePending {
+		t.Errorf("status before the run = %+v", st)
+	}
+
+	// half of the first file is written
+	j.update(0, func(f *exportFile) { f.State = fileCopying })
+	progressWriter{job: j, i: 0}.Write([]byte("1234"))
+	if got := j.Status().Progress; got != 0.5/3 {
+		t.Errorf("progress = %v, want %v", got, 0.5/3)
+	}
+	j.update(0, func(f *exportFile) { f.State, f.Written = filePending, 0 })
+
+	dst := afero.NewMemMapFs()
+	j.run(src, dst, "raw", exportOptions{}, false)
+	st := j.Status()
+	if st.State != jobFailed || st.Copied != 2 || st.Failed != 1 || st.Progress != 1 || st.Finished == nil {
+		t.Errorf("status = %+v, want 2 copied and 1 failed", st)
+	}
+	for i, f := range st.Files[:2] {
+		if f.State != fileCopied || f.Written != f.Size || len(f.Outputs) != 1 || f.Outputs[0].SHA256 == "" {
+			t.Errorf("file %d = %+v", i, f)
+		}
+	}
+	if jobs.running() != nil {
+		t.Errorf("finished job is still running")
+	}
+
+	// a new run skips the copied files
+	j, _ = jobs.start("raw", []string{"/p1/shot1", "/p1/shot2"}, []int64{8, 4})
+	j.run(src, dst, "raw", exportOptions{}, false)
+	if st := j.Status(); st.State != jobFinished || st.Skipped != 2 || st.Progress != 1 {
+		t.Errorf("resumed status = %+v, want 2 skipped", st)
+	}
+}
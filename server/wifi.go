@@ -13,9 +13,7 @@ import (
 func (s *Server) ScanNetworks(c *gin.Context) {
 	aps, err := scan()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
@@ -24,30 +22,22 @@ func (s *Server) ScanNetworks(c *gin.Context) {
 }
 
 func (s *Server) Connect(c *gin.Context) {
-	data := struct {
-		ESSID    string `json:"essid"`
-		Password string `json:"password"`
-	}{}
-	if err := c.BindJSON(&data); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+	data := WifiRequest{}
+	if !bindJSON(c, &data) {
 		return
 	}
 
 	if err := connect(data.ESSID, data.Password); err != nil {
 		s.l.Errorf("failed to connect: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+		s.event(c, EventWifi, map[string]interface{}{"essid": data.ESSID, "error": err.Error()}, "failed to connect to %s", data.ESSID)
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
+	s.event(c, EventWifi, map[string]interface{}{"essid": data.ESSID}, "connected to %s", data.ESSID)
 
 	status := <-cmd.NewCmd("/bin/ping", "-c", "4", "google.com").Start()
 	if status.Exit != 0 {
-		c.JSON(http.StatusServiceUnavailable, gin.H{
-			"error": status.Error.Error(),
-		})
+		abortWithError(c, newError(http.StatusServiceUnavailable, "no internet connection through %s", data.ESSID))
 		return
 	}
 
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MShoaei/quakeADC/driver"
+	"github.com/spf13/afero"
+)
+
+// fakeXMega records the commands sent to the board
+type fakeXMega struct {
+	err      error
+	commands []string
+
+	// battery is the battery voltage in millivolts. 0 reads 12V.
+	battery int16
+
+	// rail is called before a rail is read when it is set
+	rail func(rail int)
+}
+
+func (x *fakeXMega) do(name string) error {
+	x.commands = append(x.commands, name)
+	return x.err
+}
+
+func (x *fakeXMega) Command(op driver.Opcode, arg uint8) ([]byte, error) {
+	return []byte{0, 0, 0}, x.do(op.String())
+}
+func (x *fakeXMega) ID() ([]byte, error)              { return []byte{0x12, 0x34}, x.do("id") }
+func (x *fakeXMega) SetLED(on bool) error             { return x.do("led") }
+func (x *fakeXMega) PowerADCs() error                 { return x.do("power") }
+func (x *fakeXMega) ResetADCs() error                 { return x.do("reset") }
+func (x *fakeXMega) EnableMCLK() error                { return x.do("mclk") }
+func (x *fakeXMega) EnableLogicAnalyzers(n int) error { return x.do("logic") }
+func (x *fakeXMega) StartSampling() error             { return x.do("start") }
+func (x *fakeXMega) StopSampling() error              { return x.do("stop") }
+func (x *fakeXMega) Shutdown() error                  { return x.do("shutdown") }
+func (x *fakeXMega) ReadRail(rail int) (driver.RailReading, error) {
+	if x.rail != nil {
+		x.rail(rail)
+	}
+	battery := x.battery
+	if battery == 0 {
+		battery = 12000
+	}
+	return driver.RailReading{Voltages: [3]int16{battery, 5000, 3300}, Currents: [3]int16{500, 20, 3}}, x.do("rail")
+}
+
+func TestXMegaHandlers(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		err    error
+		want   int
+		body   string
+		sent   string
+	}{
+		{name: "board info", method: "GET", url: "/info", want: http.StatusOK, body: `"voltage":[12000,5000,3300,12000`, sent: "rail rail rail rail"},
+		{name: "board info failure", method: "GET", url: "/info", err: errors.New("bus error"), want: http.StatusInternalServerError, body: `"code":"hardware_error"`, sent: "rail"},
+		{name: "telemetry", method: "GET", url: "/telemetry", want: http.StatusOK, body: `"battery":{"voltage":12,"current":0.5,"charge":22.2`, sent: "rail rail rail rail"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x := &fakeXMega{err: tt.err}
+			s := NewServer(afero.NewMemMapFs(), afero.NewMemMapFs(), &fakeStorage{}, nil, false)
+			s.xmega = x
+			cfg := DefaultTelemetryConfig()
+			cfg.VoltsPerCount = 0.001
+			cfg.AmpsPerCount = 0.001
+			if err := s.SetTelemetry(cfg); err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			s.api.ServeHTTP(w, httptest.NewRequest(tt.method, tt.url, nil))
+			if w.Code != tt.want || !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("%s %s = %d %s, want %d %s", tt.method, tt.url, w.Code, w.Body.String(), tt.want, tt.body)
+			}
+			if got := strings.Join(x.commands, " "); got != tt.sent {
+				t.Errorf("%s %s sent %q, want %q", tt.method, tt.url, got, tt.sent)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package survey
+
+import (
+	"fmt"
+	"math"
+)
+
+// Position in meters in the coordinate system of the survey
+type Position struct {
+	X         float64 `json:"x"`
+	Y         float64 `json:"y"`
+	Elevation float64 `json:"elevation"`
+}
+
+// Distance is the horizontal distance between p and q
+func (p Position) Distance(q Position) float64 {
+	return math.Hypot(p.X-q.X, p.Y-q.Y)
+}
+
+// Receiver is the geophone station a channel is connected to
+type Receiver struct {
+	// Station number. 0 means the station with the number of the channel.
+	Station int `json:"station"`
+
+	// Position overrides the position derived from the receiver spacing when set
+	Position *Position `json:"position,omitempty"`
+}
+
+// Shot is a source position of the survey
+type Shot struct {
+	Number int `json:"number"`
+
+	// Station is the receiver station nearest to the source or 0 when the source is off the line
+	Station int      `json:"station,omitempty"`
+	Source  Position `json:"source"`
+}
+
+// Survey is the layout of a seismic line
+type Survey struct {
+	Line     string `json:"line"`
+	Operator string `json:"operator"`
+
+	// CoordinateSystem describes the coordinates e.g. local or EPSG:32639
+	CoordinateSystem string `json:"coordinateSystem"`
+
+	// Origin is the position of station 1
+	Origin Position `json:"origin"`
+
+	// ReceiverSpacing is the distance in meters between consecutive stations
+	ReceiverSpacing float64 `json:"receiverSpacing"`
+
+	// Azimuth of the line in degrees counterclockwise from the X axis
+	Azimuth float64 `json:"azimuth"`
+
+	// Receivers maps each of the 24 channels to a station
+	Receivers [24]Receiver `json:"receivers"`
+	Shots     []Shot       `json:"shots"`
+
+	// CurrentShot is the shot number assigned to new recordings
+	CurrentShot int `json:"currentShot"`
+}
+
+// Defined reports whether any part of the survey was set
+func (s Survey) Defined() bool {
+	if s.Line != "" || s.Operator != "" || s.ReceiverSpacing != 0 || len(s.Shots) > 0 || s.CurrentShot != 0 {
+		return true
+	}
+	for _, r := range s.Receivers {
+		if r.Station != 0 || r.Position != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks for values which would produce wrong geometry
+func (s Survey) Validate() error {
+	if s.ReceiverSpacing < 0 {
+		return fmt.Errorf("receiver spacing must be positive, got %v", s.ReceiverSpacing)
+	}
+	for i, r := range s.Receivers {
+		if r.Station < 0 {
+			return fmt.Errorf("channel %d: invalid station %d", i+1, r.Station)
+		}
+	}
+	if s.CurrentShot < 0 {
+		return fmt.Errorf("invalid current shot %d", s.CurrentShot)
+	}
+	numbers := make(map[int]bool, len(s.Shots))
+	for _, shot := range s.Shots {
+		if shot.Number <= 0 {
+			return fmt.Errorf("invalid shot number %d", shot.Number)
+		}
+		if shot.Station < 0 {
+			return fmt.Errorf("shot %d: invalid station %d", shot.Number, shot.Station)
+		}
+		if numbers[shot.Number] {
+			return fmt.Errorf("duplicate shot number %d", shot.Number)
+		}
+		numbers[shot.Number] = true
+	}
+	return nil
+}
+
+// StationPosition is the position of a station on the line
+func (s Survey) StationPosition(station int) Position {
+	d := float64(station-1) * s.ReceiverSpacing
+	rad := s.Azimuth * math.Pi / 180
+	return Position{
+		X:         s.Origin.X + d*math.Cos(rad),
+		Y:         s.Origin.Y + d*math.Sin(rad),
+		Elevation: s.Origin.Elevation,
+	}
+}
+
+// Receiver returns the station and position of the zero based channel ch
+func (s Survey) Receiver(ch int) Receiver {
+	r := s.Receivers[ch]
+	if r.Station == 0 {
+		r.Station = ch + 1
+	}
+	if r.Position == nil {
+		p := s.StationPosition(r.Station)
+		r.Position = &p
+	} else {
+		p := *r.Position
+		r.Position = &p
+	}
+	return r
+}
+
+// Shot returns the shot with the given number. Shots missing from the
+// survey are returned with their number only.
+func (s Survey) Shot(number int) (Shot, bool) {
+	for _, shot := range s.Shots {
+		if shot.Number == number {
+			return shot, true
+		}
+	}
+	return Shot{Number: number}, false
+}
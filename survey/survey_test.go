@@ -0,0 +1,59 @@
+package survey
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSurvey_Receiver(t *testing.T) {
+	s := Survey{
+		Origin:          Position{X: 100, Y: 200, Elevation: 10},
+		ReceiverSpacing: 5,
+		Azimuth:         90,
+	}
+	s.Receivers[1] = Receiver{Station: 7}
+	s.Receivers[2] = Receiver{Position: &Position{X: 1, Y: 2, Elevation: 3}}
+
+	tests := []struct {
+		name    string
+		ch      int
+		station int
+		want    Position
+	}{
+		{name: "default station", ch: 0, station: 1, want: Position{X: 100, Y: 200, Elevation: 10}},
+		{name: "mapped station", ch: 1, station: 7, want: Position{X: 100, Y: 230, Elevation: 10}},
+		{name: "explicit position", ch: 2, station: 3, want: Position{X: 1, Y: 2, Elevation: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.Receiver(tt.ch)
+			if got.Station != tt.station {
+				t.Errorf("Receiver() station = %d, want %d", got.Station, tt.station)
+			}
+			if math.Abs(got.Position.X-tt.want.X) > 1e-9 || math.Abs(got.Position.Y-tt.want.Y) > 1e-9 || got.Position.Elevation != tt.want.Elevation {
+				t.Errorf("Receiver() position = %+v, want %+v", *got.Position, tt.want)
+			}
+		})
+	}
+}
+
+func TestSurvey_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		survey  Survey
+		wantErr bool
+	}{
+		{name: "zero value", survey: Survey{}},
+		{name: "shots", survey: Survey{Shots: []Shot{{Number: 1}, {Number: 2, Station: 4}}}},
+		{name: "negative spacing", survey: Survey{ReceiverSpacing: -1}, wantErr: true},
+		{name: "duplicate shot", survey: Survey{Shots: []Shot{{Number: 1}, {Number: 1}}}, wantErr: true},
+		{name: "invalid shot number", survey: Survey{Shots: []Shot{{Number: 0}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.survey.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}